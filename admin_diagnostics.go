@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// userDiagnostics are the calls admin.users.diagnostics makes as the user.
+// Only GET endpoints belong here, so a diagnostic never changes the session.
+var userDiagnostics = []struct {
+	name string
+	path string
+}{
+	{"session", "/session/status"},
+	{"webhook", "/webhook"},
+	{"chatwoot", "/chatwoot/config"},
+}
+
+// diagnosticRecorder captures the response of a diagnostic call
+type diagnosticRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (d *diagnosticRecorder) Header() http.Header { return d.header }
+
+func (d *diagnosticRecorder) Write(b []byte) (int, error) {
+	if d.status == 0 {
+		d.status = http.StatusOK
+	}
+	return d.body.Write(b)
+}
+
+func (d *diagnosticRecorder) WriteHeader(status int) {
+	if d.status == 0 {
+		d.status = status
+	}
+}
+
+// runUserDiagnostic makes a diagnostic call with the user's token. The token
+// is masked in the result, which needs no knowledge of it.
+func (s *server) runUserDiagnostic(r *http.Request, token, path string) (interface{}, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("token", token)
+	req.RemoteAddr = r.RemoteAddr
+
+	rec := &diagnosticRecorder{header: make(http.Header)}
+	s.router.ServeHTTP(rec, req)
+
+	body := rec.body.Bytes()
+	if token != "" {
+		body = bytes.ReplaceAll(body, []byte(token), []byte("***"))
+	}
+	var result interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// UserDiagnostics runs the read-only diagnostics of a user's session on their
+// behalf, for support to check a session without knowing its token
+func (s *server) UserDiagnostics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)["id"]
+
+		var token string
+		err := s.db.Get(&token, "SELECT token FROM users WHERE id = $1", userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				s.Respond(w, r, http.StatusNotFound, errors.New("user not found"))
+				return
+			}
+			log.Error().Err(err).Str("userID", userID).Msg("admin DB error")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("problem accessing DB"))
+			return
+		}
+
+		checks := make([]string, 0, len(userDiagnostics))
+		for _, diagnostic := range userDiagnostics {
+			checks = append(checks, diagnostic.name)
+		}
+		log.Warn().
+			Str("audit", "admin.users.diagnostics").
+			Str("userID", userID).
+			Str("ip", r.RemoteAddr).
+			Strs("checks", checks).
+			Msg("Admin ran diagnostics as user")
+
+		diagnostics := make(map[string]interface{}, len(userDiagnostics))
+		for _, diagnostic := range userDiagnostics {
+			result, err := s.runUserDiagnostic(r, token, diagnostic.path)
+			if err != nil {
+				log.Warn().Err(err).Str("userID", userID).Str("check", diagnostic.name).Msg("User diagnostic failed")
+				result = map[string]interface{}{"success": false, "error": err.Error()}
+			}
+			diagnostics[diagnostic.name] = result
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{"id": userID, "diagnostics": diagnostics})
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
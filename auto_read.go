@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	// autoReadDelay is how long incoming messages are collected before they
+	// are marked read, so a burst of messages sends a single receipt
+	autoReadDelay = 2 * time.Second
+
+	// autoReadMaxBatch flushes a batch right away once it holds this many ids
+	autoReadMaxBatch = 50
+)
+
+// autoReadUsers holds the ids of the users with auto-read enabled
+var autoReadUsers sync.Map
+
+// autoReaders holds the batcher of each user with auto-read enabled
+var autoReaders sync.Map
+
+// markReadFunc sends a read receipt for ids, as whatsmeow's Client.MarkRead
+type markReadFunc func(ctx context.Context, ids []types.MessageID, timestamp time.Time, chat, sender types.JID) error
+
+// autoReadBatch collects the ids of the messages a sender wrote in a chat
+type autoReadBatch struct {
+	chat      types.JID
+	sender    types.JID
+	ids       []types.MessageID
+	timestamp time.Time
+	timer     *time.Timer
+}
+
+// autoReader marks incoming messages read in batches, one receipt per chat
+// and sender. Nothing is sent while receiptsAllowed reports false. A reader
+// is bound to the client of one connection, owner, and stopped with it.
+type autoReader struct {
+	mu              sync.Mutex
+	pending         map[string]*autoReadBatch
+	delay           time.Duration
+	markRead        markReadFunc
+	receiptsAllowed func() bool
+	owner           *MyClient
+	stopped         bool
+}
+
+func newAutoReader(markRead markReadFunc, receiptsAllowed func() bool) *autoReader {
+	return &autoReader{
+		pending:         make(map[string]*autoReadBatch),
+		delay:           autoReadDelay,
+		markRead:        markRead,
+		receiptsAllowed: receiptsAllowed,
+	}
+}
+
+// setAutoRead enables or disables auto-read for a user
+func setAutoRead(userID string, enabled bool) {
+	if enabled {
+		autoReadUsers.Store(userID, true)
+		return
+	}
+	autoReadUsers.Delete(userID)
+	stopAutoReader(userID)
+}
+
+// stopAutoReader drops the batcher of a user, discarding its pending
+// receipts. The next message builds one for the current client.
+func stopAutoReader(userID string) {
+	if reader, found := autoReaders.LoadAndDelete(userID); found {
+		reader.(*autoReader).stop()
+	}
+}
+
+// autoReadEnabled reports whether incoming messages of a user are marked read
+func autoReadEnabled(userID string) bool {
+	_, ok := autoReadUsers.Load(userID)
+	return ok
+}
+
+// loadAutoRead caches the auto-read setting stored for a user
+func loadAutoRead(db *sqlx.DB, userID string) {
+	var enabled bool
+	if err := db.Get(&enabled, "SELECT COALESCE(auto_read, FALSE) FROM users WHERE id = $1", userID); err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Could not load auto-read setting")
+		return
+	}
+	setAutoRead(userID, enabled)
+}
+
+// add queues evt to be marked read. Own messages, status broadcasts and
+// messages without an id are ignored.
+func (a *autoReader) add(evt *events.Message) {
+	if evt.Info.IsFromMe || evt.Info.ID == "" || evt.Info.Chat.IsBroadcastList() || evt.Info.Chat == types.StatusBroadcastJID {
+		return
+	}
+
+	// Receipts in private chats carry no participant
+	sender := types.EmptyJID
+	if evt.Info.IsGroup {
+		sender = evt.Info.Sender.ToNonAD()
+	}
+	key := evt.Info.Chat.String() + "|" + sender.String()
+
+	a.mu.Lock()
+	if a.stopped {
+		a.mu.Unlock()
+		return
+	}
+	batch, found := a.pending[key]
+	if !found {
+		batch = &autoReadBatch{chat: evt.Info.Chat, sender: sender}
+		a.pending[key] = batch
+		batch.timer = time.AfterFunc(a.delay, func() { a.flush(key) })
+	}
+	batch.ids = append(batch.ids, evt.Info.ID)
+	if evt.Info.Timestamp.After(batch.timestamp) {
+		batch.timestamp = evt.Info.Timestamp
+	}
+	full := len(batch.ids) >= autoReadMaxBatch
+	a.mu.Unlock()
+
+	if full {
+		a.flush(key)
+	}
+}
+
+// stop cancels the pending batches and makes the reader ignore new messages
+func (a *autoReader) stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.stopped = true
+	for key, batch := range a.pending {
+		batch.timer.Stop()
+		delete(a.pending, key)
+	}
+}
+
+// flush sends the receipt of a pending batch
+func (a *autoReader) flush(key string) {
+	a.mu.Lock()
+	batch, found := a.pending[key]
+	delete(a.pending, key)
+	a.mu.Unlock()
+
+	if !found || len(batch.ids) == 0 {
+		return
+	}
+	if a.receiptsAllowed != nil && !a.receiptsAllowed() {
+		log.Debug().Str("chat", batch.chat.String()).Msg("Read receipts are disabled, not auto-reading messages")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := a.markRead(ctx, batch.ids, batch.timestamp, batch.chat, batch.sender); err != nil {
+		log.Warn().Err(err).Str("chat", batch.chat.String()).Int("count", len(batch.ids)).Msg("Failed to auto-read messages")
+	}
+}
+
+// autoRead marks evt read when the user enabled auto-read, honouring the
+// account's read receipts privacy setting
+func (mycli *MyClient) autoRead(evt *events.Message) {
+	if !autoReadEnabled(mycli.userID) {
+		return
+	}
+
+	reader, found := autoReaders.Load(mycli.userID)
+	if !found || reader.(*autoReader).owner != mycli {
+		// A reader left by a previous connection would mark read through its
+		// disconnected client
+		fresh := newAutoReader(
+			func(ctx context.Context, ids []types.MessageID, timestamp time.Time, chat, sender types.JID) error {
+				return mycli.WAClient.MarkRead(ctx, ids, timestamp, chat, sender)
+			},
+			func() bool {
+				return mycli.WAClient.GetPrivacySettings(context.Background()).ReadReceipts != types.PrivacySettingNone
+			},
+		)
+		fresh.owner = mycli
+		if previous, loaded := autoReaders.Swap(mycli.userID, fresh); loaded {
+			previous.(*autoReader).stop()
+		}
+		reader = fresh
+	}
+	reader.(*autoReader).add(evt)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Limits enforced by WhatsApp on business profile fields
+const (
+	businessDescriptionMaxLen = 512
+	businessAddressMaxLen     = 256
+	businessMaxWebsites       = 2
+)
+
+var errNotBusinessAccount = errors.New("account is not a WhatsApp Business account")
+
+var businessDays = map[string]bool{"sun": true, "mon": true, "tue": true, "wed": true, "thu": true, "fri": true, "sat": true}
+
+var businessHourModes = map[string]bool{"specific_hours": true, "open_24h": true, "appointment_only": true}
+
+// businessHours is one day of the weekly schedule. Open and close times are
+// minutes since midnight and only apply to specific_hours.
+type businessHours struct {
+	DayOfWeek string
+	Mode      string
+	OpenTime  int
+	CloseTime int
+}
+
+// businessProfileUpdate holds the fields to change. Nil fields are left
+// untouched.
+type businessProfileUpdate struct {
+	Description *string
+	Address     *string
+	Email       *string
+	Websites    []string
+	Categories  []string
+	TimeZone    string
+	Hours       []businessHours
+}
+
+// validate checks the update against the WhatsApp field limits
+func (u *businessProfileUpdate) validate() error {
+	if u.Description == nil && u.Address == nil && u.Email == nil && u.Websites == nil && u.Categories == nil && u.Hours == nil {
+		return errors.New("no business profile fields to update")
+	}
+	if u.Description != nil && len([]rune(*u.Description)) > businessDescriptionMaxLen {
+		return fmt.Errorf("Description must be at most %d characters", businessDescriptionMaxLen)
+	}
+	if u.Address != nil && len([]rune(*u.Address)) > businessAddressMaxLen {
+		return fmt.Errorf("Address must be at most %d characters", businessAddressMaxLen)
+	}
+	if u.Email != nil && *u.Email != "" {
+		if addr, err := mail.ParseAddress(*u.Email); err != nil || addr.Address != *u.Email {
+			return errors.New("invalid Email")
+		}
+	}
+	if len(u.Websites) > businessMaxWebsites {
+		return fmt.Errorf("at most %d Websites are allowed", businessMaxWebsites)
+	}
+	for _, website := range u.Websites {
+		if !isHTTPURL(website) {
+			return fmt.Errorf("invalid website %q", website)
+		}
+	}
+	for _, category := range u.Categories {
+		if _, err := strconv.ParseUint(category, 10, 64); err != nil {
+			return fmt.Errorf("invalid category id %q", category)
+		}
+	}
+	if u.Hours != nil {
+		if u.TimeZone == "" {
+			return errors.New("missing TimeZone for business hours")
+		}
+		if _, err := time.LoadLocation(u.TimeZone); err != nil {
+			return fmt.Errorf("invalid TimeZone %q", u.TimeZone)
+		}
+		seen := map[string]bool{}
+		for _, h := range u.Hours {
+			if !businessDays[h.DayOfWeek] {
+				return fmt.Errorf("invalid DayOfWeek %q, use sun, mon, tue, wed, thu, fri or sat", h.DayOfWeek)
+			}
+			if seen[h.DayOfWeek] {
+				return fmt.Errorf("duplicate hours for %s", h.DayOfWeek)
+			}
+			seen[h.DayOfWeek] = true
+			if !businessHourModes[h.Mode] {
+				return fmt.Errorf("invalid Mode %q, use specific_hours, open_24h or appointment_only", h.Mode)
+			}
+			if h.Mode == "specific_hours" && (h.OpenTime < 0 || h.CloseTime > 1440 || h.OpenTime >= h.CloseTime) {
+				return fmt.Errorf("invalid hours for %s, OpenTime and CloseTime are minutes since midnight", h.DayOfWeek)
+			}
+		}
+	}
+	return nil
+}
+
+// node builds the business profile mutation sent to WhatsApp
+func (u *businessProfileUpdate) node() waBinary.Node {
+	var content []waBinary.Node
+	text := func(tag, value string) {
+		content = append(content, waBinary.Node{Tag: tag, Content: []byte(value)})
+	}
+	if u.Address != nil {
+		text("address", *u.Address)
+	}
+	if u.Description != nil {
+		text("description", *u.Description)
+	}
+	if u.Email != nil {
+		text("email", *u.Email)
+	}
+	for _, website := range u.Websites {
+		text("website", website)
+	}
+	if u.Categories != nil {
+		categories := make([]waBinary.Node, 0, len(u.Categories))
+		for _, id := range u.Categories {
+			categories = append(categories, waBinary.Node{Tag: "category", Attrs: waBinary.Attrs{"id": id}})
+		}
+		content = append(content, waBinary.Node{Tag: "categories", Content: categories})
+	}
+	if u.Hours != nil {
+		configs := make([]waBinary.Node, 0, len(u.Hours))
+		for _, h := range u.Hours {
+			attrs := waBinary.Attrs{"day_of_week": h.DayOfWeek, "mode": h.Mode}
+			if h.Mode == "specific_hours" {
+				attrs["open_time"] = strconv.Itoa(h.OpenTime)
+				attrs["close_time"] = strconv.Itoa(h.CloseTime)
+			}
+			configs = append(configs, waBinary.Node{Tag: "business_hours_config", Attrs: attrs})
+		}
+		content = append(content, waBinary.Node{Tag: "business_hours", Attrs: waBinary.Attrs{"timezone": u.TimeZone}, Content: configs})
+	}
+
+	return waBinary.Node{
+		Tag:     "business_profile",
+		Attrs:   waBinary.Attrs{"v": "3", "mutation_type": "delta"},
+		Content: content,
+	}
+}
+
+// setBusinessProfile sends the update as a w:biz query. whatsmeow only
+// exposes reading business profiles, so the query is sent directly.
+func setBusinessProfile(ctx context.Context, client *whatsmeow.Client, update *businessProfileUpdate) error {
+	if client.Store.BusinessName == "" {
+		return errNotBusinessAccount
+	}
+
+	internals := client.DangerousInternals()
+	id := internals.GenerateRequestID()
+	resp := internals.WaitResponse(id)
+	err := internals.SendNode(ctx, waBinary.Node{
+		Tag: "iq",
+		Attrs: waBinary.Attrs{
+			"id":    id,
+			"xmlns": "w:biz",
+			"type":  "set",
+			"to":    types.ServerJID,
+		},
+		Content: []waBinary.Node{update.node()},
+	})
+	if err != nil {
+		internals.CancelResponse(id, resp)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	select {
+	case node := <-resp:
+		if node.AttrGetter().OptionalString("type") == "error" {
+			errNode, _ := node.GetOptionalChildByTag("error")
+			ag := errNode.AttrGetter()
+			return fmt.Errorf("whatsapp rejected the business profile: %d %s", ag.OptionalInt("code"), ag.OptionalString("text"))
+		}
+		return nil
+	case <-ctx.Done():
+		internals.CancelResponse(id, resp)
+		return errors.New("timed out waiting for the business profile update")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// maxPresenceDuration caps how long chat.presence keeps a composing or
+// recording indication before reverting it to paused
+const maxPresenceDuration = 5 * time.Minute
+
+// presenceReverts holds the pending reverts of chat presences set with a
+// duration, one per user and chat
+var presenceReverts = newPresenceReverter()
+
+// presenceReverter reverts presences once their duration is over. Setting the
+// presence of a chat again replaces its pending revert.
+type presenceReverter struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func newPresenceReverter() *presenceReverter {
+	return &presenceReverter{timers: make(map[string]*time.Timer)}
+}
+
+// presenceKey identifies the presence of a user in a chat
+func presenceKey(userID string, chat types.JID) string {
+	return userID + "|" + chat.String()
+}
+
+// hold calls revert after d unless key is held again or cancelled before
+func (p *presenceReverter) hold(key string, d time.Duration, revert func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if timer, found := p.timers[key]; found {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		p.mu.Lock()
+		// A newer hold replaced this one after it had already fired
+		if p.timers[key] != timer {
+			p.mu.Unlock()
+			return
+		}
+		delete(p.timers, key)
+		p.mu.Unlock()
+		revert()
+	})
+	p.timers[key] = timer
+}
+
+// cancel drops the pending revert of key, if any
+func (p *presenceReverter) cancel(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if timer, found := p.timers[key]; found {
+		timer.Stop()
+		delete(p.timers, key)
+	}
+}
+
+// revertChatPresence sets the presence of userID in chat back to paused
+func revertChatPresence(userID string, chat types.JID) {
+	client := clientManager.GetWhatsmeowClient(userID)
+	if client == nil {
+		return
+	}
+	if err := client.SendChatPresence(context.Background(), chat, types.ChatPresencePaused, types.ChatPresenceMediaText); err != nil {
+		log.Warn().Err(err).Str("userID", userID).Str("chat", chat.String()).Msg("Could not revert chat presence")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"sync"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"go.mau.fi/whatsmeow"
@@ -12,6 +13,8 @@ type ClientManager struct {
 	whatsmeowClients map[string]*whatsmeow.Client
 	httpClients      map[string]*resty.Client
 	myClients        map[string]*MyClient
+	lastActivity     map[string]time.Time
+	reserved         map[string]bool
 }
 
 func NewClientManager() *ClientManager {
@@ -19,6 +22,8 @@ func NewClientManager() *ClientManager {
 		whatsmeowClients: make(map[string]*whatsmeow.Client),
 		httpClients:      make(map[string]*resty.Client),
 		myClients:        make(map[string]*MyClient),
+		lastActivity:     make(map[string]time.Time),
+		reserved:         make(map[string]bool),
 	}
 }
 
@@ -26,6 +31,7 @@ func (cm *ClientManager) SetWhatsmeowClient(userID string, client *whatsmeow.Cli
 	cm.Lock()
 	defer cm.Unlock()
 	cm.whatsmeowClients[userID] = client
+	delete(cm.reserved, userID)
 }
 
 func (cm *ClientManager) GetWhatsmeowClient(userID string) *whatsmeow.Client {
@@ -38,6 +44,7 @@ func (cm *ClientManager) DeleteWhatsmeowClient(userID string) {
 	cm.Lock()
 	defer cm.Unlock()
 	delete(cm.whatsmeowClients, userID)
+	delete(cm.reserved, userID)
 }
 
 func (cm *ClientManager) SetHTTPClient(userID string, client *resty.Client) {
@@ -70,10 +77,37 @@ func (cm *ClientManager) GetMyClient(userID string) *MyClient {
 	return cm.myClients[userID]
 }
 
+// DeleteMyClient forgets the client of a user, stopping the auto-read
+// batcher bound to it
 func (cm *ClientManager) DeleteMyClient(userID string) {
 	cm.Lock()
 	defer cm.Unlock()
 	delete(cm.myClients, userID)
+	stopAutoReader(userID)
+}
+
+// SessionCount returns the number of sessions currently held by the manager,
+// counting those reserved and still starting
+func (cm *ClientManager) SessionCount() int {
+	cm.RLock()
+	defer cm.RUnlock()
+	return len(cm.whatsmeowClients) + len(cm.reserved)
+}
+
+// ReserveSession takes a session slot for a user about to connect, false when
+// limit sessions are already held. The slot is kept once the client is set
+// and freed when it is deleted. A limit of 0 means unlimited.
+func (cm *ClientManager) ReserveSession(userID string, limit int) bool {
+	cm.Lock()
+	defer cm.Unlock()
+	if _, held := cm.whatsmeowClients[userID]; held || cm.reserved[userID] {
+		return true
+	}
+	if limit > 0 && len(cm.whatsmeowClients)+len(cm.reserved) >= limit {
+		return false
+	}
+	cm.reserved[userID] = true
+	return true
 }
 
 // UpdateMyClientSubscriptions updates the event subscriptions of a client without reconnecting
@@ -84,3 +118,26 @@ func (cm *ClientManager) UpdateMyClientSubscriptions(userID string, subscription
 		client.subscriptions = subscriptions
 	}
 }
+
+// TouchActivity records the time of the latest event received for a user
+func (cm *ClientManager) TouchActivity(userID string) {
+	cm.Lock()
+	defer cm.Unlock()
+	cm.lastActivity[userID] = time.Now()
+}
+
+// GetLastActivity returns the time of the latest event received for a user
+func (cm *ClientManager) GetLastActivity(userID string) (time.Time, bool) {
+	cm.RLock()
+	defer cm.RUnlock()
+	last, ok := cm.lastActivity[userID]
+	return last, ok
+}
+
+// ClearActivity forgets the latest activity of a user that logged out or
+// was deleted
+func (cm *ClientManager) ClearActivity(userID string) {
+	cm.Lock()
+	defer cm.Unlock()
+	delete(cm.lastActivity, userID)
+}
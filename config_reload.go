@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/joho/godotenv"
+)
+
+// configMu guards the settings that admin.config.reload may change while
+// webhooks are being delivered
+var configMu sync.RWMutex
+
+// reloadedSettings holds the reloadable settings given a value by a reload.
+// Values read from the .env file never reach the process environment, so
+// the effective config looks here to report them as set by the environment.
+var reloadedSettings = map[string]bool{}
+
+// settingReloaded reports whether a reload gave key a value
+func settingReloaded(key string) bool {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return reloadedSettings[key]
+}
+
+// reloadableSetting is a setting that can change without a restart. parse
+// validates a new value, returning it normalized with the function applying it.
+type reloadableSetting struct {
+	current func() string
+	parse   func(v string) (string, func(), error)
+}
+
+// reloadableSettings are the settings admin.config.reload applies, by
+// environment variable
+var reloadableSettings = map[string]reloadableSetting{
+	"WEBHOOK_RETRY_ENABLED": {
+		current: func() string { return strconv.FormatBool(*webhookRetryEnabled) },
+		parse: func(v string) (string, func(), error) {
+			enabled := strings.ToLower(v) == "true" || v == "1"
+			return strconv.FormatBool(enabled), func() { *webhookRetryEnabled = enabled }, nil
+		},
+	},
+	"WEBHOOK_RETRY_COUNT": {
+		current: func() string { return strconv.Itoa(*webhookRetryCount) },
+		parse: func(v string) (string, func(), error) {
+			count, err := strconv.Atoi(v)
+			if err != nil || count < 1 {
+				return "", nil, errors.New("must be a number of at least 1")
+			}
+			return strconv.Itoa(count), func() { *webhookRetryCount = count }, nil
+		},
+	},
+	"WEBHOOK_RETRY_DELAY_SECONDS": {
+		current: func() string { return strconv.Itoa(*webhookRetryDelaySeconds) },
+		parse: func(v string) (string, func(), error) {
+			delay, err := strconv.Atoi(v)
+			if err != nil || delay < 0 {
+				return "", nil, errors.New("must be a number of seconds")
+			}
+			return strconv.Itoa(delay), func() { *webhookRetryDelaySeconds = delay }, nil
+		},
+	},
+	"WEBHOOK_ERROR_QUEUE_NAME": {
+		current: func() string { return *webhookErrorQueueName },
+		parse: func(v string) (string, func(), error) {
+			return v, func() { *webhookErrorQueueName = v }, nil
+		},
+	},
+}
+
+// restartOnlySettings are read once at startup. Changing them at runtime would
+// break encrypted data, signatures or open connections, so reloading them is
+// refused.
+var restartOnlySettings = []struct {
+	key     string
+	current func() string
+}{
+	{"WUZAPI_ADMIN_TOKEN", func() string { return *adminToken }},
+	{"WUZAPI_GLOBAL_ENCRYPTION_KEY", func() string { return *globalEncryptionKey }},
+	{"WUZAPI_GLOBAL_HMAC_KEY", func() string { return *globalHMACKey }},
+	{"WUZAPI_BASE_PATH", func() string { return *basePath }},
+	{"RABBITMQ_URL", func() string { return os.Getenv("RABBITMQ_URL") }},
+	{"RABBITMQ_QUEUE", func() string { return rabbitQueue }},
+}
+
+// isRestartOnlySetting reports whether key is one of restartOnlySettings
+func isRestartOnlySetting(key string) bool {
+	for _, setting := range restartOnlySettings {
+		if setting.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// configReloadSource returns the settings to reload, read from the .env file
+// and falling back to the process environment
+var configReloadSource = func() (func(string) string, error) {
+	values, err := godotenv.Read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return func(key string) string {
+		if v, ok := values[key]; ok {
+			return v
+		}
+		return os.Getenv(key)
+	}, nil
+}
+
+// configChange is a setting changed by a reload
+type configChange struct {
+	Key      string `json:"key"`
+	Previous string `json:"previous"`
+	Value    string `json:"value"`
+}
+
+// configReloadResult reports what a reload changed. RestartRequired lists the
+// restart-only settings whose configured value differs from the running one.
+type configReloadResult struct {
+	Changed         []configChange `json:"changed"`
+	RestartRequired []string       `json:"restart_required"`
+}
+
+// webhookRetrySettings returns the webhook retry settings in effect
+func webhookRetrySettings() (enabled bool, count int, delaySeconds int) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return *webhookRetryEnabled, *webhookRetryCount, *webhookRetryDelaySeconds
+}
+
+// webhookErrorQueue returns the RabbitMQ queue failed webhooks are sent to
+func webhookErrorQueue() string {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return *webhookErrorQueueName
+}
+
+// reloadConfig applies the values getenv returns for keys, or for every
+// reloadable setting when keys is empty. Nothing is applied when a key is
+// unknown, restart-only or has an invalid value.
+func reloadConfig(getenv func(string) string, keys []string) (*configReloadResult, error) {
+	if len(keys) == 0 {
+		for key := range reloadableSettings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+	}
+
+	type pendingChange struct {
+		change configChange
+		apply  func()
+	}
+	var pending []pendingChange
+	var reloaded []string
+
+	configMu.RLock()
+	for _, key := range keys {
+		setting, ok := reloadableSettings[key]
+		if !ok {
+			configMu.RUnlock()
+			if isRestartOnlySetting(key) {
+				return nil, fmt.Errorf("%s cannot be reloaded, restart wuzapi to change it", key)
+			}
+			return nil, fmt.Errorf("unknown setting %s", key)
+		}
+		v := strings.TrimSpace(getenv(key))
+		if v == "" {
+			continue
+		}
+		v, apply, err := setting.parse(v)
+		if err != nil {
+			configMu.RUnlock()
+			return nil, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		reloaded = append(reloaded, key)
+		if v == setting.current() {
+			continue
+		}
+		pending = append(pending, pendingChange{configChange{Key: key, Previous: setting.current(), Value: v}, apply})
+	}
+	configMu.RUnlock()
+
+	result := &configReloadResult{Changed: []configChange{}, RestartRequired: []string{}}
+	configMu.Lock()
+	for _, p := range pending {
+		p.apply()
+		result.Changed = append(result.Changed, p.change)
+	}
+	for _, key := range reloaded {
+		reloadedSettings[key] = true
+	}
+	configMu.Unlock()
+
+	for _, setting := range restartOnlySettings {
+		if v := strings.TrimSpace(getenv(setting.key)); v != "" && v != setting.current() {
+			result.RestartRequired = append(result.RestartRequired, setting.key)
+		}
+	}
+	return result, nil
+}
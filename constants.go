@@ -8,6 +8,10 @@ var supportedEventTypes = []string{
 	"Receipt",
 	"MediaRetry",
 	"ReadReceipt",
+	"PollResults",
+	"MessageSendFailed",
+	"KeepInChat",
+	"StatusReply",
 
 	// Groups and Contacts
 	"GroupInfo",
@@ -22,6 +26,8 @@ var supportedEventTypes = []string{
 	"ConnectFailure",
 	"KeepAliveRestored",
 	"KeepAliveTimeout",
+	"Reconnecting",
+	"ReconnectFailed",
 	"QRTimeout",
 	"LoggedOut",
 	"ClientOutdated",
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types"
 	_ "modernc.org/sqlite"
 )
 
@@ -135,14 +140,14 @@ type HistoryMessage struct {
 	DataJson        string    `json:"data_json" db:"datajson"`
 }
 
-func (s *server) saveMessageToHistory(userID, chatJID, senderJID, messageID, messageType, textContent, mediaLink, quotedMessageID, dataJson string) error {
-	query := `INSERT INTO message_history (user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content, media_link, quoted_message_id, datajson)
-              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+func (s *server) saveMessageToHistory(userID, chatJID, senderJID, messageID, messageType, textContent, mediaLink, quotedMessageID, dataJson string, fromMe bool) error {
+	query := `INSERT INTO message_history (user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content, media_link, quoted_message_id, datajson, from_me)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
 	if s.db.DriverName() == "sqlite" {
-		query = `INSERT INTO message_history (user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content, media_link, quoted_message_id, datajson)
-                 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		query = `INSERT INTO message_history (user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content, media_link, quoted_message_id, datajson, from_me)
+                 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	}
-	_, err := s.db.Exec(query, userID, chatJID, senderJID, messageID, time.Now(), messageType, textContent, mediaLink, quotedMessageID, dataJson)
+	_, err := s.db.Exec(query, userID, chatJID, senderJID, messageID, time.Now(), messageType, textContent, mediaLink, quotedMessageID, dataJson, fromMe)
 	if err != nil {
 		return fmt.Errorf("failed to save message to history: %w", err)
 	}
@@ -200,3 +205,287 @@ func (s *server) trimMessageHistory(userID, chatJID string, limit int) error {
 
 	return nil
 }
+
+// messageStatusRank orders the states of a sent message. A receipt never
+// moves a message back to an earlier state.
+var messageStatusRank = map[string]int{
+	"sent":      0,
+	"delivered": 1,
+	"read":      2,
+	"played":    3,
+}
+
+type MessageReceipt struct {
+	MessageID string    `json:"message_id" db:"message_id"`
+	ChatJID   string    `json:"chat_jid" db:"chat_jid"`
+	Status    string    `json:"status" db:"status"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// receiptStatus maps a receipt type to the message state it reports
+func receiptStatus(receiptType types.ReceiptType) (string, bool) {
+	switch receiptType {
+	case types.ReceiptTypeDelivered:
+		return "delivered", true
+	case types.ReceiptTypeRead:
+		return "read", true
+	case types.ReceiptTypePlayed:
+		return "played", true
+	}
+	return "", false
+}
+
+func (s *server) saveMessageReceipt(userID, chatJID, messageID, status string, at time.Time) error {
+	query := `INSERT INTO message_receipts (user_id, message_id, chat_jid, status, status_rank, updated_at)
+              VALUES ($1, $2, $3, $4, $5, $6)
+              ON CONFLICT (user_id, message_id) DO UPDATE SET status = excluded.status, status_rank = excluded.status_rank, updated_at = excluded.updated_at
+              WHERE message_receipts.status_rank < excluded.status_rank`
+	if s.db.DriverName() == "sqlite" {
+		query = `INSERT INTO message_receipts (user_id, message_id, chat_jid, status, status_rank, updated_at)
+                 VALUES (?, ?, ?, ?, ?, ?)
+                 ON CONFLICT (user_id, message_id) DO UPDATE SET status = excluded.status, status_rank = excluded.status_rank, updated_at = excluded.updated_at
+                 WHERE message_receipts.status_rank < excluded.status_rank`
+	}
+	_, err := s.db.Exec(query, userID, messageID, chatJID, status, messageStatusRank[status], at)
+	if err != nil {
+		return fmt.Errorf("failed to save message receipt: %w", err)
+	}
+	if status != "read" && status != "played" {
+		return nil
+	}
+	// Keep the read state on the message itself, the receipt is pruned later
+	query = `UPDATE message_history SET is_read = TRUE WHERE user_id = $1 AND message_id = $2`
+	if s.db.DriverName() == "sqlite" {
+		query = `UPDATE message_history SET is_read = 1 WHERE user_id = ? AND message_id = ?`
+	}
+	if _, err := s.db.Exec(query, userID, messageID); err != nil {
+		return fmt.Errorf("failed to mark message as read: %w", err)
+	}
+	return nil
+}
+
+// cleanupMessageReceipts removes the receipts of messages last updated more
+// than retention ago
+func (s *server) cleanupMessageReceipts(retention time.Duration) (int64, error) {
+	query := `DELETE FROM message_receipts WHERE updated_at < $1`
+	if s.db.DriverName() == "sqlite" {
+		query = `DELETE FROM message_receipts WHERE updated_at < ?`
+	}
+	res, err := s.db.Exec(query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// runReceiptCleanup prunes expired message receipts every hour
+func (s *server) runReceiptCleanup(retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if n, err := s.cleanupMessageReceipts(retention); err != nil {
+			log.Warn().Err(err).Msg("Failed to clean up message receipts")
+		} else if n > 0 {
+			log.Debug().Int64("removed", n).Msg("Cleaned up message receipts")
+		}
+		<-ticker.C
+	}
+}
+
+// pinnedChatCount returns how many chats are pinned for the device ownJID,
+// as synced into the whatsmeow chat settings. The settings live in the
+// whatsmeow store, which on SQLite is not the users database.
+func (s *server) pinnedChatCount(ownJID string) (int, error) {
+	if s.storeDB == nil {
+		return 0, errors.New("whatsmeow store database not available")
+	}
+	query := `SELECT COUNT(*) FROM whatsmeow_chat_settings WHERE our_jid = $1 AND pinned = true`
+	if s.storeDB.DriverName() == "sqlite" {
+		query = `SELECT COUNT(*) FROM whatsmeow_chat_settings WHERE our_jid = ? AND pinned = 1`
+	}
+	var count int
+	err := s.storeDB.Get(&count, query, ownJID)
+	return count, err
+}
+
+// storedDeviceCounts returns how many devices the whatsmeow store holds for
+// each account, keyed by phone number. Nothing is fetched from WhatsApp.
+func (s *server) storedDeviceCounts() (map[string]int, error) {
+	counts := make(map[string]int)
+	if s.storeDB == nil {
+		return counts, errors.New("whatsmeow store database not available")
+	}
+	var jids []string
+	if err := s.storeDB.Select(&jids, `SELECT jid FROM whatsmeow_device`); err != nil {
+		return counts, err
+	}
+	for _, raw := range jids {
+		jid, err := types.ParseJID(raw)
+		if err != nil {
+			continue
+		}
+		counts[jid.User]++
+	}
+	return counts, nil
+}
+
+// getMessageStatus returns the latest state of a message. Messages sent by
+// the user without any receipt yet are reported as sent when they are in the
+// message history.
+func (s *server) getMessageStatus(userID, messageID string) (*MessageReceipt, error) {
+	query := `SELECT message_id, chat_jid, status, updated_at FROM message_receipts WHERE user_id = $1 AND message_id = $2`
+	if s.db.DriverName() == "sqlite" {
+		query = `SELECT message_id, chat_jid, status, updated_at FROM message_receipts WHERE user_id = ? AND message_id = ?`
+	}
+	var receipt MessageReceipt
+	err := s.db.Get(&receipt, query, userID, messageID)
+	if err == nil {
+		return &receipt, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	query = `SELECT message_id, chat_jid, timestamp AS updated_at FROM message_history WHERE user_id = $1 AND message_id = $2 AND sender_jid = 'me'`
+	if s.db.DriverName() == "sqlite" {
+		query = `SELECT message_id, chat_jid, timestamp AS updated_at FROM message_history WHERE user_id = ? AND message_id = ? AND sender_jid = 'me'`
+	}
+	if err := s.db.Get(&receipt, query, userID, messageID); err != nil {
+		return nil, err
+	}
+	receipt.Status = "sent"
+	return &receipt, nil
+}
+
+// ChatMetadata is integration data (tags, notes, owner) attached to a chat.
+// It is stored locally and never sent to WhatsApp.
+type ChatMetadata struct {
+	ChatJID   string    `json:"jid"`
+	Tags      []string  `json:"tags"`
+	Notes     string    `json:"notes"`
+	Owner     string    `json:"owner"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// saveChatMetadata stores meta for a chat, replacing what was stored before
+func (s *server) saveChatMetadata(userID string, meta ChatMetadata) error {
+	if meta.Tags == nil {
+		meta.Tags = []string{}
+	}
+	tags, err := json.Marshal(meta.Tags)
+	if err != nil {
+		return fmt.Errorf("failed to encode chat tags: %w", err)
+	}
+
+	query := `INSERT INTO chat_metadata (user_id, chat_jid, tags, notes, owner, updated_at)
+              VALUES ($1, $2, $3, $4, $5, $6)
+              ON CONFLICT (user_id, chat_jid) DO UPDATE SET tags = excluded.tags, notes = excluded.notes, owner = excluded.owner, updated_at = excluded.updated_at`
+	if s.db.DriverName() == "sqlite" {
+		query = `INSERT INTO chat_metadata (user_id, chat_jid, tags, notes, owner, updated_at)
+                 VALUES (?, ?, ?, ?, ?, ?)
+                 ON CONFLICT (user_id, chat_jid) DO UPDATE SET tags = excluded.tags, notes = excluded.notes, owner = excluded.owner, updated_at = excluded.updated_at`
+	}
+	if _, err := s.db.Exec(query, userID, meta.ChatJID, string(tags), meta.Notes, meta.Owner, meta.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to save chat metadata: %w", err)
+	}
+	return nil
+}
+
+// getChatMetadata returns the metadata stored for a chat, or sql.ErrNoRows
+func (s *server) getChatMetadata(userID, chatJID string) (*ChatMetadata, error) {
+	query := `SELECT chat_jid, tags, notes, owner, updated_at FROM chat_metadata WHERE user_id = $1 AND chat_jid = $2`
+	if s.db.DriverName() == "sqlite" {
+		query = `SELECT chat_jid, tags, notes, owner, updated_at FROM chat_metadata WHERE user_id = ? AND chat_jid = ?`
+	}
+	var row struct {
+		ChatJID   string    `db:"chat_jid"`
+		Tags      string    `db:"tags"`
+		Notes     string    `db:"notes"`
+		Owner     string    `db:"owner"`
+		UpdatedAt time.Time `db:"updated_at"`
+	}
+	if err := s.db.Get(&row, query, userID, chatJID); err != nil {
+		return nil, err
+	}
+
+	meta := &ChatMetadata{ChatJID: row.ChatJID, Notes: row.Notes, Owner: row.Owner, UpdatedAt: row.UpdatedAt}
+	if err := json.Unmarshal([]byte(row.Tags), &meta.Tags); err != nil {
+		return nil, fmt.Errorf("failed to decode chat tags: %w", err)
+	}
+	return meta, nil
+}
+
+// ChatSummary is a chat in the recent conversations list, with the last
+// message stored for it in the message history
+type ChatSummary struct {
+	ChatJID     string          `json:"chat_jid"`
+	LastMessage ChatLastMessage `json:"last_message"`
+	UnreadCount int             `json:"unread_count"`
+}
+
+// ChatLastMessage is the last message of a chat, with its text cut down to a
+// preview
+type ChatLastMessage struct {
+	ID        string    `json:"id" db:"message_id"`
+	SenderJID string    `json:"sender_jid" db:"sender_jid"`
+	FromMe    bool      `json:"from_me" db:"from_me"`
+	Type      string    `json:"type" db:"message_type"`
+	Preview   string    `json:"preview" db:"text_content"`
+	Timestamp time.Time `json:"timestamp" db:"timestamp"`
+}
+
+// chatPreviewLength caps the length of the last message preview
+const chatPreviewLength = 100
+
+// latestChatMessageSQL matches the last stored message of each chat. An
+// incoming message is unread while nothing was sent in the chat after it and
+// it was not marked read by a receipt.
+const latestChatMessageSQL = `FROM message_history h
+              WHERE h.user_id = $1 AND h.timestamp >= $2 AND h.id = (
+                  SELECT l.id FROM message_history l
+                  WHERE l.user_id = h.user_id AND l.chat_jid = h.chat_jid
+                  ORDER BY l.timestamp DESC, l.id DESC LIMIT 1
+              )`
+
+// listChatSummaries returns the chats with messages since the given time, the
+// most recently active first, along with how many chats there are in total
+func (s *server) listChatSummaries(userID string, since time.Time, limit, offset int) ([]ChatSummary, int, error) {
+	countQuery := `SELECT COUNT(*) ` + latestChatMessageSQL
+	query := `SELECT h.chat_jid, h.message_id, h.sender_jid, h.from_me, h.message_type, COALESCE(h.text_content, '') AS text_content, h.timestamp,
+                  (SELECT COUNT(*) FROM message_history u
+                   WHERE u.user_id = h.user_id AND u.chat_jid = h.chat_jid AND NOT u.from_me AND NOT u.is_read
+                   AND NOT EXISTS (SELECT 1 FROM message_history o WHERE o.user_id = u.user_id AND o.chat_jid = u.chat_jid AND o.from_me AND o.timestamp >= u.timestamp)
+                  ) AS unread_count
+              ` + latestChatMessageSQL + `
+              ORDER BY h.timestamp DESC, h.id DESC
+              LIMIT $3 OFFSET $4`
+	if s.db.DriverName() == "sqlite" {
+		replacer := strings.NewReplacer("$1", "?", "$2", "?", "$3", "?", "$4", "?")
+		countQuery = replacer.Replace(countQuery)
+		query = replacer.Replace(query)
+	}
+
+	var total int
+	if err := s.db.Get(&total, countQuery, userID, since); err != nil {
+		return nil, 0, fmt.Errorf("failed to count chats: %w", err)
+	}
+
+	var rows []struct {
+		ChatJID string `db:"chat_jid"`
+		ChatLastMessage
+		UnreadCount int `db:"unread_count"`
+	}
+	if err := s.db.Select(&rows, query, userID, since, limit, offset); err != nil {
+		return nil, 0, fmt.Errorf("failed to list chats: %w", err)
+	}
+
+	chats := make([]ChatSummary, 0, len(rows))
+	for _, row := range rows {
+		last := row.ChatLastMessage
+		if preview := []rune(last.Preview); len(preview) > chatPreviewLength {
+			last.Preview = string(preview[:chatPreviewLength]) + "…"
+		}
+		chats = append(chats, ChatSummary{ChatJID: row.ChatJID, LastMessage: last, UnreadCount: row.UnreadCount})
+	}
+	return chats, total, nil
+}
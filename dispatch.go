@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"wuzapi/pkg/chatwoot"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// dispatchEvent is a WhatsApp event on its way to the sinks. Webhook-style
+// events carry Postmap (and JSON once marshalled); raw message events used by
+// integrations carry Message instead.
+type dispatchEvent struct {
+	Client  *MyClient
+	Type    string
+	Postmap map[string]interface{}
+	JSON    []byte
+	Path    string
+	Message *events.Message
+}
+
+// eventSink is a destination for events. Enabled is resolved per event, so a
+// sink decides from the user's settings whether it wants it.
+type eventSink interface {
+	Name() string
+	Enabled(ev *dispatchEvent) bool
+	Deliver(ev *dispatchEvent)
+}
+
+// eventDispatcher fans events out to the sinks enabled for them
+type eventDispatcher struct {
+	sinks []eventSink
+}
+
+func newEventDispatcher(sinks ...eventSink) *eventDispatcher {
+	return &eventDispatcher{sinks: sinks}
+}
+
+// dispatcher is the pipeline shared by every session, built once at startup
+var dispatcher = newEventDispatcher(
+	stdioSink{},
+	userWebhookSink{},
+	globalWebhookSink{},
+	globalRabbitSink{},
+	&chatwootSink{},
+)
+
+// enabledSinks returns the sinks that want ev, in registration order
+func (d *eventDispatcher) enabledSinks(ev *dispatchEvent) []eventSink {
+	var enabled []eventSink
+	for _, sink := range d.sinks {
+		if sink.Enabled(ev) {
+			enabled = append(enabled, sink)
+		}
+	}
+	return enabled
+}
+
+// deliver hands ev to every enabled sink
+func (d *eventDispatcher) deliver(ev *dispatchEvent) {
+	for _, sink := range d.enabledSinks(ev) {
+		sink.Deliver(ev)
+	}
+}
+
+// dispatchPostmap delivers a webhook-style event. The user's event
+// subscriptions are applied first, then the JSON payload is built once for
+// all enabled sinks.
+func (d *eventDispatcher) dispatchPostmap(mycli *MyClient, postmap map[string]interface{}, path string) {
+	// Get updated events from cache/database
+	subscribedEvents, err := updateAndGetUserSubscriptions(mycli)
+	if err != nil {
+		return
+	}
+
+	eventType, ok := postmap["type"].(string)
+	if !ok {
+		log.Error().Msg("Event type is not a string in postmap")
+		return
+	}
+
+	// Log subscription details for debugging
+	log.Debug().
+		Str("userID", mycli.userID).
+		Str("eventType", eventType).
+		Strs("subscribedEvents", subscribedEvents).
+		Msg("Checking event subscription")
+
+	// Check if the current event is in the subscriptions
+	if !checkIfSubscribedToEvent(subscribedEvents, eventType, mycli.userID) {
+		return
+	}
+
+	ev := &dispatchEvent{Client: mycli, Type: eventType, Postmap: postmap, Path: path}
+	sinks := d.enabledSinks(ev)
+	if len(sinks) == 0 {
+		log.Debug().Str("userID", mycli.userID).Str("eventType", eventType).Msg("No sink enabled for event")
+		return
+	}
+
+	addMessageContent(postmap)
+
+	if *webhookRawEvent {
+		if raw, ok := rawEventPayload(postmap["event"]); ok {
+			postmap["raw"] = raw
+		}
+	}
+
+	// Prepare webhook data
+	ev.JSON, err = json.Marshal(postmap)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal postmap to JSON")
+		return
+	}
+
+	for _, sink := range sinks {
+		sink.Deliver(ev)
+	}
+}
+
+// rawEventPayload encodes the protobuf carried by evt, which the JSON form of
+// the event does not always preserve. Only message and history sync events
+// carry one.
+func rawEventPayload(evt interface{}) (map[string]interface{}, bool) {
+	var msg proto.Message
+	switch evt := evt.(type) {
+	case *events.Message:
+		if evt.RawMessage != nil {
+			msg = evt.RawMessage
+		} else if evt.Message != nil {
+			msg = evt.Message
+		}
+	case *events.HistorySync:
+		if evt.Data != nil {
+			msg = evt.Data
+		}
+	}
+	if msg == nil {
+		return nil, false
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal raw event protobuf")
+		return nil, false
+	}
+	return map[string]interface{}{
+		"type": string(proto.MessageName(msg)),
+		"data": base64.StdEncoding.EncodeToString(data),
+	}, true
+}
+
+// isStdioEvent reports whether ev belongs to a server running in stdio mode,
+// where notifications replace all HTTP and queue deliveries
+func isStdioEvent(ev *dispatchEvent) bool {
+	return ev.Client != nil && ev.Client.s != nil && ev.Client.s.mode == Stdio
+}
+
+// stdioSink sends events as JSON-RPC notifications in stdio mode
+type stdioSink struct{}
+
+func (stdioSink) Name() string { return "stdio" }
+
+func (stdioSink) Enabled(ev *dispatchEvent) bool {
+	return ev.Postmap != nil && isStdioEvent(ev)
+}
+
+func (stdioSink) Deliver(ev *dispatchEvent) {
+	ev.Client.s.SendNotification(ev.Type, ev.Postmap)
+}
+
+// userWebhookSink posts events to the webhook configured for the user
+type userWebhookSink struct{}
+
+func (userWebhookSink) Name() string { return "user_webhook" }
+
+func (userWebhookSink) Enabled(ev *dispatchEvent) bool {
+	return ev.Postmap != nil && !isStdioEvent(ev) && getUserWebhookUrl(ev.Client.token) != ""
+}
+
+func (userWebhookSink) Deliver(ev *dispatchEvent) {
+	mycli := ev.Client
+
+	// Get HMAC key for this user
+	var encryptedHmacKey []byte
+	if userinfo, found := userinfocache.Get(mycli.token); found {
+		encryptedB64 := userinfo.(Values).Get("HmacKeyEncrypted")
+		if encryptedB64 != "" {
+			var err error
+			encryptedHmacKey, err = base64.StdEncoding.DecodeString(encryptedB64)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to decode HMAC key from cache")
+			}
+		}
+	}
+
+	sendToUserWebHookWithHmac(getUserWebhookUrl(mycli.token), ev.Path, ev.JSON, mycli.userID, mycli.token, encryptedHmacKey)
+}
+
+// globalWebhookSink posts events of every user to the global webhook
+type globalWebhookSink struct{}
+
+func (globalWebhookSink) Name() string { return "global_webhook" }
+
+func (globalWebhookSink) Enabled(ev *dispatchEvent) bool {
+	return ev.Postmap != nil && !isStdioEvent(ev) && *globalWebhook != ""
+}
+
+func (globalWebhookSink) Deliver(ev *dispatchEvent) {
+	go sendToGlobalWebHook(ev.JSON, ev.Client.token, ev.Client.userID)
+}
+
+// globalRabbitSink publishes events to the global RabbitMQ queue. It stays
+// enabled while RabbitMQ is configured but disconnected so the failure is
+// logged per event.
+type globalRabbitSink struct{}
+
+func (globalRabbitSink) Name() string { return "rabbitmq" }
+
+func (globalRabbitSink) Enabled(ev *dispatchEvent) bool {
+	if ev.Postmap == nil || isStdioEvent(ev) {
+		return false
+	}
+	return rabbitEnabled || os.Getenv("RABBITMQ_URL") != "" || os.Getenv("RABBITMQ_QUEUE") != ""
+}
+
+func (globalRabbitSink) Deliver(ev *dispatchEvent) {
+	go sendToGlobalRabbit(ev.JSON, ev.Client.token, ev.Client.userID)
+}
+
+// chatwootSink forwards incoming messages to Chatwoot for users with an
+// enabled Chatwoot configuration. A single Chatwoot service is shared by
+// all sessions so its caches survive between messages.
+type chatwootSink struct {
+	once    sync.Once
+	service *chatwoot.Service
+}
+
+func (c *chatwootSink) Name() string { return "chatwoot" }
+
+// Service returns the shared Chatwoot service, creating it on first use
+func (c *chatwootSink) Service(db *sqlx.DB) *chatwoot.Service {
+	c.once.Do(func() {
+		c.service = chatwoot.NewService(db)
+	})
+	return c.service
+}
+
+func (c *chatwootSink) Enabled(ev *dispatchEvent) bool {
+	if ev.Message == nil || ev.Client == nil || ev.Client.db == nil {
+		return false
+	}
+
+	var enabled bool
+	query := `SELECT enabled FROM chatwoot_config WHERE user_id = $1`
+	if ev.Client.db.DriverName() == "sqlite" {
+		query = strings.Replace(query, "$1", "?", 1)
+	}
+	if err := ev.Client.db.Get(&enabled, query, ev.Client.userID); err != nil {
+		return false
+	}
+	return enabled
+}
+
+func (c *chatwootSink) Deliver(ev *dispatchEvent) {
+	evt := ev.Message
+
+	// Check dedupe cache first - skip if this message was sent via Chatwoot API
+	if _, exists := messageDedupeCache.Load(evt.Info.ID); exists {
+		log.Debug().
+			Str("message_id", evt.Info.ID).
+			Msg("Message ID found in dedupe cache, skipping Chatwoot forward (loop prevention)")
+
+		// Schedule cleanup after 5 minutes
+		go func(id string) {
+			time.Sleep(5 * time.Minute)
+			messageDedupeCache.Delete(id)
+			log.Debug().Str("message_id", id).Msg("Removed message from dedupe cache")
+		}(evt.Info.ID)
+
+		return
+	}
+
+	if err := c.Service(ev.Client.db).HandleIncomingMessage(ev.Client.userID, evt, ev.Client.WAClient); err != nil {
+		log.Debug().Err(err).Str("message_id", evt.Info.ID).Msg("Chatwoot forwarding error")
+	}
+}
+
+// chatwootService returns the Chatwoot service shared through the dispatcher
+func chatwootService(db *sqlx.DB) *chatwoot.Service {
+	for _, sink := range dispatcher.sinks {
+		if cw, ok := sink.(*chatwootSink); ok {
+			return cw.Service(db)
+		}
+	}
+	return chatwoot.NewService(db)
+}
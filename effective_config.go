@@ -0,0 +1,237 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// Where an effective setting comes from
+const (
+	sourceDefault = "default"
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceUser    = "user"
+)
+
+// maskedSecret replaces secrets in the effective configuration
+const maskedSecret = "***"
+
+// flagWasSet reports whether the named flag was given on the command line
+var flagWasSet = func(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// effectiveSetting is a setting in effect for a user and where it comes from
+type effectiveSetting struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// envSetting describes an instance setting whose environment variable
+// overrides its flag, as most settings are read in main
+func envSetting(value interface{}, flagName, envName string) effectiveSetting {
+	if envName != "" && (strings.TrimSpace(os.Getenv(envName)) != "" || settingReloaded(envName)) {
+		return effectiveSetting{value, sourceEnv}
+	}
+	if flagName != "" && flagWasSet(flagName) {
+		return effectiveSetting{value, sourceFlag}
+	}
+	return effectiveSetting{value, sourceDefault}
+}
+
+// flagSetting describes an instance setting whose flag takes precedence,
+// the environment variable only being read when the flag is empty
+func flagSetting(value interface{}, flagName, envName string) effectiveSetting {
+	if flagWasSet(flagName) {
+		return effectiveSetting{value, sourceFlag}
+	}
+	return envSetting(value, "", envName)
+}
+
+// userSetting describes a per-user setting. The user's value wins when set,
+// otherwise the instance setting applies.
+func userSetting(value interface{}, set bool, instance effectiveSetting) effectiveSetting {
+	if set {
+		return effectiveSetting{value, sourceUser}
+	}
+	return instance
+}
+
+// masked hides the value of a secret setting, keeping whether it is set
+func (e effectiveSetting) masked() effectiveSetting {
+	if v, ok := e.Value.(string); ok && v != "" {
+		e.Value = maskedSecret
+	}
+	return e
+}
+
+// userConfigRow holds the per-user settings of the users table
+type userConfigRow struct {
+	Webhook          string `db:"webhook"`
+	Events           string `db:"events"`
+	WebhookTemplate  string `db:"webhook_template"`
+	HasHmacKey       bool   `db:"has_hmac_key"`
+	MediaDelivery    string `db:"media_delivery"`
+	S3Enabled        bool   `db:"s3_enabled"`
+	S3Endpoint       string `db:"s3_endpoint"`
+	S3Bucket         string `db:"s3_bucket"`
+	S3AccessKey      string `db:"s3_access_key"`
+	S3SecretKey      string `db:"s3_secret_key"`
+	History          int    `db:"history"`
+	AutoRead         bool   `db:"auto_read"`
+	ReconnectEnabled bool   `db:"reconnect_enabled"`
+	ReconnectMax     int    `db:"reconnect_max_attempts"`
+}
+
+// chatwootConfigRow holds the Chatwoot settings of a user, when configured
+type chatwootConfigRow struct {
+	Enabled bool   `db:"enabled"`
+	URL     string `db:"url"`
+	Token   string `db:"token"`
+}
+
+// effectiveConfig resolves the settings in effect for a user from the
+// defaults, the instance flags and environment and the user's own settings.
+// chatwoot is nil when the user has not configured Chatwoot.
+func effectiveConfig(user userConfigRow, chatwoot *chatwootConfigRow) map[string]map[string]effectiveSetting {
+	retryEnabled, retryCount, retryDelay := webhookRetrySettings()
+
+	format := os.Getenv("WEBHOOK_FORMAT")
+	if format != "json" {
+		format = "form"
+	}
+
+	hmacKey := ""
+	if user.HasHmacKey {
+		hmacKey = maskedSecret
+	}
+
+	chatwootSettings := map[string]effectiveSetting{
+		"enabled":               {false, sourceDefault},
+		"url":                   {"", sourceDefault},
+		"token":                 {"", sourceDefault},
+		"max_media_mb":          envSetting(*chatwootMaxMediaMB, "chatwootmaxmedia", "CHATWOOT_MAX_MEDIA_MB"),
+		"media_timeout_seconds": envSetting(*chatwootMediaTimeout, "chatwootmediatimeout", "CHATWOOT_MEDIA_TIMEOUT_SECONDS"),
+		"upload_attempts":       envSetting(*chatwootUploadTries, "chatwootuploadattempts", "CHATWOOT_MEDIA_UPLOAD_ATTEMPTS"),
+		"inbox_create_attempts": envSetting(*chatwootInboxTries, "chatwootinboxattempts", "CHATWOOT_INBOX_CREATE_ATTEMPTS"),
+		"outgoing_dedupe":       envSetting(*chatwootOutDedupe, "chatwootoutdedupe", "CHATWOOT_OUTGOING_DEDUPE_SECONDS"),
+		"phone_region":          envSetting(*phoneRegion, "phoneregion", "PHONE_DEFAULT_REGION"),
+	}
+	if chatwoot != nil {
+		chatwootSettings["enabled"] = effectiveSetting{chatwoot.Enabled, sourceUser}
+		chatwootSettings["url"] = effectiveSetting{chatwoot.URL, sourceUser}
+		chatwootSettings["token"] = effectiveSetting{chatwoot.Token, sourceUser}.masked()
+	}
+
+	return map[string]map[string]effectiveSetting{
+		"webhook": {
+			"url":      userSetting(user.Webhook, user.Webhook != "", effectiveSetting{"", sourceDefault}),
+			"events":   userSetting(user.Events, user.Events != "", effectiveSetting{"", sourceDefault}),
+			"format":   envSetting(format, "", "WEBHOOK_FORMAT"),
+			"template": userSetting(user.WebhookTemplate, user.WebhookTemplate != "", effectiveSetting{"", sourceDefault}),
+			"hmac_key": userSetting(hmacKey, user.HasHmacKey, effectiveSetting{"", sourceDefault}),
+			"naming":   envSetting(string(payloadNaming), "webhooknaming", "WEBHOOK_FIELD_NAMING"),
+			"ordered":  envSetting(*webhookOrdered, "webhookordered", "WEBHOOK_ORDERED"),
+			"dedupe":   envSetting(*webhookDedupe, "webhookdedupe", "WEBHOOK_DEDUPE"),
+			"raw":      envSetting(*webhookRawEvent, "rawevent", "WEBHOOK_RAW_EVENT"),
+			"history":  envSetting(*webhookHistory, "webhookhistory", "WEBHOOK_HISTORY"),
+			"global":   flagSetting(*globalWebhook, "globalwebhook", "WUZAPI_GLOBAL_WEBHOOK"),
+		},
+		"webhook_retry": {
+			"enabled":       envSetting(retryEnabled, "webhookretry", "WEBHOOK_RETRY_ENABLED"),
+			"count":         envSetting(retryCount, "retrycount", "WEBHOOK_RETRY_COUNT"),
+			"delay_seconds": envSetting(retryDelay, "retrydelay", "WEBHOOK_RETRY_DELAY_SECONDS"),
+			"error_queue":   envSetting(webhookErrorQueue(), "errorqueue", "WEBHOOK_ERROR_QUEUE_NAME"),
+		},
+		"webhook_client": {
+			"timeout_seconds":      envSetting(*webhookTimeout, "webhooktimeout", "WEBHOOK_TIMEOUT_SECONDS"),
+			"dial_timeout_seconds": envSetting(*webhookDialTimeout, "webhookdialtimeout", "WEBHOOK_DIAL_TIMEOUT_SECONDS"),
+			"keepalive_seconds":    envSetting(*webhookKeepAlive, "webhookkeepalive", "WEBHOOK_KEEPALIVE_SECONDS"),
+		},
+		"media": {
+			"delivery":      userSetting(user.MediaDelivery, user.MediaDelivery != "" && user.MediaDelivery != "base64", effectiveSetting{"base64", sourceDefault}),
+			"skip_download": envSetting(*skipMedia, "skipmedia", ""),
+			"s3_enabled":    userSetting(user.S3Enabled, user.S3Enabled, effectiveSetting{false, sourceDefault}),
+			"s3_endpoint":   userSetting(user.S3Endpoint, user.S3Endpoint != "", effectiveSetting{"", sourceDefault}),
+			"s3_bucket":     userSetting(user.S3Bucket, user.S3Bucket != "", effectiveSetting{"", sourceDefault}),
+			"s3_access_key": userSetting(user.S3AccessKey, user.S3AccessKey != "", effectiveSetting{"", sourceDefault}).masked(),
+			"s3_secret_key": userSetting(user.S3SecretKey, user.S3SecretKey != "", effectiveSetting{"", sourceDefault}).masked(),
+		},
+		"chatwoot": chatwootSettings,
+		"session": {
+			"history":                userSetting(user.History, user.History != 0, effectiveSetting{0, sourceDefault}),
+			"auto_read":              userSetting(user.AutoRead, user.AutoRead, effectiveSetting{false, sourceDefault}),
+			"reconnect_enabled":      userSetting(user.ReconnectEnabled, !user.ReconnectEnabled, effectiveSetting{true, sourceDefault}),
+			"reconnect_max_attempts": userSetting(user.ReconnectMax, user.ReconnectMax != 0, effectiveSetting{0, sourceDefault}),
+		},
+		"limits": {
+			"max_sessions":       envSetting(*maxSessions, "maxsessions", "WUZAPI_MAX_SESSIONS"),
+			"max_text_length":    envSetting(*maxTextLength, "maxtextlength", "MAX_TEXT_LENGTH"),
+			"text_length_policy": envSetting(*textLengthPolicy, "textlengthpolicy", "TEXT_LENGTH_POLICY"),
+			"send_rate":          envSetting(*sendRate, "sendrate", "SEND_RATE_LIMIT"),
+			"send_burst":         envSetting(*sendBurst, "sendburst", "SEND_RATE_BURST"),
+		},
+	}
+}
+
+// UserEffectiveConfig returns the settings in effect for a user and where
+// each comes from, with secrets masked
+func (s *server) UserEffectiveConfig() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)["id"]
+
+		var user userConfigRow
+		err := s.db.Get(&user, `SELECT COALESCE(webhook, '') AS webhook, COALESCE(events, '') AS events,
+			COALESCE(webhook_template, '') AS webhook_template,
+			hmac_key IS NOT NULL AND length(hmac_key) > 0 AS has_hmac_key,
+			COALESCE(media_delivery, '') AS media_delivery, COALESCE(s3_enabled, FALSE) AS s3_enabled,
+			COALESCE(s3_endpoint, '') AS s3_endpoint, COALESCE(s3_bucket, '') AS s3_bucket,
+			COALESCE(s3_access_key, '') AS s3_access_key, COALESCE(s3_secret_key, '') AS s3_secret_key,
+			COALESCE(history, 0) AS history, COALESCE(auto_read, FALSE) AS auto_read,
+			COALESCE(reconnect_enabled, TRUE) AS reconnect_enabled,
+			COALESCE(reconnect_max_attempts, 0) AS reconnect_max_attempts
+			FROM users WHERE id = $1`, userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				s.Respond(w, r, http.StatusNotFound, errors.New("user not found"))
+				return
+			}
+			log.Error().Err(err).Str("userID", userID).Msg("admin DB error")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("problem accessing DB"))
+			return
+		}
+
+		var chatwoot *chatwootConfigRow
+		var row chatwootConfigRow
+		err = s.db.Get(&row, "SELECT COALESCE(enabled, FALSE) AS enabled, url, token FROM chatwoot_config WHERE user_id = $1", userID)
+		switch {
+		case err == nil:
+			chatwoot = &row
+		case !errors.Is(err, sql.ErrNoRows):
+			log.Error().Err(err).Str("userID", userID).Msg("admin DB error")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("problem accessing DB"))
+			return
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{"id": userID, "config": effectiveConfig(user, chatwoot)})
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
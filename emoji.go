@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxEmojiRunes bounds a single emoji, long enough for ZWJ sequences such
+// as families with skin tones
+const maxEmojiRunes = 16
+
+// isEmojiBase reports whether r is a pictograph that can start or make up an
+// emoji
+func isEmojiBase(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF: // pictographs, flags and skin tones
+		return true
+	case r >= 0x2600 && r <= 0x27BF: // miscellaneous symbols and dingbats
+		return true
+	case r >= 0x2300 && r <= 0x23FF, r >= 0x2B00 && r <= 0x2BFF:
+		return true
+	case r >= 0x2190 && r <= 0x21FF, r >= 0x2100 && r <= 0x214F:
+		return true
+	}
+	switch r {
+	case 0x00A9, 0x00AE, 0x203C, 0x2049, 0x3030, 0x303D, 0x3297, 0x3299:
+		return true
+	}
+	return false
+}
+
+// isEmojiComponent reports whether r only modifies the emoji before it
+func isEmojiComponent(r rune) bool {
+	return r == 0xFE0E || r == 0xFE0F || r == 0x20E3 || (r >= 0xE0020 && r <= 0xE007F)
+}
+
+// isSkinTone reports whether r is one of the Fitzpatrick skin tone modifiers
+func isSkinTone(r rune) bool {
+	return r >= 0x1F3FB && r <= 0x1F3FF
+}
+
+// isRegionalIndicator reports whether r is a letter of a flag emoji
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// isEmoji reports whether s is a single emoji, including ZWJ sequences,
+// skin tones, flags and keycaps such as 1️⃣
+func isEmoji(s string) bool {
+	if s == "" || utf8.RuneCountInString(s) > maxEmojiRunes {
+		return false
+	}
+	keycap := strings.ContainsRune(s, 0x20E3)
+
+	emojis := 0
+	joined := false // the previous rune was a zero width joiner
+	flagOpen := false
+	for _, r := range s {
+		switch {
+		case r == 0x200D:
+			if emojis == 0 || joined {
+				return false
+			}
+			joined = true
+			flagOpen = false
+			continue
+		case isRegionalIndicator(r):
+			// Two regional indicators make a single flag
+			if flagOpen {
+				flagOpen = false
+			} else {
+				flagOpen = true
+				if !joined {
+					emojis++
+				}
+			}
+		case isSkinTone(r) && emojis > 0 && !joined:
+			flagOpen = false
+		case isEmojiBase(r), keycap && (r >= '0' && r <= '9' || r == '#' || r == '*'):
+			flagOpen = false
+			if !joined {
+				emojis++
+			}
+		case isEmojiComponent(r):
+			if emojis == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+		joined = false
+	}
+	return emojis == 1 && !joined
+}
+
+// normalizeReaction validates a reaction, which is a single emoji or empty to
+// remove the reaction. Emojis are sent in their emoji presentation, as the
+// WhatsApp apps do, so ❤ and ❤️ are the same reaction.
+func normalizeReaction(reaction string) (string, error) {
+	reaction = strings.TrimSpace(reaction)
+	if reaction == "" || reaction == "remove" {
+		return "", nil
+	}
+	if !isEmoji(reaction) {
+		return "", errors.New("invalid reaction, Body must be a single emoji or empty to remove the reaction")
+	}
+
+	reaction = strings.ReplaceAll(reaction, "\uFE0E", "\uFE0F")
+	if r, _ := utf8.DecodeRuneInString(reaction); utf8.RuneCountInString(reaction) == 1 && r < 0x1F000 {
+		reaction += "\uFE0F"
+	}
+	return reaction, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ffmpegBinary resolves the ffmpeg configured with -ffmpeg, which may be a
+// path or a name looked up in PATH
+func ffmpegBinary() (string, error) {
+	path, err := exec.LookPath(*ffmpegPath)
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg is not available at %q, install it or set FFMPEG_PATH: %w", *ffmpegPath, err)
+	}
+	return path, nil
+}
+
+// ffmpegVersion returns the first line of the version of the configured
+// ffmpeg, failing when it is missing or does not run
+func ffmpegVersion() (string, error) {
+	path, err := ffmpegBinary()
+	if err != nil {
+		return "", err
+	}
+	out, err := exec.Command(path, "-version").Output()
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg at %q does not run: %w", path, err)
+	}
+	line, _, _ := bufio.NewReader(bytes.NewReader(out)).ReadLine()
+	return strings.TrimSpace(string(line)), nil
+}
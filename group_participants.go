@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// groupParticipantsTTL is how long the resolved participants of a group are
+// served from the cache
+const groupParticipantsTTL = 5 * time.Minute
+
+// groupParticipantsCache holds the resolved participants of each group, by
+// user and group JID
+var groupParticipantsCache = cache.New(groupParticipantsTTL, 10*time.Minute)
+
+// contactGetter is the part of the whatsmeow contact store used to read push
+// names
+type contactGetter interface {
+	GetContact(ctx context.Context, user types.JID) (types.ContactInfo, error)
+}
+
+// groupParticipant is a participant of a group with both of its identifiers.
+// Jid or Lid is nil when the counterpart is unknown.
+type groupParticipant struct {
+	Jid          *string `json:"jid"`
+	Lid          *string `json:"lid"`
+	PushName     string  `json:"push_name,omitempty"`
+	IsAdmin      bool    `json:"is_admin"`
+	IsSuperAdmin bool    `json:"is_super_admin"`
+}
+
+// groupParticipantsResult is the resolution of the participants of a group.
+// Unresolved counts the participants whose phone number is unknown.
+type groupParticipantsResult struct {
+	Group        string             `json:"group"`
+	Participants []groupParticipant `json:"participants"`
+	Total        int                `json:"total"`
+	Unresolved   int                `json:"unresolved"`
+}
+
+// resolveGroupParticipants maps every participant to its phone number JID
+// and LID, taking them from the group info and resolving whichever is
+// missing with the LID store. Participants that cannot be resolved are
+// returned with the identifier known.
+func resolveGroupParticipants(ctx context.Context, userID string, resolver lidResolver, contacts contactGetter, participants []types.GroupParticipant) ([]groupParticipant, int) {
+	resolved := make([]groupParticipant, 0, len(participants))
+	unresolved := 0
+
+	for _, p := range participants {
+		pn, lid := p.PhoneNumber, p.LID
+		switch p.JID.Server {
+		case types.HiddenUserServer:
+			if lid.IsEmpty() {
+				lid = p.JID
+			}
+		case types.DefaultUserServer:
+			if pn.IsEmpty() {
+				pn = p.JID
+			}
+		}
+
+		participant := groupParticipant{IsAdmin: p.IsAdmin, IsSuperAdmin: p.IsSuperAdmin}
+		if !pn.IsEmpty() {
+			jid := pn.ToNonAD().String()
+			participant.Jid = &jid
+		}
+		if !lid.IsEmpty() {
+			lidStr := lid.ToNonAD().String()
+			participant.Lid = &lidStr
+		}
+
+		if (participant.Jid == nil) != (participant.Lid == nil) {
+			known := participant.Jid
+			if known == nil {
+				known = participant.Lid
+			}
+			if mapping := resolveLIDMappings(ctx, userID, resolver, []string{*known}); len(mapping) == 1 {
+				participant.Jid, participant.Lid = mapping[0].Jid, mapping[0].Lid
+			}
+		}
+		if participant.Jid == nil {
+			unresolved++
+		}
+
+		for _, id := range []*string{participant.Jid, participant.Lid} {
+			if id == nil || participant.PushName != "" {
+				continue
+			}
+			if jid, ok := parseJID(*id); ok {
+				if info, err := contacts.GetContact(ctx, jid); err == nil {
+					participant.PushName = info.PushName
+				}
+			}
+		}
+		resolved = append(resolved, participant)
+	}
+	return resolved, unresolved
+}
+
+// Gets the participants of a group with their phone number JID, LID and push name
+func (s *server) GetGroupParticipants() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		groupJID := r.URL.Query().Get("groupJID")
+		if groupJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing groupJID parameter"))
+			return
+		}
+		group, ok := parseJID(groupJID)
+		if !ok || group.Server != types.GroupServer {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			return
+		}
+
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		cacheKey := txtid + "|" + group.String()
+		var result *groupParticipantsResult
+		if cached, found := groupParticipantsCache.Get(cacheKey); found && r.URL.Query().Get("refresh") != "true" {
+			result = cached.(*groupParticipantsResult)
+		} else {
+			ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+			defer cancel()
+			info, err := client.GetGroupInfo(ctx, group)
+			if err != nil {
+				log.Error().Err(err).Str("group", group.String()).Msg("Failed to get group info")
+				s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("failed to get group info: %v", err))
+				return
+			}
+			participants, unresolved := resolveGroupParticipants(ctx, txtid, client.Store.LIDs, client.Store.Contacts, info.Participants)
+			result = &groupParticipantsResult{
+				Group:        group.String(),
+				Participants: participants,
+				Total:        len(participants),
+				Unresolved:   unresolved,
+			}
+			groupParticipantsCache.Set(cacheKey, result, cache.DefaultExpiration)
+		}
+
+		responseJson, err := json.Marshal(result)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
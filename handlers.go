@@ -8,13 +8,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"image"
 	"image/jpeg"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -23,6 +26,7 @@ import (
 	"github.com/nfnt/resize"
 	"github.com/patrickmn/go-cache"
 	"github.com/rs/zerolog/log"
+	"github.com/skip2/go-qrcode"
 	"github.com/vincent-petithory/dataurl"
 	"go.mau.fi/whatsmeow"
 
@@ -31,6 +35,7 @@ import (
 
 	"go.mau.fi/whatsmeow/appstate"
 	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -237,6 +242,10 @@ func (s *server) Connect() http.HandlerFunc {
 				s.Respond(w, r, http.StatusInternalServerError, errors.New("already connected"))
 				return
 			}
+		} else if !clientManager.ReserveSession(txtid, *maxSessions) {
+			log.Warn().Str("userID", txtid).Int("max_sessions", *maxSessions).Msg("Refusing connect, session limit reached")
+			s.Respond(w, r, http.StatusServiceUnavailable, errors.New("maximum number of sessions reached"))
+			return
 		}
 
 		var subscribedEvents []string
@@ -400,6 +409,7 @@ func (s *server) DeleteWebhook() http.HandlerFunc {
 		v := updateUserInfo(r.Context().Value("userinfo"), "Webhook", "")
 		v = updateUserInfo(v, "Events", "")
 		userinfocache.Set(token, v, cache.NoExpiration)
+		s.recordWebhookChange(txtid, webhookActionDelete)
 
 		response := map[string]interface{}{"Details": "Webhook and events deleted successfully"}
 		responseJson, err := json.Marshal(response)
@@ -431,6 +441,10 @@ func (s *server) UpdateWebhook() http.HandlerFunc {
 		}
 
 		webhook := t.WebhookURL
+		if err := validateWebhookURL(webhook); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
 
 		var eventstring string
 		var validEvents []string
@@ -472,6 +486,7 @@ func (s *server) UpdateWebhook() http.HandlerFunc {
 		v := updateUserInfo(r.Context().Value("userinfo"), "Webhook", webhook)
 		v = updateUserInfo(v, "Events", eventstring)
 		userinfocache.Set(token, v, cache.NoExpiration)
+		s.recordWebhookChange(txtid, webhookActionUpdate)
 
 		response := map[string]interface{}{"webhook": webhook, "events": validEvents, "active": t.Active}
 		responseJson, err := json.Marshal(response)
@@ -483,6 +498,93 @@ func (s *server) UpdateWebhook() http.HandlerFunc {
 	}
 }
 
+// SetWebhookTemplate sets the template used to reshape the user's webhook payloads
+func (s *server) SetWebhookTemplate() http.HandlerFunc {
+	type webhookTemplateStruct struct {
+		Template string `json:"template"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		decoder := json.NewDecoder(r.Body)
+		var t webhookTemplateStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Template == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing template in payload"))
+			return
+		}
+
+		if _, err := parseWebhookTemplate(t.Template); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New(fmt.Sprintf("invalid template: %v", err)))
+			return
+		}
+
+		_, err = s.db.Exec("UPDATE users SET webhook_template=$1 WHERE id=$2", t.Template, txtid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not set webhook template: %v", err)))
+			return
+		}
+		setWebhookTemplate(txtid, t.Template)
+
+		response := map[string]interface{}{"Details": "Webhook template set successfully"}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// GetWebhookTemplate returns the template used to reshape the user's webhook payloads
+func (s *server) GetWebhookTemplate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		var text string
+		err := s.db.Get(&text, "SELECT COALESCE(webhook_template, '') FROM users WHERE id=$1", txtid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not get webhook template: %v", err)))
+			return
+		}
+
+		response := map[string]interface{}{"template": text}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// DeleteWebhookTemplate removes the payload template so raw events are sent again
+func (s *server) DeleteWebhookTemplate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		_, err := s.db.Exec("UPDATE users SET webhook_template='' WHERE id=$1", txtid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not delete webhook template: %v", err)))
+			return
+		}
+		setWebhookTemplate(txtid, "")
+
+		response := map[string]interface{}{"Details": "Webhook template deleted successfully"}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
 // SetWebhook sets the webhook URL and events for a user
 func (s *server) SetWebhook() http.HandlerFunc {
 	type webhookStruct struct {
@@ -502,6 +604,10 @@ func (s *server) SetWebhook() http.HandlerFunc {
 		}
 
 		webhook := t.WebhookURL
+		if err := validateWebhookURL(webhook); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
 
 		// If events are provided, validate them
 		var eventstring string
@@ -540,6 +646,7 @@ func (s *server) SetWebhook() http.HandlerFunc {
 		v := updateUserInfo(r.Context().Value("userinfo"), "Webhook", webhook)
 		v = updateUserInfo(v, "Events", eventstring)
 		userinfocache.Set(token, v, cache.NoExpiration)
+		s.recordWebhookChange(txtid, webhookActionSet)
 
 		response := map[string]interface{}{"webhook": webhook}
 		responseJson, err := json.Marshal(response)
@@ -592,6 +699,9 @@ func (s *server) GetQR() http.HandlerFunc {
 
 		log.Info().Str("instance", txtid).Str("qrcode", code).Msg("Get QR successful")
 		response := map[string]interface{}{"QRCode": fmt.Sprintf("%s", code)}
+		if expiresIn, ok := qrExpiresIn(txtid); ok && code != "" {
+			response["ExpiresIn"] = expiresIn
+		}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
@@ -623,6 +733,8 @@ func (s *server) Logout() http.HandlerFunc {
 				} else {
 					log.Info().Str("jid", jid).Msg("Logged out")
 					clientManager.DeleteWhatsmeowClient(txtid)
+					clientManager.ClearActivity(txtid)
+					qrExpiry.Delete(txtid)
 					killchannel[txtid] <- true
 				}
 			} else {
@@ -811,6 +923,7 @@ func (s *server) SendDocument() http.HandlerFunc {
 		Id          string
 		MimeType    string
 		ContextInfo waE2E.ContextInfo
+		quoteParams
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -819,8 +932,7 @@ func (s *server) SendDocument() http.HandlerFunc {
 		msgid := ""
 		var resp whatsmeow.SendResponse
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -848,12 +960,18 @@ func (s *server) SendDocument() http.HandlerFunc {
 			return
 		}
 
+		if status, err := s.applyQuoteParams(r.Context(), txtid, &t.ContextInfo, t.quoteParams); err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
 			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
 
 		if t.Id == "" {
 			msgid = clientManager.GetWhatsmeowClient(txtid).GenerateMessageID()
@@ -873,6 +991,7 @@ func (s *server) SendDocument() http.HandlerFunc {
 				filedata = dataURL.Data
 				uploaded, err = clientManager.GetWhatsmeowClient(txtid).Upload(context.Background(), filedata, whatsmeow.MediaDocument)
 				if err != nil {
+					s.notifySendFailed(txtid, recipient, msgid, "document", fmt.Errorf("%w: %v", errMediaRejected, err))
 					s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to upload file: %v", err)))
 					return
 				}
@@ -898,13 +1017,10 @@ func (s *server) SendDocument() http.HandlerFunc {
 			FileLength:    proto.Uint64(uint64(len(filedata))),
 			Caption:       proto.String(t.Caption),
 		}}
+		addPDFPreview(msg.DocumentMessage, filedata)
 
 		if t.ContextInfo.StanzaID != nil {
-			msg.DocumentMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
-			}
+			msg.DocumentMessage.ContextInfo = replyContextInfo(&t.ContextInfo)
 		}
 		if t.ContextInfo.MentionedJID != nil {
 			if msg.DocumentMessage.ContextInfo == nil {
@@ -922,6 +1038,7 @@ func (s *server) SendDocument() http.HandlerFunc {
 
 		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "document", err)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
@@ -955,6 +1072,7 @@ func (s *server) SendAudio() http.HandlerFunc {
 		Seconds     uint32
 		Waveform    []byte
 		ContextInfo waE2E.ContextInfo
+		quoteParams
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -963,8 +1081,7 @@ func (s *server) SendAudio() http.HandlerFunc {
 		msgid := ""
 		var resp whatsmeow.SendResponse
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -986,12 +1103,18 @@ func (s *server) SendAudio() http.HandlerFunc {
 			return
 		}
 
+		if status, err := s.applyQuoteParams(r.Context(), txtid, &t.ContextInfo, t.quoteParams); err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
 			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
 
 		if t.Id == "" {
 			msgid = clientManager.GetWhatsmeowClient(txtid).GenerateMessageID()
@@ -1011,6 +1134,7 @@ func (s *server) SendAudio() http.HandlerFunc {
 				filedata = dataURL.Data
 				uploaded, err = clientManager.GetWhatsmeowClient(txtid).Upload(context.Background(), filedata, whatsmeow.MediaAudio)
 				if err != nil {
+					s.notifySendFailed(txtid, recipient, msgid, "audio", fmt.Errorf("%w: %v", errMediaRejected, err))
 					s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to upload file: %v", err)))
 					return
 				}
@@ -1053,11 +1177,7 @@ func (s *server) SendAudio() http.HandlerFunc {
 		}}
 
 		if t.ContextInfo.StanzaID != nil {
-			msg.AudioMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
-			}
+			msg.AudioMessage.ContextInfo = replyContextInfo(&t.ContextInfo)
 		}
 		if t.ContextInfo.MentionedJID != nil {
 			if msg.AudioMessage.ContextInfo == nil {
@@ -1075,6 +1195,7 @@ func (s *server) SendAudio() http.HandlerFunc {
 
 		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "audio", err)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
@@ -1105,6 +1226,7 @@ func (s *server) SendImage() http.HandlerFunc {
 		Id          string
 		MimeType    string
 		ContextInfo waE2E.ContextInfo
+		quoteParams
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1113,8 +1235,7 @@ func (s *server) SendImage() http.HandlerFunc {
 		msgid := ""
 		var resp whatsmeow.SendResponse
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -1136,12 +1257,18 @@ func (s *server) SendImage() http.HandlerFunc {
 			return
 		}
 
+		if status, err := s.applyQuoteParams(r.Context(), txtid, &t.ContextInfo, t.quoteParams); err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
 			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
 
 		if t.Id == "" {
 			msgid = clientManager.GetWhatsmeowClient(txtid).GenerateMessageID()
@@ -1185,6 +1312,7 @@ func (s *server) SendImage() http.HandlerFunc {
 
 		uploaded, err = clientManager.GetWhatsmeowClient(txtid).Upload(context.Background(), filedata, whatsmeow.MediaImage)
 		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "image", fmt.Errorf("%w: %v", errMediaRejected, err))
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to upload file: %v", err)))
 			return
 		}
@@ -1238,11 +1366,7 @@ func (s *server) SendImage() http.HandlerFunc {
 
 		if t.ContextInfo.StanzaID != nil {
 			if msg.ImageMessage.ContextInfo == nil {
-				msg.ImageMessage.ContextInfo = &waE2E.ContextInfo{
-					StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-					Participant:   proto.String(*t.ContextInfo.Participant),
-					QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
-				}
+				msg.ImageMessage.ContextInfo = replyContextInfo(&t.ContextInfo)
 			}
 		}
 
@@ -1262,6 +1386,7 @@ func (s *server) SendImage() http.HandlerFunc {
 
 		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "image", err)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
@@ -1288,6 +1413,7 @@ func (s *server) SendSticker() http.HandlerFunc {
 	type stickerStruct struct {
 		Phone         string
 		Sticker       string
+		Url           string
 		Id            string
 		PngThumbnail  []byte
 		MimeType      string
@@ -1296,6 +1422,7 @@ func (s *server) SendSticker() http.HandlerFunc {
 		PackPublisher string
 		Emojis        []string
 		ContextInfo   waE2E.ContextInfo
+		quoteParams
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1304,8 +1431,7 @@ func (s *server) SendSticker() http.HandlerFunc {
 		msgid := ""
 		var resp whatsmeow.SendResponse
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -1322,8 +1448,18 @@ func (s *server) SendSticker() http.HandlerFunc {
 			return
 		}
 
-		if t.Sticker == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Sticker in Payload"))
+		if t.Sticker == "" && t.Url == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Sticker or Url in Payload"))
+			return
+		}
+
+		if err := validateStickerEmojis(t.Emojis); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if status, err := s.applyQuoteParams(r.Context(), txtid, &t.ContextInfo, t.quoteParams); err != nil {
+			s.Respond(w, r, status, err)
 			return
 		}
 
@@ -1333,6 +1469,7 @@ func (s *server) SendSticker() http.HandlerFunc {
 			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
 
 		if t.Id == "" {
 			msgid = clientManager.GetWhatsmeowClient(txtid).GenerateMessageID()
@@ -1340,8 +1477,18 @@ func (s *server) SendSticker() http.HandlerFunc {
 			msgid = t.Id
 		}
 
+		stickerData := t.Sticker
+		if stickerData == "" {
+			stickerData, err = fetchStickerURL(r.Context(), t.Url)
+			if err != nil {
+				log.Error().Err(err).Str("url", t.Url).Msg("Failed to download sticker")
+				s.Respond(w, r, http.StatusBadRequest, fmt.Errorf("could not download sticker: %w", err))
+				return
+			}
+		}
+
 		processedData, detectedMimeType, err := processStickerData(
-			t.Sticker,
+			stickerData,
 			t.MimeType,
 			t.PackId,
 			t.PackName,
@@ -1360,6 +1507,7 @@ func (s *server) SendSticker() http.HandlerFunc {
 
 		uploaded, err := clientManager.GetWhatsmeowClient(txtid).Upload(context.Background(), processedData, whatsmeow.MediaImage)
 		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "sticker", fmt.Errorf("%w: %v", errMediaRejected, err))
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to upload file: %v", err)))
 			return
 		}
@@ -1376,11 +1524,7 @@ func (s *server) SendSticker() http.HandlerFunc {
 		}}
 
 		if t.ContextInfo.StanzaID != nil {
-			msg.StickerMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
-			}
+			msg.StickerMessage.ContextInfo = replyContextInfo(&t.ContextInfo)
 		}
 		if t.ContextInfo.MentionedJID != nil {
 			if msg.StickerMessage.ContextInfo == nil {
@@ -1398,6 +1542,7 @@ func (s *server) SendSticker() http.HandlerFunc {
 
 		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "sticker", err)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
@@ -1429,6 +1574,7 @@ func (s *server) SendVideo() http.HandlerFunc {
 		JPEGThumbnail []byte
 		MimeType      string
 		ContextInfo   waE2E.ContextInfo
+		quoteParams
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1437,8 +1583,7 @@ func (s *server) SendVideo() http.HandlerFunc {
 		msgid := ""
 		var resp whatsmeow.SendResponse
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -1460,12 +1605,18 @@ func (s *server) SendVideo() http.HandlerFunc {
 			return
 		}
 
+		if status, err := s.applyQuoteParams(r.Context(), txtid, &t.ContextInfo, t.quoteParams); err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
 			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
 
 		if t.Id == "" {
 			msgid = clientManager.GetWhatsmeowClient(txtid).GenerateMessageID()
@@ -1510,6 +1661,7 @@ func (s *server) SendVideo() http.HandlerFunc {
 
 		uploaded, err = clientManager.GetWhatsmeowClient(txtid).Upload(context.Background(), filedata, whatsmeow.MediaVideo)
 		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "video", fmt.Errorf("%w: %v", errMediaRejected, err))
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to upload file: %v", err)))
 			return
 		}
@@ -1532,11 +1684,7 @@ func (s *server) SendVideo() http.HandlerFunc {
 		}}
 
 		if t.ContextInfo.StanzaID != nil {
-			msg.VideoMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
-			}
+			msg.VideoMessage.ContextInfo = replyContextInfo(&t.ContextInfo)
 		}
 		if t.ContextInfo.MentionedJID != nil {
 			if msg.VideoMessage.ContextInfo == nil {
@@ -1554,6 +1702,7 @@ func (s *server) SendVideo() http.HandlerFunc {
 
 		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "video", err)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
@@ -1583,14 +1732,14 @@ func (s *server) SendContact() http.HandlerFunc {
 		Name        string
 		Vcard       string
 		ContextInfo waE2E.ContextInfo
+		quoteParams
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -1617,12 +1766,18 @@ func (s *server) SendContact() http.HandlerFunc {
 			return
 		}
 
+		if status, err := s.applyQuoteParams(r.Context(), txtid, &t.ContextInfo, t.quoteParams); err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
 			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
 
 		if t.Id == "" {
 			msgid = clientManager.GetWhatsmeowClient(txtid).GenerateMessageID()
@@ -1636,11 +1791,7 @@ func (s *server) SendContact() http.HandlerFunc {
 		}}
 
 		if t.ContextInfo.StanzaID != nil {
-			msg.ContactMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
-			}
+			msg.ContactMessage.ContextInfo = replyContextInfo(&t.ContextInfo)
 		}
 		if t.ContextInfo.MentionedJID != nil {
 			if msg.ContactMessage.ContextInfo == nil {
@@ -1658,6 +1809,7 @@ func (s *server) SendContact() http.HandlerFunc {
 
 		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "contact", err)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
@@ -1687,15 +1839,16 @@ func (s *server) SendLocation() http.HandlerFunc {
 		Name        string
 		Latitude    float64
 		Longitude   float64
+		LiveSeconds int
 		ContextInfo waE2E.ContextInfo
+		quoteParams
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -1721,6 +1874,15 @@ func (s *server) SendLocation() http.HandlerFunc {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Longitude in Payload"))
 			return
 		}
+		if err := validateLiveSeconds(t.LiveSeconds); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if status, err := s.applyQuoteParams(r.Context(), txtid, &t.ContextInfo, t.quoteParams); err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
 
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
@@ -1728,6 +1890,7 @@ func (s *server) SendLocation() http.HandlerFunc {
 			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
 
 		if t.Id == "" {
 			msgid = clientManager.GetWhatsmeowClient(txtid).GenerateMessageID()
@@ -1735,39 +1898,48 @@ func (s *server) SendLocation() http.HandlerFunc {
 			msgid = t.Id
 		}
 
-		msg := &waE2E.Message{LocationMessage: &waE2E.LocationMessage{
-			DegreesLatitude:  &t.Latitude,
-			DegreesLongitude: &t.Longitude,
-			Name:             &t.Name,
-		}}
+		msg := buildLocationMessage(t.Latitude, t.Longitude, t.Name, t.LiveSeconds)
 
+		var contextInfo *waE2E.ContextInfo
 		if t.ContextInfo.StanzaID != nil {
-			msg.LocationMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
-			}
+			contextInfo = replyContextInfo(&t.ContextInfo)
 		}
 		if t.ContextInfo.MentionedJID != nil {
-			if msg.LocationMessage.ContextInfo == nil {
-				msg.LocationMessage.ContextInfo = &waE2E.ContextInfo{}
+			if contextInfo == nil {
+				contextInfo = &waE2E.ContextInfo{}
 			}
-			msg.LocationMessage.ContextInfo.MentionedJID = t.ContextInfo.MentionedJID
+			contextInfo.MentionedJID = t.ContextInfo.MentionedJID
 		}
 
 		if t.ContextInfo.IsForwarded != nil && *t.ContextInfo.IsForwarded {
-			if msg.LocationMessage.ContextInfo == nil {
-				msg.LocationMessage.ContextInfo = &waE2E.ContextInfo{}
+			if contextInfo == nil {
+				contextInfo = &waE2E.ContextInfo{}
 			}
-			msg.LocationMessage.ContextInfo.IsForwarded = proto.Bool(true)
+			contextInfo.IsForwarded = proto.Bool(true)
+		}
+		if msg.LiveLocationMessage != nil {
+			msg.LiveLocationMessage.ContextInfo = contextInfo
+		} else {
+			msg.LocationMessage.ContextInfo = contextInfo
 		}
 
 		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "location", err)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
 
+		if t.LiveSeconds > 0 {
+			liveLocations.start(liveLocationKey(txtid, msgid), &liveLocationShare{
+				recipient: recipient,
+				caption:   t.Name,
+				startedAt: time.Now(),
+				latitude:  t.Latitude,
+				longitude: t.Longitude,
+			}, time.Duration(t.LiveSeconds)*time.Second)
+		}
+
 		historyStr := r.Context().Value("userinfo").(Values).Get("History")
 		historyLimit, _ := strconv.Atoi(historyStr)
 		s.saveOutgoingMessageToHistory(txtid, recipient.String(), msgid, "location", t.Name, "", historyLimit)
@@ -1784,7 +1956,146 @@ func (s *server) SendLocation() http.HandlerFunc {
 	}
 }
 
-// Sends Buttons (not implemented, does not work)
+// buttonHeaderLimits are the media types and sizes WhatsApp accepts as the
+// header of a buttons message
+var buttonHeaderLimits = map[string]struct {
+	mimeTypes []string
+	maxBytes  int
+}{
+	"image":    {[]string{"image/jpeg", "image/png"}, 5 * 1024 * 1024},
+	"video":    {[]string{"video/mp4", "video/3gpp"}, 16 * 1024 * 1024},
+	"document": {nil, 100 * 1024 * 1024},
+}
+
+// buttonHeaderStruct is the optional media header of a buttons message.
+// Media is a base64 data URL or an http(s) URL.
+type buttonHeaderStruct struct {
+	Type     string
+	Media    string
+	FileName string
+	MimeType string
+}
+
+// buttonHeaderMedia is a validated header, ready to be uploaded
+type buttonHeaderMedia struct {
+	Type     string
+	Data     []byte
+	MimeType string
+	FileName string
+}
+
+// loadButtonHeader decodes or downloads the header media and checks it
+// against WhatsApp's header constraints
+func loadButtonHeader(ctx context.Context, h buttonHeaderStruct) (*buttonHeaderMedia, error) {
+	mediaType := strings.ToLower(strings.TrimSpace(h.Type))
+	limits, ok := buttonHeaderLimits[mediaType]
+	if !ok {
+		return nil, errors.New("invalid Header Type, use image, video or document")
+	}
+	if h.Media == "" {
+		return nil, errors.New("missing Header Media in Payload")
+	}
+
+	var data []byte
+	mimeType := h.MimeType
+	if strings.HasPrefix(h.Media, "data:") {
+		dataURL, err := dataurl.DecodeString(h.Media)
+		if err != nil {
+			return nil, errors.New("could not decode base64 encoded Header Media")
+		}
+		data = dataURL.Data
+		if mimeType == "" {
+			mimeType = dataURL.ContentType()
+		}
+	} else if isHTTPURL(h.Media) {
+		fetched, ct, err := fetchURLBytes(ctx, h.Media, int64(limits.maxBytes))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch Header Media from url: %v", err)
+		}
+		data = fetched
+		if mimeType == "" {
+			mimeType = ct
+		}
+	} else {
+		return nil, errors.New("invalid Header Media, use a data URL or an http(s) URL")
+	}
+
+	if len(data) == 0 {
+		return nil, errors.New("empty Header Media")
+	}
+	if len(data) > limits.maxBytes {
+		return nil, fmt.Errorf("%s header exceeds %d MB", mediaType, limits.maxBytes/(1024*1024))
+	}
+
+	mimeType = strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = strings.Split(http.DetectContentType(data), ";")[0]
+	}
+	if limits.mimeTypes != nil && !Find(limits.mimeTypes, mimeType) {
+		return nil, fmt.Errorf("%s header must be one of %s, got %s", mediaType, strings.Join(limits.mimeTypes, ", "), mimeType)
+	}
+
+	fileName := h.FileName
+	if mediaType == "document" && fileName == "" {
+		fileName = "document"
+	}
+	return &buttonHeaderMedia{Type: mediaType, Data: data, MimeType: mimeType, FileName: fileName}, nil
+}
+
+// whatsmeowMediaType returns the upload type of a header
+func (h *buttonHeaderMedia) whatsmeowMediaType() whatsmeow.MediaType {
+	switch h.Type {
+	case "image":
+		return whatsmeow.MediaImage
+	case "video":
+		return whatsmeow.MediaVideo
+	default:
+		return whatsmeow.MediaDocument
+	}
+}
+
+// applyButtonsHeader attaches the uploaded header media to msg
+func applyButtonsHeader(msg *waE2E.ButtonsMessage, h *buttonHeaderMedia, uploaded whatsmeow.UploadResponse) {
+	switch h.Type {
+	case "image":
+		msg.HeaderType = waE2E.ButtonsMessage_IMAGE.Enum()
+		msg.Header = &waE2E.ButtonsMessage_ImageMessage{ImageMessage: &waE2E.ImageMessage{
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(h.MimeType),
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uint64(len(h.Data))),
+		}}
+	case "video":
+		msg.HeaderType = waE2E.ButtonsMessage_VIDEO.Enum()
+		msg.Header = &waE2E.ButtonsMessage_VideoMessage{VideoMessage: &waE2E.VideoMessage{
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(h.MimeType),
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uint64(len(h.Data))),
+		}}
+	case "document":
+		msg.HeaderType = waE2E.ButtonsMessage_DOCUMENT.Enum()
+		msg.Header = &waE2E.ButtonsMessage_DocumentMessage{DocumentMessage: &waE2E.DocumentMessage{
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(h.MimeType),
+			FileName:      proto.String(h.FileName),
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uint64(len(h.Data))),
+		}}
+	}
+}
+
+// Sends Buttons, optionally with an image, video or document header (not
+// supported by WhatsApp, recipients may not see the message rendered)
 func (s *server) SendButtons() http.HandlerFunc {
 
 	type buttonStruct struct {
@@ -1795,6 +2106,7 @@ func (s *server) SendButtons() http.HandlerFunc {
 		Phone   string
 		Title   string
 		Buttons []buttonStruct
+		Header  *buttonHeaderStruct
 		Id      string
 	}
 
@@ -1802,8 +2114,7 @@ func (s *server) SendButtons() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -1842,6 +2153,16 @@ func (s *server) SendButtons() http.HandlerFunc {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
 			return
 		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
+
+		var header *buttonHeaderMedia
+		if t.Header != nil {
+			header, err = loadButtonHeader(r.Context(), *t.Header)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+		}
 
 		if t.Id == "" {
 			msgid = clientManager.GetWhatsmeowClient(txtid).GenerateMessageID()
@@ -1866,12 +2187,23 @@ func (s *server) SendButtons() http.HandlerFunc {
 			Buttons:     buttons,
 		}
 
+		if header != nil {
+			uploaded, err := clientManager.GetWhatsmeowClient(txtid).Upload(context.Background(), header.Data, header.whatsmeowMediaType())
+			if err != nil {
+				s.notifySendFailed(txtid, recipient, msgid, "buttons", fmt.Errorf("%w: %v", errMediaRejected, err))
+				s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("failed to upload header media: %v", err))
+				return
+			}
+			applyButtonsHeader(msg2, header, uploaded)
+		}
+
 		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, &waE2E.Message{ViewOnceMessage: &waE2E.FutureProofMessage{
 			Message: &waE2E.Message{
 				ButtonsMessage: msg2,
 			},
 		}}, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "buttons", err)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
@@ -1912,8 +2244,7 @@ func (s *server) SendList() http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -1984,6 +2315,7 @@ func (s *server) SendList() http.HandlerFunc {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
 			return
 		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
 
 		msgid := req.Id
 		if msgid == "" {
@@ -2020,6 +2352,7 @@ func (s *server) SendList() http.HandlerFunc {
 			whatsmeow.SendRequestExtra{ID: msgid},
 		)
 		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "list", err)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
@@ -2050,8 +2383,7 @@ func (s *server) SetStatusMessage() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -2078,6 +2410,7 @@ func (s *server) SetStatusMessage() http.HandlerFunc {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending status message: %v", err)))
 			return
 		}
+		sessionProfileCache.Delete(txtid)
 
 		log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", msgid).Msg("Status message sent")
 		response := map[string]interface{}{"Details": "Set"}
@@ -2092,41 +2425,419 @@ func (s *server) SetStatusMessage() http.HandlerFunc {
 	}
 }
 
-// Sends a regular text message
-func (s *server) SendMessage() http.HandlerFunc {
-
-	type textStruct struct {
-		Phone       string
-		Body        string
-		LinkPreview bool
-		Id          string
-		ContextInfo waE2E.ContextInfo
-		QuotedText  string `json:"QuotedText,omitempty"`
-	}
+// Maximum size of media posted as a status update
+const statusMediaMaxBytes = 16 * 1024 * 1024 // 16MB
 
-	return func(w http.ResponseWriter, r *http.Request) {
+// statusAudience describes who a status update is meant for. Type is one of
+// "contacts", "except" (all contacts except List) or "only" (just List).
+type statusAudience struct {
+	Type string
+	List []string
+}
 
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+// statusPrivacyTypes maps audience types to whatsmeow status privacy types
+var statusPrivacyTypes = map[string]types.StatusPrivacyType{
+	"contacts": types.StatusPrivacyTypeContacts,
+	"except":   types.StatusPrivacyTypeBlacklist,
+	"only":     types.StatusPrivacyTypeWhitelist,
+}
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
-			return
+// validateStatusAudience checks the audience type and parses its JID list
+func validateStatusAudience(audience *statusAudience) (types.StatusPrivacy, error) {
+	privacyType, ok := statusPrivacyTypes[audience.Type]
+	if !ok {
+		return types.StatusPrivacy{}, errors.New("invalid Audience Type. Allowed values: 'contacts', 'except', 'only'")
+	}
+	privacy := types.StatusPrivacy{Type: privacyType}
+	if privacyType == types.StatusPrivacyTypeContacts {
+		if len(audience.List) > 0 {
+			return types.StatusPrivacy{}, errors.New("Audience List is not allowed with type 'contacts'")
+		}
+		return privacy, nil
+	}
+	if len(audience.List) == 0 {
+		return types.StatusPrivacy{}, fmt.Errorf("missing Audience List for type '%s'", audience.Type)
+	}
+	for _, arg := range audience.List {
+		jid, ok := parseJID(arg)
+		if !ok || jid.Server != types.DefaultUserServer {
+			return types.StatusPrivacy{}, fmt.Errorf("invalid Audience JID %s", arg)
 		}
+		privacy.List = append(privacy.List, jid.ToNonAD())
+	}
+	return privacy, nil
+}
 
-		msgid := ""
-		var resp whatsmeow.SendResponse
+// sameStatusAudience reports whether the requested audience matches the account privacy
+func sameStatusAudience(requested, current types.StatusPrivacy) bool {
+	if requested.Type != current.Type || len(requested.List) != len(current.List) {
+		return false
+	}
+	members := make(map[string]bool, len(current.List))
+	for _, jid := range current.List {
+		members[jid.ToNonAD().String()] = true
+	}
+	for _, jid := range requested.List {
+		if !members[jid.String()] {
+			return false
+		}
+	}
+	return true
+}
 
-		decoder := json.NewDecoder(r.Body)
-		var t textStruct
-		err := decoder.Decode(&t)
+// decodeStatusMedia reads a data URL or http(s) URL, enforcing the status size limit
+func decodeStatusMedia(ctx context.Context, data string, mediaPrefix string) ([]byte, error) {
+	var filedata []byte
+	if strings.HasPrefix(data, "data:") {
+		dataURL, err := dataurl.DecodeString(data)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
-			return
+			return nil, errors.New("could not decode base64 encoded data from payload")
 		}
-
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
-			return
+		filedata = dataURL.Data
+	} else if isHTTPURL(data) {
+		fetched, _, err := fetchURLBytes(ctx, data, statusMediaMaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s from url: %v", mediaPrefix, err)
+		}
+		filedata = fetched
+	} else {
+		return nil, errors.New("data should start with \"data:mime/type;base64,\"")
+	}
+	if len(filedata) == 0 {
+		return nil, fmt.Errorf("empty %s data", mediaPrefix)
+	}
+	if len(filedata) > statusMediaMaxBytes {
+		return nil, fmt.Errorf("%s exceeds maximum status size of %d bytes", mediaPrefix, statusMediaMaxBytes)
+	}
+	return filedata, nil
+}
+
+// statusMentions parses the contacts tagged in a status update
+func statusMentions(list []string) ([]string, error) {
+	var mentioned []string
+	for _, arg := range list {
+		jid, ok := parseJID(arg)
+		if !ok || jid.Server != types.DefaultUserServer {
+			return nil, fmt.Errorf("invalid Mentions JID %s", arg)
+		}
+		if !Find(mentioned, jid.ToNonAD().String()) {
+			mentioned = append(mentioned, jid.ToNonAD().String())
+		}
+	}
+	return mentioned, nil
+}
+
+// buildStatusMediaMessage builds the message of an uploaded status update,
+// tagging the mentioned contacts
+func buildStatusMediaMessage(mediaType whatsmeow.MediaType, uploaded whatsmeow.UploadResponse, mimeType, caption string, size int, mentions []string) *waE2E.Message {
+	var contextInfo *waE2E.ContextInfo
+	if len(mentions) > 0 {
+		contextInfo = &waE2E.ContextInfo{MentionedJID: mentions}
+	}
+	if mediaType == whatsmeow.MediaVideo {
+		return &waE2E.Message{VideoMessage: &waE2E.VideoMessage{
+			Caption:       proto.String(caption),
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(mimeType),
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uint64(size)),
+			ContextInfo:   contextInfo,
+		}}
+	}
+	return &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+		Caption:       proto.String(caption),
+		URL:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		Mimetype:      proto.String(mimeType),
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileSHA256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uint64(size)),
+		ContextInfo:   contextInfo,
+	}}
+}
+
+// Posts an image status update
+func (s *server) SetStatusImage() http.HandlerFunc {
+	return s.setStatusMedia(whatsmeow.MediaImage)
+}
+
+// Posts a video status update
+func (s *server) SetStatusVideo() http.HandlerFunc {
+	return s.setStatusMedia(whatsmeow.MediaVideo)
+}
+
+// setStatusMedia posts media to the status broadcast. whatsmeow sends status
+// updates to the audience stored in the account status privacy settings, so a
+// requested Audience is checked against those settings before posting.
+func (s *server) setStatusMedia(mediaType whatsmeow.MediaType) http.HandlerFunc {
+
+	type statusMediaStruct struct {
+		Image    string
+		Video    string
+		Caption  string
+		MimeType string
+		Id       string
+		Audience *statusAudience
+		Mentions []string `json:"Mentions,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t statusMediaStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+
+		media, field, kind := t.Image, "Image", "image"
+		if mediaType == whatsmeow.MediaVideo {
+			media, field, kind = t.Video, "Video", "video"
+		}
+		if media == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New(fmt.Sprintf("missing %s in Payload", field)))
+			return
+		}
+
+		mentions, err := statusMentions(t.Mentions)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		var audience types.StatusPrivacy
+		if t.Audience != nil {
+			audience, err = validateStatusAudience(t.Audience)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		filedata, err := decodeStatusMedia(r.Context(), media, kind)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		mimeType := t.MimeType
+		if mimeType == "" {
+			mimeType = http.DetectContentType(filedata)
+		}
+		if !strings.HasPrefix(mimeType, kind+"/") {
+			s.Respond(w, r, http.StatusBadRequest, errors.New(fmt.Sprintf("%s has unsupported mime type %s", field, mimeType)))
+			return
+		}
+
+		// The account settings decide who sees the status, so they are only read
+		// to check a requested Audience against them
+		if t.Audience != nil {
+			privacy, err := client.GetStatusPrivacy(r.Context())
+			if err != nil || len(privacy) == 0 {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to get status privacy: %v", err)))
+				return
+			}
+			if !sameStatusAudience(audience, privacy[0]) {
+				s.Respond(w, r, http.StatusConflict, errors.New(fmt.Sprintf("requested Audience does not match the account status privacy (%s), update it in the WhatsApp app first", privacy[0].Type)))
+				return
+			}
+		}
+
+		uploaded, err := client.Upload(r.Context(), filedata, mediaType)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to upload file: %v", err)))
+			return
+		}
+
+		msg := buildStatusMediaMessage(mediaType, uploaded, mimeType, t.Caption, len(filedata), mentions)
+
+		msgid := t.Id
+		if msgid == "" {
+			msgid = client.GenerateMessageID()
+		}
+
+		resp, err := client.SendMessage(context.Background(), types.StatusBroadcastJID, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error posting status: %v", err)))
+			return
+		}
+
+		log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", msgid).Str("type", kind).Msg("Status posted")
+		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// linkPreviewStruct is a link preview supplied by the caller instead of the
+// one fetched from the page
+type linkPreviewStruct struct {
+	URL         string `json:"URL,omitempty"`
+	Title       string `json:"Title,omitempty"`
+	Description string `json:"Description,omitempty"`
+	Image       string `json:"Image,omitempty"`
+}
+
+// customLinkPreview is a validated linkPreviewStruct, with the image already
+// turned into the preview thumbnail
+type customLinkPreview struct {
+	URL         string
+	Title       string
+	Description string
+	Thumbnail   []byte
+}
+
+// loadCustomLinkPreview validates a caller supplied preview for body. The
+// previewed URL defaults to the first one in body and the image, a data URL
+// or an http(s) URL, is shrunk to a thumbnail like fetched Open Graph images.
+func loadCustomLinkPreview(ctx context.Context, body string, p linkPreviewStruct) (*customLinkPreview, error) {
+	if p.Title == "" && p.Description == "" && p.Image == "" {
+		return nil, errors.New("missing Preview Title, Description or Image in Payload")
+	}
+
+	previewURL := p.URL
+	if previewURL == "" {
+		previewURL = extractFirstURL(body)
+	}
+	if previewURL == "" {
+		return nil, errors.New("missing Preview URL, and no URL found in Body")
+	}
+	if !isHTTPURL(previewURL) {
+		return nil, errors.New("invalid Preview URL, use an http(s) URL")
+	}
+
+	preview := &customLinkPreview{URL: previewURL, Title: p.Title, Description: p.Description}
+	if p.Image == "" {
+		return preview, nil
+	}
+
+	var data []byte
+	if strings.HasPrefix(p.Image, "data:") {
+		dataURL, err := dataurl.DecodeString(p.Image)
+		if err != nil {
+			return nil, errors.New("could not decode base64 encoded Preview Image")
+		}
+		data = dataURL.Data
+	} else if isHTTPURL(p.Image) {
+		fetched, _, err := fetchURLBytes(ctx, p.Image, openGraphImageMaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch Preview Image from url: %v", err)
+		}
+		data = fetched
+	} else {
+		return nil, errors.New("invalid Preview Image, use a data URL or an http(s) URL")
+	}
+	if len(data) > openGraphImageMaxBytes {
+		return nil, fmt.Errorf("Preview Image exceeds %d MB", openGraphImageMaxBytes/(1024*1024))
+	}
+
+	preview.Thumbnail = openGraphThumbnail(data, previewURL)
+	if preview.Thumbnail == nil {
+		return nil, fmt.Errorf("invalid Preview Image, use an image between %d and %d pixels wide and high", openGraphMinImageDim, openGraphMaxImageDim)
+	}
+	return preview, nil
+}
+
+// buildExtendedText builds the text of a message. A custom preview is used
+// as is; otherwise with linkPreview the Open Graph data of the first URL in
+// body is fetched for the preview. noLinkPreview skips both and asks WhatsApp
+// not to render any preview.
+func buildExtendedText(body string, linkPreview, noLinkPreview bool, custom *customLinkPreview, fetchPreview func(url string) (string, string, []byte)) *waE2E.ExtendedTextMessage {
+	if noLinkPreview {
+		return &waE2E.ExtendedTextMessage{
+			Text:        proto.String(body),
+			PreviewType: waE2E.ExtendedTextMessage_NONE.Enum(),
+		}
+	}
+
+	if custom != nil {
+		return &waE2E.ExtendedTextMessage{
+			Text:          proto.String(body),
+			MatchedText:   proto.String(custom.URL),
+			Title:         proto.String(custom.Title),
+			Description:   proto.String(custom.Description),
+			JPEGThumbnail: custom.Thumbnail,
+		}
+	}
+
+	var (
+		url         string
+		title       string
+		description string
+		imageData   []byte
+	)
+
+	if linkPreview {
+		url = extractFirstURL(body)
+		if url != "" {
+			title, description, imageData = fetchPreview(url)
+		}
+	}
+
+	return &waE2E.ExtendedTextMessage{
+		Text:          proto.String(body),
+		MatchedText:   proto.String(url),
+		Title:         proto.String(title),
+		Description:   proto.String(description),
+		JPEGThumbnail: imageData,
+	}
+}
+
+// Sends a regular text message
+func (s *server) SendMessage() http.HandlerFunc {
+
+	type textStruct struct {
+		Phone         string
+		Body          string
+		LinkPreview   bool
+		NoLinkPreview bool
+		Preview       *linkPreviewStruct `json:"Preview,omitempty"`
+		Id            string
+		ContextInfo   waE2E.ContextInfo
+		quoteParams
+		QuotedText     string   `json:"QuotedText,omitempty"`
+		Mentions       []string `json:"Mentions,omitempty"`
+		DetectMentions bool     `json:"DetectMentions,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		if _, ok := s.readyClient(w, r, txtid); !ok {
+			return
+		}
+
+		msgid := ""
+		var resp whatsmeow.SendResponse
+
+		decoder := json.NewDecoder(r.Body)
+		var t textStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			return
 		}
 
 		if t.Body == "" {
@@ -2134,12 +2845,25 @@ func (s *server) SendMessage() http.HandlerFunc {
 			return
 		}
 
+		body, truncated, err := textLimit.apply(t.Body)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		t.Body = body
+
+		if status, err := s.applyQuoteParams(r.Context(), txtid, &t.ContextInfo, t.quoteParams); err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
 			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
 
 		if t.Id == "" {
 			msgid = clientManager.GetWhatsmeowClient(txtid).GenerateMessageID()
@@ -2147,43 +2871,29 @@ func (s *server) SendMessage() http.HandlerFunc {
 			msgid = t.Id
 		}
 
-		var (
-			url         string
-			title       string
-			description string
-			imageData   []byte
-		)
-
-		if t.LinkPreview {
-			url = extractFirstURL(t.Body)
-			if url != "" {
-				title, description, imageData = getOpenGraphData(r.Context(), url, txtid)
+		var customPreview *customLinkPreview
+		if t.Preview != nil && !t.NoLinkPreview {
+			customPreview, err = loadCustomLinkPreview(r.Context(), t.Body, *t.Preview)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, err)
+				return
 			}
 		}
 
 		msg := &waE2E.Message{
-			ExtendedTextMessage: &waE2E.ExtendedTextMessage{
-				Text:          proto.String(t.Body),
-				MatchedText:   proto.String(url),
-				Title:         proto.String(title),
-				Description:   proto.String(description),
-				JPEGThumbnail: imageData,
-			},
+			ExtendedTextMessage: buildExtendedText(t.Body, t.LinkPreview, t.NoLinkPreview, customPreview, func(url string) (string, string, []byte) {
+				return getOpenGraphData(r.Context(), url, txtid)
+			}),
 		}
 
 		if t.ContextInfo.StanzaID != nil {
-			qm := &waE2E.Message{}
+			msg.ExtendedTextMessage.ContextInfo = replyContextInfo(&t.ContextInfo)
 			if t.QuotedText != "" {
-				qm.ExtendedTextMessage = &waE2E.ExtendedTextMessage{
-					Text: proto.String(t.QuotedText),
+				msg.ExtendedTextMessage.ContextInfo.QuotedMessage = &waE2E.Message{
+					ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+						Text: proto.String(t.QuotedText),
+					},
 				}
-			} else {
-				qm.Conversation = proto.String("")
-			}
-			msg.ExtendedTextMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: qm,
 			}
 		}
 		if t.ContextInfo.MentionedJID != nil {
@@ -2193,6 +2903,13 @@ func (s *server) SendMessage() http.HandlerFunc {
 			msg.ExtendedTextMessage.ContextInfo.MentionedJID = t.ContextInfo.MentionedJID
 		}
 
+		if len(t.Mentions) > 0 || t.DetectMentions {
+			if status, err := applyGroupMentions(r.Context(), clientManager.GetWhatsmeowClient(txtid), recipient, msg.ExtendedTextMessage, t.Mentions, t.DetectMentions); err != nil {
+				s.Respond(w, r, status, err)
+				return
+			}
+		}
+
 		if t.ContextInfo.IsForwarded != nil && *t.ContextInfo.IsForwarded {
 			if msg.ExtendedTextMessage.ContextInfo == nil {
 				msg.ExtendedTextMessage.ContextInfo = &waE2E.ContextInfo{}
@@ -2202,6 +2919,7 @@ func (s *server) SendMessage() http.HandlerFunc {
 
 		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "text", err)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
@@ -2212,30 +2930,226 @@ func (s *server) SendMessage() http.HandlerFunc {
 
 		log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
+		if truncated {
+			response["Truncated"] = true
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		return
+	}
+}
+
+// forwardContextInfo marks a message as forwarded, bumping the forwarding
+// score carried over from the original
+func forwardContextInfo(prev *waE2E.ContextInfo) *waE2E.ContextInfo {
+	return &waE2E.ContextInfo{
+		IsForwarded:     proto.Bool(true),
+		ForwardingScore: proto.Uint32(prev.GetForwardingScore() + 1),
+	}
+}
+
+// buildForwardMessage rebuilds a stored message as a forwarded copy. Media is
+// forwarded by reusing the original upload (direct path and media key), so
+// nothing is re-uploaded. Messages stored without event data (outgoing
+// messages) can only be forwarded when they are text.
+func buildForwardMessage(row HistoryMessage) (*waE2E.Message, error) {
+	var evt events.Message
+	if row.DataJson != "" {
+		if err := json.Unmarshal([]byte(row.DataJson), &evt); err != nil {
+			return nil, fmt.Errorf("could not decode stored message: %w", err)
+		}
+	}
+
+	if evt.Message == nil {
+		if row.MessageType != "text" || row.TextContent == "" {
+			return nil, errors.New("stored message has no content that can be forwarded")
+		}
+		return &waE2E.Message{
+			ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+				Text:        proto.String(row.TextContent),
+				ContextInfo: forwardContextInfo(nil),
+			},
+		}, nil
+	}
+
+	src := evt.Message
+	if inner := src.GetEphemeralMessage().GetMessage(); inner != nil {
+		src = inner
+	}
+
+	switch {
+	case src.GetConversation() != "":
+		return &waE2E.Message{
+			ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+				Text:        proto.String(src.GetConversation()),
+				ContextInfo: forwardContextInfo(nil),
+			},
+		}, nil
+	case src.GetExtendedTextMessage() != nil:
+		m := proto.Clone(src.GetExtendedTextMessage()).(*waE2E.ExtendedTextMessage)
+		m.ContextInfo = forwardContextInfo(m.GetContextInfo())
+		return &waE2E.Message{ExtendedTextMessage: m}, nil
+	case src.GetImageMessage() != nil:
+		m := proto.Clone(src.GetImageMessage()).(*waE2E.ImageMessage)
+		m.ContextInfo = forwardContextInfo(m.GetContextInfo())
+		return &waE2E.Message{ImageMessage: m}, nil
+	case src.GetVideoMessage() != nil:
+		m := proto.Clone(src.GetVideoMessage()).(*waE2E.VideoMessage)
+		m.ContextInfo = forwardContextInfo(m.GetContextInfo())
+		return &waE2E.Message{VideoMessage: m}, nil
+	case src.GetAudioMessage() != nil:
+		m := proto.Clone(src.GetAudioMessage()).(*waE2E.AudioMessage)
+		m.ContextInfo = forwardContextInfo(m.GetContextInfo())
+		return &waE2E.Message{AudioMessage: m}, nil
+	case src.GetDocumentMessage() != nil:
+		m := proto.Clone(src.GetDocumentMessage()).(*waE2E.DocumentMessage)
+		m.ContextInfo = forwardContextInfo(m.GetContextInfo())
+		return &waE2E.Message{DocumentMessage: m}, nil
+	case src.GetStickerMessage() != nil:
+		m := proto.Clone(src.GetStickerMessage()).(*waE2E.StickerMessage)
+		m.ContextInfo = forwardContextInfo(m.GetContextInfo())
+		return &waE2E.Message{StickerMessage: m}, nil
+	case src.GetLocationMessage() != nil:
+		m := proto.Clone(src.GetLocationMessage()).(*waE2E.LocationMessage)
+		m.ContextInfo = forwardContextInfo(m.GetContextInfo())
+		return &waE2E.Message{LocationMessage: m}, nil
+	case src.GetContactMessage() != nil:
+		m := proto.Clone(src.GetContactMessage()).(*waE2E.ContactMessage)
+		m.ContextInfo = forwardContextInfo(m.GetContextInfo())
+		return &waE2E.Message{ContactMessage: m}, nil
+	}
+
+	return nil, errors.New("stored message type cannot be forwarded")
+}
+
+// Forwards a message stored in history to another chat
+func (s *server) ForwardMessage() http.HandlerFunc {
+
+	type forwardStruct struct {
+		Phone string
+		Id    string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		if _, ok := s.readyClient(w, r, txtid); !ok {
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t forwardStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			return
+		}
+
+		if t.Id == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Id in Payload"))
+			return
+		}
+
+		recipient, err := validateMessageFields(t.Phone, nil, nil)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
+
+		var stored HistoryMessage
+		err = s.db.GetContext(r.Context(), &stored, "SELECT message_type, text_content, COALESCE(datajson, '') AS datajson FROM message_history WHERE user_id = $1 AND message_id = $2 LIMIT 1", txtid, t.Id)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Respond(w, r, http.StatusNotFound, errors.New("message not found in history"))
+			return
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to look up message: %v", err)))
+			return
+		}
+
+		msg, err := buildForwardMessage(stored)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		msgid := clientManager.GetWhatsmeowClient(txtid).GenerateMessageID()
+		resp, err := clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "forward", err)
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
+			return
+		}
+
+		historyStr := r.Context().Value("userinfo").(Values).Get("History")
+		historyLimit, _ := strconv.Atoi(historyStr)
+		s.saveOutgoingMessageToHistory(txtid, recipient.String(), msgid, stored.MessageType, stored.TextContent, "", historyLimit)
+
+		log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", msgid).Str("source", t.Id).Msg("Message forwarded")
+		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
+	}
+}
+
+// maxPollOptions is the most options WhatsApp shows in a poll
+const maxPollOptions = 12
+
+// buildPollMessage checks the options of a poll and builds it with cli.
+// selectableCount is how many options a voter may pick, 0 meaning one.
+func buildPollMessage(cli *whatsmeow.Client, header string, options []string, selectableCount int) (*waE2E.Message, error) {
+	if len(options) < 2 || len(options) > maxPollOptions {
+		return nil, fmt.Errorf("between 2 and %d options are required", maxPollOptions)
+	}
+	seen := make(map[string]bool, len(options))
+	for _, option := range options {
+		if strings.TrimSpace(option) == "" {
+			return nil, errors.New("options cannot be empty")
+		}
+		if seen[option] {
+			return nil, fmt.Errorf("duplicate option %q", option)
+		}
+		seen[option] = true
+	}
 
-		return
+	if selectableCount == 0 {
+		selectableCount = 1
+	}
+	if selectableCount < 1 || selectableCount > len(options) {
+		return nil, fmt.Errorf("selectableCount must be between 1 and the number of options (%d)", len(options))
 	}
+	return cli.BuildPollCreation(header, options, selectableCount), nil
 }
 
 func (s *server) SendPoll() http.HandlerFunc {
 	type pollRequest struct {
-		Group   string   `json:"group"`   // The recipient's group id (120363313346913103@g.us)
-		Header  string   `json:"header"`  // The poll's headline text
-		Options []string `json:"options"` // The list of poll options
-		Id      string
+		Group           string   `json:"group"`           // The recipient's group id (120363313346913103@g.us)
+		Header          string   `json:"header"`          // The poll's headline text
+		Options         []string `json:"options"`         // The list of poll options
+		SelectableCount int      `json:"selectableCount"` // How many options a voter may pick, 1 when omitted
+		Id              string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -2260,8 +3174,9 @@ func (s *server) SendPoll() http.HandlerFunc {
 			return
 		}
 
-		if len(req.Options) < 2 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("at least 2 options are required"))
+		pollMessage, err := buildPollMessage(clientManager.GetWhatsmeowClient(txtid), req.Header, req.Options, req.SelectableCount)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
@@ -2277,15 +3192,20 @@ func (s *server) SendPoll() http.HandlerFunc {
 			return
 		}
 
-		pollMessage := clientManager.GetWhatsmeowClient(txtid).BuildPollCreation(req.Header, req.Options, 1)
 		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, pollMessage, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			s.notifySendFailed(txtid, recipient, msgid, "poll", err)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to send poll: %v", err)))
 			return
 		}
 
 		log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", msgid).Msg("Poll sent")
 
+		// Votes on our own polls are only decryptable with the secret we generated
+		if ownID := clientManager.GetWhatsmeowClient(txtid).Store.ID; *pollResultsEnabled && ownID != nil {
+			polls.track(txtid, msgid, recipient, ownID.ToNonAD(), pollMessage)
+		}
+
 		response := map[string]interface{}{"Details": "Poll sent successfully", "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
@@ -2308,8 +3228,7 @@ func (s *server) DeleteMessage() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -2341,6 +3260,7 @@ func (s *server) DeleteMessage() http.HandlerFunc {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
 			return
 		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
 
 		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, clientManager.GetWhatsmeowClient(txtid).BuildRevoke(recipient, types.EmptyJID, msgid))
 		if err != nil {
@@ -2375,8 +3295,7 @@ func (s *server) SendEditMessage() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -2407,6 +3326,7 @@ func (s *server) SendEditMessage() http.HandlerFunc {
 			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
 
 		if t.Id == "" {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Id in Payload"))
@@ -2464,8 +3384,8 @@ func (s *server) RequestHistorySync() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
 			return
 		}
 
@@ -2511,7 +3431,7 @@ func (s *server) RequestHistorySync() http.HandlerFunc {
 			}
 		}
 
-		historyMsg := clientManager.GetWhatsmeowClient(txtid).BuildHistorySyncRequest(info, count)
+		historyMsg := client.BuildHistorySyncRequest(info, count)
 		if historyMsg == nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New("Failed to build history sync request."))
 			return
@@ -2528,7 +3448,7 @@ func (s *server) RequestHistorySync() http.HandlerFunc {
 			Time("oldest_msg_timestamp", info.Timestamp).
 			Msg("Preparing to send history sync request")
 
-		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), clientManager.GetMyClient(txtid).WAClient.Store.ID.ToNonAD(), historyMsg, whatsmeow.SendRequestExtra{Peer: true})
+		resp, err = client.SendMessage(context.Background(), client.Store.ID.ToNonAD(), historyMsg, whatsmeow.SendRequestExtra{Peer: true})
 		if err != nil {
 			log.Error().
 				Str("userID", txtid).
@@ -2634,6 +3554,7 @@ func (s *server) SendTemplate() http.HandlerFunc {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
 			return
 		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
 
 		if t.Id == "" {
 			msgid = clientManager.GetWhatsmeowClient(txtid).GenerateMessageID()
@@ -2749,8 +3670,7 @@ func (s *server) CheckUser() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -2793,6 +3713,123 @@ func (s *server) CheckUser() http.HandlerFunc {
 	}
 }
 
+// userResolveTTL is how long the resolution of a phone number is reused
+const userResolveTTL = 30 * time.Minute
+
+// userResolveCache keeps resolved phone numbers per user
+var userResolveCache = cache.New(userResolveTTL, time.Hour)
+
+// whatsAppChecker is the part of the WhatsApp client that looks up numbers
+type whatsAppChecker interface {
+	IsOnWhatsApp(ctx context.Context, phones []string) ([]types.IsOnWhatsAppResponse, error)
+}
+
+// resolvedUser is what a phone number resolves to. JID is empty and
+// IsInWhatsapp false when the number is not on WhatsApp.
+type resolvedUser struct {
+	Query        string
+	IsInWhatsapp bool
+	JID          string
+	IsBusiness   bool
+	VerifiedName string
+}
+
+// resolveUsers resolves phones to their canonical JID and business details,
+// asking WhatsApp only about the numbers not cached for userID
+func resolveUsers(ctx context.Context, userID string, checker whatsAppChecker, phones []string) ([]resolvedUser, error) {
+	users := make([]resolvedUser, len(phones))
+	var missing []string
+	for i, phone := range phones {
+		if cached, found := userResolveCache.Get(userID + "|" + phone); found {
+			users[i] = cached.(resolvedUser)
+		} else {
+			missing = append(missing, phone)
+		}
+	}
+
+	if len(missing) > 0 {
+		resp, err := checker.IsOnWhatsApp(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+
+		resolved := make(map[string]resolvedUser, len(missing))
+		for _, phone := range missing {
+			resolved[phone] = resolvedUser{Query: phone}
+		}
+		for _, item := range resp {
+			user := resolvedUser{Query: item.Query, IsInWhatsapp: item.IsIn}
+			if item.IsIn {
+				user.JID = item.JID.String()
+			}
+			if item.VerifiedName != nil {
+				user.IsBusiness = true
+				user.VerifiedName = item.VerifiedName.Details.GetVerifiedName()
+			}
+			resolved[item.Query] = user
+		}
+		for _, phone := range missing {
+			userResolveCache.Set(userID+"|"+phone, resolved[phone], cache.DefaultExpiration)
+		}
+
+		for i, phone := range phones {
+			if user, ok := resolved[phone]; ok {
+				users[i] = user
+			}
+		}
+	}
+	return users, nil
+}
+
+// Resolves phone numbers to their canonical JID and business details
+func (s *server) ResolveUser() http.HandlerFunc {
+
+	type resolveUserStruct struct {
+		Phone []string
+	}
+
+	type UserCollection struct {
+		Users []resolvedUser
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t resolveUserStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+
+		if len(t.Phone) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			return
+		}
+
+		users, err := resolveUsers(context.Background(), txtid, client, t.Phone)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("failed to resolve users on WhatsApp: %w", err))
+			return
+		}
+
+		responseJson, err := json.Marshal(UserCollection{Users: users})
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
 // Gets user information
 func (s *server) GetUser() http.HandlerFunc {
 
@@ -2808,8 +3845,7 @@ func (s *server) GetUser() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -2871,8 +3907,7 @@ func (s *server) SendPresence() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -2900,87 +3935,359 @@ func (s *server) SendPresence() http.HandlerFunc {
 
 		err = clientManager.GetWhatsmeowClient(txtid).SendPresence(context.Background(), presence)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failure sending presence to Whatsapp servers"))
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failure sending presence to Whatsapp servers"))
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Presence set successfuly"}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+
+	}
+}
+
+// SubscribePresence subscribes to the presence updates of many contacts, which
+// are then delivered as Presence events
+func (s *server) SubscribePresence() http.HandlerFunc {
+
+	type subscribePresenceStruct struct {
+		Jids []string `json:"jids"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		decoder := json.NewDecoder(r.Body)
+		var t subscribePresenceStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+		if len(t.Jids) == 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing jids in Payload"))
+			return
+		}
+		if len(t.Jids) > maxPresenceSubscriptions {
+			s.Respond(w, r, http.StatusBadRequest, fmt.Errorf("at most %d jids can be subscribed to at once", maxPresenceSubscriptions))
+			return
+		}
+
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		online, lastSeen := presenceSharing(client.GetPrivacySettings(r.Context()))
+		if !online {
+			s.Respond(w, r, http.StatusConflict, errors.New("presence of contacts is not shared while the account hides its own last seen and online status"))
+			return
+		}
+
+		subscriptions := subscribePresences(r.Context(), client, t.Jids)
+
+		response := map[string]interface{}{
+			"subscriptions":  subscriptions,
+			"total":          len(subscriptions),
+			"lastSeenShared": lastSeen,
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Gets avatar info for user
+func (s *server) GetAvatar() http.HandlerFunc {
+
+	type getAvatarStruct struct {
+		Phone      string
+		Preview    bool
+		Resolution string
+		Deliver    string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		if _, ok := s.readyClient(w, r, txtid); !ok {
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t getAvatarStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+
+		if len(t.Phone) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			return
+		}
+
+		jid, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
+			return
+		}
+
+		preview, err := avatarPreview(t.Resolution, t.Preview)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		deliver := strings.ToLower(t.Deliver)
+		if deliver == "" {
+			deliver = "url"
+		}
+		if deliver != "url" && deliver != "base64" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid Deliver, use url or base64"))
+			return
+		}
+
+		var pic *types.ProfilePictureInfo
+
+		existingID := ""
+		pic, err = clientManager.GetWhatsmeowClient(txtid).GetProfilePictureInfo(context.Background(), jid, &whatsmeow.GetProfilePictureParams{
+			Preview:    preview,
+			ExistingID: existingID,
+		})
+		if errors.Is(err, whatsmeow.ErrProfilePictureNotSet) {
+			s.Respond(w, r, http.StatusNotFound, errors.New("no avatar set"))
+			return
+		}
+		if errors.Is(err, whatsmeow.ErrProfilePictureUnauthorized) {
+			s.Respond(w, r, http.StatusForbidden, errors.New("avatar hidden by privacy settings"))
+			return
+		}
+		if err != nil {
+			msg := fmt.Sprintf("failed to get avatar: %v", err)
+			log.Error().Msg(msg)
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+			return
+		}
+
+		if pic == nil {
+			s.Respond(w, r, http.StatusNotFound, errors.New("no avatar set"))
+			return
+		}
+
+		log.Info().Str("id", pic.ID).Str("url", pic.URL).Msg("Got avatar")
+
+		if deliver == "url" {
+			responseJson, err := json.Marshal(pic)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				s.Respond(w, r, http.StatusOK, string(responseJson))
+			}
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+		response, err := s.deliverAvatar(ctx, txtid, jid, pic)
+		if err != nil {
+			msg := fmt.Sprintf("failed to download avatar: %v", err)
+			log.Error().Msg(msg)
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+			return
+		}
+
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Gets the business profile of the account, or of the business in phone
+func (s *server) GetBusinessProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		var jid types.JID
+		if phone := r.URL.Query().Get("phone"); phone != "" {
+			var ok bool
+			jid, ok = parseJID(phone)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
+				return
+			}
+		} else {
+			if client.Store.ID == nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New("not logged in"))
+				return
+			}
+			if client.Store.BusinessName == "" {
+				s.Respond(w, r, http.StatusBadRequest, errNotBusinessAccount)
+				return
+			}
+			jid = client.Store.ID.ToNonAD()
+		}
+
+		profile, err := client.GetBusinessProfile(r.Context(), jid)
+		if errors.Is(err, whatsmeow.ErrIQNotFound) {
+			s.Respond(w, r, http.StatusNotFound, errNotBusinessAccount)
+			return
+		}
+		if err != nil {
+			msg := fmt.Sprintf("failed to get business profile: %v", err)
+			log.Error().Msg(msg)
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
 			return
 		}
 
-		response := map[string]interface{}{"Details": "Presence set successfuly"}
-		responseJson, err := json.Marshal(response)
+		responseJson, err := json.Marshal(profile)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
-		return
-
 	}
 }
 
-// Gets avatar info for user
-func (s *server) GetAvatar() http.HandlerFunc {
+// Updates the business profile of the account
+func (s *server) SetBusinessProfile() http.HandlerFunc {
 
-	type getAvatarStruct struct {
-		Phone   string
-		Preview bool
+	type setBusinessProfileStruct struct {
+		Description   *string
+		Address       *string
+		Email         *string
+		Websites      []string
+		Categories    []string
+		BusinessHours *struct {
+			TimeZone string
+			Hours    []businessHours
+		}
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
 			return
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t getAvatarStruct
+		var t setBusinessProfileStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
 			return
 		}
 
-		if len(t.Phone) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+		update := &businessProfileUpdate{
+			Description: t.Description,
+			Address:     t.Address,
+			Email:       t.Email,
+			Websites:    t.Websites,
+			Categories:  t.Categories,
+		}
+		if t.BusinessHours != nil {
+			update.TimeZone = t.BusinessHours.TimeZone
+			update.Hours = t.BusinessHours.Hours
+			if update.Hours == nil {
+				update.Hours = []businessHours{}
+			}
+		}
+		if err := update.validate(); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
-		jid, ok := parseJID(t.Phone)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
+		err = setBusinessProfile(r.Context(), client, update)
+		if errors.Is(err, errNotBusinessAccount) {
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
-
-		var pic *types.ProfilePictureInfo
-
-		existingID := ""
-		pic, err = clientManager.GetWhatsmeowClient(txtid).GetProfilePictureInfo(context.Background(), jid, &whatsmeow.GetProfilePictureParams{
-			Preview:    t.Preview,
-			ExistingID: existingID,
-		})
 		if err != nil {
-			msg := fmt.Sprintf("failed to get avatar: %v", err)
+			msg := fmt.Sprintf("failed to set business profile: %v", err)
 			log.Error().Msg(msg)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
 			return
 		}
 
-		if pic == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no avatar found"))
-			return
+		log.Info().Str("userID", txtid).Msg("Business profile updated")
+		response := map[string]interface{}{"Details": "Business profile updated"}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
+	}
+}
 
-		log.Info().Str("id", pic.ID).Str("url", pic.URL).Msg("Got avatar")
+// avatarMaxBytes caps the size of a downloaded profile picture
+const avatarMaxBytes = 5 * 1024 * 1024
+
+// deliverAvatar downloads a profile picture and returns it the way the user's
+// media delivery setting asks for, as for received media: base64 data, an S3
+// upload or both
+func (s *server) deliverAvatar(ctx context.Context, txtid string, jid types.JID, pic *types.ProfilePictureInfo) (map[string]interface{}, error) {
+	data, mimeType, err := fetchURLBytes(ctx, pic.URL, avatarMaxBytes)
+	if err != nil {
+		return nil, err
+	}
 
-		responseJson, err := json.Marshal(pic)
+	response := map[string]interface{}{"ID": pic.ID, "Type": pic.Type, "Mimetype": mimeType}
+
+	var s3Config struct {
+		Enabled       bool   `db:"s3_enabled"`
+		MediaDelivery string `db:"media_delivery"`
+	}
+	if err := s.db.Get(&s3Config, "SELECT COALESCE(s3_enabled, false) AS s3_enabled, COALESCE(media_delivery, 'base64') AS media_delivery FROM users WHERE id = $1", txtid); err != nil {
+		log.Warn().Err(err).Msg("Failed to get S3 config, returning avatar as base64")
+	}
+	if s3Config.Enabled && (s3Config.MediaDelivery == "s3" || s3Config.MediaDelivery == "both") {
+		s3Data, err := GetS3Manager().ProcessMediaForS3(ctx, txtid, jid.String(), "avatar-"+pic.ID, data, mimeType, pic.ID+".jpg", true)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			log.Error().Err(err).Msg("Failed to upload avatar to S3")
 		} else {
-			s.Respond(w, r, http.StatusOK, string(responseJson))
+			response["S3"] = s3Data
 		}
-		return
 	}
+	if s3Config.MediaDelivery != "s3" || response["S3"] == nil {
+		response["Data"] = "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	}
+	return response, nil
+}
+
+// avatarPreview resolves the requested avatar resolution. Resolution takes
+// precedence over the older Preview flag.
+func avatarPreview(resolution string, preview bool) (bool, error) {
+	switch strings.ToLower(resolution) {
+	case "":
+		return preview, nil
+	case "preview":
+		return true, nil
+	case "full":
+		return false, nil
+	}
+	return false, errors.New("invalid Resolution, use preview or full")
 }
 
 // Gets all contacts
@@ -2990,8 +4297,7 @@ func (s *server) GetContacts() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3017,17 +4323,17 @@ func (s *server) GetContacts() http.HandlerFunc {
 func (s *server) ChatPresence() http.HandlerFunc {
 
 	type chatPresenceStruct struct {
-		Phone string
-		State string
-		Media types.ChatPresenceMedia
+		Phone    string
+		State    string
+		Media    types.ChatPresenceMedia
+		Duration int
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3049,6 +4355,12 @@ func (s *server) ChatPresence() http.HandlerFunc {
 			return
 		}
 
+		duration := time.Duration(t.Duration) * time.Second
+		if duration < 0 || duration > maxPresenceDuration {
+			s.Respond(w, r, http.StatusBadRequest, fmt.Errorf("Duration must be between 0 and %d seconds", int(maxPresenceDuration.Seconds())))
+			return
+		}
+
 		jid, ok := parseJID(t.Phone)
 		if !ok {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
@@ -3061,7 +4373,15 @@ func (s *server) ChatPresence() http.HandlerFunc {
 			return
 		}
 
+		// A new presence always replaces the revert of the previous one
 		response := map[string]interface{}{"Details": "Chat presence set successfuly"}
+		key := presenceKey(txtid, jid)
+		if duration > 0 && types.ChatPresence(t.State) == types.ChatPresenceComposing {
+			presenceReverts.hold(key, duration, func() { revertChatPresence(txtid, jid) })
+			response["RevertsAt"] = time.Now().Add(duration).Unix()
+		} else {
+			presenceReverts.cancel(key)
+		}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
@@ -3092,8 +4412,7 @@ func (s *server) DownloadImage() http.HandlerFunc {
 		mimetype := ""
 		var imgdata []byte
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3171,8 +4490,7 @@ func (s *server) DownloadDocument() http.HandlerFunc {
 		mimetype := ""
 		var docdata []byte
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3250,8 +4568,7 @@ func (s *server) DownloadVideo() http.HandlerFunc {
 		mimetype := ""
 		var docdata []byte
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3329,8 +4646,7 @@ func (s *server) DownloadAudio() http.HandlerFunc {
 		mimetype := ""
 		var docdata []byte
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3393,7 +4709,7 @@ func (s *server) React() http.HandlerFunc {
 
 	type textStruct struct {
 		Phone       string
-		Body        string
+		Body        *string
 		Id          string
 		Participant string
 	}
@@ -3402,8 +4718,7 @@ func (s *server) React() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3423,16 +4738,22 @@ func (s *server) React() http.HandlerFunc {
 			return
 		}
 
-		if t.Body == "" {
+		if t.Body == nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Body in Payload"))
 			return
 		}
+		reaction, err := normalizeReaction(*t.Body)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
 
 		recipient, ok := parseJID(t.Phone)
 		if !ok {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
 			return
 		}
+		recipient = s.sendableRecipient(r.Context(), txtid, recipient)
 
 		if t.Id == "" {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Id in Payload"))
@@ -3446,10 +4767,6 @@ func (s *server) React() http.HandlerFunc {
 			fromMe = true
 			msgid = msgid[len("me:"):]
 		}
-		reaction := t.Body
-		if reaction == "remove" {
-			reaction = ""
-		}
 
 		var participantJID types.JID
 		if !fromMe && t.Participant != "" {
@@ -3510,8 +4827,7 @@ func (s *server) MarkRead() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3562,6 +4878,7 @@ func (s *server) MarkRead() http.HandlerFunc {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New("failure marking messages as read"))
 			return
 		}
+		s.recordReadReceipts(txtid, jidChat, t.Id)
 
 		response := map[string]interface{}{"Details": "Message(s) marked as read"}
 		responseJson, err := json.Marshal(response)
@@ -3574,19 +4891,202 @@ func (s *server) MarkRead() http.HandlerFunc {
 	}
 }
 
+// recordReadReceipts stores that ids were read, so they no longer count as
+// unread chat messages
+func (s *server) recordReadReceipts(userID string, chat types.JID, ids []string) {
+	for _, id := range ids {
+		if err := s.saveMessageReceipt(userID, chat.String(), id, "read", time.Now()); err != nil {
+			log.Warn().Err(err).Str("id", id).Msg("Failed to record read receipt")
+		}
+	}
+}
+
+// maxMarkReadBatch caps the number of message ids marked read in one call
+const maxMarkReadBatch = 1000
+
+// markReadItem is a message to mark read in a batch
+type markReadItem struct {
+	Id          string
+	ChatPhone   string
+	SenderPhone string
+}
+
+// markReadGroup is one read receipt: the messages a sender wrote in a chat
+type markReadGroup struct {
+	Chat   types.JID
+	Sender types.JID
+	Ids    []types.MessageID
+}
+
+// markReadResult is the outcome of marking one message id read
+type markReadResult struct {
+	Id      string
+	Success bool
+	Error   string `json:",omitempty"`
+	group   int
+}
+
+// planMarkReadBatch validates items and groups the valid ones by chat and
+// sender, so each group is sent as a single receipt. results holds one entry
+// per item, in order, pointing at its group; invalid items already carry
+// their error.
+func planMarkReadBatch(items []markReadItem) ([]markReadGroup, []markReadResult) {
+	results := make([]markReadResult, len(items))
+	var groups []markReadGroup
+	groupIndex := make(map[string]int)
+	seen := make(map[string]bool)
+
+	for i, item := range items {
+		id := strings.TrimSpace(item.Id)
+		results[i].Id = id
+		results[i].group = -1
+		if id == "" {
+			results[i].Error = "missing Id"
+			continue
+		}
+		if strings.TrimSpace(item.ChatPhone) == "" {
+			results[i].Error = "missing ChatPhone"
+			continue
+		}
+		chat, ok := parseJID(strings.TrimSpace(item.ChatPhone))
+		if !ok {
+			results[i].Error = "could not parse ChatPhone"
+			continue
+		}
+		var sender types.JID
+		if strings.TrimSpace(item.SenderPhone) != "" {
+			sender, ok = parseJID(strings.TrimSpace(item.SenderPhone))
+			if !ok {
+				results[i].Error = "could not parse SenderPhone"
+				continue
+			}
+		}
+
+		key := chat.String() + "|" + sender.String()
+		if seen[key+"|"+id] {
+			results[i].Error = "duplicate Id"
+			continue
+		}
+		seen[key+"|"+id] = true
+
+		g, found := groupIndex[key]
+		if !found {
+			g = len(groups)
+			groupIndex[key] = g
+			groups = append(groups, markReadGroup{Chat: chat, Sender: sender})
+		}
+		groups[g].Ids = append(groups[g].Ids, id)
+		results[i].group = g
+	}
+	return groups, results
+}
+
+// Mark many messages as read, sending one receipt per chat and sender
+func (s *server) MarkReadBatch() http.HandlerFunc {
+
+	type markReadChatStruct struct {
+		ChatPhone   string
+		SenderPhone string
+		Id          []string
+	}
+
+	type markReadBatchStruct struct {
+		Messages []markReadItem
+		Chats    []markReadChatStruct
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		decoder := json.NewDecoder(r.Body)
+		var t markReadBatchStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+
+		items := t.Messages
+		for _, chat := range t.Chats {
+			for _, id := range chat.Id {
+				items = append(items, markReadItem{Id: id, ChatPhone: chat.ChatPhone, SenderPhone: chat.SenderPhone})
+			}
+		}
+		if len(items) == 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Messages in Payload"))
+			return
+		}
+		if len(items) > maxMarkReadBatch {
+			s.Respond(w, r, http.StatusBadRequest, fmt.Errorf("at most %d messages can be marked read at once", maxMarkReadBatch))
+			return
+		}
+
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		groups, results := planMarkReadBatch(items)
+
+		failed := make([]bool, len(groups))
+		for g, group := range groups {
+			if err := client.MarkRead(context.Background(), group.Ids, time.Now(), group.Chat, group.Sender); err != nil {
+				log.Warn().Err(err).Str("chat", group.Chat.String()).Int("count", len(group.Ids)).Msg("Failed to mark messages as read")
+				failed[g] = true
+				continue
+			}
+			s.recordReadReceipts(txtid, group.Chat, group.Ids)
+		}
+
+		marked := 0
+		for i := range results {
+			if results[i].group < 0 {
+				continue
+			}
+			if failed[results[i].group] {
+				results[i].Error = "failure marking messages as read"
+				continue
+			}
+			results[i].Success = true
+			marked++
+		}
+
+		response := map[string]interface{}{
+			"Details": fmt.Sprintf("%d of %d message(s) marked as read", marked, len(results)),
+			"Results": results,
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
 // List groups
 func (s *server) ListGroups() http.HandlerFunc {
 
 	type GroupCollection struct {
 		Groups []types.GroupInfo
+		Total  int
+		Limit  int
+		Offset int
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		limit, offset, err := parsePagination(r)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3599,8 +5099,12 @@ func (s *server) ListGroups() http.HandlerFunc {
 			return
 		}
 
-		gc := new(GroupCollection)
-		for _, info := range resp {
+		// Sorted so pages stay stable between requests
+		sort.Slice(resp, func(i, j int) bool { return resp[i].JID.String() < resp[j].JID.String() })
+
+		gc := &GroupCollection{Groups: []types.GroupInfo{}, Total: len(resp), Limit: limit, Offset: offset}
+		start, end := pageBounds(len(resp), limit, offset)
+		for _, info := range resp[start:end] {
 			gc.Groups = append(gc.Groups, *info)
 		}
 
@@ -3626,8 +5130,7 @@ func (s *server) GetGroupInfo() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3677,8 +5180,7 @@ func (s *server) GetGroupInviteLink() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3701,6 +5203,18 @@ func (s *server) GetGroupInviteLink() http.HandlerFunc {
 			}
 		}
 
+		// Get qr parameter
+		qrParam := r.URL.Query().Get("qr")
+		withQR := false
+		if qrParam != "" {
+			var err error
+			withQR, err = strconv.ParseBool(qrParam)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("invalid qr parameter, must be true or false"))
+				return
+			}
+		}
+
 		group, ok := parseJID(groupJID)
 		if !ok {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
@@ -3717,6 +5231,14 @@ func (s *server) GetGroupInviteLink() http.HandlerFunc {
 		}
 
 		response := map[string]interface{}{"InviteLink": resp}
+		if withQR {
+			qrCode, err := inviteLinkQRCode(resp)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to encode invite link QR code: %v", err)))
+				return
+			}
+			response["QRCode"] = qrCode
+		}
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
@@ -3729,6 +5251,116 @@ func (s *server) GetGroupInviteLink() http.HandlerFunc {
 	}
 }
 
+// isGroupAdmin reports whether the account identified by own (phone JID) or
+// ownLID is an admin of the group
+func isGroupAdmin(participants []types.GroupParticipant, own, ownLID types.JID) bool {
+	for _, p := range participants {
+		if !p.IsAdmin && !p.IsSuperAdmin {
+			continue
+		}
+		if (!own.IsEmpty() && (p.JID.User == own.User || p.PhoneNumber.User == own.User)) ||
+			(!ownLID.IsEmpty() && (p.LID.User == ownLID.User || p.JID.User == ownLID.User)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Revoke group invite link, returning the new one
+func (s *server) RevokeGroupInviteLink() http.HandlerFunc {
+
+	type revokeInviteLinkStruct struct {
+		GroupJID string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t revokeInviteLinkStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+
+		if t.GroupJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing GroupJID in Payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			return
+		}
+
+		info, err := client.GetGroupInfo(r.Context(), group)
+		if err != nil {
+			s.Respond(w, r, groupErrorStatus(err), errors.New(fmt.Sprintf("failed to get group info: %v", err)))
+			return
+		}
+
+		var own types.JID
+		if client.Store.ID != nil {
+			own = client.Store.ID.ToNonAD()
+		}
+		if !isGroupAdmin(info.Participants, own, client.Store.LID.ToNonAD()) {
+			s.Respond(w, r, http.StatusForbidden, errors.New("only group admins can revoke the invite link"))
+			return
+		}
+
+		link, err := client.GetGroupInviteLink(r.Context(), group, true)
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to revoke group invite link")
+			s.Respond(w, r, groupErrorStatus(err), errors.New(fmt.Sprintf("failed to revoke group invite link: %v", err)))
+			return
+		}
+
+		log.Info().Str("group", group.String()).Msg("Group invite link revoked")
+		response := map[string]interface{}{"Details": "Invite link revoked", "InviteLink": link}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// groupErrorStatus maps whatsmeow group errors to HTTP status codes
+func groupErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, whatsmeow.ErrGroupInviteLinkUnauthorized), errors.Is(err, whatsmeow.ErrNotInGroup):
+		return http.StatusForbidden
+	case errors.Is(err, whatsmeow.ErrGroupNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// qrCodeDataURI renders content as a 256x256 PNG QR code, returned as a
+// base64 data URI that can be used directly as an image source
+func qrCodeDataURI(content string) (string, error) {
+	pngData, err := qrcode.Encode(content, qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(pngData), nil
+}
+
+// inviteLinkQRCode renders an invite link as a base64 PNG QR code data URI,
+// in the same format as the session QR code
+func inviteLinkQRCode(link string) (string, error) {
+	return qrCodeDataURI(link)
+}
+
 // Join group invite link
 func (s *server) GroupJoin() http.HandlerFunc {
 
@@ -3740,8 +5372,7 @@ func (s *server) GroupJoin() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3792,8 +5423,7 @@ func (s *server) CreateGroup() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3864,8 +5494,7 @@ func (s *server) SetGroupLocked() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3917,8 +5546,7 @@ func (s *server) SetDisappearingTimer() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -3989,8 +5617,7 @@ func (s *server) RemoveGroupPhoto() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -4044,8 +5671,7 @@ func (s *server) UpdateGroupParticipants() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -4132,8 +5758,7 @@ func (s *server) GetGroupInviteInfo() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -4183,8 +5808,7 @@ func (s *server) SetGroupPhoto() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -4269,8 +5893,7 @@ func (s *server) SetGroupName() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -4327,8 +5950,7 @@ func (s *server) SetGroupTopic() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -4384,8 +6006,7 @@ func (s *server) GroupLeave() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -4437,8 +6058,7 @@ func (s *server) SetGroupAnnounce() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -4478,19 +6098,152 @@ func (s *server) SetGroupAnnounce() http.HandlerFunc {
 	}
 }
 
+// groupSettingsUpdater is the part of the WhatsApp client that changes group
+// settings
+type groupSettingsUpdater interface {
+	SetGroupName(ctx context.Context, jid types.JID, name string) error
+	SetGroupTopic(ctx context.Context, jid types.JID, previousID, newID, topic string) error
+	SetGroupAnnounce(ctx context.Context, jid types.JID, announce bool) error
+	SetGroupLocked(ctx context.Context, jid types.JID, locked bool) error
+}
+
+// groupSettings are the group settings to change. Nil fields are left as they are.
+type groupSettings struct {
+	GroupJID string
+	Name     *string
+	Topic    *string
+	Announce *bool
+	Locked   *bool
+}
+
+// groupSettingResult is the outcome of changing one group setting
+type groupSettingResult struct {
+	Field   string
+	Success bool
+	Error   string `json:",omitempty"`
+}
+
+// applyGroupSettings changes every provided setting of group in turn. A
+// failing setting does not stop the ones after it.
+func applyGroupSettings(ctx context.Context, updater groupSettingsUpdater, group types.JID, t groupSettings) []groupSettingResult {
+	var results []groupSettingResult
+	apply := func(field string, set func() error) {
+		result := groupSettingResult{Field: field, Success: true}
+		if err := set(); err != nil {
+			log.Error().Err(err).Str("group", group.String()).Str("field", field).Msg("failed to set group setting")
+			result.Success = false
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	if t.Name != nil {
+		apply("Name", func() error { return updater.SetGroupName(ctx, group, *t.Name) })
+	}
+	if t.Topic != nil {
+		apply("Topic", func() error { return updater.SetGroupTopic(ctx, group, "", "", *t.Topic) })
+	}
+	if t.Announce != nil {
+		apply("Announce", func() error { return updater.SetGroupAnnounce(ctx, group, *t.Announce) })
+	}
+	if t.Locked != nil {
+		apply("Locked", func() error { return updater.SetGroupLocked(ctx, group, *t.Locked) })
+	}
+	return results
+}
+
+// respondGroupSettings reports the outcome of every setting, failing with 500
+// when none could be applied
+func (s *server) respondGroupSettings(w http.ResponseWriter, r *http.Request, results []groupSettingResult) {
+	applied := 0
+	for _, result := range results {
+		if result.Success {
+			applied++
+		}
+	}
+	if applied == 0 {
+		s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("failed to set group settings: %s", results[0].Error))
+		return
+	}
+
+	response := map[string]interface{}{
+		"Details": fmt.Sprintf("%d of %d group settings applied", applied, len(results)),
+		"Results": results,
+	}
+	responseJson, err := json.Marshal(response)
+
+	if err != nil {
+		s.Respond(w, r, http.StatusInternalServerError, err)
+	} else {
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Set several group settings in one call
+func (s *server) SetGroupSettings() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		decoder := json.NewDecoder(r.Body)
+		var t groupSettings
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+
+		if t.GroupJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing GroupJID in Payload"))
+			return
+		}
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			return
+		}
+
+		if t.Name == nil && t.Topic == nil && t.Announce == nil && t.Locked == nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("no settings in Payload, use Name, Topic, Announce or Locked"))
+			return
+		}
+		if t.Name != nil && *t.Name == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("empty Name in Payload"))
+			return
+		}
+
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		s.respondGroupSettings(w, r, applyGroupSettings(context.Background(), client, group, t))
+
+		return
+	}
+}
+
 // List newsletters
 func (s *server) ListNewsletter() http.HandlerFunc {
 
 	type NewsletterCollection struct {
 		Newsletter []types.NewsletterMetadata
+		Total      int
+		Limit      int
+		Offset     int
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		limit, offset, err := parsePagination(r)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -4503,9 +6256,12 @@ func (s *server) ListNewsletter() http.HandlerFunc {
 			return
 		}
 
-		gc := new(NewsletterCollection)
-		gc.Newsletter = []types.NewsletterMetadata{}
-		for _, info := range resp {
+		// Sorted so pages stay stable between requests
+		sort.Slice(resp, func(i, j int) bool { return resp[i].ID.String() < resp[j].ID.String() })
+
+		gc := &NewsletterCollection{Newsletter: []types.NewsletterMetadata{}, Total: len(resp), Limit: limit, Offset: offset}
+		start, end := pageBounds(len(resp), limit, offset)
+		for _, info := range resp[start:end] {
 			gc.Newsletter = append(gc.Newsletter, *info)
 		}
 
@@ -4543,100 +6299,347 @@ func (s *server) ListUsers() http.HandlerFunc {
 		MediaDelivery   sql.NullString `db:"media_delivery"`
 		S3RetentionDays sql.NullInt64  `db:"s3_retention_days"`
 	}
-	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		userID, hasID := vars["id"]
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		userID, hasID := vars["id"]
+
+		var query string
+		var args []interface{}
+
+		// Optimized query to fetch everything at once and avoid N+1 deadlock
+		baseQuery := `
+			SELECT 
+				id, name, token, webhook, jid, qrcode, connected, expiration, proxy_url, events, history,
+				s3_enabled, s3_endpoint, s3_region, s3_bucket, s3_path_style, s3_public_url, media_delivery, s3_retention_days
+			FROM users`
+
+		if hasID {
+			query = baseQuery + " WHERE id = $1"
+			args = append(args, userID)
+		} else {
+			query = baseQuery
+		}
+
+		rows, err := s.db.Queryx(query, args...)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("problem accessing DB"))
+			return
+		}
+		defer rows.Close()
+
+		// Create a slice to store the user data
+		users := []map[string]interface{}{}
+
+		// Iterate over the rows and populate the user data
+		for rows.Next() {
+			var user usersStruct
+			err := rows.StructScan(&user)
+			if err != nil {
+				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("admin DB error")
+				s.Respond(w, r, http.StatusInternalServerError, errors.New("problem accessing DB"))
+				return
+			}
+
+			isConnected := false
+			isLoggedIn := false
+			if clientManager.GetWhatsmeowClient(user.Id) != nil {
+				isConnected = clientManager.GetWhatsmeowClient(user.Id).IsConnected()
+				isLoggedIn = clientManager.GetWhatsmeowClient(user.Id).IsLoggedIn()
+			}
+
+			userMap := map[string]interface{}{
+				"id":         user.Id,
+				"name":       user.Name,
+				"token":      user.Token,
+				"webhook":    user.Webhook,
+				"jid":        user.Jid,
+				"qrcode":     user.Qrcode,
+				"connected":  isConnected,
+				"loggedIn":   isLoggedIn,
+				"expiration": user.Expiration.Int64,
+				"proxy_url":  user.ProxyURL.String,
+				"events":     user.Events,
+			}
+
+			// Add proxy_config
+			proxyURL := user.ProxyURL.String
+			userMap["proxy_config"] = map[string]interface{}{
+				"enabled":   proxyURL != "",
+				"proxy_url": proxyURL,
+			}
+
+			// Add s3_config from the same query result
+			s3Config := map[string]interface{}{
+				"enabled":        user.S3Enabled.Bool,
+				"endpoint":       user.S3Endpoint.String,
+				"region":         user.S3Region.String,
+				"bucket":         user.S3Bucket.String,
+				"access_key":     "***", // Don't expose secrets
+				"path_style":     user.S3PathStyle.Bool,
+				"public_url":     user.S3PublicURL.String,
+				"media_delivery": user.MediaDelivery.String,
+				"retention_days": user.S3RetentionDays.Int64,
+			}
+
+			userMap["s3_config"] = s3Config
+			users = append(users, userMap)
+		}
+
+		// Check for any error that occurred during iteration
+		if err := rows.Err(); err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("problem accessing DB"))
+			return
+		}
+
+		// Encode users slice into a JSON string
+		responseJson, err := json.Marshal(users)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// sessionState is the part of the whatsmeow client telling whether it can
+// serve requests
+type sessionState interface {
+	IsConnected() bool
+	IsLoggedIn() bool
+}
+
+// sessionUnavailable returns the status and error to respond with when the
+// session cannot serve requests: 503 while it is not connected and 409 while
+// it is connected but not logged in
+func sessionUnavailable(client sessionState) (int, error) {
+	if !client.IsConnected() {
+		return http.StatusServiceUnavailable, errors.New("session not connected")
+	}
+	if !client.IsLoggedIn() {
+		return http.StatusConflict, errors.New("session not logged in")
+	}
+	return http.StatusOK, nil
+}
+
+// readyClient resolves the whatsmeow client of the user for handlers that
+// talk to WhatsApp. When the session cannot serve the request it responds
+// with 503 or 409 and reports false.
+func (s *server) readyClient(w http.ResponseWriter, r *http.Request, txtid string) (*whatsmeow.Client, bool) {
+	client := clientManager.GetWhatsmeowClient(txtid)
+	if client == nil {
+		s.Respond(w, r, http.StatusServiceUnavailable, errors.New("no session"))
+		return nil, false
+	}
+	if status, err := sessionUnavailable(client); err != nil {
+		s.Respond(w, r, status, err)
+		return nil, false
+	}
+	return client, true
+}
+
+// Admin List sessions, reporting the in-memory connection state of every user
+// and how much of the session capacity is in use
+func (s *server) ListSessions() http.HandlerFunc {
+	type sessionUserStruct struct {
+		Id    string `db:"id"`
+		Name  string `db:"name"`
+		Token string `db:"token"`
+		Jid   string `db:"jid"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var users []sessionUserStruct
+		err := s.db.Select(&users, "SELECT id, name, token, jid FROM users ORDER BY name")
+		if err != nil {
+			log.Error().Err(err).Msg("admin DB error")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("problem accessing DB"))
+			return
+		}
+
+		deviceCounts, err := s.storedDeviceCounts()
+		if err != nil {
+			log.Warn().Err(err).Msg("Could not count stored devices")
+		}
+
+		sessions := []map[string]interface{}{}
+		for _, user := range users {
+			deviceCount := 0
+			if jid, err := types.ParseJID(user.Jid); err == nil && jid.User != "" {
+				deviceCount = deviceCounts[jid.User]
+			}
+
+			isConnected := false
+			isLoggedIn := false
+			deviceJid := ""
+			if client := clientManager.GetWhatsmeowClient(user.Id); client != nil {
+				isConnected = client.IsConnected()
+				isLoggedIn = client.IsLoggedIn()
+				if client.Store != nil && client.Store.ID != nil {
+					deviceJid = client.Store.ID.String()
+				}
+			}
+
+			lastActivity := ""
+			if last, ok := clientManager.GetLastActivity(user.Id); ok {
+				lastActivity = last.UTC().Format(time.RFC3339)
+			}
+
+			sessions = append(sessions, map[string]interface{}{
+				"id":            user.Id,
+				"name":          user.Name,
+				"token":         user.Token,
+				"jid":           user.Jid,
+				"connected":     isConnected,
+				"loggedIn":      isLoggedIn,
+				"device_jid":    deviceJid,
+				"device_count":  deviceCount,
+				"last_activity": lastActivity,
+			})
+		}
+
+		active := clientManager.SessionCount()
+		capacity := map[string]interface{}{
+			"max_sessions":    *maxSessions,
+			"active_sessions": active,
+			"available":       nil,
+		}
+		if *maxSessions > 0 {
+			capacity["available"] = max(*maxSessions-active, 0)
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{"sessions": sessions, "capacity": capacity})
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Reloads the hot-reloadable settings from the .env file and the environment
+func (s *server) ReloadConfig() http.HandlerFunc {
+
+	type reloadStruct struct {
+		Keys []string `json:"keys"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var t reloadStruct
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil && !errors.Is(err, io.EOF) {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+
+		getenv, err := configReloadSource()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read configuration for reload")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to read configuration"))
+			return
+		}
+
+		result, err := reloadConfig(getenv, t.Keys)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		for _, change := range result.Changed {
+			log.Info().Str("key", change.Key).Str("previous", change.Previous).Str("value", change.Value).Msg("Configuration reloaded")
+		}
+		if len(result.RestartRequired) > 0 {
+			log.Warn().Strs("keys", result.RestartRequired).Msg("Configuration changes need a restart to take effect")
+		}
+
+		responseJson, err := json.Marshal(result)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
 
-		var query string
-		var args []interface{}
+// Reports whether RabbitMQ publishing is configured and connected, optionally
+// publishing a test message
+func (s *server) TestRabbitMQ() http.HandlerFunc {
 
-		// Optimized query to fetch everything at once and avoid N+1 deadlock
-		baseQuery := `
-			SELECT 
-				id, name, token, webhook, jid, qrcode, connected, expiration, proxy_url, events, history,
-				s3_enabled, s3_endpoint, s3_region, s3_bucket, s3_path_style, s3_public_url, media_delivery, s3_retention_days
-			FROM users`
+	type rabbitTestStruct struct {
+		Ping bool `json:"ping"`
+	}
 
-		if hasID {
-			query = baseQuery + " WHERE id = $1"
-			args = append(args, userID)
-		} else {
-			query = baseQuery
+	return func(w http.ResponseWriter, r *http.Request) {
+		var t rabbitTestStruct
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil && !errors.Is(err, io.EOF) {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
 		}
 
-		rows, err := s.db.Queryx(query, args...)
+		var open rabbitChannelOpener
+		if rabbitChannel != nil {
+			open = openRabbitPingChannel(rabbitConn)
+		}
+		status := checkRabbit(os.Getenv("RABBITMQ_URL"), rabbitEnabled, open, t.Ping)
+		if status.Error != "" {
+			log.Warn().Str("error", status.Error).Msg("RabbitMQ ping failed")
+		}
+
+		responseJson, err := json.Marshal(status)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("problem accessing DB"))
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
-		defer rows.Close()
-
-		// Create a slice to store the user data
-		users := []map[string]interface{}{}
 
-		// Iterate over the rows and populate the user data
-		for rows.Next() {
-			var user usersStruct
-			err := rows.StructScan(&user)
-			if err != nil {
-				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("admin DB error")
-				s.Respond(w, r, http.StatusInternalServerError, errors.New("problem accessing DB"))
-				return
-			}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
 
-			isConnected := false
-			isLoggedIn := false
-			if clientManager.GetWhatsmeowClient(user.Id) != nil {
-				isConnected = clientManager.GetWhatsmeowClient(user.Id).IsConnected()
-				isLoggedIn = clientManager.GetWhatsmeowClient(user.Id).IsLoggedIn()
-			}
+// Returns the counters of the Open Graph link preview cache
+func (s *server) GetMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := map[string]interface{}{"open_graph": openGraphStats.snapshot()}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
 
-			userMap := map[string]interface{}{
-				"id":         user.Id,
-				"name":       user.Name,
-				"token":      user.Token,
-				"webhook":    user.Webhook,
-				"jid":        user.Jid,
-				"qrcode":     user.Qrcode,
-				"connected":  isConnected,
-				"loggedIn":   isLoggedIn,
-				"expiration": user.Expiration.Int64,
-				"proxy_url":  user.ProxyURL.String,
-				"events":     user.Events,
-			}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
 
-			// Add proxy_config
-			proxyURL := user.ProxyURL.String
-			userMap["proxy_config"] = map[string]interface{}{
-				"enabled":   proxyURL != "",
-				"proxy_url": proxyURL,
-			}
+// Purges the Chatwoot conversation and message mappings older than a given age
+func (s *server) PurgeChatwootCache() http.HandlerFunc {
 
-			// Add s3_config from the same query result
-			s3Config := map[string]interface{}{
-				"enabled":        user.S3Enabled.Bool,
-				"endpoint":       user.S3Endpoint.String,
-				"region":         user.S3Region.String,
-				"bucket":         user.S3Bucket.String,
-				"access_key":     "***", // Don't expose secrets
-				"path_style":     user.S3PathStyle.Bool,
-				"public_url":     user.S3PublicURL.String,
-				"media_delivery": user.MediaDelivery.String,
-				"retention_days": user.S3RetentionDays.Int64,
-			}
+	type purgeStruct struct {
+		OlderThanDays int    `json:"older_than_days"`
+		UserID        string `json:"user_id"`
+		DryRun        bool   `json:"dry_run"`
+	}
 
-			userMap["s3_config"] = s3Config
-			users = append(users, userMap)
+	return func(w http.ResponseWriter, r *http.Request) {
+		var t purgeStruct
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+		if t.OlderThanDays < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("older_than_days must be at least 1"))
+			return
 		}
 
-		// Check for any error that occurred during iteration
-		if err := rows.Err(); err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("problem accessing DB"))
+		cutoff := time.Now().AddDate(0, 0, -t.OlderThanDays)
+		result, err := chatwootService(s.db).PurgeCache(cutoff, t.UserID, t.DryRun)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to purge Chatwoot cache")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to purge Chatwoot cache"))
 			return
 		}
+		if !t.DryRun {
+			log.Info().Int64("conversations", result.Conversations).Int64("messages", result.Messages).Int("older_than_days", t.OlderThanDays).Str("userID", t.UserID).Msg("Purged Chatwoot cache")
+		}
 
-		// Encode users slice into a JSON string
-		responseJson, err := json.Marshal(users)
+		responseJson, err := json.Marshal(result)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
@@ -4695,6 +6698,14 @@ func (s *server) AddUser() http.HandlerFunc {
 		if user.Webhook == "" {
 			user.Webhook = ""
 		}
+		if err := validateWebhookURL(user.Webhook); err != nil {
+			s.respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"code":    http.StatusBadRequest,
+				"error":   err.Error(),
+				"success": false,
+			})
+			return
+		}
 
 		// Encrypt HMAC key if provided
 		var encryptedHmacKey []byte
@@ -4916,6 +6927,15 @@ func (s *server) EditUser() http.HandlerFunc {
 			}
 		}
 
+		if err := validateWebhookURL(user.Webhook); err != nil {
+			s.respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"code":    http.StatusBadRequest,
+				"error":   err.Error(),
+				"success": false,
+			})
+			return
+		}
+
 		// Build dynamic UPDATE query based on provided fields
 		query := "UPDATE users SET "
 		args := []interface{}{}
@@ -5102,6 +7122,7 @@ func (s *server) DeleteUser() http.HandlerFunc {
 			})
 			return
 		}
+		clientManager.ClearActivity(userID)
 		s.respondWithJSON(w, http.StatusOK, map[string]interface{}{
 			"code":    http.StatusOK,
 			"data":    map[string]string{"id": userID},
@@ -5185,6 +7206,8 @@ func (s *server) DeleteUserComplete() http.HandlerFunc {
 		clientManager.DeleteWhatsmeowClient(id)
 		clientManager.DeleteMyClient(id)
 		clientManager.DeleteHTTPClient(id)
+		clientManager.ClearActivity(id)
+		qrExpiry.Delete(id)
 		userinfocache.Delete(token)
 
 		// 4. Remove media files
@@ -5281,6 +7304,88 @@ func validateMessageFields(phone string, stanzaid *string, participant *string)
 	return recipient, nil
 }
 
+// quoteParams holds the optional reply fields accepted by the send handlers.
+// QuotedMessageId is looked up in the message history; QuotedParticipant and
+// QuotedChat can be given when the quoted message is not stored locally.
+type quoteParams struct {
+	QuotedMessageId   string
+	QuotedParticipant string
+	QuotedChat        string
+}
+
+// applyQuoteParams fills the reply fields of ci from the quote params. On error
+// it returns the status to respond with: 400 for bad params and 500 when the
+// message history cannot be read.
+func (s *server) applyQuoteParams(ctx context.Context, userID string, ci *waE2E.ContextInfo, q quoteParams) (int, error) {
+	if q.QuotedMessageId == "" {
+		if q.QuotedParticipant != "" || q.QuotedChat != "" {
+			return http.StatusBadRequest, errors.New("missing QuotedMessageId in Payload")
+		}
+		return http.StatusOK, nil
+	}
+
+	participant := q.QuotedParticipant
+	chat := q.QuotedChat
+	quoted := &waE2E.Message{Conversation: proto.String("")}
+
+	var stored HistoryMessage
+	err := s.db.GetContext(ctx, &stored, "SELECT chat_jid, sender_jid, text_content FROM message_history WHERE user_id = $1 AND message_id = $2 LIMIT 1", userID, q.QuotedMessageId)
+	if err == nil {
+		if participant == "" {
+			participant = stored.SenderJID
+			if participant == "me" {
+				if client := clientManager.GetWhatsmeowClient(userID); client != nil && client.Store.ID != nil {
+					participant = client.Store.ID.ToNonAD().String()
+				}
+			}
+		}
+		if chat == "" {
+			chat = stored.ChatJID
+		}
+		quoted.Conversation = proto.String(stored.TextContent)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		log.Error().Err(err).Str("userID", userID).Str("messageID", q.QuotedMessageId).Msg("Failed to look up quoted message")
+		return http.StatusInternalServerError, errors.New("failed to look up quoted message")
+	}
+
+	if participant == "" || participant == "me" {
+		return http.StatusBadRequest, errors.New("quoted message not found in history, QuotedParticipant is required")
+	}
+
+	participantJID, ok := parseJID(participant)
+	if !ok {
+		return http.StatusBadRequest, errors.New("could not parse QuotedParticipant")
+	}
+
+	ci.StanzaID = proto.String(q.QuotedMessageId)
+	ci.Participant = proto.String(participantJID.String())
+	ci.QuotedMessage = quoted
+	if chat != "" {
+		chatJID, ok := parseJID(chat)
+		if !ok {
+			return http.StatusBadRequest, errors.New("could not parse QuotedChat")
+		}
+		ci.RemoteJID = proto.String(chatJID.String())
+	}
+	return http.StatusOK, nil
+}
+
+// replyContextInfo builds the ContextInfo that makes a message render as a reply
+func replyContextInfo(ci *waE2E.ContextInfo) *waE2E.ContextInfo {
+	reply := &waE2E.ContextInfo{
+		StanzaID:      proto.String(ci.GetStanzaID()),
+		Participant:   proto.String(ci.GetParticipant()),
+		QuotedMessage: ci.QuotedMessage,
+	}
+	if reply.QuotedMessage == nil {
+		reply.QuotedMessage = &waE2E.Message{Conversation: proto.String("")}
+	}
+	if ci.RemoteJID != nil {
+		reply.RemoteJID = proto.String(ci.GetRemoteJID())
+	}
+	return reply
+}
+
 // Set history
 func (s *server) SetHistory() http.HandlerFunc {
 	type historyStruct struct {
@@ -5719,8 +7824,191 @@ func (s *server) DeleteS3Config() http.HandlerFunc {
 		// Remove S3 client
 		GetS3Manager().RemoveClient(txtid)
 
-		response := map[string]interface{}{"Details": "S3 configuration deleted successfully"}
-		responseJson, err := json.Marshal(response)
+		response := map[string]interface{}{"Details": "S3 configuration deleted successfully"}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Gets the delivery state of a sent message from the stored receipts
+func (s *server) GetMessageStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		messageID := r.URL.Query().Get("id")
+		if messageID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id parameter"))
+			return
+		}
+
+		receipt, err := s.getMessageStatus(txtid, messageID)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Respond(w, r, http.StatusNotFound, errors.New("no status known for this message"))
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Str("id", messageID).Msg("Failed to get message status")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to get message status"))
+			return
+		}
+
+		responseJson, err := json.Marshal(receipt)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// ListChats returns the chats in the message history, the most recently
+// active first, with a preview of their last message and their unread count
+func (s *server) ListChats() http.HandlerFunc {
+
+	type ChatCollection struct {
+		Chats  []ChatSummary
+		Total  int
+		Limit  int
+		Offset int
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		limit, offset, err := parsePagination(r)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		var since time.Time
+		if v := r.URL.Query().Get("since"); v != "" {
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || ts < 0 {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("since must be a unix timestamp"))
+				return
+			}
+			since = time.Unix(ts, 0)
+		}
+
+		chats, total, err := s.listChatSummaries(txtid, since, limit, offset)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to list chats")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to list chats"))
+			return
+		}
+
+		responseJson, err := json.Marshal(ChatCollection{Chats: chats, Total: total, Limit: limit, Offset: offset})
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// parseChatMetaJID validates the chat a metadata request refers to
+func parseChatMetaJID(jid string) (types.JID, error) {
+	if jid == "" {
+		return types.JID{}, errors.New("missing jid")
+	}
+	chatJID, err := types.ParseJID(jid)
+	if err != nil || chatJID.User == "" {
+		return types.JID{}, errors.New("invalid Chat JID format")
+	}
+	return chatJID, nil
+}
+
+// Stores local metadata (tags, notes, owner) for a chat, replacing the previous one
+func (s *server) SetChatMeta() http.HandlerFunc {
+
+	type chatMetaStruct struct {
+		Jid   string   `json:"jid"`
+		Tags  []string `json:"tags"`
+		Notes string   `json:"notes"`
+		Owner string   `json:"owner"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		decoder := json.NewDecoder(r.Body)
+		var t chatMetaStruct
+		if err := decoder.Decode(&t); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+
+		chatJID, err := parseChatMetaJID(t.Jid)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		tags := []string{}
+		for _, tag := range t.Tags {
+			tag = strings.TrimSpace(tag)
+			if tag != "" && !Find(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+
+		meta := ChatMetadata{
+			ChatJID:   chatJID.String(),
+			Tags:      tags,
+			Notes:     t.Notes,
+			Owner:     strings.TrimSpace(t.Owner),
+			UpdatedAt: time.Now().UTC(),
+		}
+		if err := s.saveChatMetadata(txtid, meta); err != nil {
+			log.Error().Err(err).Str("jid", meta.ChatJID).Msg("Failed to save chat metadata")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to save chat metadata"))
+			return
+		}
+
+		stored, err := s.getChatMetadata(txtid, meta.ChatJID)
+		if err != nil {
+			log.Error().Err(err).Str("jid", meta.ChatJID).Msg("Failed to read chat metadata")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to read chat metadata"))
+			return
+		}
+
+		responseJson, err := json.Marshal(stored)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Returns the local metadata stored for a chat
+func (s *server) GetChatMeta() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		chatJID, err := parseChatMetaJID(r.URL.Query().Get("jid"))
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		meta, err := s.getChatMetadata(txtid, chatJID.String())
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Respond(w, r, http.StatusNotFound, errors.New("no metadata stored for this chat"))
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Str("jid", chatJID.String()).Msg("Failed to get chat metadata")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to get chat metadata"))
+			return
+		}
+
+		responseJson, err := json.Marshal(meta)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
@@ -5880,6 +8168,286 @@ func (s *server) GetHistory() http.HandlerFunc {
 	}
 }
 
+// historyExportPageSize is how many rows are read from message_history per query
+// while streaming an export
+var historyExportPageSize = 500
+
+// historyExportInlineMaxKB is the default size limit for inlining media in exports
+const historyExportInlineMaxKB = 256
+
+// historyExportMedia describes the media attached to an exported message.
+// Media is referenced by its S3 URL when available, otherwise small files
+// can be inlined as a data URL.
+type historyExportMedia struct {
+	Mimetype string `json:"mimetype,omitempty"`
+	Size     uint64 `json:"size,omitempty"`
+	URL      string `json:"url,omitempty"`
+	DataURL  string `json:"data_url,omitempty"`
+}
+
+// historyExportMessage is a single message in a chat export
+type historyExportMessage struct {
+	MessageID       string              `json:"message_id"`
+	SenderJID       string              `json:"sender_jid"`
+	Timestamp       time.Time           `json:"timestamp"`
+	MessageType     string              `json:"message_type"`
+	TextContent     string              `json:"text_content,omitempty"`
+	QuotedMessageID string              `json:"quoted_message_id,omitempty"`
+	Media           *historyExportMedia `json:"media,omitempty"`
+}
+
+// historyExportWriter renders an export archive incrementally
+type historyExportWriter interface {
+	Begin(chatJID string, exportedAt time.Time) error
+	Message(msg historyExportMessage) error
+	End(count int) error
+}
+
+// jsonHistoryExportWriter writes {"chat_jid", "exported_at", "messages": [...], "count"}
+type jsonHistoryExportWriter struct {
+	w     io.Writer
+	first bool
+}
+
+func (e *jsonHistoryExportWriter) Begin(chatJID string, exportedAt time.Time) error {
+	header, err := json.Marshal(map[string]interface{}{"chat_jid": chatJID, "exported_at": exportedAt})
+	if err != nil {
+		return err
+	}
+	// Reopen the header object to append the messages array
+	e.first = true
+	_, err = fmt.Fprintf(e.w, "%s,\"messages\":[", header[:len(header)-1])
+	return err
+}
+
+func (e *jsonHistoryExportWriter) Message(msg historyExportMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if !e.first {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.first = false
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonHistoryExportWriter) End(count int) error {
+	_, err := fmt.Fprintf(e.w, "],\"count\":%d}\n", count)
+	return err
+}
+
+// htmlHistoryExportWriter writes a self-contained HTML page
+type htmlHistoryExportWriter struct {
+	w io.Writer
+}
+
+func (e *htmlHistoryExportWriter) Begin(chatJID string, exportedAt time.Time) error {
+	title := html.EscapeString(chatJID)
+	_, err := fmt.Fprintf(e.w, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>Chat export %s</title></head>\n<body>\n<h1>%s</h1>\n<p class=\"exported-at\">Exported at %s</p>\n<ol class=\"messages\">\n",
+		title, title, exportedAt.Format(time.RFC3339))
+	return err
+}
+
+func (e *htmlHistoryExportWriter) Message(msg historyExportMessage) error {
+	var media string
+	if msg.Media != nil {
+		src := msg.Media.URL
+		if src == "" {
+			src = msg.Media.DataURL
+		}
+		if src != "" {
+			src = html.EscapeString(src)
+			if msg.MessageType == "image" || msg.MessageType == "sticker" {
+				media = fmt.Sprintf("<img class=\"media\" src=\"%s\">", src)
+			} else {
+				media = fmt.Sprintf("<a class=\"media\" href=\"%s\">%s</a>", src, html.EscapeString(msg.MessageType))
+			}
+		}
+	}
+
+	_, err := fmt.Fprintf(e.w, "<li class=\"message %s\" id=\"%s\"><span class=\"sender\">%s</span> <time>%s</time><p>%s</p>%s</li>\n",
+		html.EscapeString(msg.MessageType),
+		html.EscapeString(msg.MessageID),
+		html.EscapeString(msg.SenderJID),
+		msg.Timestamp.Format(time.RFC3339),
+		html.EscapeString(msg.TextContent),
+		media)
+	return err
+}
+
+func (e *htmlHistoryExportWriter) End(count int) error {
+	_, err := fmt.Fprintf(e.w, "</ol>\n<p class=\"count\">%d messages</p>\n</body>\n</html>\n", count)
+	return err
+}
+
+// historyMediaInfo returns the downloadable media of a stored history event,
+// its mimetype and declared size
+func historyMediaInfo(dataJson string) (*waE2E.Message, string, uint64) {
+	if dataJson == "" {
+		return nil, "", 0
+	}
+
+	var evt events.Message
+	if err := json.Unmarshal([]byte(dataJson), &evt); err != nil || evt.Message == nil {
+		return nil, "", 0
+	}
+
+	msg := evt.Message
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg, msg.GetImageMessage().GetMimetype(), msg.GetImageMessage().GetFileLength()
+	case msg.GetVideoMessage() != nil:
+		return msg, msg.GetVideoMessage().GetMimetype(), msg.GetVideoMessage().GetFileLength()
+	case msg.GetAudioMessage() != nil:
+		return msg, msg.GetAudioMessage().GetMimetype(), msg.GetAudioMessage().GetFileLength()
+	case msg.GetDocumentMessage() != nil:
+		return msg, msg.GetDocumentMessage().GetMimetype(), msg.GetDocumentMessage().GetFileLength()
+	case msg.GetStickerMessage() != nil:
+		return msg, msg.GetStickerMessage().GetMimetype(), msg.GetStickerMessage().GetFileLength()
+	}
+	return nil, "", 0
+}
+
+// exportHistoryMessage converts a stored row into its export form. Media is
+// inlined when inlineMax > 0, no S3 URL is stored, the client is connected and
+// the file fits within inlineMax bytes.
+func exportHistoryMessage(ctx context.Context, client *whatsmeow.Client, row HistoryMessage, inlineMax uint64) historyExportMessage {
+	exported := historyExportMessage{
+		MessageID:       row.MessageID,
+		SenderJID:       row.SenderJID,
+		Timestamp:       row.Timestamp,
+		MessageType:     row.MessageType,
+		TextContent:     row.TextContent,
+		QuotedMessageID: row.QuotedMessageID,
+	}
+
+	mediaMsg, mimetype, size := historyMediaInfo(row.DataJson)
+	if mediaMsg == nil && row.MediaLink == "" {
+		return exported
+	}
+
+	exported.Media = &historyExportMedia{Mimetype: mimetype, Size: size, URL: row.MediaLink}
+	if row.MediaLink != "" || mediaMsg == nil || inlineMax == 0 || size == 0 || size > inlineMax {
+		return exported
+	}
+	if client == nil || !client.IsConnected() {
+		return exported
+	}
+
+	data, err := client.DownloadAny(ctx, mediaMsg)
+	if err != nil {
+		log.Warn().Err(err).Str("message_id", row.MessageID).Msg("Failed to download media for history export")
+		return exported
+	}
+	exported.Media.DataURL = fmt.Sprintf("data:%s;base64,%s", mimetype, base64.StdEncoding.EncodeToString(data))
+	return exported
+}
+
+// ExportHistory streams the full stored history of a chat as a JSON or HTML archive
+func (s *server) ExportHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		if chatJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("chat_jid is required"))
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "html" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("format must be json or html"))
+			return
+		}
+
+		var inlineMax uint64
+		if r.URL.Query().Get("inline_media") == "true" {
+			inlineMaxKB := historyExportInlineMaxKB
+			if v := r.URL.Query().Get("inline_max_kb"); v != "" {
+				kb, err := strconv.Atoi(v)
+				if err != nil || kb <= 0 {
+					s.Respond(w, r, http.StatusBadRequest, errors.New("invalid inline_max_kb"))
+					return
+				}
+				inlineMaxKB = kb
+			}
+			inlineMax = uint64(inlineMaxKB) * 1024
+		}
+
+		query := `
+                SELECT id, user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content, media_link, COALESCE(quoted_message_id, '') as quoted_message_id, COALESCE(datajson, '') as datajson
+                FROM message_history
+                WHERE user_id = $1 AND chat_jid = $2
+                ORDER BY timestamp ASC, id ASC
+                LIMIT $3 OFFSET $4`
+		if s.db.DriverName() == "sqlite" {
+			for i := 1; i <= 4; i++ {
+				query = strings.Replace(query, fmt.Sprintf("$%d", i), "?", 1)
+			}
+		}
+
+		// Read the first page before writing so errors still get a JSON response
+		var page []HistoryMessage
+		if err := s.db.SelectContext(r.Context(), &page, query, txtid, chatJID, historyExportPageSize, 0); err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("failed to get message history: %w", err))
+			return
+		}
+
+		var exporter historyExportWriter
+		filename := "chat-" + strings.NewReplacer("@", "_", ".", "_", ":", "_").Replace(chatJID)
+		if format == "html" {
+			exporter = &htmlHistoryExportWriter{w: w}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			filename += ".html"
+		} else {
+			exporter = &jsonHistoryExportWriter{w: w}
+			w.Header().Set("Content-Type", "application/json")
+			filename += ".json"
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.WriteHeader(http.StatusOK)
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		count := 0
+		if err := exporter.Begin(chatJID, time.Now().UTC()); err != nil {
+			log.Warn().Err(err).Str("chat_jid", chatJID).Msg("History export aborted")
+			return
+		}
+		for len(page) > 0 {
+			for _, row := range page {
+				if err := exporter.Message(exportHistoryMessage(r.Context(), client, row, inlineMax)); err != nil {
+					log.Warn().Err(err).Str("chat_jid", chatJID).Msg("History export aborted")
+					return
+				}
+				count++
+			}
+			if len(page) < historyExportPageSize {
+				break
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+
+			page = nil
+			if err := s.db.SelectContext(r.Context(), &page, query, txtid, chatJID, historyExportPageSize, count); err != nil {
+				// Headers are already sent, the truncated archive is the only signal left
+				log.Error().Err(err).Str("chat_jid", chatJID).Msg("Failed to read history page during export")
+				return
+			}
+		}
+		if err := exporter.End(count); err != nil {
+			log.Warn().Err(err).Str("chat_jid", chatJID).Msg("History export aborted")
+		}
+	}
+}
+
 // syncHistoryForChat syncs history for a specific chat
 func (s *server) syncHistoryForChat(ctx context.Context, userID string, chatJID types.JID, count int) error {
 	chatJIDStr := chatJID.String()
@@ -5987,7 +8555,7 @@ func (s *server) syncHistoryForChat(ctx context.Context, userID string, chatJID
 // save outgoing message to history
 func (s *server) saveOutgoingMessageToHistory(userID, chatJID, messageID, messageType, textContent, mediaLink string, historyLimit int) {
 	if historyLimit > 0 {
-		err := s.saveMessageToHistory(userID, chatJID, "me", messageID, messageType, textContent, mediaLink, "", "")
+		err := s.saveMessageToHistory(userID, chatJID, "me", messageID, messageType, textContent, mediaLink, "", "", true)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to save outgoing message to history")
 		} else {
@@ -6124,6 +8692,129 @@ func (s *server) DeleteHmacConfig() http.HandlerFunc {
 	}
 }
 
+// Configure auto-reconnect policy
+func (s *server) ConfigureReconnect() http.HandlerFunc {
+	type reconnectConfigStruct struct {
+		Enabled     bool `json:"enabled"`
+		MaxAttempts int  `json:"max_attempts"`
+		BaseDelay   int  `json:"base_delay"`
+		MaxDelay    int  `json:"max_delay"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		t := reconnectConfigStruct{
+			Enabled:   defaultReconnectPolicy.Enabled,
+			BaseDelay: int(defaultReconnectPolicy.BaseDelay.Seconds()),
+			MaxDelay:  int(defaultReconnectPolicy.MaxDelay.Seconds()),
+		}
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.MaxAttempts < 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("max_attempts cannot be negative"))
+			return
+		}
+		if t.BaseDelay < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("base_delay must be at least 1 second"))
+			return
+		}
+		if t.MaxDelay < t.BaseDelay {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("max_delay cannot be lower than base_delay"))
+			return
+		}
+
+		_, err = s.db.Exec(`
+            UPDATE users SET reconnect_enabled = $1, reconnect_max_attempts = $2, reconnect_base_delay = $3, reconnect_max_delay = $4 WHERE id = $5`,
+			t.Enabled, t.MaxAttempts, t.BaseDelay, t.MaxDelay, txtid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to save reconnect configuration"))
+			return
+		}
+
+		s.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"Details":      "Reconnect configuration saved successfully",
+			"enabled":      t.Enabled,
+			"max_attempts": t.MaxAttempts,
+			"base_delay":   t.BaseDelay,
+			"max_delay":    t.MaxDelay,
+		})
+	}
+}
+
+// Get auto-reconnect policy
+func (s *server) GetReconnectConfig() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		policy := s.getReconnectPolicy(txtid)
+		s.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"enabled":      policy.Enabled,
+			"max_attempts": policy.MaxAttempts,
+			"base_delay":   int(policy.BaseDelay.Seconds()),
+			"max_delay":    int(policy.MaxDelay.Seconds()),
+		})
+	}
+}
+
+// Enable or disable marking incoming messages read automatically
+func (s *server) SetAutoRead() http.HandlerFunc {
+	type autoReadStruct struct {
+		Enabled *bool `json:"enabled"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		var t autoReadStruct
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+		if t.Enabled == nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing enabled in payload"))
+			return
+		}
+
+		_, err = s.db.Exec("UPDATE users SET auto_read = $1 WHERE id = $2", *t.Enabled, txtid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to save auto-read setting"))
+			return
+		}
+		setAutoRead(txtid, *t.Enabled)
+
+		s.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"Details": "Auto-read setting saved successfully",
+			"enabled": *t.Enabled,
+		})
+	}
+}
+
+// Get whether incoming messages are marked read automatically
+func (s *server) GetAutoRead() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		var enabled bool
+		err := s.db.Get(&enabled, "SELECT COALESCE(auto_read, FALSE) FROM users WHERE id = $1", txtid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to get auto-read setting"))
+			return
+		}
+
+		s.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"enabled": enabled,
+		})
+	}
+}
+
 // RejectCall rejects an incoming call
 func (s *server) RejectCall() http.HandlerFunc {
 
@@ -6136,8 +8827,7 @@ func (s *server) RejectCall() http.HandlerFunc {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
@@ -6171,65 +8861,235 @@ func (s *server) RejectCall() http.HandlerFunc {
 			return
 		}
 
-		log.Info().Str("call_id", t.CallID).Str("call_from", t.CallFrom).Msg("Call rejected")
-		response := map[string]interface{}{"Details": "Call rejected", "CallID": t.CallID}
-		responseJson, err := json.Marshal(response)
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+		log.Info().Str("call_id", t.CallID).Str("call_from", t.CallFrom).Msg("Call rejected")
+		response := map[string]interface{}{"Details": "Call rejected", "CallID": t.CallID}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// GetUserLID retrieves the Local ID (LID) for a given JID/Phone Number
+func (s *server) GetUserLID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		if _, ok := s.readyClient(w, r, txtid); !ok {
+			return
+		}
+
+		// Get JID from URL parameter
+		vars := mux.Vars(r)
+		jidParam := vars["jid"]
+
+		if jidParam == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing jid parameter"))
+			return
+		}
+
+		// Parse the JID (phone number)
+		jid, ok := parseJID(jidParam)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid jid format"))
+			return
+		}
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+
+		// Get the LID for this phone number from the store
+		lid, err := client.Store.LIDs.GetLIDForPN(context.Background(), jid)
+		if err != nil {
+			log.Error().Err(err).Str("jid", jidParam).Msg("Failed to get LID for phone number")
+			s.Respond(w, r, http.StatusNotFound, errors.New(fmt.Sprintf("LID not found for this number: %v", err)))
+			return
+		}
+
+		if lid.IsEmpty() {
+			s.Respond(w, r, http.StatusNotFound, errors.New("LID not found for this number"))
+			return
+		}
+
+		// Return the LID
+		response := map[string]interface{}{
+			"jid": jid.String(),
+			"lid": lid.String(),
+		}
+
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// maxLIDBatch caps the number of identifiers resolved in one call
+const maxLIDBatch = 1000
+
+// lidMappingCache keeps resolved JID/LID pairs per user. Unknown identifiers
+// are not cached so they resolve as soon as WhatsApp shares the mapping.
+var lidMappingCache = cache.New(time.Hour, 2*time.Hour)
+
+// lidResolver is the part of the whatsmeow LID store used to map identifiers
+type lidResolver interface {
+	GetPNForLID(ctx context.Context, lid types.JID) (types.JID, error)
+	GetLIDForPN(ctx context.Context, pn types.JID) (types.JID, error)
+}
+
+// lidMapping is the resolution of one identifier. Jid or Lid is nil when the
+// counterpart is unknown, or both when the input could not be parsed.
+type lidMapping struct {
+	Input string  `json:"input"`
+	Jid   *string `json:"jid"`
+	Lid   *string `json:"lid"`
+	Error string  `json:"error,omitempty"`
+}
+
+// resolveLIDMappings maps phone number JIDs to LIDs and LIDs to phone number
+// JIDs. Inputs naming the same identifier are resolved once, keeping the
+// order of their first appearance.
+func resolveLIDMappings(ctx context.Context, userID string, resolver lidResolver, inputs []string) []lidMapping {
+	mappings := make([]lidMapping, 0, len(inputs))
+	seen := make(map[string]bool)
+
+	for _, input := range inputs {
+		input = strings.TrimSpace(input)
+		if input == "" {
+			continue
+		}
+		jid, ok := parseJID(input)
+		if !ok || jid.User == "" {
+			if !seen[input] {
+				seen[input] = true
+				mappings = append(mappings, lidMapping{Input: input, Error: "invalid jid format"})
+			}
+			continue
+		}
+		jid = jid.ToNonAD()
+		key := jid.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		own := key
+		mapping := lidMapping{Input: input}
+		if jid.Server == types.HiddenUserServer {
+			mapping.Lid = &own
+		} else {
+			mapping.Jid = &own
+		}
+
+		if cached, found := lidMappingCache.Get(userID + "|" + key); found {
+			other := cached.(string)
+			if mapping.Lid == nil {
+				mapping.Lid = &other
+			} else {
+				mapping.Jid = &other
+			}
+			mappings = append(mappings, mapping)
+			continue
+		}
+
+		var other types.JID
+		var err error
+		if jid.Server == types.HiddenUserServer {
+			other, err = resolver.GetPNForLID(ctx, jid)
 		} else {
-			s.Respond(w, r, http.StatusOK, string(responseJson))
+			other, err = resolver.GetLIDForPN(ctx, jid)
 		}
-		return
+		if err != nil {
+			log.Warn().Err(err).Str("jid", key).Msg("Failed to resolve LID mapping")
+		} else if !other.IsEmpty() {
+			otherStr := other.String()
+			if mapping.Lid == nil {
+				mapping.Lid = &otherStr
+			} else {
+				mapping.Jid = &otherStr
+			}
+			lidMappingCache.Set(userID+"|"+key, otherStr, cache.DefaultExpiration)
+			lidMappingCache.Set(userID+"|"+otherStr, key, cache.DefaultExpiration)
+		}
+		mappings = append(mappings, mapping)
 	}
+	return mappings
 }
 
-// GetUserLID retrieves the Local ID (LID) for a given JID/Phone Number
-func (s *server) GetUserLID() http.HandlerFunc {
+// resolveLIDRecipient returns the phone number JID a LID recipient maps to,
+// so messages land in the chat the phone shows for the contact. Other
+// recipients, and LIDs with no known mapping, are returned as is since
+// WhatsApp accepts LIDs as recipients too.
+func resolveLIDRecipient(ctx context.Context, userID string, resolver lidResolver, recipient types.JID) types.JID {
+	if recipient.Server != types.HiddenUserServer {
+		return recipient
+	}
+	mapping := resolveLIDMappings(ctx, userID, resolver, []string{recipient.String()})
+	if len(mapping) != 1 || mapping[0].Jid == nil {
+		log.Debug().Str("lid", recipient.String()).Msg("No phone number known for LID, sending to the LID")
+		return recipient
+	}
+	jid, err := types.ParseJID(*mapping[0].Jid)
+	if err != nil {
+		return recipient
+	}
+	return jid
+}
+
+// sendableRecipient resolves a LID recipient of a send handler with the LID
+// store of the user's session
+func (s *server) sendableRecipient(ctx context.Context, userID string, recipient types.JID) types.JID {
+	if recipient.Server != types.HiddenUserServer {
+		return recipient
+	}
+	client := clientManager.GetWhatsmeowClient(userID)
+	if client == nil || client.Store == nil || client.Store.LIDs == nil {
+		return recipient
+	}
+	return resolveLIDRecipient(ctx, userID, client.Store.LIDs, recipient)
+}
+
+// GetUserLIDs resolves many phone number JIDs and LIDs at once
+func (s *server) GetUserLIDs() http.HandlerFunc {
+
+	type lidBatchStruct struct {
+		Jids []string `json:"jids"`
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		decoder := json.NewDecoder(r.Body)
+		var t lidBatchStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
 			return
 		}
-
-		// Get JID from URL parameter
-		vars := mux.Vars(r)
-		jidParam := vars["jid"]
-
-		if jidParam == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing jid parameter"))
+		if len(t.Jids) == 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing jids in Payload"))
 			return
 		}
-
-		// Parse the JID (phone number)
-		jid, ok := parseJID(jidParam)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid jid format"))
+		if len(t.Jids) > maxLIDBatch {
+			s.Respond(w, r, http.StatusBadRequest, fmt.Errorf("at most %d jids can be resolved at once", maxLIDBatch))
 			return
 		}
 
-		client := clientManager.GetWhatsmeowClient(txtid)
-
-		// Get the LID for this phone number from the store
-		lid, err := client.Store.LIDs.GetLIDForPN(context.Background(), jid)
-		if err != nil {
-			log.Error().Err(err).Str("jid", jidParam).Msg("Failed to get LID for phone number")
-			s.Respond(w, r, http.StatusNotFound, errors.New(fmt.Sprintf("LID not found for this number: %v", err)))
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
 			return
 		}
 
-		if lid.IsEmpty() {
-			s.Respond(w, r, http.StatusNotFound, errors.New("LID not found for this number"))
-			return
-		}
+		mappings := resolveLIDMappings(r.Context(), txtid, client.Store.LIDs, t.Jids)
 
-		// Return the LID
 		response := map[string]interface{}{
-			"jid": jid.String(),
-			"lid": lid.String(),
+			"mappings": mappings,
+			"total":    len(mappings),
 		}
-
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
@@ -6251,10 +9111,8 @@ func (s *server) RequestUnavailableMessage() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		client := clientManager.GetWhatsmeowClient(txtid)
-
-		if client == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
 			return
 		}
 
@@ -6336,10 +9194,8 @@ func (s *server) ArchiveChat() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		client := clientManager.GetWhatsmeowClient(txtid)
-
-		if client == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
 			return
 		}
 
@@ -6389,6 +9245,294 @@ func (s *server) ArchiveChat() http.HandlerFunc {
 
 }
 
+// Marks a chat as unread, flagging it in the chat list of every linked device
+func (s *server) MarkChatUnread() http.HandlerFunc {
+
+	type requestUnreadStruct struct {
+		Jid string `json:"jid"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		decoder := json.NewDecoder(r.Body)
+		var t requestUnreadStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+
+		if t.Jid == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing jid in Payload"))
+			return
+		}
+
+		chatJID, err := types.ParseJID(t.Jid)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid Chat JID format"))
+			return
+		}
+
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		err = client.SendAppState(ctx, appstate.BuildMarkChatAsRead(chatJID, false, time.Time{}, nil))
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to mark chat as unread: %s", err)))
+			return
+		}
+
+		response := map[string]interface{}{
+			"success": true,
+			"message": "Chat marked as unread",
+			"jid":     chatJID.String(),
+			"unread":  true,
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// chatMuteDurations are the mute durations offered by WhatsApp. Always mutes
+// until the chat is unmuted.
+var chatMuteDurations = map[string]time.Duration{
+	"8h":     8 * time.Hour,
+	"1w":     7 * 24 * time.Hour,
+	"always": 0,
+}
+
+// Mutes or unmutes a chat
+func (s *server) MuteChat(mute bool) http.HandlerFunc {
+
+	type requestMuteStruct struct {
+		Jid      string `json:"jid"`
+		Duration string `json:"duration"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		decoder := json.NewDecoder(r.Body)
+		var t requestMuteStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+
+		if t.Jid == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing jid in Payload"))
+			return
+		}
+
+		chatJID, err := types.ParseJID(t.Jid)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid Chat JID format"))
+			return
+		}
+
+		duration, ok := chatMuteDurations[t.Duration]
+		if mute && !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("duration must be one of 8h, 1w or always"))
+			return
+		}
+
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		var muteEnd *int64
+		var mutedUntil *time.Time
+		if mute && duration > 0 {
+			until := time.Now().Add(duration)
+			mutedUntil = &until
+			muteEnd = proto.Int64(until.UnixMilli())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		err = client.SendAppState(ctx, appstate.BuildMuteAbs(chatJID, mute, muteEnd))
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to update chat mute: %s", err)))
+			return
+		}
+
+		statusText := "Chat muted"
+		if !mute {
+			statusText = "Chat unmuted"
+		}
+		response := map[string]interface{}{
+			"success":     true,
+			"message":     statusText,
+			"jid":         chatJID.String(),
+			"muted":       mute,
+			"muted_until": mutedUntil,
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// maxPinnedChats is how many chats WhatsApp allows pinned in the chat list
+const maxPinnedChats = 3
+
+// messagePinDurations are the durations WhatsApp offers for pinned messages
+var messagePinDurations = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// Pins or unpins a chat in the chat list, or a message within a chat when
+// message_id is given
+func (s *server) PinChat(pin bool) http.HandlerFunc {
+
+	type requestPinStruct struct {
+		Jid       string `json:"jid"`
+		MessageID string `json:"message_id"`
+		Sender    string `json:"sender"`
+		Duration  string `json:"duration"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		decoder := json.NewDecoder(r.Body)
+		var t requestPinStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+
+		if t.Jid == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing jid in Payload"))
+			return
+		}
+
+		chatJID, err := types.ParseJID(t.Jid)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid Chat JID format"))
+			return
+		}
+
+		if t.MessageID == "" && t.Duration != "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("duration only applies to pinned messages"))
+			return
+		}
+		if t.Duration == "" {
+			t.Duration = "7d"
+		}
+		duration, ok := messagePinDurations[t.Duration]
+		if pin && t.MessageID != "" && !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("duration must be one of 24h, 7d or 30d"))
+			return
+		}
+
+		sender := types.EmptyJID
+		if t.Sender != "" {
+			var ok bool
+			sender, ok = parseJID(t.Sender)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("invalid sender"))
+				return
+			}
+		}
+
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		response := map[string]interface{}{
+			"success": true,
+			"jid":     chatJID.String(),
+			"pinned":  pin,
+		}
+
+		if t.MessageID != "" {
+			pinType := waE2E.PinInChatMessage_PIN_FOR_ALL
+			if !pin {
+				pinType = waE2E.PinInChatMessage_UNPIN_FOR_ALL
+			}
+			msg := &waE2E.Message{
+				PinInChatMessage: &waE2E.PinInChatMessage{
+					Key:               client.BuildMessageKey(chatJID, sender, t.MessageID),
+					Type:              pinType.Enum(),
+					SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
+				},
+			}
+			if pin {
+				msg.MessageContextInfo = &waE2E.MessageContextInfo{
+					MessageAddOnDurationInSecs: proto.Uint32(uint32(duration.Seconds())),
+				}
+				response["expires_at"] = time.Now().Add(duration)
+			}
+
+			if _, err := client.SendMessage(ctx, chatJID, msg); err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to pin message: %s", err)))
+				return
+			}
+			response["message_id"] = t.MessageID
+			response["message"] = "Message pinned"
+			if !pin {
+				response["message"] = "Message unpinned"
+			}
+		} else {
+			if pin {
+				settings, err := client.Store.ChatSettings.GetChatSettings(ctx, chatJID)
+				if err != nil {
+					log.Warn().Err(err).Str("jid", chatJID.String()).Msg("Failed to get chat settings")
+				}
+				if !settings.Pinned {
+					count, err := s.pinnedChatCount(client.Store.ID.String())
+					if err != nil {
+						log.Warn().Err(err).Msg("Could not count pinned chats, limit not enforced")
+					} else if count >= maxPinnedChats {
+						s.Respond(w, r, http.StatusConflict, fmt.Errorf("at most %d chats can be pinned", maxPinnedChats))
+						return
+					}
+				}
+			}
+
+			if err := client.SendAppState(ctx, appstate.BuildPin(chatJID, pin)); err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to pin chat: %s", err)))
+				return
+			}
+			response["message"] = "Chat pinned"
+			if !pin {
+				response["message"] = "Chat unpinned"
+			}
+		}
+
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
 // Downloads Sticker and returns base64 representation
 func (s *server) DownloadSticker() http.HandlerFunc {
 
@@ -6409,8 +9553,7 @@ func (s *server) DownloadSticker() http.HandlerFunc {
 		mimetype := ""
 		var stickerdata []byte
 
-		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+		if _, ok := s.readyClient(w, r, txtid); !ok {
 			return
 		}
 
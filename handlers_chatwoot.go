@@ -2,13 +2,21 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 	"wuzapi/pkg/chatwoot"
 
 	"github.com/gorilla/mux"
+	"github.com/patrickmn/go-cache"
 	"github.com/rs/zerolog/log"
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/proto/waE2E"
@@ -23,6 +31,7 @@ type ChatwootWebhookPayload struct {
 	ID           int                    `json:"id"`
 	Content      string                 `json:"content"`
 	Private      bool                   `json:"private"`
+	IsPrivate    bool                   `json:"is_private"` // typing events only
 	ContentAttrs map[string]interface{} `json:"content_attributes"`
 	Conversation struct {
 		ID     int    `json:"id"`
@@ -53,6 +62,27 @@ type ChatwootWebhookPayload struct {
 	} `json:"sender"`
 }
 
+// Webhook outcome statuses. Ignored-by-design events answer 200, client
+// errors 4xx, and WhatsApp availability or send failures 5xx.
+const (
+	webhookStatusSuccess = "success"
+	webhookStatusIgnored = "ignored"
+	webhookStatusError   = "error"
+)
+
+// ChatwootWebhookResponse is the envelope returned for every Chatwoot webhook.
+// Reason is a stable snake_case code; Detail is optional free text.
+type ChatwootWebhookResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// respondWebhook sends the standard Chatwoot webhook envelope
+func respondWebhook(w http.ResponseWriter, statusCode int, status, reason, detail string) {
+	respondJSON(w, statusCode, ChatwootWebhookResponse{Status: status, Reason: reason, Detail: detail})
+}
+
 // HandleChatwootWebhook processes incoming webhooks from Chatwoot
 func (s *server) HandleChatwootWebhook() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -66,7 +96,7 @@ func (s *server) HandleChatwootWebhook() http.HandlerFunc {
 
 		if token == "" {
 			log.Warn().Msg("Chatwoot webhook called without token")
-			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing token"})
+			respondWebhook(w, http.StatusUnauthorized, webhookStatusError, "missing_token", "token required in path or query")
 			return
 		}
 
@@ -78,7 +108,7 @@ func (s *server) HandleChatwootWebhook() http.HandlerFunc {
 			err := s.db.Get(&userID, "SELECT id FROM users WHERE token=$1 LIMIT 1", token)
 			if err != nil {
 				log.Warn().Str("token", token).Msg("Chatwoot webhook: user not found")
-				respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid token"})
+				respondWebhook(w, http.StatusUnauthorized, webhookStatusError, "invalid_token", "")
 				return
 			}
 		} else {
@@ -89,7 +119,7 @@ func (s *server) HandleChatwootWebhook() http.HandlerFunc {
 		var payload ChatwootWebhookPayload
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 			log.Error().Err(err).Msg("Failed to parse Chatwoot webhook payload")
-			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid payload"})
+			respondWebhook(w, http.StatusBadRequest, webhookStatusError, "invalid_payload", err.Error())
 			return
 		}
 
@@ -100,22 +130,28 @@ func (s *server) HandleChatwootWebhook() http.HandlerFunc {
 			Int("conversation_id", payload.Conversation.ID).
 			Msg("Chatwoot webhook received")
 
+		// Agent typing in Chatwoot, mirrored as WhatsApp presence when enabled
+		if payload.Event == "conversation_typing_on" || payload.Event == "conversation_typing_off" {
+			s.handleChatwootTyping(w, userID, &payload)
+			return
+		}
+
 		// 4. Filter events - only process outgoing messages from agents
 		if payload.Event != "message_created" {
 			log.Debug().Str("event", payload.Event).Msg("Ignoring non-message_created event")
-			respondJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": "not message_created"})
+			respondWebhook(w, http.StatusOK, webhookStatusIgnored, "not_message_created", payload.Event)
 			return
 		}
 
 		if payload.MessageType != "outgoing" {
 			log.Debug().Str("message_type", payload.MessageType).Msg("Ignoring non-outgoing message")
-			respondJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": "not outgoing"})
+			respondWebhook(w, http.StatusOK, webhookStatusIgnored, "not_outgoing", payload.MessageType)
 			return
 		}
 
 		if payload.Private {
 			log.Debug().Msg("Ignoring private note")
-			respondJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": "private note"})
+			respondWebhook(w, http.StatusOK, webhookStatusIgnored, "private_note", "")
 			return
 		}
 
@@ -124,30 +160,47 @@ func (s *server) HandleChatwootWebhook() http.HandlerFunc {
 			firstMsg := payload.Conversation.Messages[0]
 			if strings.HasPrefix(firstMsg.SourceID, "WAID:") && firstMsg.ID == payload.ID {
 				log.Debug().Int("message_id", payload.ID).Msg("Ignoring message sent by Wuzapi (loop prevention)")
-				respondJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": "loop prevention"})
+				respondWebhook(w, http.StatusOK, webhookStatusIgnored, "loop_prevention", "")
 				return
 			}
 		}
 
-		// 6. Extract destination phone number
-		chatID := payload.Conversation.Meta.Sender.Identifier
-		if chatID == "" {
-			chatID = payload.Conversation.Meta.Sender.PhoneNumber
-			chatID = strings.TrimPrefix(chatID, "+")
-		} else {
-			// If identifier is already a JID, extract phone number
-			chatID = strings.Split(chatID, "@")[0]
+		// Chatwoot may deliver the same webhook again before it is sent, and
+		// its id only reaches messageDedupeCache once WhatsApp accepted it.
+		// The key holds false, without expiry, while the message is being
+		// sent however long that takes, and only the sent state expires.
+		sent := false
+		if chatwootOutgoingDedupeTTL > 0 {
+			dedupeKey := chatwootOutgoingKey(userID, &payload)
+			if err := chatwootOutgoing.Add(dedupeKey, false, cache.NoExpiration); err != nil {
+				if done, _ := chatwootOutgoing.Get(dedupeKey); done == true {
+					log.Info().Int("message_id", payload.ID).Int("conversation_id", payload.Conversation.ID).Msg("Ignoring duplicate Chatwoot webhook")
+					respondWebhook(w, http.StatusOK, webhookStatusIgnored, "duplicate", "")
+					return
+				}
+				// Not acknowledged, the first attempt may still fail
+				log.Info().Int("message_id", payload.ID).Int("conversation_id", payload.Conversation.ID).Msg("Chatwoot webhook redelivered while still sending")
+				respondWebhook(w, http.StatusConflict, webhookStatusError, "in_flight", "message is still being sent")
+				return
+			}
+			// Webhooks that were not sent are forgotten so Chatwoot can retry them
+			defer func() {
+				if sent {
+					chatwootOutgoing.Set(dedupeKey, true, chatwootOutgoingDedupeTTL)
+				} else {
+					chatwootOutgoing.Delete(dedupeKey)
+				}
+			}()
 		}
 
-		if chatID == "" {
+		// 6-7. Extract destination and convert to WhatsApp JID
+		recipientJID, ok := chatwootRecipient(&payload)
+		if !ok {
 			log.Error().Msg("Could not extract destination phone from Chatwoot webhook")
-			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "no destination"})
+			respondWebhook(w, http.StatusBadRequest, webhookStatusError, "no_destination", "conversation sender has no identifier or phone number")
 			return
 		}
 
-		// 7. Convert to WhatsApp JID
-		recipientJID := types.NewJID(chatID, types.DefaultUserServer)
-
 		log.Info().
 			Str("user_id", userID).
 			Str("recipient_jid", recipientJID.String()).
@@ -156,7 +209,7 @@ func (s *server) HandleChatwootWebhook() http.HandlerFunc {
 
 		// 8. FIRST: Save conversation to cache BEFORE sending (even if send fails)
 		if payload.Conversation.ID > 0 {
-			cwService := chatwoot.NewService(s.db)
+			cwService := chatwootService(s.db)
 			chatJID := recipientJID.String()
 			err := cwService.StoreConversationFromWebhook(
 				userID,
@@ -172,22 +225,10 @@ func (s *server) HandleChatwootWebhook() http.HandlerFunc {
 
 		// 9. THEN: Send message to WhatsApp (SYNCHRONOUSLY - no goroutine)
 		ctx := context.Background()
-		waClient := clientManager.GetWhatsmeowClient(userID)
-		if waClient == nil {
-			log.Error().Str("user_id", userID).Msg("WhatsApp client not found")
-			respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "whatsapp client not ready"})
-			return
-		}
-
-		if !waClient.IsLoggedIn() {
-			log.Error().Str("user_id", userID).Msg("WhatsApp client not logged in")
-			respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "whatsapp not logged in"})
-			return
-		}
-
-		if !waClient.IsConnected() {
-			log.Error().Str("user_id", userID).Msg("WhatsApp client not connected")
-			respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "whatsapp disconnected"})
+		waClient, reason := chatwootWhatsAppClient(userID)
+		if reason != "" {
+			log.Error().Str("user_id", userID).Str("reason", reason).Msg("WhatsApp client not ready for Chatwoot message")
+			respondWebhook(w, http.StatusServiceUnavailable, webhookStatusError, reason, "")
 			return
 		}
 
@@ -302,10 +343,12 @@ func (s *server) HandleChatwootWebhook() http.HandlerFunc {
 							whatsappMsg.DocumentMessage.FileLength = proto.Uint64(uploadedMedia.FileLength)
 						}
 						// Send media message
-						resp, err := waClient.SendMessage(ctx, recipientJID, whatsappMsg)
+						msgid := waClient.GenerateMessageID()
+						resp, err := sendChatwootMessage(ctx, waClient, recipientJID, whatsappMsg, msgid)
 						if err != nil {
 							log.Error().Err(err).Msg("Failed to send media message to WhatsApp")
-							respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to send media"})
+							s.notifySendFailed(userID, recipientJID, msgid, chatwootMediaType(attachment.FileType), err)
+							respondWebhook(w, http.StatusInternalServerError, webhookStatusError, "send_failed", err.Error())
 							return
 						}
 						// Store message ID in dedupe cache
@@ -314,36 +357,347 @@ func (s *server) HandleChatwootWebhook() http.HandlerFunc {
 					}
 
 					// 10. Return success
-					respondJSON(w, http.StatusOK, map[string]string{"status": "success"})
+					sent = true
+					respondWebhook(w, http.StatusOK, webhookStatusSuccess, "sent", "")
 					return
 				}
 			}
 		}
 
+		// Nothing to send (no attachments and empty content)
+		if payload.Content == "" {
+			respondWebhook(w, http.StatusOK, webhookStatusIgnored, "empty_message", "")
+			return
+		}
+
 		// Send text message
-		if payload.Content != "" {
-			resp, err := waClient.SendMessage(ctx, recipientJID, &waE2E.Message{
-				Conversation: proto.String(payload.Content),
-			})
+		msgid := waClient.GenerateMessageID()
+		resp, err := sendChatwootMessage(ctx, waClient, recipientJID, &waE2E.Message{
+			Conversation: proto.String(payload.Content),
+		}, msgid)
 
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to send text message to WhatsApp")
-				respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to send message"})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to send text message to WhatsApp")
+			s.notifySendFailed(userID, recipientJID, msgid, "text", err)
+			respondWebhook(w, http.StatusInternalServerError, webhookStatusError, "send_failed", err.Error())
+			return
+		}
+
+		// Store message ID in dedupe cache to prevent echo when message comes back
+		messageDedupeCache.Store(resp.ID, true)
+
+		log.Info().
+			Str("recipient_jid", recipientJID.String()).
+			Str("whatsapp_message_id", resp.ID).
+			Int("chatwoot_message_id", payload.ID).
+			Msg("Message sent from Chatwoot to WhatsApp successfully (ID stored in dedupe cache)")
+
+		// 10. Return success
+		sent = true
+		respondWebhook(w, http.StatusOK, webhookStatusSuccess, "sent", "")
+	}
+}
+
+// chatwootOutgoingDedupeTTL is how long a message from Chatwoot is remembered
+// by its id and content, dropping webhooks delivered again meanwhile. Zero
+// disables the check.
+var chatwootOutgoingDedupeTTL = 10 * time.Second
+
+// chatwootOutgoing holds the dedupe keys of the recent messages from Chatwoot
+var chatwootOutgoing = cache.New(time.Minute, time.Minute)
+
+// chatwootOutgoingKey identifies a message from Chatwoot by user,
+// conversation, message id and a hash of its content and attachments, so
+// the same reply sent twice by an agent is not mistaken for a redelivery
+func chatwootOutgoingKey(userID string, payload *ChatwootWebhookPayload) string {
+	hash := sha256.New()
+	hash.Write([]byte(payload.Content))
+	for _, msg := range payload.Conversation.Messages {
+		if msg.ID != payload.ID {
+			continue
+		}
+		for _, attachment := range msg.Attachments {
+			hash.Write([]byte{0})
+			hash.Write([]byte(attachment.DataURL))
+		}
+	}
+	return fmt.Sprintf("%s|%d|%d|%x", userID, payload.Conversation.ID, payload.ID, hash.Sum(nil))
+}
+
+// chatwootWhatsAppClient returns the WhatsApp client messages from Chatwoot
+// are sent with, or the webhook reason it cannot send them. It is a variable
+// so tests can stand in for a connected client.
+var chatwootWhatsAppClient = func(userID string) (*whatsmeow.Client, string) {
+	waClient := clientManager.GetWhatsmeowClient(userID)
+	switch {
+	case waClient == nil:
+		return nil, "client_not_ready"
+	case !waClient.IsLoggedIn():
+		return nil, "not_logged_in"
+	case !waClient.IsConnected():
+		return nil, "disconnected"
+	}
+	return waClient, ""
+}
+
+// sendChatwootMessage sends a message from Chatwoot to WhatsApp with the
+// given id. It is a variable so tests can observe sends.
+var sendChatwootMessage = func(ctx context.Context, waClient *whatsmeow.Client, to types.JID, msg *waE2E.Message, id types.MessageID) (whatsmeow.SendResponse, error) {
+	return waClient.SendMessage(ctx, to, msg, whatsmeow.SendRequestExtra{ID: id})
+}
+
+// chatwootMediaType names the message type a Chatwoot attachment is sent as
+func chatwootMediaType(fileType string) string {
+	for _, kind := range []string{"image", "video", "audio"} {
+		if strings.HasPrefix(fileType, kind) {
+			return kind
+		}
+	}
+	return "document"
+}
+
+// chatwootRecipient extracts the WhatsApp recipient of a Chatwoot conversation
+// from the sender identifier (a JID) or phone number
+func chatwootRecipient(payload *ChatwootWebhookPayload) (types.JID, bool) {
+	chatID := payload.Conversation.Meta.Sender.Identifier
+	if chatID == "" {
+		chatID = payload.Conversation.Meta.Sender.PhoneNumber
+		chatID = strings.TrimPrefix(chatID, "+")
+	} else {
+		// If identifier is already a JID, extract phone number
+		chatID = strings.Split(chatID, "@")[0]
+	}
+
+	if chatID == "" {
+		return types.EmptyJID, false
+	}
+	return types.NewJID(chatID, types.DefaultUserServer), true
+}
+
+// chatwootTypingInterval is the minimum time between composing presences sent
+// for the same recipient while an agent keeps typing
+var chatwootTypingInterval = 5 * time.Second
+
+// chatwootTyping debounces typing presences per user and recipient
+var chatwootTyping = struct {
+	sync.Mutex
+	lastComposing map[string]time.Time
+}{lastComposing: make(map[string]time.Time)}
+
+// sendChatwootPresence sends a chat presence through the user's WhatsApp client.
+// It is a variable so tests can observe presence calls.
+var sendChatwootPresence = func(userID string, recipient types.JID, state types.ChatPresence) error {
+	client := clientManager.GetWhatsmeowClient(userID)
+	if client == nil || !client.IsConnected() {
+		return errors.New("whatsapp client not ready")
+	}
+	return client.SendChatPresence(context.Background(), recipient, state, types.ChatPresenceMediaText)
+}
+
+// shouldSendChatwootTyping reports whether a presence for state must be sent
+// to key now, recording composing presences for debouncing
+func shouldSendChatwootTyping(key string, state types.ChatPresence, now time.Time) bool {
+	chatwootTyping.Lock()
+	defer chatwootTyping.Unlock()
+
+	last, composing := chatwootTyping.lastComposing[key]
+	if state == types.ChatPresencePaused {
+		// Only stop typing when a composing presence was sent
+		delete(chatwootTyping.lastComposing, key)
+		return composing
+	}
+
+	if composing && now.Sub(last) < chatwootTypingInterval {
+		return false
+	}
+	chatwootTyping.lastComposing[key] = now
+	return true
+}
+
+// handleChatwootTyping mirrors Chatwoot agent typing events as WhatsApp
+// composing/paused presence when typing_presence is enabled for the user
+func (s *server) handleChatwootTyping(w http.ResponseWriter, userID string, payload *ChatwootWebhookPayload) {
+	var enabled bool
+	query := `SELECT typing_presence FROM chatwoot_config WHERE user_id = $1 AND enabled = $2`
+	if s.db.DriverName() == "sqlite" {
+		query = strings.Replace(query, "$1", "?", 1)
+		query = strings.Replace(query, "$2", "?", 1)
+	}
+	if err := s.db.Get(&enabled, query, userID, true); err != nil || !enabled {
+		respondWebhook(w, http.StatusOK, webhookStatusIgnored, "typing_presence_disabled", "")
+		return
+	}
+
+	if payload.IsPrivate {
+		respondWebhook(w, http.StatusOK, webhookStatusIgnored, "private_note", "")
+		return
+	}
+
+	recipientJID, ok := chatwootRecipient(payload)
+	if !ok {
+		respondWebhook(w, http.StatusBadRequest, webhookStatusError, "no_destination", "conversation sender has no identifier or phone number")
+		return
+	}
+
+	state := types.ChatPresenceComposing
+	if payload.Event == "conversation_typing_off" {
+		state = types.ChatPresencePaused
+	}
+
+	key := userID + ":" + recipientJID.String()
+	if !shouldSendChatwootTyping(key, state, time.Now()) {
+		respondWebhook(w, http.StatusOK, webhookStatusIgnored, "typing_debounced", "")
+		return
+	}
+
+	if err := sendChatwootPresence(userID, recipientJID, state); err != nil {
+		log.Warn().Err(err).Str("recipient_jid", recipientJID.String()).Msg("Failed to send typing presence from Chatwoot")
+		// Let the next typing event retry instead of being debounced
+		chatwootTyping.Lock()
+		delete(chatwootTyping.lastComposing, key)
+		chatwootTyping.Unlock()
+		respondWebhook(w, http.StatusServiceUnavailable, webhookStatusError, "presence_failed", err.Error())
+		return
+	}
+
+	respondWebhook(w, http.StatusOK, webhookStatusSuccess, "presence_sent", string(state))
+}
+
+// chatwootSyncContacts converts the WhatsApp contact store into Chatwoot sync
+// entries. Only phone-number contacts are kept and the result is sorted by JID.
+func chatwootSyncContacts(contacts map[types.JID]types.ContactInfo) []chatwoot.SyncContact {
+	result := make([]chatwoot.SyncContact, 0, len(contacts))
+	for jid, info := range contacts {
+		if jid.Server != types.DefaultUserServer || jid.User == "" {
+			continue
+		}
+
+		name := info.FullName
+		if name == "" {
+			name = info.PushName
+		}
+		if name == "" {
+			name = info.BusinessName
+		}
+		if name == "" {
+			name = jid.User
+		}
+
+		result = append(result, chatwoot.SyncContact{
+			JID:   jid.ToNonAD().String(),
+			Phone: "+" + jid.User,
+			Name:  name,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].JID < result[j].JID })
+	return result
+}
+
+// SyncChatwootContacts upserts the WhatsApp contacts of the user into
+// Chatwoot, one page of the contacts sorted by JID per call so a call ends
+// well within the server write timeout
+func (s *server) SyncChatwootContacts() http.HandlerFunc {
+
+	type syncResponse struct {
+		*chatwoot.SyncSummary
+		Contacts   int  `json:"contacts"`
+		Limit      int  `json:"limit"`
+		Offset     int  `json:"offset"`
+		NextOffset *int `json:"next_offset,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		limit, offset, err := parsePagination(r)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		contacts, err := client.Store.Contacts.GetAllContacts(r.Context())
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		all := chatwootSyncContacts(contacts)
+		start, end := pageBounds(len(all), limit, offset)
+
+		cwService := chatwootService(s.db)
+
+		summary, err := cwService.SyncContacts(r.Context(), txtid, all[start:end], client)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				s.Respond(w, r, http.StatusNotFound, errors.New("chatwoot not configured"))
 				return
 			}
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
 
-			// Store message ID in dedupe cache to prevent echo when message comes back
-			messageDedupeCache.Store(resp.ID, true)
+		response := syncResponse{SyncSummary: summary, Contacts: len(all), Limit: limit, Offset: offset}
+		if end < len(all) {
+			response.NextOffset = &end
+		}
 
-			log.Info().
-				Str("recipient_jid", recipientJID.String()).
-				Str("whatsapp_message_id", resp.ID).
-				Int("chatwoot_message_id", payload.ID).
-				Msg("Message sent from Chatwoot to WhatsApp successfully (ID stored in dedupe cache)")
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
+	}
+}
 
-		// 10. Return success
-		respondJSON(w, http.StatusOK, map[string]string{"status": "success"})
+// ImportChatwootContacts upserts a list of contacts, with custom attributes,
+// into Chatwoot
+func (s *server) ImportChatwootContacts() http.HandlerFunc {
+
+	type importStruct struct {
+		Contacts []chatwoot.ImportContact `json:"contacts"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		var t importStruct
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+		if len(t.Contacts) == 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing contacts in Payload"))
+			return
+		}
+		if len(t.Contacts) > chatwoot.MaxImportContacts {
+			s.Respond(w, r, http.StatusBadRequest, fmt.Errorf("too many contacts, at most %d per import", chatwoot.MaxImportContacts))
+			return
+		}
+
+		cwService := chatwootService(s.db)
+
+		summary, err := cwService.ImportContacts(r.Context(), txtid, t.Contacts)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				s.Respond(w, r, http.StatusNotFound, errors.New("chatwoot not configured"))
+				return
+			}
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		responseJson, err := json.Marshal(summary)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
 	}
 }
 
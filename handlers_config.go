@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -25,8 +26,13 @@ type ChatwootConfigRequest struct {
 	ReopenConversation  bool   `json:"reopen_conversation,omitempty"`
 	ConversationPending bool   `json:"conversation_pending,omitempty"`
 	MergeBrazilContacts bool   `json:"merge_brazil_contacts,omitempty"`
+	TypingPresence      bool   `json:"typing_presence,omitempty"`
+	UpdateContactNames  bool   `json:"update_contact_names,omitempty"`
 	Organization        string `json:"organization,omitempty"`
 	Logo                string `json:"logo,omitempty"`
+	DefaultAssigneeID   *int64 `json:"default_assignee_id,omitempty"`
+	DefaultTeamID       *int64 `json:"default_team_id,omitempty"`
+	DryRun              bool   `json:"dry_run,omitempty"`
 }
 
 // ChatwootConfigResponse represents the response for Chatwoot configuration
@@ -44,8 +50,12 @@ type ChatwootConfigResponse struct {
 	ReopenConversation  bool   `json:"reopen_conversation"`
 	ConversationPending bool   `json:"conversation_pending"`
 	MergeBrazilContacts bool   `json:"merge_brazil_contacts"`
+	TypingPresence      bool   `json:"typing_presence"`
+	UpdateContactNames  bool   `json:"update_contact_names"`
 	Organization        string `json:"organization,omitempty"`
 	Logo                string `json:"logo,omitempty"`
+	DefaultAssigneeID   *int64 `json:"default_assignee_id,omitempty"`
+	DefaultTeamID       *int64 `json:"default_team_id,omitempty"`
 	WebhookURL          string `json:"webhook_url"`
 	CreatedAt           string `json:"created_at"`
 	UpdatedAt           string `json:"updated_at"`
@@ -89,6 +99,14 @@ func (s *server) GetChatwootConfig() http.HandlerFunc {
 			inboxID = &config.InboxID.Int64
 		}
 
+		var defaultAssigneeID, defaultTeamID *int64
+		if config.DefaultAssigneeID.Valid {
+			defaultAssigneeID = &config.DefaultAssigneeID.Int64
+		}
+		if config.DefaultTeamID.Valid {
+			defaultTeamID = &config.DefaultTeamID.Int64
+		}
+
 		response := ChatwootConfigResponse{
 			UserID:              config.UserID,
 			AccountID:           config.AccountID,
@@ -103,8 +121,12 @@ func (s *server) GetChatwootConfig() http.HandlerFunc {
 			ReopenConversation:  config.ReopenConversation,
 			ConversationPending: config.ConversationPending,
 			MergeBrazilContacts: config.MergeBrazilContacts,
+			TypingPresence:      config.TypingPresence,
+			UpdateContactNames:  config.UpdateContactNames,
 			Organization:        config.Organization,
 			Logo:                config.Logo,
+			DefaultAssigneeID:   defaultAssigneeID,
+			DefaultTeamID:       defaultTeamID,
 			WebhookURL:          webhookURL,
 			CreatedAt:           config.CreatedAt.Format("2006-01-02T15:04:05Z"),
 			UpdatedAt:           config.UpdatedAt.Format("2006-01-02T15:04:05Z"),
@@ -150,6 +172,45 @@ func (s *server) SetChatwootConfig() http.HandlerFunc {
 			Bool("auto_create", req.AutoCreate).
 			Msg("Saving Chatwoot configuration")
 
+		// Dry run: check connectivity and report what would be created, without
+		// touching Chatwoot or the stored configuration
+		if req.DryRun {
+			tempConfig := &chatwoot.Config{
+				UserID:       userID,
+				AccountID:    req.AccountID,
+				Token:        req.Token,
+				URL:          req.URL,
+				NameInbox:    req.NameInbox,
+				Organization: req.Organization,
+				Logo:         req.Logo,
+			}
+			webhookURL := fmt.Sprintf("%s/chatwoot/webhook/%s", s.getBaseURL(r), token)
+
+			cwService := chatwootService(s.db)
+			plan, err := cwService.PlanInbox(tempConfig, webhookURL)
+			if err != nil {
+				log.Error().Err(err).Msg("Chatwoot dry run failed")
+				respondChatwootError(w, "Dry run failed", err)
+				return
+			}
+
+			response := map[string]interface{}{
+				"status":      "dry_run",
+				"message":     "Chatwoot is reachable, nothing was created or saved",
+				"auto_create": req.AutoCreate,
+			}
+			if req.AutoCreate {
+				response["would_create"] = plan
+			} else if plan.ExistingInboxID != 0 {
+				response["existing_inbox_id"] = plan.ExistingInboxID
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
 		// Check if config already exists
 		var existingConfig chatwoot.Config
 		checkQuery := `SELECT * FROM chatwoot_config WHERE user_id = $1`
@@ -182,11 +243,11 @@ func (s *server) SetChatwootConfig() http.HandlerFunc {
 			}
 
 			// Initialize service and create inbox
-			cwService := chatwoot.NewService(s.db)
+			cwService := chatwootService(s.db)
 			createdInboxID, err := cwService.InitializeInbox(tempConfig, webhookURL)
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to auto-create Chatwoot inbox")
-				s.Respond(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to create inbox: %v", err))
+				respondChatwootError(w, "Failed to create inbox", err)
 				return
 			}
 
@@ -196,6 +257,14 @@ func (s *server) SetChatwootConfig() http.HandlerFunc {
 			inboxID = existingConfig.InboxID
 		}
 
+		var defaultAssigneeID, defaultTeamID sql.NullInt64
+		if req.DefaultAssigneeID != nil {
+			defaultAssigneeID = sql.NullInt64{Int64: *req.DefaultAssigneeID, Valid: true}
+		}
+		if req.DefaultTeamID != nil {
+			defaultTeamID = sql.NullInt64{Int64: *req.DefaultTeamID, Valid: true}
+		}
+
 		// Save or update configuration
 		if configExists {
 			// Update existing config
@@ -214,11 +283,15 @@ func (s *server) SetChatwootConfig() http.HandlerFunc {
 				merge_brazil_contacts = $13, 
 				organization = $14, 
 				logo = $15, 
+				default_assignee_id = $16, 
+				default_team_id = $17, 
+				typing_presence = $18, 
+				update_contact_names = $19, 
 				updated_at = CURRENT_TIMESTAMP 
 				WHERE user_id = $1`
 
 			if s.db.DriverName() == "sqlite" {
-				for i := 1; i <= 15; i++ {
+				for i := 1; i <= 19; i++ {
 					updateQuery = strings.Replace(updateQuery, fmt.Sprintf("$%d", i), "?", 1)
 				}
 			}
@@ -239,17 +312,22 @@ func (s *server) SetChatwootConfig() http.HandlerFunc {
 				req.MergeBrazilContacts,
 				req.Organization,
 				req.Logo,
+				defaultAssigneeID,
+				defaultTeamID,
+				req.TypingPresence,
+				req.UpdateContactNames,
 			)
 		} else {
 			// Insert new config
 			insertQuery := `INSERT INTO chatwoot_config 
 				(user_id, account_id, token, url, inbox_id, name_inbox, enabled, auto_create, 
 				sign_msg, sign_delimiter, reopen_conversation, conversation_pending, 
-				merge_brazil_contacts, organization, logo) 
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+				merge_brazil_contacts, organization, logo, default_assignee_id, default_team_id, typing_presence, 
+				update_contact_names) 
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`
 
 			if s.db.DriverName() == "sqlite" {
-				for i := 1; i <= 15; i++ {
+				for i := 1; i <= 19; i++ {
 					insertQuery = strings.Replace(insertQuery, fmt.Sprintf("$%d", i), "?", 1)
 				}
 			}
@@ -270,6 +348,10 @@ func (s *server) SetChatwootConfig() http.HandlerFunc {
 				req.MergeBrazilContacts,
 				req.Organization,
 				req.Logo,
+				defaultAssigneeID,
+				defaultTeamID,
+				req.TypingPresence,
+				req.UpdateContactNames,
 			)
 		}
 
@@ -331,7 +413,38 @@ func (s *server) DeleteChatwootConfig() http.HandlerFunc {
 	}
 }
 
-// getBaseURL extracts the base URL from the request
+// respondChatwootError reports a failed Chatwoot call. A token Chatwoot refused
+// answers 502 with a "token rejected" reason, other errors Chatwoot returned for
+// our input answer 422 with its field errors; anything else answers 500.
+func respondChatwootError(w http.ResponseWriter, message string, err error) {
+	status := http.StatusInternalServerError
+	response := map[string]interface{}{
+		"status":  "error",
+		"message": fmt.Sprintf("%s: %v", message, err),
+	}
+
+	var apiErr *chatwoot.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+			status = http.StatusBadGateway
+			response["reason"] = "token rejected"
+		case apiErr.StatusCode >= 400 && apiErr.StatusCode < 500:
+			status = http.StatusUnprocessableEntity
+		}
+		response["chatwoot_status"] = apiErr.StatusCode
+		if len(apiErr.Errors) > 0 {
+			response["errors"] = apiErr.Errors
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// getBaseURL extracts the base URL from the request, including the path
+// prefix from the configured base path or the X-Forwarded-Prefix header
 func (s *server) getBaseURL(r *http.Request) string {
 	scheme := "http"
 	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
@@ -343,5 +456,20 @@ func (s *server) getBaseURL(r *http.Request) string {
 		host = forwardedHost
 	}
 
-	return fmt.Sprintf("%s://%s", scheme, host)
+	prefix := *basePath
+	if prefix == "" {
+		prefix = r.Header.Get("X-Forwarded-Prefix")
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, host, normalizeBasePath(prefix))
+}
+
+// normalizeBasePath returns prefix with a single leading slash and no
+// trailing slash, or an empty string for the root path
+func normalizeBasePath(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
 }
@@ -11,6 +11,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	_ "image/gif"
@@ -23,8 +24,10 @@ import (
 	"os/exec"
 	"regexp"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 
 	"time"
 
@@ -40,11 +43,14 @@ import (
 	"github.com/nfnt/resize"
 	"github.com/rs/zerolog/log"
 	"github.com/vincent-petithory/dataurl"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
 )
 
 const (
 	openGraphFetchTimeout    = 5 * time.Second
 	openGraphPageMaxBytes    = 2 * 1024 * 1024  // 2MB
+	openGraphHeadMaxBytes    = 256 * 1024       // 256KB read looking for </head> before reading the whole page
 	openGraphImageMaxBytes   = 10 * 1024 * 1024 // 10MB
 	openGraphThumbnailWidth  = 100
 	openGraphThumbnailHeight = 100
@@ -53,6 +59,11 @@ const (
 	openGraphUserFetchLimit  = 20   // Limit concurrent Open Graph fetches per user
 )
 
+// openGraphMinImageDim is the smallest width and height of an image used for
+// a link preview thumbnail. Smaller images, like 16x16 favicons, give no
+// thumbnail at all rather than a blurry one.
+var openGraphMinImageDim = 48
+
 type WebhookFileErrorPayload struct {
 	URL              string                 `json:"url"`
 	Payload          map[string]interface{} `json:"payload"`
@@ -94,6 +105,8 @@ func (usm *UserSemaphoreManager) ForUser(userID string) chan struct{} {
 var (
 	urlRegex = regexp.MustCompile(`https?://[^\s"']*[^\"'\s\.,!?()[\]{}]`)
 
+	mentionRegex = regexp.MustCompile(`@(\d{5,20})\b`)
+
 	userSemaphoreManager = NewUserSemaphoreManager()
 
 	openGraphGroup singleflight.Group
@@ -111,6 +124,39 @@ func Find(slice []string, val string) bool {
 	return false
 }
 
+// Page sizes of list endpoints. Requests without a limit get the first
+// defaultPageLimit items so large accounts never return everything at once.
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 500
+)
+
+// parsePagination reads the limit and offset query parameters of a list request
+func parsePagination(r *http.Request) (int, int, error) {
+	limit, offset := defaultPageLimit, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("offset must be a non-negative number")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// pageBounds returns the slice bounds of a page within total items
+func pageBounds(total, limit, offset int) (int, int) {
+	start := min(offset, total)
+	return start, min(start+limit, total)
+}
+
 func isHTTPURL(input string) bool {
 	parsed, err := url.ParseRequestURI(input)
 	if err != nil {
@@ -121,21 +167,33 @@ func isHTTPURL(input string) bool {
 	}
 	return parsed.Host != ""
 }
-func fetchURLBytes(ctx context.Context, resourceURL string, limit int64) ([]byte, string, error) {
+
+// getURL sends a GET request for resourceURL, returning the response of a
+// successful one. The caller closes its body.
+func getURL(ctx context.Context, resourceURL string) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", resourceURL, nil)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 
 	resp, err := globalHTTPClient.Do(req)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, "", fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func fetchURLBytes(ctx context.Context, resourceURL string, limit int64) ([]byte, string, error) {
+	resp, err := getURL(ctx, resourceURL)
+	if err != nil {
+		return nil, "", err
 	}
+	defer resp.Body.Close()
 
 	lr := io.LimitReader(resp.Body, limit+1)
 	data, err := io.ReadAll(lr)
@@ -159,23 +217,50 @@ func getOpenGraphData(ctx context.Context, urlStr string, userID string) (title,
 	if cachedData, found := openGraphCache.Get(urlStr); found {
 		if data, ok := cachedData.(openGraphResult); ok {
 			log.Debug().Str("url", urlStr).Msg("Open Graph data fetched from cache")
+			openGraphStats.memoryHits.Add(1)
 			return data.Title, data.Description, data.ImageData
 		}
 	}
 
+	// Then the persistent cache, refreshing the hot in-memory tier on a hit
+	if openGraphStore != nil {
+		if data, found := openGraphStore.get(urlStr); found {
+			log.Debug().Str("url", urlStr).Msg("Open Graph data fetched from persistent cache")
+			openGraphStats.storeHits.Add(1)
+			openGraphCache.Set(urlStr, data, cache.DefaultExpiration)
+			return data.Title, data.Description, data.ImageData
+		}
+	}
+
+	openGraphStats.misses.Add(1)
+	leader := false
 	v, err, _ := openGraphGroup.Do(urlStr, func() (res any, err error) {
+		leader = true
+		openGraphStats.leaders.Add(1)
+		defer func() {
+			if err != nil {
+				openGraphStats.fetchErrors.Add(1)
+			}
+		}()
+
 		ctx, cancel := context.WithTimeout(ctx, openGraphFetchTimeout)
 		defer cancel()
 
-		// Acquire a token from the semaphore pool
+		// Acquire a token from the semaphore pool, counting the fetches that
+		// have to wait for one
 		userPool := userSemaphoreManager.ForUser(userID)
 		select {
 		case userPool <- struct{}{}:
-			defer func() { <-userPool }()
-		case <-ctx.Done():
-			log.Warn().Str("url", urlStr).Msg("Open Graph data fetch timed out while waiting for a worker")
-			return nil, ctx.Err()
+		default:
+			openGraphStats.semaphoreWaits.Add(1)
+			select {
+			case userPool <- struct{}{}:
+			case <-ctx.Done():
+				log.Warn().Str("url", urlStr).Msg("Open Graph data fetch timed out while waiting for a worker")
+				return nil, ctx.Err()
+			}
 		}
+		defer func() { <-userPool }()
 
 		// Recover from panics and convert to error
 		defer func() {
@@ -192,12 +277,24 @@ func getOpenGraphData(ctx context.Context, urlStr string, userID string) (title,
 
 		// Fetch Open Graph data
 		title, description, imageData := fetchOpenGraphData(ctx, urlStr)
+		if title != "" || description != "" || len(imageData) > 0 {
+			openGraphStats.fetchOK.Add(1)
+		} else {
+			openGraphStats.fetchEmpty.Add(1)
+		}
 
-		// Store in cache
+		// Store in cache. Empty results, usually failed fetches, are not
+		// persisted so they are retried once the memory entry expires.
 		openGraphCache.Set(urlStr, openGraphResult{title, description, imageData}, cache.DefaultExpiration)
+		if openGraphStore != nil && (title != "" || description != "" || len(imageData) > 0) {
+			openGraphStore.put(urlStr, openGraphResult{title, description, imageData})
+		}
 
 		return openGraphResult{title, description, imageData}, nil
 	})
+	if !leader {
+		openGraphStats.followers.Add(1)
+	}
 
 	if err != nil {
 		log.Error().Err(err).Str("url", urlStr).Msg("Error fetching Open Graph data via singleflight")
@@ -226,27 +323,49 @@ func callHook(myurl string, payload map[string]string, userID string) {
 
 // webhook for regular messages with HMAC
 func callHookWithHmac(myurl string, payload map[string]string, userID string, encryptedHmacKey []byte) {
+	callHookWithTemplate(myurl, payload, userID, encryptedHmacKey, nil)
+}
+
+// webhook for regular messages with HMAC, reshaping the event with tmpl when set.
+// The signature covers the reshaped payload.
+func callHookWithTemplate(myurl string, payload map[string]string, userID string, encryptedHmacKey []byte, tmpl *template.Template) {
+	sendHook(myurl, payload, userID, encryptedHmacKey, tmpl, true)
+}
+
+// sendHook delivers a webhook, retrying as configured when retry is set. It
+// returns the last error once delivery failed for good, after sending the
+// payload to the error queue.
+func sendHook(myurl string, payload map[string]string, userID string, encryptedHmacKey []byte, tmpl *template.Template, retry bool) error {
 	log.Info().Str("url", myurl).Str("userID", userID).Msg("Sending POST to client with retry logic")
 
 	client := clientManager.GetHTTPClient(userID)
 
 	// Retry settings
+	retryEnabled, retryCount, retryDelaySeconds := webhookRetrySettings()
+	retryEnabled = retryEnabled && retry
 	maxRetries := 1
-	if *webhookRetryEnabled {
-		maxRetries = *webhookRetryCount
+	if retryEnabled {
+		maxRetries = retryCount
 	}
 
 	var lastError error
 
 	var body interface{} = payload
 
+	// Skip events the receiver already acknowledged, e.g. before a restart
+	idempotencyKey := webhookIdempotencyKey(myurl, payload["jsonData"])
+	if webhookDeliveries != nil && idempotencyKey != "" && webhookDeliveries.acked(idempotencyKey) {
+		log.Info().Str("url", myurl).Str("key", idempotencyKey).Msg("Webhook already delivered, skipping")
+		return nil
+	}
+
 	// Starts the retry loop.
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		if attempt > 0 {
 			backoffFactor := 1 << uint(attempt-1)
 
 			// Calculate the final delay.
-			delayDuration := time.Duration(*webhookRetryDelaySeconds) * time.Second * time.Duration(backoffFactor)
+			delayDuration := time.Duration(retryDelaySeconds) * time.Second * time.Duration(backoffFactor)
 
 			log.Warn().
 				Int("attempt", attempt+1).
@@ -258,7 +377,7 @@ func callHookWithHmac(myurl string, payload map[string]string, userID string, en
 		}
 
 		var req *resty.Request
-		var hmacSignature string
+		var signedBody []byte
 		var marshalErr error
 
 		format := os.Getenv("WEBHOOK_FORMAT")
@@ -274,7 +393,12 @@ func callHookWithHmac(myurl string, payload map[string]string, userID string, en
 						postmap["instanceName"] = instanceName
 					}
 					postmap["userID"] = userID
-					body = postmap
+					body = payloadNaming.apply(postmap)
+
+					// Reshape with the user's payload template, if any
+					if rendered, ok := applyWebhookTemplate(tmpl, userID, postmap); ok {
+						body = json.RawMessage(rendered)
+					}
 				}
 			}
 
@@ -284,37 +408,35 @@ func callHookWithHmac(myurl string, payload map[string]string, userID string, en
 				log.Error().Err(marshalErr).Msg("Failed to marshal body for HMAC")
 			}
 
-			// Generate HMAC signature if key exists
-			if len(encryptedHmacKey) > 0 && len(jsonBody) > 0 {
-				var err error
-				hmacSignature, err = generateHmacSignature(jsonBody, encryptedHmacKey)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to generate HMAC signature")
-				}
-			}
-
+			signedBody = jsonBody
 			req = client.R().SetHeader("Content-Type", "application/json").SetBody(body)
 
 		} else {
 
-			if len(encryptedHmacKey) > 0 {
-				formData := url.Values{}
-				for k, v := range payload {
-					formData.Add(k, v)
-				}
-				formString := formData.Encode()
-				var err error
-				hmacSignature, err = generateHmacSignature([]byte(formString), encryptedHmacKey)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to generate HMAC signature")
-				}
+			// Templates shape their own fields, so naming only applies without one
+			formPayload := payloadNaming.form(payload)
+			if tmpl != nil {
+				formPayload = templateWebhookPayload(tmpl, userID, payload)
+			}
+
+			formData := url.Values{}
+			for k, v := range formPayload {
+				formData.Add(k, v)
 			}
-			req = client.R().SetFormData(payload)
-			body = payload
+			signedBody = []byte(formData.Encode())
+			req = client.R().SetFormData(formPayload)
+			body = formPayload
 		}
+		req.SetContext(webhookRequestContext(myurl))
 
-		if hmacSignature != "" {
-			req.SetHeader("x-hmac-signature", hmacSignature)
+		// Generate HMAC signature if key exists
+		if len(encryptedHmacKey) > 0 && len(signedBody) > 0 {
+			if err := signWebhookRequest(req, signedBody, idempotencyKey, encryptedHmacKey); err != nil {
+				log.Error().Err(err).Msg("Failed to generate HMAC signature")
+			}
+		}
+		if idempotencyKey != "" {
+			req.SetHeader(idempotencyKeyHeader, idempotencyKey)
 		}
 
 		resp, postErr := req.Post(myurl)
@@ -334,14 +456,17 @@ func callHookWithHmac(myurl string, payload map[string]string, userID string, en
 				Str("url", myurl).
 				Msg("Webhook failed due to non-2xx status code")
 
-			if !*webhookRetryEnabled {
+			if !retryEnabled {
 				break
 			}
 			continue
 		}
 
 		log.Info().Int("status", resp.StatusCode()).Str("url", myurl).Msg("Webhook call successful")
-		return
+		if webhookDeliveries != nil && idempotencyKey != "" {
+			webhookDeliveries.markAcked(idempotencyKey, userID)
+		}
+		return nil
 	}
 
 	if lastError != nil {
@@ -356,6 +481,11 @@ func callHookWithHmac(myurl string, payload map[string]string, userID string, en
 		} else if p, ok := body.(map[string]interface{}); ok {
 
 			errorPayloadMap = p
+		} else if p, ok := body.(json.RawMessage); ok {
+
+			if err := json.Unmarshal(p, &errorPayloadMap); err != nil {
+				errorPayloadMap["payload"] = string(p)
+			}
 		}
 
 		errorPayload := WebhookErrorPayload{
@@ -369,6 +499,7 @@ func callHookWithHmac(myurl string, payload map[string]string, userID string, en
 
 		PublishDataErrorToQueue(errorPayload)
 	}
+	return lastError
 }
 
 // webhook for messages with file attachments
@@ -378,29 +509,54 @@ func callHookFile(myurl string, payload map[string]string, userID string, file s
 
 // webhook for messages with file attachments and HMAC
 func callHookFileWithHmac(myurl string, payload map[string]string, userID string, file string, encryptedHmacKey []byte) error {
+	return callHookFileWithTemplate(myurl, payload, userID, file, encryptedHmacKey, nil)
+}
+
+// webhook for messages with file attachments and HMAC, reshaping the event with tmpl when set
+func callHookFileWithTemplate(myurl string, payload map[string]string, userID string, file string, encryptedHmacKey []byte, tmpl *template.Template) error {
+	return sendFileHook(myurl, payload, userID, file, encryptedHmacKey, tmpl, true)
+}
+
+// sendFileHook delivers a webhook with a file attachment, retrying as
+// configured when retry is set
+func sendFileHook(myurl string, payload map[string]string, userID string, file string, encryptedHmacKey []byte, tmpl *template.Template, retry bool) error {
 	log.Info().Str("file", file).Str("url", myurl).Msg("Sending POST with retry logic")
 
 	client := clientManager.GetHTTPClient(userID)
 
+	retryEnabled, retryCount, retryDelaySeconds := webhookRetrySettings()
+	retryEnabled = retryEnabled && retry
 	maxRetries := 1
-	if *webhookRetryEnabled {
-		maxRetries = *webhookRetryCount
+	if retryEnabled {
+		maxRetries = retryCount
 	}
 
 	var lastError error
 
+	// Templates shape their own fields, so naming only applies without one
+	formPayload := payloadNaming.form(payload)
+	if tmpl != nil {
+		formPayload = templateWebhookPayload(tmpl, userID, payload)
+	}
 	finalPayload := make(map[string]string)
-	for k, v := range payload {
+	for k, v := range formPayload {
 		finalPayload[k] = v
 	}
 	finalPayload["file"] = file
 
+	// Skip events the receiver already acknowledged, e.g. before a restart
+	idempotencyKey := webhookIdempotencyKey(myurl, payload["jsonData"])
+	if webhookDeliveries != nil && idempotencyKey != "" && webhookDeliveries.acked(idempotencyKey) {
+		log.Info().Str("url", myurl).Str("key", idempotencyKey).Msg("File webhook already delivered, skipping")
+		return nil
+	}
+
 	// 2. Loop Retry
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		if attempt > 0 {
 			backoffFactor := 1 << uint(attempt-1)
 
-			delayDuration := time.Duration(*webhookRetryDelaySeconds) * time.Second * time.Duration(backoffFactor)
+			delayDuration := time.Duration(retryDelaySeconds) * time.Second * time.Duration(backoffFactor)
 
 			log.Warn().
 				Int("attempt", attempt+1).
@@ -411,30 +567,23 @@ func callHookFileWithHmac(myurl string, payload map[string]string, userID string
 			time.Sleep(delayDuration)
 		}
 
-		var hmacSignature string
-		var jsonPayload []byte
-
-		if len(encryptedHmacKey) > 0 {
-			var err error
-			jsonPayload, err = json.Marshal(finalPayload)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to marshal payload for HMAC")
-			} else {
-				hmacSignature, err = generateHmacSignature(jsonPayload, encryptedHmacKey)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to generate HMAC signature")
-				}
-			}
-		}
-
 		req := client.R().
+			SetContext(webhookRequestContext(myurl)).
 			SetFiles(map[string]string{
 				"file": file,
 			}).
 			SetFormData(finalPayload)
 
-		if hmacSignature != "" {
-			req.SetHeader("x-hmac-signature", hmacSignature)
+		if len(encryptedHmacKey) > 0 {
+			jsonPayload, err := json.Marshal(finalPayload)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal payload for HMAC")
+			} else if err := signWebhookRequest(req, jsonPayload, idempotencyKey, encryptedHmacKey); err != nil {
+				log.Error().Err(err).Msg("Failed to generate HMAC signature")
+			}
+		}
+		if idempotencyKey != "" {
+			req.SetHeader(idempotencyKeyHeader, idempotencyKey)
 		}
 
 		resp, postErr := req.Post(myurl)
@@ -454,13 +603,16 @@ func callHookFileWithHmac(myurl string, payload map[string]string, userID string
 				Str("url", myurl).
 				Msg("File webhook failed due to non-2xx status code")
 
-			if !*webhookRetryEnabled {
+			if !retryEnabled {
 				break
 			}
 			continue
 		}
 
 		log.Info().Int("status", resp.StatusCode()).Str("url", myurl).Msg("File webhook call successful")
+		if webhookDeliveries != nil && idempotencyKey != "" {
+			webhookDeliveries.markAcked(idempotencyKey, userID)
+		}
 		return nil
 	}
 
@@ -626,8 +778,151 @@ func extractFirstURL(text string) string {
 
 	return match
 }
+
+// extractMentions returns the phone numbers tagged as @<number> in text
+func extractMentions(text string) []string {
+	var numbers []string
+	for _, match := range mentionRegex.FindAllStringSubmatch(text, -1) {
+		numbers = append(numbers, match[1])
+	}
+	return numbers
+}
+
+// groupInfoGetter is the part of the WhatsApp client that reads group info
+type groupInfoGetter interface {
+	GetGroupInfo(ctx context.Context, jid types.JID) (*types.GroupInfo, error)
+}
+
+// applyGroupMentions tags the explicit mentions, and the @<number> tokens of
+// the text when detect is set, in msg sent to group. On error it returns the
+// status to respond with.
+func applyGroupMentions(ctx context.Context, groups groupInfoGetter, group types.JID, msg *waE2E.ExtendedTextMessage, explicit []string, detect bool) (int, error) {
+	if group.Server != types.GroupServer {
+		return http.StatusBadRequest, errors.New("Mentions are only supported for group chats")
+	}
+	var detected []string
+	if detect {
+		detected = extractMentions(msg.GetText())
+	}
+	if len(explicit) == 0 && len(detected) == 0 {
+		return http.StatusOK, nil
+	}
+
+	groupInfo, err := groups.GetGroupInfo(ctx, group)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to get group info for mentions: %v", err)
+	}
+	mentioned, err := resolveGroupMentions(explicit, detected, groupInfo.Participants)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if len(mentioned) > 0 {
+		if msg.ContextInfo == nil {
+			msg.ContextInfo = &waE2E.ContextInfo{}
+		}
+		msg.ContextInfo.MentionedJID = append(msg.ContextInfo.MentionedJID, mentioned...)
+	}
+	return http.StatusOK, nil
+}
+
+// resolveGroupMentions maps the explicit and detected mentions to the JIDs of
+// group participants. Explicit mentions that are not members are rejected,
+// detected ones are silently dropped since the body may contain plain numbers.
+func resolveGroupMentions(explicit []string, detected []string, participants []types.GroupParticipant) ([]string, error) {
+	members := make(map[string]string)
+	for _, p := range participants {
+		for _, jid := range []types.JID{p.JID, p.PhoneNumber, p.LID} {
+			if !jid.IsEmpty() {
+				members[jid.ToNonAD().String()] = p.JID.ToNonAD().String()
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	var mentioned []string
+	add := func(arg string, strict bool) error {
+		jid, ok := parseJID(arg)
+		if !ok {
+			if strict {
+				return fmt.Errorf("could not parse mention %s", arg)
+			}
+			return nil
+		}
+		member, ok := members[jid.ToNonAD().String()]
+		if !ok {
+			if strict {
+				return fmt.Errorf("mentioned %s is not a group member", arg)
+			}
+			return nil
+		}
+		if !seen[member] {
+			seen[member] = true
+			mentioned = append(mentioned, member)
+		}
+		return nil
+	}
+
+	for _, arg := range explicit {
+		if err := add(arg, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, arg := range detected {
+		add(arg, false)
+	}
+	return mentioned, nil
+}
+
+// fetchOpenGraphPage fetches the HTML of a page for its Open Graph tags,
+// which live in the head, so usually only the start of the page is read
+func fetchOpenGraphPage(ctx context.Context, pageURL string) ([]byte, error) {
+	resp, err := getURL(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return readPageHead(resp.Body, openGraphHeadMaxBytes, openGraphPageMaxBytes)
+}
+
+// readPageHead reads an HTML page up to the end of its head. When no </head>
+// shows up within headBudget bytes the rest of the page is read, up to limit.
+func readPageHead(r io.Reader, headBudget, limit int64) ([]byte, error) {
+	const closingTag = "</head"
+	var page []byte
+	chunk := make([]byte, 32*1024)
+	searched := 0
+	for {
+		n, err := r.Read(chunk)
+		page = append(page, chunk[:n]...)
+		if int64(len(page)) > limit {
+			return nil, fmt.Errorf("response exceeds allowed size (%d bytes)", limit)
+		}
+
+		if searched >= 0 {
+			// Look at the new bytes, and enough before them for a tag split
+			// across reads
+			from := max(searched-len(closingTag), 0)
+			if i := bytes.Index(bytes.ToLower(page[from:]), []byte(closingTag)); i >= 0 {
+				return page, nil
+			}
+			searched = len(page)
+			if int64(searched) > headBudget {
+				searched = -1
+			}
+		}
+
+		if err == io.EOF {
+			return page, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
 func fetchOpenGraphData(ctx context.Context, urlStr string) (string, string, []byte) {
-	pageData, _, err := fetchURLBytes(ctx, urlStr, openGraphPageMaxBytes)
+	pageData, err := fetchOpenGraphPage(ctx, urlStr)
 	if err != nil {
 		log.Warn().Err(err).Str("url", urlStr).Msg("Failed to fetch URL for Open Graph data")
 		return "", "", nil
@@ -691,6 +986,12 @@ func fetchOpenGraphImage(ctx context.Context, pageURL *url.URL, imageURLStr stri
 		return nil
 	}
 
+	return openGraphThumbnail(imgBytes, resolvedImageURL)
+}
+
+// openGraphThumbnail turns the image fetched from resolvedImageURL into the
+// JPEG thumbnail of a link preview
+func openGraphThumbnail(imgBytes []byte, resolvedImageURL string) []byte {
 	imgConfig, _, err := image.DecodeConfig(bytes.NewReader(imgBytes))
 	if err != nil {
 		log.Warn().Err(err).Str("imageURL", resolvedImageURL).Msg("Failed to decode Open Graph image config")
@@ -706,6 +1007,16 @@ func fetchOpenGraphImage(ctx context.Context, pageURL *url.URL, imageURLStr stri
 		return nil
 	}
 
+	// Tiny images, usually favicons, would be badly upscaled to the thumbnail
+	if imgConfig.Width < openGraphMinImageDim || imgConfig.Height < openGraphMinImageDim {
+		log.Debug().
+			Int("width", imgConfig.Width).
+			Int("height", imgConfig.Height).
+			Str("imageURL", resolvedImageURL).
+			Msg("Open Graph image too small for a thumbnail")
+		return nil
+	}
+
 	img, _, err := image.Decode(bytes.NewReader(imgBytes))
 	if err != nil {
 		log.Warn().Err(err).Str("imageURL", resolvedImageURL).Msg("Failed to decode Open Graph image")
@@ -723,6 +1034,11 @@ func fetchOpenGraphImage(ctx context.Context, pageURL *url.URL, imageURLStr stri
 }
 
 func convertVideoStickerToWebP(input []byte) ([]byte, error) {
+	ffmpeg, err := ffmpegBinary()
+	if err != nil {
+		return nil, err
+	}
+
 	inFile, err := os.CreateTemp("", "sticker-input-*.mp4")
 	if err != nil {
 		return nil, err
@@ -745,7 +1061,7 @@ func convertVideoStickerToWebP(input []byte) ([]byte, error) {
 	qValue := 10
 	filter := "fps=15,scale=512:512:force_original_aspect_ratio=increase,crop=512:512"
 	cmd := exec.Command(
-		"ffmpeg",
+		ffmpeg,
 		"-y",
 		"-t", "10",
 		"-i", inFile.Name(),
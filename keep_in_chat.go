@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// keepInChatEvent builds the KeepInChat event of a message kept, or no longer
+// kept, in a chat with disappearing messages. It returns nil for other
+// messages.
+func keepInChatEvent(evt *events.Message) map[string]interface{} {
+	keep := evt.Message.GetKeepInChatMessage()
+	if keep == nil {
+		return nil
+	}
+	kept := keep.GetKeepType() == waE2E.KeepType_KEEP_FOR_ALL
+	if !kept && keep.GetKeepType() != waE2E.KeepType_UNDO_KEEP_FOR_ALL {
+		return nil
+	}
+	return map[string]interface{}{
+		"type": "KeepInChat",
+		"event": map[string]interface{}{
+			"id":            evt.Info.ID,
+			"chat":          evt.Info.Chat.String(),
+			"sender":        evt.Info.Sender.String(),
+			"isFromMe":      evt.Info.IsFromMe,
+			"messageId":     keep.GetKey().GetID(),
+			"messageFromMe": keep.GetKey().GetFromMe(),
+			"kept":          kept,
+			"timestamp":     evt.Info.Timestamp.Unix(),
+		},
+	}
+}
+
+// keepInChatNote renders a KeepInChat event as text for Chatwoot notes
+func keepInChatNote(evt *events.Message, kept bool) string {
+	messageID := evt.Message.GetKeepInChatMessage().GetKey().GetID()
+	if kept {
+		return fmt.Sprintf("%s kept message %s in the chat", evt.Info.Sender.User, messageID)
+	}
+	return fmt.Sprintf("%s no longer keeps message %s in the chat", evt.Info.Sender.User, messageID)
+}
+
+// handleKeepInChatMessage turns keep-in-chat actions into KeepInChat events,
+// delivered to the webhooks and as a private note to Chatwoot. They are
+// dropped unless enabled with -keepinchat.
+func (mycli *MyClient) handleKeepInChatMessage(evt *events.Message) {
+	if !*keepInChatEvents {
+		return
+	}
+	postmap := keepInChatEvent(evt)
+	if postmap == nil {
+		return
+	}
+	sendEventWithWebHook(mycli, postmap, "")
+
+	kept := postmap["event"].(map[string]interface{})["kept"].(bool)
+	go func() {
+		if err := chatwootService(mycli.db).PostPrivateNote(mycli.userID, evt.Info.Chat.String(), keepInChatNote(evt, kept)); err != nil {
+			log.Debug().Err(err).Str("id", evt.Info.ID).Msg("Failed to post keep in chat note to Chatwoot")
+		}
+	}()
+}
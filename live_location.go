@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// liveLocationDurations are the live location durations offered by WhatsApp
+// that fit in the edit window updates are sent in, in seconds. WhatsApp also
+// offers 1 and 8 hours, but recipients drop edits older than
+// whatsmeow.EditWindow, so such shares could not be moved or stopped.
+var liveLocationDurations = []int{900}
+
+// validateLiveSeconds checks that a live location duration is one WhatsApp
+// offers. Zero means a static location and is valid.
+func validateLiveSeconds(seconds int) error {
+	if seconds == 0 {
+		return nil
+	}
+	for _, allowed := range liveLocationDurations {
+		if seconds == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("LiveSeconds must be one of %v", liveLocationDurations)
+}
+
+// buildLocationMessage returns a live location message when liveSeconds is
+// set, and a static location pin otherwise
+func buildLocationMessage(latitude, longitude float64, name string, liveSeconds int) *waE2E.Message {
+	if liveSeconds > 0 {
+		return &waE2E.Message{LiveLocationMessage: liveLocationUpdate(latitude, longitude, name, 0, 0)}
+	}
+	return &waE2E.Message{LocationMessage: &waE2E.LocationMessage{
+		DegreesLatitude:  proto.Float64(latitude),
+		DegreesLongitude: proto.Float64(longitude),
+		Name:             proto.String(name),
+	}}
+}
+
+// liveLocationUpdate is the position of a live location share, sequence
+// updates after it started and offset seconds into it
+func liveLocationUpdate(latitude, longitude float64, caption string, sequence int64, offset uint32) *waE2E.LiveLocationMessage {
+	update := &waE2E.LiveLocationMessage{
+		DegreesLatitude:  proto.Float64(latitude),
+		DegreesLongitude: proto.Float64(longitude),
+		SequenceNumber:   proto.Int64(sequence),
+		TimeOffset:       proto.Uint32(offset),
+	}
+	if caption != "" {
+		update.Caption = proto.String(caption)
+	}
+	return update
+}
+
+// liveLocations holds the live location shares still running, so they can be
+// updated and stopped
+var liveLocations = newLiveLocationRegistry()
+
+// liveLocationShare is a live location share sent by a user
+type liveLocationShare struct {
+	recipient types.JID
+	caption   string
+	startedAt time.Time
+	sequence  int64
+	latitude  float64
+	longitude float64
+	timer     *time.Timer
+}
+
+// editable reports whether the share can still be updated by editing its
+// message
+func (share *liveLocationShare) editable() bool {
+	return time.Since(share.startedAt) < whatsmeow.EditWindow
+}
+
+// liveLocationRegistry tracks live location shares by user and message id.
+// Shares are forgotten once their duration is over.
+type liveLocationRegistry struct {
+	mu     sync.Mutex
+	shares map[string]*liveLocationShare
+}
+
+func newLiveLocationRegistry() *liveLocationRegistry {
+	return &liveLocationRegistry{shares: make(map[string]*liveLocationShare)}
+}
+
+// liveLocationKey identifies a live location share of a user
+func liveLocationKey(userID, msgid string) string {
+	return userID + "|" + msgid
+}
+
+// start registers a share that runs for duration
+func (l *liveLocationRegistry) start(key string, share *liveLocationShare, duration time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if previous, found := l.shares[key]; found {
+		previous.timer.Stop()
+	}
+	share.timer = time.AfterFunc(duration, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.shares[key] == share {
+			delete(l.shares, key)
+		}
+	})
+	l.shares[key] = share
+}
+
+// next moves a running share to a new position and returns the update to
+// send for it, false when the share is over or unknown
+func (l *liveLocationRegistry) next(key string, latitude, longitude float64) (types.JID, *waE2E.LiveLocationMessage, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	share, found := l.shares[key]
+	if !found || !share.editable() {
+		return types.JID{}, nil, false
+	}
+	share.sequence++
+	share.latitude, share.longitude = latitude, longitude
+	offset := uint32(time.Since(share.startedAt) / time.Second)
+	return share.recipient, liveLocationUpdate(latitude, longitude, share.caption, share.sequence, offset), true
+}
+
+// stop ends a running share and returns its final update at the last known
+// position, false when the share is over or unknown
+func (l *liveLocationRegistry) stop(key string) (types.JID, *waE2E.LiveLocationMessage, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	share, found := l.shares[key]
+	if !found || !share.editable() {
+		return types.JID{}, nil, false
+	}
+	share.timer.Stop()
+	delete(l.shares, key)
+	share.sequence++
+	offset := uint32(time.Since(share.startedAt) / time.Second)
+	return share.recipient, liveLocationUpdate(share.latitude, share.longitude, share.caption, share.sequence, offset), true
+}
+
+// sendLiveLocationUpdate sends update as an edit of the live location message
+// msgid, so recipients see the pin move instead of a new message
+func sendLiveLocationUpdate(txtid string, recipient types.JID, msgid string, update *waE2E.LiveLocationMessage) (whatsmeow.SendResponse, error) {
+	client := clientManager.GetWhatsmeowClient(txtid)
+	msg := &waE2E.Message{LiveLocationMessage: update}
+	return client.SendMessage(context.Background(), recipient, client.BuildEdit(recipient, msgid, msg))
+}
+
+// Updates the position of a live location shared with chat/send/location
+func (s *server) UpdateLiveLocation() http.HandlerFunc {
+
+	type updateStruct struct {
+		Id        string
+		Latitude  float64
+		Longitude float64
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		if _, ok := s.readyClient(w, r, txtid); !ok {
+			return
+		}
+
+		var t updateStruct
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+		if t.Id == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Id in Payload"))
+			return
+		}
+		if t.Latitude == 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Latitude in Payload"))
+			return
+		}
+		if t.Longitude == 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Longitude in Payload"))
+			return
+		}
+
+		recipient, update, found := liveLocations.next(liveLocationKey(txtid, t.Id), t.Latitude, t.Longitude)
+		if !found {
+			s.Respond(w, r, http.StatusNotFound, errors.New("live location not found or already over"))
+			return
+		}
+
+		resp, err := sendLiveLocationUpdate(txtid, recipient, t.Id, update)
+		if err != nil {
+			s.notifySendFailed(txtid, recipient, t.Id, "live_location", err)
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("error sending live location update: %v", err))
+			return
+		}
+
+		log.Info().Str("id", t.Id).Int64("sequence", update.GetSequenceNumber()).Msg("Live location updated")
+		response := map[string]interface{}{"Details": "Updated", "Timestamp": resp.Timestamp.Unix(), "Id": t.Id, "Sequence": update.GetSequenceNumber()}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Stops a live location shared with chat/send/location before its duration is over
+func (s *server) StopLiveLocation() http.HandlerFunc {
+
+	type stopStruct struct {
+		Id string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		if _, ok := s.readyClient(w, r, txtid); !ok {
+			return
+		}
+
+		var t stopStruct
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+		if t.Id == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Id in Payload"))
+			return
+		}
+
+		recipient, update, found := liveLocations.stop(liveLocationKey(txtid, t.Id))
+		if !found {
+			s.Respond(w, r, http.StatusNotFound, errors.New("live location not found or already over"))
+			return
+		}
+
+		resp, err := sendLiveLocationUpdate(txtid, recipient, t.Id, update)
+		if err != nil {
+			s.notifySendFailed(txtid, recipient, t.Id, "live_location", err)
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("error sending live location update: %v", err))
+			return
+		}
+
+		log.Info().Str("id", t.Id).Msg("Live location stopped")
+		response := map[string]interface{}{"Details": "Stopped", "Timestamp": resp.Timestamp.Unix(), "Id": t.Id}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
@@ -16,6 +16,8 @@ import (
 	"syscall"
 	"time"
 
+	"wuzapi/pkg/chatwoot"
+
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	waLog "go.mau.fi/whatsmeow/util/log"
 
@@ -41,6 +43,9 @@ type server struct {
 	router *mux.Router
 	exPath string
 	mode   ServerMode
+	stdio  *stdioServer
+	// storeDB is the whatsmeow store database, main.db on SQLite
+	storeDB *sqlx.DB
 }
 
 // Replace the global variables
@@ -68,6 +73,42 @@ var (
 	webhookRetryCount        = flag.Int("retrycount", 5, "Number of times to retry failed webhooks")
 	webhookRetryDelaySeconds = flag.Int("retrydelay", 30, "Delay in seconds between webhook retries")
 	webhookErrorQueueName    = flag.String("errorqueue", "webhook_errors", "RabbitMQ queue name for failed webhooks")
+	webhookTimeout           = flag.Int("webhooktimeout", 30, "Seconds a webhook request may take before it fails and is retried")
+	webhookDialTimeout       = flag.Int("webhookdialtimeout", 10, "Seconds allowed to connect to a webhook endpoint, TLS handshake included")
+	webhookKeepAlive         = flag.Int("webhookkeepalive", 30, "Interval in seconds between TCP keepalive probes on webhook connections (0 disables them)")
+
+	chatwootMaxMediaMB   = flag.Int("chatwootmaxmedia", 40, "Maximum media size in MB forwarded to Chatwoot (0 disables the limit)")
+	chatwootMediaTimeout = flag.Int("chatwootmediatimeout", 120, "Timeout in seconds for downloading media forwarded to Chatwoot (0 disables the timeout)")
+	chatwootUploadTries  = flag.Int("chatwootuploadattempts", 3, "Attempts made to upload media to Chatwoot before giving up")
+	chatwootInboxTries   = flag.Int("chatwootinboxattempts", 3, "Attempts made to create the Chatwoot inbox on auto-create before giving up")
+	chatwootUploadTime   = flag.Int("chatwootuploadtimeout", 300, "Timeout in seconds for each media upload to Chatwoot (0 disables the timeout)")
+	chatwootDedupeTTL    = flag.Int("chatwootdedupettl", 1800, "Seconds a forwarded message id is remembered in memory to drop duplicate Chatwoot deliveries")
+	chatwootDedupeClean  = flag.Int("chatwootdedupecleanup", 600, "Interval in seconds between purges of expired Chatwoot dedupe entries")
+	chatwootOutDedupe    = flag.Int("chatwootoutdedupe", 10, "Seconds a message from Chatwoot is remembered by id and content to drop redelivered webhooks (0 disables it)")
+	phoneRegion          = flag.String("phoneregion", "", "Default region (ISO 3166 code such as BR or US) for Chatwoot phone numbers written without a country code")
+	basePath             = flag.String("basepath", "", "Path prefix when served behind a reverse proxy (e.g. /wuzapi)")
+	webhookRawEvent      = flag.Bool("rawevent", false, "Include the raw protobuf of message and history sync events in webhook and RabbitMQ payloads")
+	webhookNaming        = flag.String("webhooknaming", "", "Casing of webhook and RabbitMQ payload keys: camel or snake (empty keeps keys as built)")
+	webhookDedupe        = flag.Bool("webhookdedupe", false, "Remember acknowledged webhook deliveries so events are not delivered twice after a restart")
+	openGraphCacheHours  = flag.Int("opengraphcachettl", 0, "Hours link previews are kept in the database to avoid refetching them after a restart (0 disables the persistent cache)")
+	receiptRetentionDays = flag.Int("receiptretention", 30, "Days the receipts of sent messages are kept for /chat/status (0 keeps them forever)")
+	openGraphMinImage    = flag.Int("opengraphminimage", 48, "Smallest width and height in pixels of an image used as link preview thumbnail")
+	webhookOrdered       = flag.Bool("webhookordered", false, "Deliver user webhooks of the same chat one at a time, in the order events were received")
+	webhookAllowHosts    = flag.String("webhookallow", "", "Comma separated hostnames or CIDRs users may point webhooks to (empty allows any)")
+	webhookDenyHosts     = flag.String("webhookdeny", "", "Comma separated hostnames or CIDRs users may not point webhooks to")
+	webhookSignHeaders   = flag.String("webhooksignheaders", "", "Comma separated headers signed with the webhook body, in canonical order (x-webhook-timestamp, idempotency-key)")
+	webhookBlockPrivate  = flag.Bool("webhookblockprivate", false, "Refuse user webhooks resolving to private or loopback addresses unless explicitly allowed")
+	pollResultsEnabled   = flag.Bool("pollresults", false, "Decrypt poll votes and emit aggregated PollResults events to webhooks and Chatwoot")
+	keepInChatEvents     = flag.Bool("keepinchat", false, "Emit KeepInChat events to webhooks and Chatwoot when a disappearing message is kept or unkept")
+	requestUnavailable   = flag.Bool("autorequestunavailable", false, "Ask the phone for a copy of messages that fail to decrypt when the sender does not resend them")
+	maxTextLength        = flag.Int("maxtextlength", 0, "Maximum length in characters of text message bodies (0 disables the limit)")
+	textLengthPolicy     = flag.String("textlengthpolicy", "reject", "What to do with text bodies over -maxtextlength: reject or truncate")
+	maxSessions          = flag.Int("maxsessions", 0, "Maximum number of concurrently connected WhatsApp sessions (0 means unlimited)")
+	ffmpegPath           = flag.String("ffmpeg", "ffmpeg", "Path to the ffmpeg binary used to convert video stickers, or its name in PATH")
+	pdfThumbnails        = flag.Bool("pdfthumbnails", false, "Attach a first page thumbnail and the page count to PDF documents sent (needs pdftoppm from poppler-utils)")
+	webhookHistory       = flag.Bool("webhookhistory", false, "Keep an append-only history of each user's webhook URL and events changes, unmasked, for auditing")
+	sendRate             = flag.Int("sendrate", 0, "Messages per second all sessions may send together, further sends are refused with 429, failed sends do not count (0 disables the limit)")
+	sendBurst            = flag.Int("sendburst", 0, "Messages that may be sent at once under -sendrate after a quiet period (0 uses the rate)")
 
 	container        *sqlstore.Container
 	clientManager    = NewClientManager()
@@ -183,6 +224,108 @@ func main() {
 	if v := os.Getenv("WEBHOOK_RETRY_ENABLED"); v != "" {
 		*webhookRetryEnabled = strings.ToLower(v) == "true" || v == "1"
 	}
+	if v := os.Getenv("WEBHOOK_RAW_EVENT"); v != "" {
+		*webhookRawEvent = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("WEBHOOK_FIELD_NAMING"); v != "" {
+		*webhookNaming = v
+	}
+	if naming, err := parseFieldNaming(*webhookNaming); err != nil {
+		log.Fatal().Err(err).Msg("Invalid webhook field naming")
+	} else {
+		payloadNaming = naming
+	}
+	if v := os.Getenv("WEBHOOK_DEDUPE"); v != "" {
+		*webhookDedupe = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("OPEN_GRAPH_CACHE_TTL_HOURS"); v != "" {
+		if hours, err := strconv.Atoi(v); err == nil {
+			*openGraphCacheHours = hours
+		}
+	}
+	if v := os.Getenv("MESSAGE_RECEIPT_RETENTION_DAYS"); v != "" {
+		if days, err := strconv.Atoi(v); err == nil {
+			*receiptRetentionDays = days
+		}
+	}
+	if v := os.Getenv("OPEN_GRAPH_MIN_IMAGE_SIZE"); v != "" {
+		if size, err := strconv.Atoi(v); err == nil {
+			*openGraphMinImage = size
+		}
+	}
+	openGraphMinImageDim = *openGraphMinImage
+	if v := os.Getenv("WEBHOOK_ORDERED"); v != "" {
+		*webhookOrdered = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("POLL_RESULTS"); v != "" {
+		*pollResultsEnabled = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("KEEP_IN_CHAT_EVENTS"); v != "" {
+		*keepInChatEvents = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("AUTO_REQUEST_UNAVAILABLE"); v != "" {
+		*requestUnavailable = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("PDF_THUMBNAILS"); v != "" {
+		*pdfThumbnails = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("FFMPEG_PATH"); v != "" {
+		*ffmpegPath = v
+	}
+	if v := os.Getenv("WEBHOOK_HISTORY"); v != "" {
+		*webhookHistory = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("MAX_TEXT_LENGTH"); v != "" {
+		if length, err := strconv.Atoi(v); err == nil {
+			*maxTextLength = length
+		}
+	}
+	if v := os.Getenv("TEXT_LENGTH_POLICY"); v != "" {
+		*textLengthPolicy = v
+	}
+	textLimit, err = newTextLengthLimit(*maxTextLength, *textLengthPolicy)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid text length limit")
+	}
+	if v := os.Getenv("WEBHOOK_ALLOWED_HOSTS"); v != "" {
+		*webhookAllowHosts = v
+	}
+	if v := os.Getenv("WEBHOOK_DENIED_HOSTS"); v != "" {
+		*webhookDenyHosts = v
+	}
+	if v := os.Getenv("WEBHOOK_BLOCK_PRIVATE"); v != "" {
+		*webhookBlockPrivate = strings.ToLower(v) == "true" || v == "1"
+	}
+	webhookPolicy, err = newWebhookHostPolicy(*webhookAllowHosts, *webhookDenyHosts, *webhookBlockPrivate)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid webhook host policy")
+	}
+	if v := os.Getenv("WEBHOOK_SIGNED_HEADERS"); v != "" {
+		*webhookSignHeaders = v
+	}
+	webhookSignedHeaders, err = parseWebhookSignedHeaders(*webhookSignHeaders)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid webhook signed headers")
+	}
+	if v := os.Getenv("WUZAPI_MAX_SESSIONS"); v != "" {
+		if sessions, err := strconv.Atoi(v); err == nil {
+			*maxSessions = sessions
+		}
+	}
+	if v := os.Getenv("SEND_RATE_LIMIT"); v != "" {
+		if rate, err := strconv.Atoi(v); err == nil {
+			*sendRate = rate
+		}
+	}
+	if v := os.Getenv("SEND_RATE_BURST"); v != "" {
+		if burst, err := strconv.Atoi(v); err == nil {
+			*sendBurst = burst
+		}
+	}
+	sendLimiter, err = newSendRateLimiter(*sendRate, *sendBurst)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid global send rate limit")
+	}
 	if v := os.Getenv("WEBHOOK_RETRY_COUNT"); v != "" {
 		if count, err := strconv.Atoi(v); err == nil {
 			*webhookRetryCount = count
@@ -196,6 +339,25 @@ func main() {
 	if v := os.Getenv("WEBHOOK_ERROR_QUEUE_NAME"); v != "" {
 		*webhookErrorQueueName = v
 	}
+	if v := os.Getenv("WEBHOOK_TIMEOUT_SECONDS"); v != "" {
+		if timeout, err := strconv.Atoi(v); err == nil {
+			*webhookTimeout = timeout
+		}
+	}
+	if v := os.Getenv("WEBHOOK_DIAL_TIMEOUT_SECONDS"); v != "" {
+		if timeout, err := strconv.Atoi(v); err == nil {
+			*webhookDialTimeout = timeout
+		}
+	}
+	if v := os.Getenv("WEBHOOK_KEEPALIVE_SECONDS"); v != "" {
+		if interval, err := strconv.Atoi(v); err == nil {
+			*webhookKeepAlive = interval
+		}
+	}
+	if *webhookTimeout < 1 || *webhookDialTimeout < 1 || *webhookKeepAlive < 0 {
+		log.Fatal().Int("timeout", *webhookTimeout).Int("dial_timeout", *webhookDialTimeout).Int("keepalive", *webhookKeepAlive).
+			Msg("Webhook timeouts must be at least 1 second and the keepalive interval cannot be negative")
+	}
 
 	log.Info().
 		Bool("enabled", *webhookRetryEnabled).
@@ -204,6 +366,83 @@ func main() {
 		Str("queue", *webhookErrorQueueName).
 		Msg("Webhook Retry Configured")
 
+	if v := os.Getenv("CHATWOOT_MAX_MEDIA_MB"); v != "" {
+		if size, err := strconv.Atoi(v); err == nil {
+			*chatwootMaxMediaMB = size
+		}
+	}
+	chatwoot.MaxMediaSize = int64(*chatwootMaxMediaMB) * 1024 * 1024
+
+	if v := os.Getenv("CHATWOOT_MEDIA_TIMEOUT_SECONDS"); v != "" {
+		if timeout, err := strconv.Atoi(v); err == nil {
+			*chatwootMediaTimeout = timeout
+		}
+	}
+	chatwoot.MediaDownloadTimeout = time.Duration(*chatwootMediaTimeout) * time.Second
+
+	if v := os.Getenv("CHATWOOT_MEDIA_UPLOAD_ATTEMPTS"); v != "" {
+		if attempts, err := strconv.Atoi(v); err == nil {
+			*chatwootUploadTries = attempts
+		}
+	}
+	if v := os.Getenv("CHATWOOT_MEDIA_UPLOAD_TIMEOUT_SECONDS"); v != "" {
+		if timeout, err := strconv.Atoi(v); err == nil {
+			*chatwootUploadTime = timeout
+		}
+	}
+	if *chatwootUploadTries < 1 {
+		log.Fatal().Int("attempts", *chatwootUploadTries).Msg("Chatwoot media upload attempts must be at least 1")
+	}
+	chatwoot.MediaUploadAttempts = *chatwootUploadTries
+	chatwoot.MediaUploadTimeout = time.Duration(*chatwootUploadTime) * time.Second
+
+	if v := os.Getenv("CHATWOOT_INBOX_CREATE_ATTEMPTS"); v != "" {
+		if attempts, err := strconv.Atoi(v); err == nil {
+			*chatwootInboxTries = attempts
+		}
+	}
+	if *chatwootInboxTries < 1 {
+		log.Fatal().Int("attempts", *chatwootInboxTries).Msg("Chatwoot inbox create attempts must be at least 1")
+	}
+	chatwoot.InboxCreateAttempts = *chatwootInboxTries
+
+	if v := os.Getenv("CHATWOOT_DEDUPE_TTL_SECONDS"); v != "" {
+		if ttl, err := strconv.Atoi(v); err == nil {
+			*chatwootDedupeTTL = ttl
+		}
+	}
+	if v := os.Getenv("CHATWOOT_DEDUPE_CLEANUP_SECONDS"); v != "" {
+		if interval, err := strconv.Atoi(v); err == nil {
+			*chatwootDedupeClean = interval
+		}
+	}
+	if *chatwootDedupeTTL < 1 || *chatwootDedupeClean < 1 {
+		log.Fatal().Int("ttl", *chatwootDedupeTTL).Int("cleanup", *chatwootDedupeClean).Msg("Chatwoot dedupe retention and cleanup interval must be at least 1 second")
+	}
+	chatwoot.DedupeCacheTTL = time.Duration(*chatwootDedupeTTL) * time.Second
+	chatwoot.DedupeCleanupInterval = time.Duration(*chatwootDedupeClean) * time.Second
+
+	if v := os.Getenv("CHATWOOT_OUTGOING_DEDUPE_SECONDS"); v != "" {
+		if ttl, err := strconv.Atoi(v); err == nil {
+			*chatwootOutDedupe = ttl
+		}
+	}
+	if *chatwootOutDedupe < 0 {
+		log.Fatal().Int("ttl", *chatwootOutDedupe).Msg("Chatwoot outgoing dedupe window cannot be negative")
+	}
+	chatwootOutgoingDedupeTTL = time.Duration(*chatwootOutDedupe) * time.Second
+
+	if v := os.Getenv("PHONE_DEFAULT_REGION"); v != "" {
+		*phoneRegion = v
+	}
+	if err := chatwoot.SetDefaultPhoneRegion(*phoneRegion); err != nil {
+		log.Fatal().Err(err).Msg("Invalid default phone region")
+	}
+
+	if v := os.Getenv("WUZAPI_BASE_PATH"); v != "" {
+		*basePath = v
+	}
+
 	// Novo bloco para sobrescrever o osName pelo ENV, se existir
 	if v := os.Getenv("SESSION_DEVICE_NAME"); v != "" {
 		*osName = v
@@ -271,6 +510,13 @@ func main() {
 		}
 	}
 
+	// Video stickers need ffmpeg, check it now rather than when one is sent
+	if version, err := ffmpegVersion(); err != nil {
+		log.Warn().Err(err).Msg("ffmpeg NOT AVAILABLE: video and animated stickers cannot be sent until it is installed or FFMPEG_PATH is set")
+	} else {
+		log.Info().Str("version", version).Msg("ffmpeg available")
+	}
+
 	if *adminToken == "" {
 		if v := os.Getenv("WUZAPI_ADMIN_TOKEN"); v != "" {
 			*adminToken = v
@@ -378,16 +624,23 @@ func main() {
 
 	// Get database configuration
 	config := getDatabaseConfig(exPath, *dataDir)
-	var storeConnStr string
+	var storeConnStr, storeDialect string
 	if config.Type == "postgres" {
 		storeConnStr = fmt.Sprintf(
 			"user=%s password=%s dbname=%s host=%s port=%s sslmode=%s",
 			config.User, config.Password, config.Name, config.Host, config.Port, config.SSLMode,
 		)
-		container, err = sqlstore.New(context.Background(), "postgres", storeConnStr, dbLog)
+		storeDialect = "postgres"
 	} else {
 		storeConnStr = "file:" + filepath.Join(config.Path, "main.db") + "?_pragma=foreign_keys(1)&_busy_timeout=60000&_journal_mode=WAL"
-		container, err = sqlstore.New(context.Background(), "sqlite", storeConnStr, dbLog)
+		storeDialect = "sqlite"
+	}
+
+	// The store handle is kept to read whatsmeow tables, such as chat settings
+	storeDB, err := sqlx.Open(storeDialect, storeConnStr)
+	if err == nil {
+		container = sqlstore.NewWithDB(storeDB.DB, storeDialect, dbLog)
+		err = container.Upgrade(context.Background())
 	}
 
 	if err != nil {
@@ -401,13 +654,28 @@ func main() {
 	}
 
 	s := &server{
-		router: mux.NewRouter(),
-		db:     db,
-		exPath: exPath,
-		mode:   serverMode,
+		router:  mux.NewRouter(),
+		db:      db,
+		exPath:  exPath,
+		mode:    serverMode,
+		storeDB: storeDB,
 	}
 	s.routes()
 
+	if *webhookDedupe {
+		webhookDeliveries = newWebhookDeliveryStore(db)
+		go webhookDeliveries.runCleanup()
+	}
+
+	if *openGraphCacheHours > 0 {
+		openGraphStore = newOpenGraphDataStore(db, time.Duration(*openGraphCacheHours)*time.Hour)
+		go openGraphStore.runCleanup()
+	}
+	if *receiptRetentionDays > 0 {
+		go s.runReceiptCleanup(time.Duration(*receiptRetentionDays) * 24 * time.Hour)
+	}
+	go logOpenGraphStats(openGraphStatsLogInterval)
+
 	s.connectOnStartup()
 
 	if serverMode == Stdio {
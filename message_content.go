@@ -0,0 +1,119 @@
+package main
+
+import (
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// messageContent is the normalized view of a message added to Message event
+// payloads, so consumers can tell text from media without walking the raw
+// protobuf
+type messageContent struct {
+	Text      string         `json:"text,omitempty"`
+	MediaType string         `json:"mediaType,omitempty"`
+	Caption   string         `json:"caption,omitempty"`
+	Mimetype  string         `json:"mimetype,omitempty"`
+	FileName  string         `json:"fileName,omitempty"`
+	Size      uint64         `json:"size,omitempty"`
+	Event     *calendarEvent `json:"event,omitempty"`
+}
+
+// calendarEvent is the normalized form of an event (calendar) message. Times
+// are unix seconds, zero when unset.
+type calendarEvent struct {
+	Name          string         `json:"name"`
+	Description   string         `json:"description,omitempty"`
+	StartTime     int64          `json:"startTime,omitempty"`
+	EndTime       int64          `json:"endTime,omitempty"`
+	Location      *eventLocation `json:"location,omitempty"`
+	CallLink      string         `json:"callLink,omitempty"`
+	Canceled      bool           `json:"canceled,omitempty"`
+	ScheduledCall bool           `json:"scheduledCall,omitempty"`
+}
+
+// eventLocation is where a calendar event takes place
+type eventLocation struct {
+	Name      string  `json:"name,omitempty"`
+	Address   string  `json:"address,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+}
+
+// normalizeEvent returns the normalized form of an event message
+func normalizeEvent(ev *waE2E.EventMessage) *calendarEvent {
+	event := &calendarEvent{
+		Name:          ev.GetName(),
+		Description:   ev.GetDescription(),
+		StartTime:     ev.GetStartTime(),
+		EndTime:       ev.GetEndTime(),
+		CallLink:      ev.GetJoinLink(),
+		Canceled:      ev.GetIsCanceled(),
+		ScheduledCall: ev.GetIsScheduleCall(),
+	}
+	if loc := ev.GetLocation(); loc != nil {
+		event.Location = &eventLocation{
+			Name:      loc.GetName(),
+			Address:   loc.GetAddress(),
+			Latitude:  loc.GetDegreesLatitude(),
+			Longitude: loc.GetDegreesLongitude(),
+		}
+	}
+	return event
+}
+
+// normalizeMessage returns the message type and typed content of msg. The
+// type is one of text, image, video, audio, document, sticker, location,
+// contact, reaction, poll, event or unknown.
+func normalizeMessage(msg *waE2E.Message) (string, messageContent) {
+	switch {
+	case msg == nil:
+		return "unknown", messageContent{}
+	case msg.GetConversation() != "":
+		return "text", messageContent{Text: msg.GetConversation()}
+	case msg.GetExtendedTextMessage() != nil:
+		return "text", messageContent{Text: msg.GetExtendedTextMessage().GetText()}
+	case msg.GetImageMessage() != nil:
+		img := msg.GetImageMessage()
+		return "image", messageContent{MediaType: "image", Caption: img.GetCaption(), Mimetype: img.GetMimetype(), Size: img.GetFileLength()}
+	case msg.GetVideoMessage() != nil:
+		vid := msg.GetVideoMessage()
+		return "video", messageContent{MediaType: "video", Caption: vid.GetCaption(), Mimetype: vid.GetMimetype(), Size: vid.GetFileLength()}
+	case msg.GetAudioMessage() != nil:
+		aud := msg.GetAudioMessage()
+		return "audio", messageContent{MediaType: "audio", Mimetype: aud.GetMimetype(), Size: aud.GetFileLength()}
+	case msg.GetDocumentMessage() != nil:
+		doc := msg.GetDocumentMessage()
+		return "document", messageContent{MediaType: "document", Caption: doc.GetCaption(), Mimetype: doc.GetMimetype(), FileName: doc.GetFileName(), Size: doc.GetFileLength()}
+	case msg.GetStickerMessage() != nil:
+		st := msg.GetStickerMessage()
+		return "sticker", messageContent{MediaType: "sticker", Mimetype: st.GetMimetype(), Size: st.GetFileLength()}
+	case msg.GetLocationMessage() != nil:
+		return "location", messageContent{Text: msg.GetLocationMessage().GetName()}
+	case msg.GetContactMessage() != nil:
+		return "contact", messageContent{Text: msg.GetContactMessage().GetDisplayName()}
+	case msg.GetReactionMessage() != nil:
+		return "reaction", messageContent{Text: msg.GetReactionMessage().GetText()}
+	case msg.GetPollCreationMessage() != nil, msg.GetPollCreationMessageV3() != nil:
+		poll := msg.GetPollCreationMessage()
+		if poll == nil {
+			poll = msg.GetPollCreationMessageV3()
+		}
+		return "poll", messageContent{Text: poll.GetName()}
+	case msg.GetEventMessage() != nil:
+		event := normalizeEvent(msg.GetEventMessage())
+		return "event", messageContent{Text: event.Name, Event: event}
+	}
+	return "unknown", messageContent{}
+}
+
+// addMessageContent sets messageType and content on the payload of a Message
+// event. Other events are left untouched.
+func addMessageContent(postmap map[string]interface{}) {
+	evt, ok := postmap["event"].(*events.Message)
+	if !ok {
+		return
+	}
+	messageType, content := normalizeMessage(evt.Message)
+	postmap["messageType"] = messageType
+	postmap["content"] = content
+}
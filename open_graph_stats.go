@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// openGraphStatsLogInterval is how often the Open Graph counters are logged
+const openGraphStatsLogInterval = 10 * time.Minute
+
+// openGraphStats counts how getOpenGraphData requests were answered
+var openGraphStats openGraphCounters
+
+type openGraphCounters struct {
+	memoryHits     atomic.Int64
+	storeHits      atomic.Int64
+	misses         atomic.Int64
+	leaders        atomic.Int64
+	followers      atomic.Int64
+	semaphoreWaits atomic.Int64
+	fetchOK        atomic.Int64
+	fetchEmpty     atomic.Int64
+	fetchErrors    atomic.Int64
+}
+
+// OpenGraphStats is a snapshot of the Open Graph counters. Misses are split
+// into the singleflight leaders that fetched and the followers that shared a
+// fetch in flight.
+type OpenGraphStats struct {
+	MemoryHits     int64   `json:"memory_hits"`
+	StoreHits      int64   `json:"store_hits"`
+	Misses         int64   `json:"misses"`
+	HitRate        float64 `json:"hit_rate"`
+	Leaders        int64   `json:"singleflight_leaders"`
+	Followers      int64   `json:"singleflight_followers"`
+	SemaphoreWaits int64   `json:"semaphore_waits"`
+	FetchOK        int64   `json:"fetch_ok"`
+	FetchEmpty     int64   `json:"fetch_empty"`
+	FetchErrors    int64   `json:"fetch_errors"`
+}
+
+func (c *openGraphCounters) snapshot() OpenGraphStats {
+	stats := OpenGraphStats{
+		MemoryHits:     c.memoryHits.Load(),
+		StoreHits:      c.storeHits.Load(),
+		Misses:         c.misses.Load(),
+		Leaders:        c.leaders.Load(),
+		Followers:      c.followers.Load(),
+		SemaphoreWaits: c.semaphoreWaits.Load(),
+		FetchOK:        c.fetchOK.Load(),
+		FetchEmpty:     c.fetchEmpty.Load(),
+		FetchErrors:    c.fetchErrors.Load(),
+	}
+	if total := stats.MemoryHits + stats.StoreHits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.MemoryHits+stats.StoreHits) / float64(total)
+	}
+	return stats
+}
+
+// reset zeroes all counters
+func (c *openGraphCounters) reset() {
+	for _, counter := range []*atomic.Int64{&c.memoryHits, &c.storeHits, &c.misses, &c.leaders, &c.followers,
+		&c.semaphoreWaits, &c.fetchOK, &c.fetchEmpty, &c.fetchErrors} {
+		counter.Store(0)
+	}
+}
+
+// logOpenGraphStats logs the counters every interval while there are new
+// requests
+func logOpenGraphStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var logged int64
+	for range ticker.C {
+		stats := openGraphStats.snapshot()
+		requests := stats.MemoryHits + stats.StoreHits + stats.Misses
+		if requests == logged {
+			continue
+		}
+		logged = requests
+		log.Info().
+			Int64("memory_hits", stats.MemoryHits).
+			Int64("store_hits", stats.StoreHits).
+			Int64("misses", stats.Misses).
+			Float64("hit_rate", stats.HitRate).
+			Int64("singleflight_leaders", stats.Leaders).
+			Int64("singleflight_followers", stats.Followers).
+			Int64("semaphore_waits", stats.SemaphoreWaits).
+			Int64("fetch_ok", stats.FetchOK).
+			Int64("fetch_empty", stats.FetchEmpty).
+			Int64("fetch_errors", stats.FetchErrors).
+			Msg("Open Graph cache stats")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+// openGraphStore keeps fetched Open Graph data across restarts, behind the
+// in-memory openGraphCache. It is nil unless the persistent cache is enabled.
+var openGraphStore *openGraphDataStore
+
+type openGraphDataStore struct {
+	db  *sqlx.DB
+	ttl time.Duration
+}
+
+func newOpenGraphDataStore(db *sqlx.DB, ttl time.Duration) *openGraphDataStore {
+	return &openGraphDataStore{db: db, ttl: ttl}
+}
+
+func (s *openGraphDataStore) query(q string) string {
+	if s.db.DriverName() == "sqlite" {
+		q = strings.NewReplacer("$1", "?", "$2", "?", "$3", "?", "$4", "?", "$5", "?").Replace(q)
+	}
+	return q
+}
+
+// get returns the data stored for url when it was fetched less than ttl ago
+func (s *openGraphDataStore) get(url string) (openGraphResult, bool) {
+	var row struct {
+		Title       string `db:"title"`
+		Description string `db:"description"`
+		Image       []byte `db:"image"`
+		FetchedAt   int64  `db:"fetched_at"`
+	}
+	err := s.db.Get(&row, s.query(`SELECT title, description, image, fetched_at FROM open_graph_cache WHERE url = $1`), url)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Warn().Err(err).Str("url", url).Msg("Could not read stored Open Graph data")
+		}
+		return openGraphResult{}, false
+	}
+	if time.Since(time.Unix(row.FetchedAt, 0)) > s.ttl {
+		return openGraphResult{}, false
+	}
+	return openGraphResult{Title: row.Title, Description: row.Description, ImageData: row.Image}, true
+}
+
+// put stores the data fetched for url
+func (s *openGraphDataStore) put(url string, data openGraphResult) {
+	_, err := s.db.Exec(s.query(`INSERT INTO open_graph_cache (url, title, description, image, fetched_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (url) DO UPDATE SET title = excluded.title, description = excluded.description, image = excluded.image, fetched_at = excluded.fetched_at`),
+		url, data.Title, data.Description, data.ImageData, time.Now().Unix())
+	if err != nil {
+		log.Warn().Err(err).Str("url", url).Msg("Could not store Open Graph data")
+	}
+}
+
+// cleanup removes data fetched more than ttl ago
+func (s *openGraphDataStore) cleanup() (int64, error) {
+	res, err := s.db.Exec(s.query(`DELETE FROM open_graph_cache WHERE fetched_at < $1`), time.Now().Add(-s.ttl).Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// runCleanup prunes expired Open Graph data every hour
+func (s *openGraphDataStore) runCleanup() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if n, err := s.cleanup(); err != nil {
+			log.Warn().Err(err).Msg("Failed to clean up stored Open Graph data")
+		} else if n > 0 {
+			log.Debug().Int64("removed", n).Msg("Cleaned up stored Open Graph data")
+		}
+		<-ticker.C
+	}
+}
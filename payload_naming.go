@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// fieldNaming is the casing applied to the keys of webhook and RabbitMQ
+// payloads. The zero value keeps keys as they are built.
+type fieldNaming string
+
+const (
+	namingAsIs  fieldNaming = ""
+	namingCamel fieldNaming = "camel"
+	namingSnake fieldNaming = "snake"
+)
+
+// payloadNaming is the casing set with -webhooknaming
+var payloadNaming = namingAsIs
+
+// parseFieldNaming validates a -webhooknaming value
+func parseFieldNaming(v string) (fieldNaming, error) {
+	switch n := fieldNaming(strings.ToLower(strings.TrimSpace(v))); n {
+	case namingAsIs, namingCamel, namingSnake:
+		return n, nil
+	case "none", "default":
+		return namingAsIs, nil
+	}
+	return namingAsIs, fmt.Errorf("unknown field naming %q, use camel or snake", v)
+}
+
+// fieldWords splits a key into its words: userID is user and ID,
+// JPEGThumbnail is JPEG and Thumbnail and message_id is message and id
+func fieldWords(key string) []string {
+	var words []string
+	runes := []rune(key)
+	flush := func(word []rune) {
+		if w := strings.Trim(string(word), "_"); w != "" {
+			words = append(words, w)
+		}
+	}
+
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+		separator := prev == '_' || cur == '_'
+		lowerToUpper := (unicode.IsLower(prev) || unicode.IsDigit(prev)) && unicode.IsUpper(cur)
+		acronymEnd := unicode.IsUpper(prev) && unicode.IsUpper(cur) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if separator || lowerToUpper || acronymEnd {
+			flush(runes[start:i])
+			start = i
+		}
+	}
+	flush(runes[start:])
+	return words
+}
+
+// isFieldName reports whether key looks like a field name. Keys such as JIDs,
+// URLs or message ids used to index maps are left alone.
+func isFieldName(key string) bool {
+	if key == "" || !unicode.IsLetter([]rune(key)[0]) {
+		return false
+	}
+	for _, r := range key {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// key returns key in the casing of n
+func (n fieldNaming) key(key string) string {
+	if n == namingAsIs || !isFieldName(key) {
+		return key
+	}
+	words := fieldWords(key)
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if n == namingCamel && i > 0 {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		words[i] = word
+	}
+	if n == namingSnake {
+		return strings.Join(words, "_")
+	}
+	return strings.Join(words, "")
+}
+
+// apply renames the keys of the decoded JSON value v and of the objects
+// nested in it
+func (n fieldNaming) apply(v interface{}) interface{} {
+	if n == namingAsIs {
+		return v
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		named := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			named[n.key(k)] = n.apply(item)
+		}
+		return named
+	case []interface{}:
+		named := make([]interface{}, len(v))
+		for i, item := range v {
+			named[i] = n.apply(item)
+		}
+		return named
+	}
+	return v
+}
+
+// form renames the fields of a form-encoded webhook, including the keys of
+// the event carried as JSON in jsonData
+func (n fieldNaming) form(payload map[string]string) map[string]string {
+	if n == namingAsIs {
+		return payload
+	}
+	named := make(map[string]string, len(payload))
+	for k, v := range payload {
+		if k == "jsonData" {
+			var event interface{}
+			if err := json.Unmarshal([]byte(v), &event); err == nil {
+				if data, err := json.Marshal(n.apply(event)); err == nil {
+					v = string(data)
+				}
+			}
+		}
+		named[n.key(k)] = v
+	}
+	return named
+}
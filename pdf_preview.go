@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"image"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// pdfThumbnailSize is the longest side in pixels of PDF thumbnails
+const pdfThumbnailSize = 320
+
+// pdfRenderTimeout bounds the time spent rendering a thumbnail or counting
+// the pages of a PDF
+const pdfRenderTimeout = 15 * time.Second
+
+// pdfPagePattern matches the page objects of a PDF, not its /Pages tree
+var pdfPagePattern = regexp.MustCompile(`/Type\s*/Page[^s]`)
+
+// isPDF reports whether a document is a PDF, by mimetype or content
+func isPDF(mimeType string, data []byte) bool {
+	return strings.HasPrefix(mimeType, "application/pdf") || bytes.HasPrefix(data, []byte("%PDF-"))
+}
+
+// renderPDFThumbnail renders the first page of the PDF in path as a JPEG with
+// pdftoppm. It returns nil when pdftoppm is not installed or fails.
+func renderPDFThumbnail(ctx context.Context, path string) []byte {
+	renderer, err := exec.LookPath("pdftoppm")
+	if err != nil {
+		log.Debug().Msg("pdftoppm not available, sending PDF without thumbnail")
+		return nil
+	}
+
+	outPrefix := strings.TrimSuffix(path, filepath.Ext(path)) + "-thumb"
+	defer os.Remove(outPrefix + ".jpg")
+
+	cmd := exec.CommandContext(ctx, renderer, "-f", "1", "-l", "1", "-jpeg", "-singlefile",
+		"-scale-to", strconv.Itoa(pdfThumbnailSize), path, outPrefix)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		log.Warn().Err(err).Str("stderr", stderr.String()).Msg("Failed to render PDF thumbnail")
+		return nil
+	}
+	thumbnail, err := os.ReadFile(outPrefix + ".jpg")
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read rendered PDF thumbnail")
+		return nil
+	}
+	return thumbnail
+}
+
+// countPDFPages returns the number of pages of the PDF in path, asking
+// pdfinfo when installed and counting the page objects otherwise. Zero means
+// the count is unknown.
+func countPDFPages(ctx context.Context, path string, data []byte) uint32 {
+	if info, err := exec.LookPath("pdfinfo"); err == nil {
+		if out, err := exec.CommandContext(ctx, info, path).Output(); err == nil {
+			scanner := bufio.NewScanner(bytes.NewReader(out))
+			for scanner.Scan() {
+				if value, found := strings.CutPrefix(scanner.Text(), "Pages:"); found {
+					if pages, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32); err == nil {
+						return uint32(pages)
+					}
+				}
+			}
+		}
+	}
+	// Pages inside compressed object streams are not found this way
+	return uint32(len(pdfPagePattern.FindAll(data, -1)))
+}
+
+// addPDFPreview attaches a first page thumbnail and the page count to a PDF
+// document message when -pdfthumbnails is set. Anything missing is skipped,
+// leaving the document as it would be sent without a preview.
+func addPDFPreview(doc *waE2E.DocumentMessage, data []byte) {
+	if !*pdfThumbnails || !isPDF(doc.GetMimetype(), data) {
+		return
+	}
+
+	file, err := os.CreateTemp("", "document-*.pdf")
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to create temporary file for PDF preview")
+		return
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	file.Close()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to write temporary file for PDF preview")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pdfRenderTimeout)
+	defer cancel()
+
+	if pages := countPDFPages(ctx, file.Name(), data); pages > 0 {
+		doc.PageCount = proto.Uint32(pages)
+	}
+	thumbnail := renderPDFThumbnail(ctx, file.Name())
+	if thumbnail == nil {
+		return
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(thumbnail))
+	if err != nil {
+		log.Warn().Err(err).Msg("Rendered PDF thumbnail is not a valid image")
+		return
+	}
+	doc.JPEGThumbnail = thumbnail
+	doc.ThumbnailWidth = proto.Uint32(uint32(config.Width))
+	doc.ThumbnailHeight = proto.Uint32(uint32(config.Height))
+}
@@ -0,0 +1,80 @@
+package chatwoot
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// AvatarRefreshInterval is how often the WhatsApp profile picture of a known
+// contact is checked again, and copied to Chatwoot when it changed
+var AvatarRefreshInterval = 24 * time.Hour
+
+// avatarTimeout bounds the time spent asking WhatsApp for a profile picture
+const avatarTimeout = 10 * time.Second
+
+// avatarFetcher is the part of the WhatsApp client used to read profile pictures
+type avatarFetcher interface {
+	GetProfilePictureInfo(ctx context.Context, jid types.JID, params *whatsmeow.GetProfilePictureParams) (*types.ProfilePictureInfo, error)
+}
+
+// avatarState is the profile picture last seen for a contact and when
+type avatarState struct {
+	id        string
+	checkedAt time.Time
+}
+
+// contactAvatar returns the URL of the WhatsApp profile picture of identifier
+// when it should be set on the Chatwoot contact: always for a new contact
+// (current is nil), and for a known one when the picture changed since it was
+// last checked, at most once per AvatarRefreshInterval. Hidden or missing
+// pictures give an empty URL and are checked again after the interval.
+func (s *Service) contactAvatar(avatars avatarFetcher, userID, identifier string, current *ContactPayload) string {
+	if avatars == nil {
+		return ""
+	}
+	jid, err := types.ParseJID(identifier)
+	if err != nil || jid.User == "" {
+		return ""
+	}
+	jid = jid.ToNonAD()
+	key := userID + ":" + jid.String()
+
+	params := &whatsmeow.GetProfilePictureParams{}
+	var state avatarState
+	if cached, ok := s.avatarCache.Load(key); ok {
+		state = cached.(avatarState)
+		if current != nil && time.Since(state.checkedAt) < AvatarRefreshInterval {
+			return ""
+		}
+		params.ExistingID = state.id
+	} else if current != nil && current.Thumbnail != "" {
+		// The avatar was set before a restart, check it again after the interval
+		s.avatarCache.Store(key, avatarState{checkedAt: time.Now()})
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), avatarTimeout)
+	defer cancel()
+	pic, err := avatars.GetProfilePictureInfo(ctx, jid, params)
+	s.avatarCache.Store(key, avatarState{id: state.id, checkedAt: time.Now()})
+
+	switch {
+	case errors.Is(err, whatsmeow.ErrProfilePictureUnauthorized), errors.Is(err, whatsmeow.ErrProfilePictureNotSet):
+		log.Debug().Err(err).Str("jid", jid.String()).Msg("No WhatsApp avatar to copy to Chatwoot")
+		return ""
+	case err != nil:
+		log.Warn().Err(err).Str("jid", jid.String()).Msg("Failed to get WhatsApp avatar for Chatwoot contact")
+		return ""
+	case pic == nil:
+		// Unchanged since the last check
+		return ""
+	}
+
+	s.avatarCache.Store(key, avatarState{id: pic.ID, checkedAt: time.Now()})
+	return pic.URL
+}
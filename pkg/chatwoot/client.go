@@ -3,15 +3,22 @@ package chatwoot
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
+// ErrContactNotFound is returned when no Chatwoot contact has the phone
+// number searched for
+var ErrContactNotFound = errors.New("contact not found")
+
 // Client represents a Chatwoot API client
 type Client struct {
 	config     *Config
@@ -52,6 +59,11 @@ type InboxResponse struct {
 	WebhookURL  string `json:"webhook_url"`
 }
 
+// InboxListResponse represents the list inboxes response
+type InboxListResponse struct {
+	Payload []InboxResponse `json:"payload"`
+}
+
 // ContactSearchResponse represents the search contact response
 type ContactSearchResponse struct {
 	Payload []ContactPayload `json:"payload"`
@@ -59,20 +71,30 @@ type ContactSearchResponse struct {
 
 // ContactPayload represents a contact in the response
 type ContactPayload struct {
-	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	PhoneNumber string `json:"phone_number"`
-	Identifier  string `json:"identifier"`
-	Thumbnail   string `json:"thumbnail"`
+	ID               int                    `json:"id"`
+	Name             string                 `json:"name"`
+	PhoneNumber      string                 `json:"phone_number"`
+	Identifier       string                 `json:"identifier"`
+	Thumbnail        string                 `json:"thumbnail"`
+	CustomAttributes map[string]interface{} `json:"custom_attributes,omitempty"`
 }
 
 // CreateContactPayloadRequest represents the contact creation request
 type CreateContactPayloadRequest struct {
-	InboxID     int    `json:"inbox_id"`
-	Name        string `json:"name"`
-	Identifier  string `json:"identifier,omitempty"`
-	PhoneNumber string `json:"phone_number,omitempty"`
-	AvatarURL   string `json:"avatar_url,omitempty"`
+	InboxID          int                    `json:"inbox_id"`
+	Name             string                 `json:"name"`
+	Identifier       string                 `json:"identifier,omitempty"`
+	PhoneNumber      string                 `json:"phone_number,omitempty"`
+	AvatarURL        string                 `json:"avatar_url,omitempty"`
+	CustomAttributes map[string]interface{} `json:"custom_attributes,omitempty"`
+}
+
+// UpdateContactRequest represents the contact update request. Empty fields
+// are left unchanged.
+type UpdateContactRequest struct {
+	Name             string                 `json:"name,omitempty"`
+	AvatarURL        string                 `json:"avatar_url,omitempty"`
+	CustomAttributes map[string]interface{} `json:"custom_attributes,omitempty"`
 }
 
 // ContactResponse represents the contact creation response
@@ -99,6 +121,12 @@ type ConversationResponse struct {
 	ContactID int    `json:"contact_id"`
 }
 
+// AssignmentRequest represents the conversation assignment request
+type AssignmentRequest struct {
+	AssigneeID int `json:"assignee_id,omitempty"`
+	TeamID     int `json:"team_id,omitempty"`
+}
+
 // MessageRequest represents the message creation request
 type MessageRequest struct {
 	Content           string                 `json:"content"`
@@ -120,11 +148,35 @@ type MessageResponse struct {
 
 // ErrorResponse represents a Chatwoot API error
 type ErrorResponse struct {
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a validation error Chatwoot reports for a single field
+type FieldError struct {
+	Field   string `json:"field"`
 	Message string `json:"message"`
-	Errors  []struct {
-		Field   string `json:"field"`
-		Message string `json:"message"`
-	} `json:"errors,omitempty"`
+}
+
+// APIError is returned for non-2xx Chatwoot responses. It keeps the field
+// errors so callers can report exactly what Chatwoot rejected.
+type APIError struct {
+	StatusCode int          `json:"status_code"`
+	Message    string       `json:"message"`
+	Errors     []FieldError `json:"errors,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Message)
+	if len(e.Errors) == 0 {
+		return msg
+	}
+
+	fields := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		fields = append(fields, fe.Field+": "+fe.Message)
+	}
+	return msg + " (" + strings.Join(fields, "; ") + ")"
 }
 
 // doRequest performs an HTTP request with authentication
@@ -174,10 +226,10 @@ func (c *Client) handleError(resp *http.Response) error {
 
 	var errResp ErrorResponse
 	if err := json.Unmarshal(bodyBytes, &errResp); err != nil {
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(bodyBytes))
+		return &APIError{StatusCode: resp.StatusCode, Message: string(bodyBytes)}
 	}
 
-	return fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Message)
+	return &APIError{StatusCode: resp.StatusCode, Message: errResp.Message, Errors: errResp.Errors}
 }
 
 // CreateInbox creates a new inbox in Chatwoot
@@ -214,8 +266,40 @@ func (c *Client) CreateInbox(name string, webhookURL string) (int, error) {
 	return inboxResp.ID, nil
 }
 
+// ListInboxes returns the inboxes of the account. It is read-only, which makes
+// it suitable for checking the URL, account and token.
+func (c *Client) ListInboxes() ([]InboxResponse, error) {
+	path := fmt.Sprintf("/api/v1/accounts/%s/inboxes", c.accountID)
+	resp, err := c.doRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := c.handleError(resp); err != nil {
+		return nil, err
+	}
+
+	var listResp InboxListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+		return nil, fmt.Errorf("failed to decode inbox list response: %w", err)
+	}
+
+	return listResp.Payload, nil
+}
+
 // FindContactByPhone searches for a contact by phone number
 func (c *Client) FindContactByPhone(phone string) (int, error) {
+	contact, err := c.SearchContactByPhone(phone)
+	if err != nil {
+		return 0, err
+	}
+	return contact.ID, nil
+}
+
+// SearchContactByPhone returns the first Chatwoot contact with the phone
+// number, with its current name
+func (c *Client) SearchContactByPhone(phone string) (*ContactPayload, error) {
 	// Ensure phone has + prefix
 	if phone[0] != '+' {
 		phone = "+" + phone
@@ -224,40 +308,47 @@ func (c *Client) FindContactByPhone(phone string) (int, error) {
 	path := fmt.Sprintf("/api/v1/accounts/%s/contacts/search?q=%s", c.accountID, phone)
 	resp, err := c.doRequest("GET", path, nil)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if err := c.handleError(resp); err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	var searchResp ContactSearchResponse
 	if err := json.NewDecoder(resp.Body).Decode(&searchResp); err != nil {
-		return 0, fmt.Errorf("failed to decode search response: %w", err)
+		return nil, fmt.Errorf("failed to decode search response: %w", err)
 	}
 
 	if len(searchResp.Payload) == 0 {
-		return 0, fmt.Errorf("contact not found: %s", phone)
+		return nil, fmt.Errorf("%w: %s", ErrContactNotFound, phone)
 	}
 
 	// Return first match
-	contactID := searchResp.Payload[0].ID
+	contact := searchResp.Payload[0]
 	log.Debug().
-		Int("contact_id", contactID).
+		Int("contact_id", contact.ID).
 		Str("phone", phone).
 		Msg("Contact found")
 
-	return contactID, nil
+	return &contact, nil
 }
 
 // CreateContact creates a new contact in Chatwoot
 func (c *Client) CreateContact(inboxID int, name, phone, identifier, avatarURL string) (int, error) {
+	return c.CreateContactWithAttributes(inboxID, name, phone, identifier, avatarURL, nil)
+}
+
+// CreateContactWithAttributes creates a new contact in Chatwoot with custom
+// attributes
+func (c *Client) CreateContactWithAttributes(inboxID int, name, phone, identifier, avatarURL string, attributes map[string]interface{}) (int, error) {
 	request := CreateContactPayloadRequest{
-		InboxID:    inboxID,
-		Name:       name,
-		Identifier: identifier,
-		AvatarURL:  avatarURL,
+		InboxID:          inboxID,
+		Name:             name,
+		Identifier:       identifier,
+		AvatarURL:        avatarURL,
+		CustomAttributes: attributes,
 	}
 
 	// Only add phone_number if it's a valid phone (not a group)
@@ -294,6 +385,23 @@ func (c *Client) CreateContact(inboxID int, name, phone, identifier, avatarURL s
 	return contactID, nil
 }
 
+// UpdateContact changes the given fields of a Chatwoot contact
+func (c *Client) UpdateContact(contactID int, fields UpdateContactRequest) error {
+	path := fmt.Sprintf("/api/v1/accounts/%s/contacts/%d", c.accountID, contactID)
+	resp, err := c.doRequest("PUT", path, fields)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := c.handleError(resp); err != nil {
+		return err
+	}
+
+	log.Info().Int("contact_id", contactID).Str("name", fields.Name).Msg("Chatwoot contact updated")
+	return nil
+}
+
 // CreateConversation creates a new conversation in Chatwoot
 func (c *Client) CreateConversation(contactID int, inboxID int, sourceID string, pending bool) (int, error) {
 	request := ConversationRequest{
@@ -331,6 +439,34 @@ func (c *Client) CreateConversation(contactID int, inboxID int, sourceID string,
 	return convResp.ID, nil
 }
 
+// AssignConversation assigns a conversation to an agent or a team. Chatwoot
+// handles one target per call, so set either assigneeID or teamID.
+func (c *Client) AssignConversation(conversationID int, assigneeID int, teamID int) error {
+	request := AssignmentRequest{
+		AssigneeID: assigneeID,
+		TeamID:     teamID,
+	}
+
+	path := fmt.Sprintf("/api/v1/accounts/%s/conversations/%d/assignments", c.accountID, conversationID)
+	resp, err := c.doRequest("POST", path, request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := c.handleError(resp); err != nil {
+		return err
+	}
+
+	log.Info().
+		Int("conversation_id", conversationID).
+		Int("assignee_id", assigneeID).
+		Int("team_id", teamID).
+		Msg("Chatwoot conversation assigned")
+
+	return nil
+}
+
 // CreateMessage creates a new text message in a conversation
 func (c *Client) CreateMessage(conversationID int, msgType string, content string, private bool, sourceID string) (int, error) {
 	request := MessageRequest{
@@ -365,49 +501,101 @@ func (c *Client) CreateMessage(conversationID int, msgType string, content strin
 	return msgResp.ID, nil
 }
 
-// SendMediaMessage sends a message with media attachment using multipart/form-data
-func (c *Client) SendMediaMessage(conversationID int, msgType string, fileData []byte, fileName string, mimeType string, caption string, sourceID string) (int, error) {
-	// Create multipart form
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+// MediaUploadAttempts is how many times a media upload to Chatwoot is tried
+// before giving up. Retries need a seekable file to resend from the start.
+var MediaUploadAttempts = 3
 
-	// Add message_type field
-	if err := writer.WriteField("message_type", msgType); err != nil {
-		return 0, fmt.Errorf("failed to write message_type field: %w", err)
-	}
+// MediaUploadBackoff is the wait before the first media upload retry, doubled
+// after each further attempt
+var MediaUploadBackoff = 2 * time.Second
 
-	// Add caption if provided
-	if caption != "" {
-		if err := writer.WriteField("content", caption); err != nil {
-			return 0, fmt.Errorf("failed to write content field: %w", err)
-		}
+// MediaUploadTimeout bounds each media upload attempt. Uploads get longer than
+// the 30 seconds other API calls do as attachments can be tens of megabytes.
+// Zero disables the deadline.
+var MediaUploadTimeout = 5 * time.Minute
+
+// isRetryable reports whether a failed request may succeed when sent again:
+// network errors, rate limiting and server errors
+func isRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
 	}
+	return true
+}
 
-	// Add source_id if provided
-	if sourceID != "" {
-		if err := writer.WriteField("source_id", sourceID); err != nil {
-			return 0, fmt.Errorf("failed to write source_id field: %w", err)
+// SendMediaMessage sends a message with media attachment using multipart/form-data.
+// The file is streamed into the request body instead of being buffered in memory.
+// Transient failures are retried up to MediaUploadAttempts times when fileData
+// can be rewound. Before each retry the conversation is checked for a message
+// with sourceID, so an upload Chatwoot stored before failing is not sent twice.
+func (c *Client) SendMediaMessage(conversationID int, msgType string, fileData io.Reader, fileName string, mimeType string, caption string, sourceID string) (int, error) {
+	seeker, seekable := fileData.(io.Seeker)
+	var start int64
+	if seekable {
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			seekable = false
 		}
+		start = offset
 	}
 
-	// Add file attachment
-	part, err := writer.CreateFormFile("attachments[]", fileName)
-	if err != nil {
-		return 0, fmt.Errorf("failed to create form file: %w", err)
-	}
+	backoff := MediaUploadBackoff
+	for attempt := 1; ; attempt++ {
+		messageID, err := c.sendMediaOnce(conversationID, msgType, fileData, fileName, mimeType, caption, sourceID)
+		if err == nil {
+			return messageID, nil
+		}
+		if attempt >= MediaUploadAttempts || !seekable || !isRetryable(err) {
+			return 0, err
+		}
 
-	if _, err := part.Write(fileData); err != nil {
-		return 0, fmt.Errorf("failed to write file data: %w", err)
+		log.Warn().
+			Err(err).
+			Int("attempt", attempt).
+			Int("conversation_id", conversationID).
+			Str("filename", fileName).
+			Dur("backoff", backoff).
+			Msg("Chatwoot media upload failed, retrying")
+		time.Sleep(backoff)
+		backoff *= 2
+
+		if sourceID != "" {
+			if existing, found, lookupErr := c.findMessageBySourceID(conversationID, sourceID); lookupErr == nil && found {
+				log.Info().
+					Int("message_id", existing).
+					Int("conversation_id", conversationID).
+					Str("source_id", sourceID).
+					Msg("Chatwoot media message already stored, not resending")
+				return existing, nil
+			}
+		}
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("failed to rewind media for retry: %w", err)
+		}
 	}
+}
 
-	// Close the multipart writer
-	if err := writer.Close(); err != nil {
-		return 0, fmt.Errorf("failed to close multipart writer: %w", err)
-	}
+// sendMediaOnce makes a single media upload attempt for SendMediaMessage
+func (c *Client) sendMediaOnce(conversationID int, msgType string, fileData io.Reader, fileName string, mimeType string, caption string, sourceID string) (int, error) {
+	// Create multipart form, written by a goroutine while the request is sent
+	bodyReader, bodyWriter := io.Pipe()
+	writer := multipart.NewWriter(bodyWriter)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bodyWriter.CloseWithError(writeMediaForm(writer, msgType, fileData, fileName, mimeType, caption, sourceID))
+	}()
+	// Stop the form writer and wait for it, so a retry can rewind fileData
+	defer func() {
+		bodyReader.Close()
+		<-done
+	}()
 
 	// Create HTTP request
 	url := fmt.Sprintf("%s/api/v1/accounts/%s/conversations/%d/messages", c.baseURL, c.accountID, conversationID)
-	req, err := http.NewRequest("POST", url, body)
+	req, err := http.NewRequest("POST", url, bodyReader)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -419,11 +607,11 @@ func (c *Client) SendMediaMessage(conversationID int, msgType string, fileData [
 		Str("url", url).
 		Str("filename", fileName).
 		Str("mime_type", mimeType).
-		Int("size_bytes", len(fileData)).
 		Msg("Sending media to Chatwoot")
 
-	// Execute request
-	resp, err := c.httpClient.Do(req)
+	// Execute request with the media timeout instead of the API one
+	httpClient := &http.Client{Transport: c.httpClient.Transport, Timeout: MediaUploadTimeout}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return 0, fmt.Errorf("failed to execute request: %w", err)
 	}
@@ -447,6 +635,83 @@ func (c *Client) SendMediaMessage(conversationID int, msgType string, fileData [
 	return msgResp.ID, nil
 }
 
+// findMessageBySourceID looks for a message with sourceID among the latest
+// messages of a conversation
+func (c *Client) findMessageBySourceID(conversationID int, sourceID string) (int, bool, error) {
+	path := fmt.Sprintf("/api/v1/accounts/%s/conversations/%d/messages", c.accountID, conversationID)
+	resp, err := c.doRequest("GET", path, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	if err := c.handleError(resp); err != nil {
+		return 0, false, err
+	}
+
+	var messages struct {
+		Payload []MessageResponse `json:"payload"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&messages); err != nil {
+		return 0, false, fmt.Errorf("failed to decode messages response: %w", err)
+	}
+	for _, msg := range messages.Payload {
+		if msg.SourceID == sourceID {
+			return msg.ID, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// formQuoteEscaper escapes a filename for a Content-Disposition header, the
+// same way multipart.Writer.CreateFormFile does
+var formQuoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// writeMediaForm writes the multipart fields and file attachment for SendMediaMessage
+func writeMediaForm(writer *multipart.Writer, msgType string, fileData io.Reader, fileName, mimeType, caption, sourceID string) error {
+	// Add message_type field
+	if err := writer.WriteField("message_type", msgType); err != nil {
+		return fmt.Errorf("failed to write message_type field: %w", err)
+	}
+
+	// Add caption if provided
+	if caption != "" {
+		if err := writer.WriteField("content", caption); err != nil {
+			return fmt.Errorf("failed to write content field: %w", err)
+		}
+	}
+
+	// Add source_id if provided
+	if sourceID != "" {
+		if err := writer.WriteField("source_id", sourceID); err != nil {
+			return fmt.Errorf("failed to write source_id field: %w", err)
+		}
+	}
+
+	// Add file attachment, typed with the real mimetype so Chatwoot does not
+	// have to guess it from the filename
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="attachments[]"; filename="%s"`, formQuoteEscaper.Replace(fileName)))
+	header.Set("Content-Type", mimeType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return fmt.Errorf("failed to create form file: %w", err)
+	}
+
+	if _, err := io.Copy(part, fileData); err != nil {
+		return fmt.Errorf("failed to write file data: %w", err)
+	}
+
+	// Close the multipart writer
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to close multipart writer: %w", err)
+	}
+	return nil
+}
+
 // Helper function to check if string contains substring
 func containsString(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) &&
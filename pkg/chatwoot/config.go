@@ -22,12 +22,18 @@ type Config struct {
 	ReopenConversation    bool           `db:"reopen_conversation" json:"reopen_conversation"`
 	ConversationPending   bool           `db:"conversation_pending" json:"conversation_pending"`
 	MergeBrazilContacts   bool           `db:"merge_brazil_contacts" json:"merge_brazil_contacts"`
+	TypingPresence        bool           `db:"typing_presence" json:"typing_presence"`
+	UpdateContactNames    bool           `db:"update_contact_names" json:"update_contact_names"`
 	
 	// Customization
 	SignDelimiter         string         `db:"sign_delimiter" json:"sign_delimiter"`
 	Organization          string         `db:"organization" json:"organization"`
 	Logo                  string         `db:"logo" json:"logo"`
 	
+	// Assignment of newly created conversations
+	DefaultAssigneeID     sql.NullInt64  `db:"default_assignee_id" json:"default_assignee_id,omitempty"`
+	DefaultTeamID         sql.NullInt64  `db:"default_team_id" json:"default_team_id,omitempty"`
+	
 	// Metadata
 	CreatedAt             time.Time      `db:"created_at" json:"created_at"`
 	UpdatedAt             time.Time      `db:"updated_at" json:"updated_at"`
@@ -68,8 +74,12 @@ type CreateConfigRequest struct {
 	ReopenConversation    bool   `json:"reopen_conversation,omitempty"`
 	ConversationPending   bool   `json:"conversation_pending,omitempty"`
 	MergeBrazilContacts   bool   `json:"merge_brazil_contacts,omitempty"`
+	TypingPresence        bool   `json:"typing_presence,omitempty"`
+	UpdateContactNames    bool   `json:"update_contact_names,omitempty"`
 	Organization          string `json:"organization,omitempty"`
 	Logo                  string `json:"logo,omitempty"`
+	DefaultAssigneeID     *int64 `json:"default_assignee_id,omitempty"`
+	DefaultTeamID         *int64 `json:"default_team_id,omitempty"`
 }
 
 // ConfigResponse represents the API response for Chatwoot config
@@ -86,8 +96,12 @@ type ConfigResponse struct {
 	ReopenConversation    bool   `json:"reopen_conversation"`
 	ConversationPending   bool   `json:"conversation_pending"`
 	MergeBrazilContacts   bool   `json:"merge_brazil_contacts"`
+	TypingPresence        bool   `json:"typing_presence"`
+	UpdateContactNames    bool   `json:"update_contact_names"`
 	Organization          string `json:"organization,omitempty"`
 	Logo                  string `json:"logo,omitempty"`
+	DefaultAssigneeID     *int64 `json:"default_assignee_id,omitempty"`
+	DefaultTeamID         *int64 `json:"default_team_id,omitempty"`
 	WebhookURL            string `json:"webhook_url"`
 	CreatedAt             string `json:"created_at"`
 	UpdatedAt             string `json:"updated_at"`
@@ -0,0 +1,166 @@
+package chatwoot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// MaxImportContacts caps the number of contacts imported in one call. At one
+// contact per ContactSyncInterval a full import takes about 50 seconds,
+// within the server write timeout.
+const MaxImportContacts = 500
+
+// ImportContact is a contact to upsert into Chatwoot with its attributes
+type ImportContact struct {
+	Name             string                 `json:"name"`
+	Phone            string                 `json:"phone"`
+	CustomAttributes map[string]interface{} `json:"custom_attributes,omitempty"`
+}
+
+// Outcomes of importing a contact
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportFailed  = "failed"
+	ImportSkipped = "skipped"
+)
+
+// ImportResult is the outcome of importing one contact
+type ImportResult struct {
+	Phone     string `json:"phone"`
+	ContactID int    `json:"contact_id,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ImportSummary reports the outcome of a contact import, with one result per
+// contact in the order they were given
+type ImportSummary struct {
+	Total   int            `json:"total"`
+	Created int            `json:"created"`
+	Updated int            `json:"updated"`
+	Failed  int            `json:"failed"`
+	Skipped int            `json:"skipped,omitempty"`
+	Results []ImportResult `json:"results"`
+}
+
+// importPhone returns phone in E.164 form, or an error when it cannot be a
+// phone number
+func importPhone(phone string) (string, error) {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		if r == '+' || r == ' ' || r == '-' || r == '(' || r == ')' || r == '.' {
+			return -1
+		}
+		return 'x'
+	}, phone)
+	if strings.ContainsRune(digits, 'x') || len(digits) < 8 || len(digits) > 15 {
+		return "", fmt.Errorf("invalid phone %q", phone)
+	}
+	if normalized, ok := NormalizePhone(phone); ok {
+		return normalized, nil
+	}
+	return "+" + digits, nil
+}
+
+// ImportContacts upserts contacts into Chatwoot for userID. Contacts found by
+// phone get their name and custom attributes updated, the others are created
+// in the user's inbox. Requests are limited as in SyncContacts.
+func (s *Service) ImportContacts(ctx context.Context, userID string, contacts []ImportContact) (*ImportSummary, error) {
+	config, err := s.getConfig(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chatwoot config: %w", err)
+	}
+	if !config.Enabled {
+		return nil, fmt.Errorf("chatwoot is disabled for this user")
+	}
+	if !config.InboxID.Valid || config.InboxID.Int64 == 0 {
+		return nil, fmt.Errorf("inbox_id not configured")
+	}
+
+	return s.importContacts(ctx, NewClient(config), int(config.InboxID.Int64), contacts), nil
+}
+
+func (s *Service) importContacts(ctx context.Context, client *Client, inboxID int, contacts []ImportContact) *ImportSummary {
+	summary := &ImportSummary{Total: len(contacts), Results: make([]ImportResult, len(contacts))}
+	for i, contact := range contacts {
+		summary.Results[i] = ImportResult{Phone: contact.Phone, Status: ImportSkipped}
+	}
+
+	// Each call writes its own result, so no lock is needed
+	forEachLimited(ctx, len(contacts), func(i int) {
+		result := &summary.Results[i]
+		contactID, status, err := importContact(client, inboxID, contacts[i])
+		if err != nil {
+			log.Warn().Err(err).Str("phone", contacts[i].Phone).Msg("Failed to import contact to Chatwoot")
+			result.Status = ImportFailed
+			result.Error = err.Error()
+			return
+		}
+		result.ContactID = contactID
+		result.Status = status
+	})
+
+	for _, result := range summary.Results {
+		switch result.Status {
+		case ImportCreated:
+			summary.Created++
+		case ImportUpdated:
+			summary.Updated++
+		case ImportFailed:
+			summary.Failed++
+		default:
+			summary.Skipped++
+		}
+	}
+
+	log.Info().
+		Int("total", summary.Total).
+		Int("created", summary.Created).
+		Int("updated", summary.Updated).
+		Int("failed", summary.Failed).
+		Int("skipped", summary.Skipped).
+		Msg("Chatwoot contact import finished")
+
+	return summary
+}
+
+// importContact updates the contact with the phone of contact, creating it
+// only when Chatwoot reports there is none
+func importContact(client *Client, inboxID int, contact ImportContact) (int, string, error) {
+	phone, err := importPhone(contact.Phone)
+	if err != nil {
+		return 0, "", err
+	}
+
+	contactID, err := client.FindContactByPhone(phone)
+	if err == nil {
+		if err := client.UpdateContact(contactID, UpdateContactRequest{Name: contact.Name, CustomAttributes: contact.CustomAttributes}); err != nil {
+			return 0, "", fmt.Errorf("failed to update contact: %w", err)
+		}
+		return contactID, ImportUpdated, nil
+	}
+	// A failed search says nothing about the contact, creating it could
+	// duplicate an existing one
+	if !errors.Is(err, ErrContactNotFound) {
+		return 0, "", fmt.Errorf("failed to search contact: %w", err)
+	}
+
+	name := contact.Name
+	if name == "" {
+		name = phone
+	}
+	// The identifier matches the one given to contacts messaging the inbox
+	identifier := strings.TrimPrefix(phone, "+") + "@s.whatsapp.net"
+	contactID, err = client.CreateContactWithAttributes(inboxID, name, phone, identifier, "", contact.CustomAttributes)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to create contact: %w", err)
+	}
+	return contactID, ImportCreated, nil
+}
@@ -0,0 +1,52 @@
+package chatwoot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+// DefaultPhoneRegion is the region (ISO 3166-1 code such as BR or US) phone
+// numbers typed without a country code are read in. Empty means numbers are
+// expected to start with their country code.
+var DefaultPhoneRegion = ""
+
+// SetDefaultPhoneRegion sets DefaultPhoneRegion, checking that region is one
+// known to the phone number library
+func SetDefaultPhoneRegion(region string) error {
+	region = strings.ToUpper(strings.TrimSpace(region))
+	if region != "" && phonenumbers.GetCountryCodeForRegion(region) == 0 {
+		return fmt.Errorf("unknown phone region %q", region)
+	}
+	DefaultPhoneRegion = region
+	return nil
+}
+
+// PhoneNormalizer turns a phone number as typed, in international or
+// national form, into E.164. It returns false when it cannot read the number.
+type PhoneNormalizer func(phone string) (string, bool)
+
+// NormalizePhone is applied to phone numbers not taken from a WhatsApp JID
+// before falling back to keeping their digits. It can be replaced for
+// numbering rules the phone number library does not know.
+var NormalizePhone PhoneNormalizer = libPhoneNormalizer
+
+// libPhoneNormalizer parses phone with libphonenumber metadata. Numbers
+// starting with + are international, the others are read in
+// DefaultPhoneRegion, dropping trunk and international call prefixes.
+func libPhoneNormalizer(phone string) (string, bool) {
+	phone = strings.TrimSpace(phone)
+	region := DefaultPhoneRegion
+	if strings.HasPrefix(phone, "+") {
+		region = ""
+	} else if region == "" {
+		return "", false
+	}
+
+	number, err := phonenumbers.Parse(phone, region)
+	if err != nil || !phonenumbers.IsValidNumber(number) {
+		return "", false
+	}
+	return phonenumbers.Format(number, phonenumbers.E164), true
+}
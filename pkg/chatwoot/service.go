@@ -3,7 +3,12 @@ package chatwoot
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
 	"strings"
 	"sync"
 	"time"
@@ -11,54 +16,361 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/rs/zerolog/log"
 	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
+	"golang.org/x/sync/singleflight"
 )
 
-// conversationCreationMutex serializes conversation creation to prevent race conditions
-var conversationCreationMutex sync.Mutex
+// MaxMediaSize is the largest WhatsApp media (in bytes) forwarded to Chatwoot.
+// Bigger files are replaced by a text placeholder. Zero disables the limit.
+var MaxMediaSize int64 = 40 * 1024 * 1024
+
+// MediaDownloadTimeout bounds each media download from WhatsApp so a stalled
+// transfer cannot block the message pipeline. Zero disables the deadline.
+var MediaDownloadTimeout = 2 * time.Minute
+
+// ErrMediaDownloadTimeout is returned when a media download misses its deadline
+var ErrMediaDownloadTimeout = errors.New("media download timed out")
+
+// Dedupe entries live in memory for DedupeCacheTTL and in the database for
+// dedupePersistTTL, so redeliveries after a restart are still detected
+const dedupePersistTTL = 24 * time.Hour
+
+// DedupeCacheTTL is how long a message id is remembered in memory. A shorter
+// window saves memory, a longer one catches slower redeliveries.
+var DedupeCacheTTL = 30 * time.Minute
+
+// DedupeCleanupInterval is how often expired dedupe entries are purged
+var DedupeCleanupInterval = 10 * time.Minute
+
+// mediaDownloader is the part of the WhatsApp client used to fetch media
+type mediaDownloader interface {
+	DownloadToFile(ctx context.Context, msg whatsmeow.DownloadableMessage, file whatsmeow.File) error
+}
 
 // Service manages the business logic between WhatsApp and Chatwoot
 type Service struct {
 	db                *sqlx.DB
-	dedupeCache       sync.Map // map[messageID]timestamp - prevents processing same message twice
-	conversationCache sync.Map // map[cacheKey]conversationID - avoids DB lookups
+	dedupeCache       sync.Map           // map[messageID]timestamp - prevents processing same message twice
+	conversationCache sync.Map           // map[cacheKey]conversationID - avoids DB lookups
+	conversationGroup singleflight.Group // one conversation creation per cacheKey at a time
+	avatarCache       sync.Map           // map[userID:jid]avatarState - last WhatsApp avatar seen per contact
+	cancel            context.CancelFunc
+	done              chan struct{}
+	closeOnce         sync.Once
 }
 
 // NewService creates a new Chatwoot service instance
 func NewService(db *sqlx.DB) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
 	service := &Service{
 		db:                db,
 		dedupeCache:       sync.Map{},
 		conversationCache: sync.Map{},
+		cancel:            cancel,
+		done:              make(chan struct{}),
 	}
 
 	// Start background cleanup goroutine for dedupe cache
-	go service.cleanupDedupeCache()
+	go service.cleanupDedupeCache(ctx)
 
 	return service
 }
 
-// cleanupDedupeCache removes old entries from the dedupe cache every 10 minutes
-func (s *Service) cleanupDedupeCache() {
-	ticker := time.NewTicker(10 * time.Minute)
+// Close stops the background cleanup goroutine and waits for it to exit.
+// It is safe to call more than once.
+func (s *Service) Close() {
+	s.closeOnce.Do(func() {
+		if s.cancel == nil {
+			return
+		}
+		s.cancel()
+		<-s.done
+	})
+}
+
+// cleanupDedupeCache removes old entries from the dedupe cache every
+// DedupeCleanupInterval until ctx is cancelled
+func (s *Service) cleanupDedupeCache(ctx context.Context) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(DedupeCleanupInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		now := time.Now()
-		s.dedupeCache.Range(func(key, value interface{}) bool {
-			if timestamp, ok := value.(time.Time); ok {
-				// Remove entries older than 30 minutes
-				if now.Sub(timestamp) > 30*time.Minute {
-					s.dedupeCache.Delete(key)
-					log.Debug().Str("message_id", key.(string)).Msg("Removed old message from dedupe cache")
-				}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.purgeDedupeCache(time.Now(), DedupeCacheTTL)
+
+		if _, err := s.CleanupExpiredDedupe(); err != nil {
+			log.Warn().Err(err).Msg("Failed to clean up expired Chatwoot dedupe rows")
+		}
+	}
+}
+
+// purgeDedupeCache removes the in-memory entries older than ttl at now
+func (s *Service) purgeDedupeCache(now time.Time, ttl time.Duration) {
+	s.dedupeCache.Range(func(key, value interface{}) bool {
+		if timestamp, ok := value.(time.Time); ok {
+			if now.Sub(timestamp) > ttl {
+				s.dedupeCache.Delete(key)
+				log.Debug().Str("message_id", key.(string)).Msg("Removed old message from dedupe cache")
+			}
+		}
+		return true
+	})
+}
+
+// CleanupExpiredDedupe deletes persisted dedupe rows whose expiry has passed
+func (s *Service) CleanupExpiredDedupe() (int64, error) {
+	query := `DELETE FROM chatwoot_dedupe WHERE expires_at < $1`
+	if s.db.DriverName() == "sqlite" {
+		query = strings.Replace(query, "$1", "?", 1)
+	}
+
+	result, err := s.db.Exec(query, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// CachePurgeResult counts the cached mappings older than a cutoff, removed
+// unless DryRun is set
+type CachePurgeResult struct {
+	Conversations int64 `json:"conversations"`
+	Messages      int64 `json:"messages"`
+	DryRun        bool  `json:"dry_run"`
+}
+
+// PurgeCache deletes the conversation and message mappings last updated
+// before cutoff, for userID or for every user when userID is empty. A purged
+// chat is looked up (or created) again in Chatwoot on its next message.
+func (s *Service) PurgeCache(cutoff time.Time, userID string, dryRun bool) (*CachePurgeResult, error) {
+	// SQLite stores CURRENT_TIMESTAMP as UTC text, compared as such
+	var before interface{} = cutoff.UTC()
+	if s.db.DriverName() == "sqlite" {
+		before = cutoff.UTC().Format("2006-01-02 15:04:05")
+	}
+
+	where := ` WHERE COALESCE(updated_at, created_at) < $1`
+	messagesWhere := ` WHERE created_at < $1`
+	args := []interface{}{before}
+	if userID != "" {
+		where += ` AND user_id = $2`
+		messagesWhere += ` AND user_id = $2`
+		args = append(args, userID)
+	}
+	rebind := func(q string) string {
+		if s.db.DriverName() == "sqlite" {
+			q = strings.NewReplacer("$1", "?", "$2", "?").Replace(q)
+		}
+		return q
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var conversations []struct {
+		UserID  string `db:"user_id"`
+		ChatJID string `db:"chat_jid"`
+	}
+	if err := tx.Select(&conversations, rebind(`SELECT user_id, chat_jid FROM chatwoot_conversations`+where), args...); err != nil {
+		return nil, fmt.Errorf("failed to list cached conversations: %w", err)
+	}
+	result := &CachePurgeResult{Conversations: int64(len(conversations)), DryRun: dryRun}
+
+	if dryRun {
+		if err := tx.Get(&result.Messages, rebind(`SELECT COUNT(*) FROM chatwoot_messages`+messagesWhere), args...); err != nil {
+			return nil, fmt.Errorf("failed to count cached messages: %w", err)
+		}
+		return result, nil
+	}
+
+	if _, err := tx.Exec(rebind(`DELETE FROM chatwoot_conversations`+where), args...); err != nil {
+		return nil, fmt.Errorf("failed to purge cached conversations: %w", err)
+	}
+	res, err := tx.Exec(rebind(`DELETE FROM chatwoot_messages`+messagesWhere), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge cached messages: %w", err)
+	}
+	if result.Messages, err = res.RowsAffected(); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, conv := range conversations {
+		s.conversationCache.Delete(fmt.Sprintf("%s:%s", conv.UserID, conv.ChatJID))
+	}
+	return result, nil
+}
+
+// isPersistedDuplicate records messageID in the dedupe table, reporting
+// whether an unexpired row was already there. The check and the insert are a
+// single statement, so of two concurrent deliveries only one gets through.
+// Database errors are logged and treated as not duplicate so a failing table
+// never blocks forwarding.
+func (s *Service) isPersistedDuplicate(userID, messageID string) bool {
+	now := time.Now()
+
+	// An existing row is only taken over once it has expired
+	query := `INSERT INTO chatwoot_dedupe (user_id, message_id, expires_at)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (user_id, message_id)
+        DO UPDATE SET expires_at = excluded.expires_at
+        WHERE chatwoot_dedupe.expires_at <= $4`
+	if s.db.DriverName() == "sqlite" {
+		query = strings.NewReplacer("$1", "?", "$2", "?", "$3", "?", "$4", "?").Replace(query)
+	}
+
+	result, err := s.db.Exec(query, userID, messageID, now.Add(dedupePersistTTL).Unix(), now.Unix())
+	if err != nil {
+		log.Warn().Err(err).Str("message_id", messageID).Msg("Failed to record message in Chatwoot dedupe table")
+		return false
+	}
+	recorded, err := result.RowsAffected()
+	if err != nil {
+		log.Warn().Err(err).Str("message_id", messageID).Msg("Failed to check Chatwoot dedupe table")
+		return false
+	}
+	return recorded == 0
+}
+
+// botContactIdentifier is the Chatwoot identifier of the bot contact created
+// alongside the inbox
+const botContactIdentifier = "123456"
+
+// InboxPlan describes what InitializeInbox would create in Chatwoot
+type InboxPlan struct {
+	InboxName       string `json:"inbox_name"`
+	WebhookURL      string `json:"webhook_url"`
+	BotName         string `json:"bot_name"`
+	BotIdentifier   string `json:"bot_identifier"`
+	BotAvatarURL    string `json:"bot_avatar_url"`
+	ExistingInboxID int    `json:"existing_inbox_id,omitempty"`
+}
+
+// botContactDefaults returns the bot contact name and avatar for config
+func botContactDefaults(config *Config) (string, string) {
+	organization := config.Organization
+	if organization == "" {
+		organization = "Wuzapi"
+	}
+
+	logo := config.Logo
+	if logo == "" {
+		logo = "https://avatars.githubusercontent.com/u/70125501"
+	}
+
+	return organization, logo
+}
+
+// PlanInbox checks connectivity with Chatwoot and reports what InitializeInbox
+// would create, without changing anything in Chatwoot
+func (s *Service) PlanInbox(config *Config, webhookURL string) (*InboxPlan, error) {
+	client := NewClient(config)
+
+	inboxes, err := client.ListInboxes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Chatwoot: %w", err)
+	}
+
+	organization, logo := botContactDefaults(config)
+	plan := &InboxPlan{
+		InboxName:     config.NameInbox,
+		WebhookURL:    webhookURL,
+		BotName:       organization,
+		BotIdentifier: botContactIdentifier,
+		BotAvatarURL:  logo,
+	}
+
+	// A same-named inbox is reported since creating another is likely a mistake
+	for _, inbox := range inboxes {
+		if inbox.Name == config.NameInbox {
+			plan.ExistingInboxID = inbox.ID
+			break
+		}
+	}
+
+	return plan, nil
+}
+
+// InboxCreateAttempts is how many times InitializeInbox tries to create the
+// inbox before giving up on transient failures
+var InboxCreateAttempts = 3
+
+// InboxCreateBackoff is the wait before the first inbox creation retry,
+// doubled after each further attempt
+var InboxCreateBackoff = 2 * time.Second
+
+// findInboxByName returns the inbox named name, nil when there is none
+func findInboxByName(client *Client, name string) (*InboxResponse, error) {
+	inboxes, err := client.ListInboxes()
+	if err != nil {
+		return nil, err
+	}
+	for _, inbox := range inboxes {
+		if inbox.Name == name {
+			return &inbox, nil
+		}
+	}
+	return nil, nil
+}
+
+// createInbox creates the inbox named after config, reusing an inbox with the
+// same name. The name is looked up again before each retry, so an inbox
+// Chatwoot created before the request failed is not created twice. The
+// boolean reports whether the inbox was created.
+func createInbox(client *Client, config *Config, webhookURL string) (int, bool, error) {
+	backoff := InboxCreateBackoff
+	for attempt := 1; ; attempt++ {
+		existing, err := findInboxByName(client, config.NameInbox)
+		if err == nil && existing != nil {
+			log.Info().Int("inbox_id", existing.ID).Str("name", config.NameInbox).Msg("Reusing existing Chatwoot inbox")
+			if existing.WebhookURL != "" && existing.WebhookURL != webhookURL {
+				log.Warn().
+					Int("inbox_id", existing.ID).
+					Str("webhook_url", existing.WebhookURL).
+					Str("expected_webhook_url", webhookURL).
+					Msg("Existing Chatwoot inbox has another webhook URL, agent replies will not reach wuzapi until it is updated")
+			}
+			return existing.ID, false, nil
+		}
+		if err == nil {
+			var inboxID int
+			inboxID, err = client.CreateInbox(config.NameInbox, webhookURL)
+			if err == nil {
+				return inboxID, true, nil
 			}
-			return true
-		})
+		}
+		if attempt >= InboxCreateAttempts || !isRetryable(err) {
+			return 0, false, err
+		}
+
+		log.Warn().
+			Err(err).
+			Int("attempt", attempt).
+			Str("name", config.NameInbox).
+			Dur("backoff", backoff).
+			Msg("Chatwoot inbox creation failed, retrying")
+		time.Sleep(backoff)
+		backoff *= 2
 	}
 }
 
-// InitializeInbox creates a new inbox in Chatwoot and sets up the bot contact
+// InitializeInbox creates a new inbox in Chatwoot and sets up the bot contact.
+// An inbox with the configured name is reused, and transient failures are
+// retried up to InboxCreateAttempts times.
 func (s *Service) InitializeInbox(config *Config, webhookURL string) (int, error) {
 	client := NewClient(config)
 
@@ -68,31 +380,27 @@ func (s *Service) InitializeInbox(config *Config, webhookURL string) (int, error
 		Str("webhook_url", webhookURL).
 		Msg("Creating Chatwoot inbox")
 
-	inboxID, err := client.CreateInbox(config.NameInbox, webhookURL)
+	inboxID, created, err := createInbox(client, config, webhookURL)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create inbox: %w", err)
 	}
+	if !created {
+		// The bot contact was set up with the inbox
+		return inboxID, nil
+	}
 
 	log.Info().Int("inbox_id", inboxID).Msg("Chatwoot inbox created successfully")
 
 	// 2. Create bot contact (identifier: 123456)
-	organization := config.Organization
-	if organization == "" {
-		organization = "Wuzapi"
-	}
-
-	logo := config.Logo
-	if logo == "" {
-		logo = "https://avatars.githubusercontent.com/u/70125501"
-	}
+	organization, logo := botContactDefaults(config)
 
 	log.Info().Msg("Creating bot contact in Chatwoot")
 
 	botContactID, err := client.CreateContact(
 		inboxID,
 		organization,
-		"",       // no phone for bot contact
-		"123456", // identifier
+		"", // no phone for bot contact
+		botContactIdentifier,
 		logo,
 	)
 	if err != nil {
@@ -107,11 +415,35 @@ func (s *Service) InitializeInbox(config *Config, webhookURL string) (int, error
 
 // HandleIncomingMessage processes an incoming WhatsApp message and forwards it to Chatwoot
 func (s *Service) HandleIncomingMessage(userID string, evt *events.Message, waClient *whatsmeow.Client) error {
-	// 1. Deduplication check
-	if _, loaded := s.dedupeCache.LoadOrStore(evt.Info.ID, time.Now()); loaded {
+	return s.handleIncomingMessage(userID, evt, waClient, false)
+}
+
+// HandlePlaceholderMessage forwards evt in place of a message that could not
+// be decrypted yet. The id of the message is not recorded as forwarded, so
+// the message itself still gets through once it decrypts.
+func (s *Service) HandlePlaceholderMessage(userID string, evt *events.Message, waClient *whatsmeow.Client) error {
+	return s.handleIncomingMessage(userID, evt, waClient, true)
+}
+
+// placeholderKey is the dedupe cache key of the placeholder of messageID
+func placeholderKey(messageID string) string {
+	return "placeholder:" + messageID
+}
+
+func (s *Service) handleIncomingMessage(userID string, evt *events.Message, waClient *whatsmeow.Client, placeholder bool) error {
+	// 1. Deduplication check, placeholders being tracked apart from messages
+	dedupeKey := evt.Info.ID
+	if placeholder {
+		dedupeKey = placeholderKey(evt.Info.ID)
+	}
+	if _, loaded := s.dedupeCache.LoadOrStore(dedupeKey, time.Now()); loaded {
 		log.Debug().Str("message_id", evt.Info.ID).Msg("Message already processed, skipping")
 		return nil
 	}
+	if !placeholder {
+		// The message decrypted, however it arrived, so its placeholder is done
+		s.dedupeCache.Delete(placeholderKey(evt.Info.ID))
+	}
 
 	// 2. Noise filters - skip protocol messages, reactions, polls, etc.
 	if s.shouldSkipMessage(evt) {
@@ -137,6 +469,12 @@ func (s *Service) HandleIncomingMessage(userID string, evt *events.Message, waCl
 		return nil
 	}
 
+	// Persistent deduplication, survives restarts and redeliveries
+	if !placeholder && s.isPersistedDuplicate(userID, evt.Info.ID) {
+		log.Debug().Str("message_id", evt.Info.ID).Msg("Message already forwarded before restart, skipping")
+		return nil
+	}
+
 	// 4. Initialize Chatwoot client
 	client := NewClient(config)
 
@@ -158,9 +496,10 @@ func (s *Service) HandleIncomingMessage(userID string, evt *events.Message, waCl
 			contactJID = evt.Info.Chat.String()
 			contactName = "" // Will be extracted from group info
 		} else {
-			// For 1-on-1, contact is who we sent TO (the Chat)
+			// For 1-on-1, contact is who we sent TO (the Chat). The push name
+			// is our own, so the contact is named after its phone number.
 			contactJID = evt.Info.Chat.String()
-			contactName = evt.Info.PushName
+			contactName = ""
 		}
 	} else {
 		// Message received -> Contact is the sender
@@ -195,7 +534,11 @@ func (s *Service) HandleIncomingMessage(userID string, evt *events.Message, waCl
 	phoneNumber := formatToE164(contactJID)
 
 	// 7. Ensure contact exists in Chatwoot
-	contactID, err := s.ensureContact(client, config, phoneNumber, contactName, contactJID)
+	var avatars avatarFetcher
+	if waClient != nil {
+		avatars = waClient
+	}
+	contactID, _, err := s.ensureContact(client, config, avatars, phoneNumber, contactName, contactJID)
 	if err != nil {
 		return fmt.Errorf("failed to ensure contact: %w", err)
 	}
@@ -257,13 +600,49 @@ func (s *Service) shouldSkipMessage(evt *events.Message) bool {
 		evt.Message.GetDocumentMessage() != nil ||
 		evt.Message.GetStickerMessage() != nil
 
-	if !hasText && !hasMedia {
+	hasEvent := evt.Message.GetEventMessage() != nil
+
+	if !hasText && !hasMedia && !hasEvent {
 		return true
 	}
 
 	return false
 }
 
+// eventTimeLayout formats the times of event messages forwarded to Chatwoot
+const eventTimeLayout = "Mon, 02 Jan 2006 15:04 MST"
+
+// eventMessageText renders a WhatsApp event (calendar) message as text
+func eventMessageText(ev *waE2E.EventMessage) string {
+	lines := []string{"📅 " + ev.GetName()}
+	if ev.GetIsCanceled() {
+		lines[0] += " (canceled)"
+	}
+	if start := ev.GetStartTime(); start > 0 {
+		when := time.Unix(start, 0).Format(eventTimeLayout)
+		if end := ev.GetEndTime(); end > 0 {
+			when += " - " + time.Unix(end, 0).Format(eventTimeLayout)
+		}
+		lines = append(lines, "When: "+when)
+	}
+	if loc := ev.GetLocation(); loc != nil {
+		where := strings.TrimSpace(strings.Join([]string{loc.GetName(), loc.GetAddress()}, " "))
+		if where == "" && (loc.GetDegreesLatitude() != 0 || loc.GetDegreesLongitude() != 0) {
+			where = fmt.Sprintf("%f, %f", loc.GetDegreesLatitude(), loc.GetDegreesLongitude())
+		}
+		if where != "" {
+			lines = append(lines, "Where: "+where)
+		}
+	}
+	if link := ev.GetJoinLink(); link != "" {
+		lines = append(lines, "Call: "+link)
+	}
+	if description := ev.GetDescription(); description != "" {
+		lines = append(lines, "", description)
+	}
+	return strings.Join(lines, "\n")
+}
+
 // getConfig retrieves the Chatwoot configuration for a user
 func (s *Service) getConfig(userID string) (*Config, error) {
 	var config Config
@@ -280,13 +659,33 @@ func (s *Service) getConfig(userID string) (*Config, error) {
 	return &config, nil
 }
 
-// ensureContact ensures a contact exists in Chatwoot, creates if not found
-func (s *Service) ensureContact(client *Client, config *Config, phoneNumber, name, identifier string) (int, error) {
+// ensureContact ensures a contact exists in Chatwoot, creates if not found.
+// The boolean reports whether the contact was created. With
+// update_contact_names, a found contact whose name differs from the current
+// WhatsApp name is renamed, overwriting names edited by agents, and its
+// WhatsApp custom attributes are brought up to date. When avatars
+// is set, the WhatsApp profile picture is used as the contact avatar and
+// refreshed as described in contactAvatar.
+func (s *Service) ensureContact(client *Client, config *Config, avatars avatarFetcher, phoneNumber, name, identifier string) (int, bool, error) {
 	// Try to find existing contact
-	contactID, err := client.FindContactByPhone(phoneNumber)
+	contact, err := client.SearchContactByPhone(phoneNumber)
 	if err == nil {
-		log.Debug().Int("contact_id", contactID).Str("phone", phoneNumber).Msg("Contact found in Chatwoot")
-		return contactID, nil
+		log.Debug().Int("contact_id", contact.ID).Str("phone", phoneNumber).Msg("Contact found in Chatwoot")
+		var update UpdateContactRequest
+		if config.UpdateContactNames {
+			if name != "" && name != contact.Name && !isPhoneName(name, phoneNumber) {
+				update.Name = name
+			}
+			update.CustomAttributes = changedAttributes(contact.CustomAttributes, contactAttributes(identifier, name, phoneNumber))
+		}
+		update.AvatarURL = s.contactAvatar(avatars, config.UserID, identifier, contact)
+		if update.Name != "" || update.AvatarURL != "" || update.CustomAttributes != nil {
+			// A failed update keeps the old details, the message still goes through
+			if err := client.UpdateContact(contact.ID, update); err != nil {
+				log.Warn().Err(err).Int("contact_id", contact.ID).Msg("Failed to update Chatwoot contact")
+			}
+		}
+		return contact.ID, false, nil
 	}
 
 	// Contact not found, create new one
@@ -294,15 +693,94 @@ func (s *Service) ensureContact(client *Client, config *Config, phoneNumber, nam
 
 	inboxID := int(config.InboxID.Int64)
 	if inboxID == 0 {
-		return 0, fmt.Errorf("inbox_id not configured")
+		return 0, false, fmt.Errorf("inbox_id not configured")
 	}
 
-	contactID, err = client.CreateContact(inboxID, name, phoneNumber, identifier, "")
+	attributes := contactAttributes(identifier, name, phoneNumber)
+	avatarURL := s.contactAvatar(avatars, config.UserID, identifier, nil)
+	contactID, err := client.CreateContactWithAttributes(inboxID, name, phoneNumber, identifier, avatarURL, attributes)
 	if err != nil {
-		return 0, fmt.Errorf("failed to create contact: %w", err)
+		return 0, false, fmt.Errorf("failed to create contact: %w", err)
+	}
+
+	return contactID, true, nil
+}
+
+// isPhoneName reports whether name is only the phone number, as used when a
+// contact has no WhatsApp name
+func isPhoneName(name, phoneNumber string) bool {
+	return formatToE164(name) == phoneNumber
+}
+
+// contactAttributes returns the WhatsApp details stored as custom attributes
+// of a Chatwoot contact: its JID (or LID) without device and its push name,
+// unless the name is only the phone number fallback
+func contactAttributes(identifier, name, phoneNumber string) map[string]interface{} {
+	attributes := make(map[string]interface{})
+	if jid, err := types.ParseJID(identifier); err == nil && jid.User != "" {
+		jid = jid.ToNonAD()
+		if jid.Server == types.HiddenUserServer {
+			attributes["whatsapp_lid"] = jid.String()
+		} else {
+			attributes["whatsapp_jid"] = jid.String()
+		}
+	}
+	if name != "" && !isPhoneName(name, phoneNumber) {
+		attributes["whatsapp_push_name"] = name
+	}
+	if len(attributes) == 0 {
+		return nil
+	}
+	return attributes
+}
+
+// changedAttributes returns the custom attributes to update a contact with:
+// current with attributes applied, so attributes set by agents are kept, or
+// nil when current already holds every attribute
+func changedAttributes(current, attributes map[string]interface{}) map[string]interface{} {
+	changed := false
+	for key, value := range attributes {
+		if current[key] != value {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	merged := make(map[string]interface{}, len(current)+len(attributes))
+	for key, value := range current {
+		merged[key] = value
+	}
+	for key, value := range attributes {
+		merged[key] = value
+	}
+	return merged
+}
+
+// assignNewConversation applies the configured default team and assignee to a
+// newly created conversation. Failures are logged and otherwise ignored.
+func (s *Service) assignNewConversation(client *Client, config *Config, conversationID int) {
+	if config.DefaultTeamID.Valid && config.DefaultTeamID.Int64 > 0 {
+		if err := client.AssignConversation(conversationID, 0, int(config.DefaultTeamID.Int64)); err != nil {
+			log.Warn().
+				Err(err).
+				Int("conversation_id", conversationID).
+				Int64("team_id", config.DefaultTeamID.Int64).
+				Msg("Failed to assign Chatwoot conversation to default team")
+		}
 	}
 
-	return contactID, nil
+	if config.DefaultAssigneeID.Valid && config.DefaultAssigneeID.Int64 > 0 {
+		if err := client.AssignConversation(conversationID, int(config.DefaultAssigneeID.Int64), 0); err != nil {
+			log.Warn().
+				Err(err).
+				Int("conversation_id", conversationID).
+				Int64("assignee_id", config.DefaultAssigneeID.Int64).
+				Msg("Failed to assign Chatwoot conversation to default agent")
+		}
+	}
 }
 
 // ensureConversation ensures a conversation exists, uses cache for performance
@@ -353,24 +831,36 @@ func (s *Service) ensureConversation(userID string, client *Client, config *Conf
 		return 0, fmt.Errorf("database error: %w", err)
 	}
 
-	// Conversation not found, create new one
-	inboxID := int(config.InboxID.Int64)
-	sourceID := fmt.Sprintf("wa:%s", chatJID)
-
-	// Lock to prevent race condition when multiple messages arrive simultaneously
-	conversationCreationMutex.Lock()
-	defer conversationCreationMutex.Unlock()
-
-	// Double-check cache after acquiring lock (another goroutine may have created it)
-	if cached, ok := s.conversationCache.Load(cacheKey); ok {
-		if convID, ok := cached.(int); ok {
-			log.Info().
-				Int("conversation_id", convID).
-				Str("cache_key", cacheKey).
-				Msg("✓ Conversation found in cache after lock (created by another goroutine)")
-			return convID, nil
+	// Concurrent first messages of the same chat share a single creation,
+	// while different chats are created in parallel
+	result, err, shared := s.conversationGroup.Do(cacheKey, func() (interface{}, error) {
+		// Double-check cache (a previous creation may have just finished)
+		if cached, ok := s.conversationCache.Load(cacheKey); ok {
+			if convID, ok := cached.(int); ok {
+				log.Info().
+					Int("conversation_id", convID).
+					Str("cache_key", cacheKey).
+					Msg("✓ Conversation found in cache after lock (created by another goroutine)")
+				return convID, nil
+			}
 		}
+		return s.createConversation(userID, client, config, contactID, chatJID)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if shared {
+		log.Debug().Str("cache_key", cacheKey).Msg("Conversation creation shared with a concurrent message")
 	}
+	return result.(int), nil
+}
+
+// createConversation creates the conversation of chatJID in Chatwoot and
+// caches it in the database and in memory
+func (s *Service) createConversation(userID string, client *Client, config *Config, contactID int, chatJID string) (int, error) {
+	cacheKey := fmt.Sprintf("%s:%s", userID, chatJID)
+	inboxID := int(config.InboxID.Int64)
+	sourceID := fmt.Sprintf("wa:%s", chatJID)
 
 	log.Warn().
 		Str("user_id", userID).
@@ -431,6 +921,9 @@ func (s *Service) ensureConversation(userID string, client *Client, config *Conf
 		Str("cache_key", cacheKey).
 		Msg("✓ Conversation stored in memory cache")
 
+	// Assign after the conversation is cached so a failure never loses it
+	s.assignNewConversation(client, config, conversationID)
+
 	return conversationID, nil
 }
 
@@ -483,6 +976,58 @@ func (s *Service) StoreConversationFromWebhook(userID, chatJID string, conversat
 	return nil
 }
 
+// PostPrivateNote adds content as a private note to the Chatwoot conversation
+// of chatJID. Nothing is posted when Chatwoot is disabled for the user or the
+// chat has no conversation yet.
+func (s *Service) PostPrivateNote(userID, chatJID, content string) error {
+	config, err := s.getConfig(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("failed to load chatwoot config: %w", err)
+	}
+	if !config.Enabled {
+		return nil
+	}
+
+	conversationID, found, err := s.findConversation(userID, chatJID)
+	if err != nil || !found {
+		return err
+	}
+
+	_, err = NewClient(config).CreateMessage(conversationID, "outgoing", content, true, "")
+	return err
+}
+
+// findConversation looks up the known Chatwoot conversation of chatJID
+// without creating one
+func (s *Service) findConversation(userID, chatJID string) (int, bool, error) {
+	cacheKey := fmt.Sprintf("%s:%s", userID, chatJID)
+	if cached, ok := s.conversationCache.Load(cacheKey); ok {
+		if convID, ok := cached.(int); ok {
+			return convID, true, nil
+		}
+	}
+
+	var conv ConversationCache
+	query := `SELECT * FROM chatwoot_conversations WHERE user_id = $1 AND chat_jid = $2`
+	if s.db.DriverName() == "sqlite" {
+		query = strings.Replace(query, "$1", "?", 1)
+		query = strings.Replace(query, "$2", "?", 1)
+	}
+	err := s.db.Get(&conv, query, userID, chatJID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("database error: %w", err)
+	}
+
+	s.conversationCache.Store(cacheKey, int(conv.ChatwootConversationID))
+	return int(conv.ChatwootConversationID), true, nil
+}
+
 // sendMessageToChatwoot extracts message content and sends it to Chatwoot
 func (s *Service) sendMessageToChatwoot(client *Client, waClient *whatsmeow.Client, evt *events.Message, conversationID int, msgType string) error {
 	sourceID := fmt.Sprintf("WAID:%s", evt.Info.ID)
@@ -492,6 +1037,9 @@ func (s *Service) sendMessageToChatwoot(client *Client, waClient *whatsmeow.Clie
 	if textContent == "" && evt.Message.GetExtendedTextMessage() != nil {
 		textContent = evt.Message.GetExtendedTextMessage().GetText()
 	}
+	if textContent == "" && evt.Message.GetEventMessage() != nil {
+		textContent = eventMessageText(evt.Message.GetEventMessage())
+	}
 
 	// Check for media
 	if img := evt.Message.GetImageMessage(); img != nil {
@@ -520,53 +1068,83 @@ func (s *Service) sendMessageToChatwoot(client *Client, waClient *whatsmeow.Clie
 }
 
 // sendMediaMessage downloads media from WhatsApp and sends to Chatwoot
-func (s *Service) sendMediaMessage(client *Client, waClient *whatsmeow.Client, evt *events.Message, conversationID int, msgType, sourceID, mimeType, caption, mediaType string) error {
+func (s *Service) sendMediaMessage(client *Client, waClient mediaDownloader, evt *events.Message, conversationID int, msgType, sourceID, mimeType, caption, mediaType string) error {
 	var downloadable whatsmeow.DownloadableMessage
-	var fileName string
+	var originalName string
 
 	switch mediaType {
 	case "image":
 		downloadable = evt.Message.GetImageMessage()
-		fileName = fmt.Sprintf("%s.jpg", evt.Info.ID)
-		if mimeType == "image/png" {
-			fileName = fmt.Sprintf("%s.png", evt.Info.ID)
-		}
 	case "video":
 		downloadable = evt.Message.GetVideoMessage()
-		fileName = fmt.Sprintf("%s.mp4", evt.Info.ID)
 	case "audio":
 		downloadable = evt.Message.GetAudioMessage()
-		fileName = fmt.Sprintf("%s.ogg", evt.Info.ID)
-		if mimeType == "audio/mpeg" {
-			fileName = fmt.Sprintf("%s.mp3", evt.Info.ID)
-		}
 	case "document":
 		doc := evt.Message.GetDocumentMessage()
 		downloadable = doc
-		fileName = doc.GetFileName()
-		if fileName == "" {
-			fileName = fmt.Sprintf("%s.pdf", evt.Info.ID)
+		originalName = doc.GetFileName()
+		if originalName == "" {
+			originalName = doc.GetTitle()
 		}
 	default:
 		return fmt.Errorf("unsupported media type: %s", mediaType)
 	}
 
-	// Download media from WhatsApp
+	mimeType = mediaMimeType(mediaType, mimeType)
+	fileName := mediaFileName(evt.Info.ID, mediaType, mimeType, originalName)
+
+	// Skip the download entirely when WhatsApp already reports an oversized file
+	if sized, ok := downloadable.(interface{ GetFileLength() uint64 }); ok && mediaTooLarge(int64(sized.GetFileLength())) {
+		return s.sendOversizedMediaPlaceholder(client, conversationID, msgType, sourceID, mediaType, fileName, caption, int64(sized.GetFileLength()))
+	}
+
+	// Download media from WhatsApp into a temp file to keep it out of memory
 	log.Debug().Str("media_type", mediaType).Str("filename", fileName).Msg("Downloading media from WhatsApp")
 
-	data, err := waClient.Download(context.Background(), downloadable)
+	file, err := os.CreateTemp("", "chatwoot-media-*")
 	if err != nil {
+		return fmt.Errorf("failed to create temp file for media: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := downloadMedia(waClient, downloadable, file); err != nil {
+		if errors.Is(err, ErrMediaDownloadTimeout) {
+			log.Warn().Err(err).Str("media_type", mediaType).Str("filename", fileName).Msg("⚠ Media download timed out, sending placeholder")
+			content := fmt.Sprintf("[%s %s not forwarded: download timed out]", mediaType, fileName)
+			if caption != "" {
+				content = caption + "\n\n" + content
+			}
+			if _, err := client.CreateMessage(conversationID, msgType, content, false, sourceID); err != nil {
+				return fmt.Errorf("failed to send media placeholder to chatwoot: %w", err)
+			}
+			return nil
+		}
 		return fmt.Errorf("failed to download media: %w", err)
 	}
 
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat downloaded media: %w", err)
+	}
+
+	// The declared length may be missing or wrong, check the actual size too
+	if mediaTooLarge(info.Size()) {
+		return s.sendOversizedMediaPlaceholder(client, conversationID, msgType, sourceID, mediaType, fileName, caption, info.Size())
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind downloaded media: %w", err)
+	}
+
 	log.Info().
 		Str("media_type", mediaType).
-		Int("size_bytes", len(data)).
+		Int64("size_bytes", info.Size()).
 		Str("filename", fileName).
 		Msg("Media downloaded, sending to Chatwoot")
 
 	// Send to Chatwoot
-	_, err = client.SendMediaMessage(conversationID, msgType, data, fileName, mimeType, caption, sourceID)
+	_, err = client.SendMediaMessage(conversationID, msgType, file, fileName, mimeType, caption, sourceID)
 	if err != nil {
 		return fmt.Errorf("failed to send media to chatwoot: %w", err)
 	}
@@ -574,12 +1152,132 @@ func (s *Service) sendMediaMessage(client *Client, waClient *whatsmeow.Client, e
 	return nil
 }
 
+// defaultMediaMimeTypes are used when WhatsApp does not report a mimetype
+var defaultMediaMimeTypes = map[string]string{
+	"image":    "image/jpeg",
+	"video":    "video/mp4",
+	"audio":    "audio/ogg",
+	"document": "application/octet-stream",
+}
+
+// mediaExtensions maps common WhatsApp mimetypes to the extension used in
+// Chatwoot filenames. Other types fall back to the system mime table.
+var mediaExtensions = map[string]string{
+	"image/jpeg":               ".jpg",
+	"image/png":                ".png",
+	"image/webp":               ".webp",
+	"image/gif":                ".gif",
+	"video/mp4":                ".mp4",
+	"video/3gpp":               ".3gp",
+	"video/quicktime":          ".mov",
+	"audio/ogg":                ".ogg",
+	"audio/mpeg":               ".mp3",
+	"audio/mp4":                ".m4a",
+	"audio/aac":                ".aac",
+	"audio/amr":                ".amr",
+	"application/pdf":          ".pdf",
+	"application/zip":          ".zip",
+	"application/octet-stream": ".bin",
+}
+
+// mediaMimeType returns the mimetype reported by WhatsApp, or the usual one
+// for mediaType when it is missing
+func mediaMimeType(mediaType, mimeType string) string {
+	if mimeType = strings.TrimSpace(mimeType); mimeType != "" {
+		return mimeType
+	}
+	if fallback, ok := defaultMediaMimeTypes[mediaType]; ok {
+		return fallback
+	}
+	return "application/octet-stream"
+}
+
+// mediaExtension returns the file extension for mimeType, ignoring
+// parameters such as "; codecs=opus"
+func mediaExtension(mimeType string) string {
+	base, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		base = strings.TrimSpace(strings.Split(mimeType, ";")[0])
+	}
+	base = strings.ToLower(base)
+	if ext, ok := mediaExtensions[base]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(base); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// mediaFileName keeps the filename WhatsApp sent with the media, stripped of
+// any path. Media without one is named after the message id, with the
+// extension of its mimetype.
+func mediaFileName(messageID, mediaType, mimeType, originalName string) string {
+	name := strings.TrimSpace(strings.ReplaceAll(originalName, "\\", "/"))
+	if name != "" {
+		name = path.Base(name)
+	}
+	if name == "" || name == "." || name == "/" {
+		return messageID + mediaExtension(mimeType)
+	}
+	if path.Ext(name) == "" {
+		name += mediaExtension(mimeType)
+	}
+	return name
+}
+
+// downloadMedia fetches downloadable into file within MediaDownloadTimeout
+func downloadMedia(waClient mediaDownloader, downloadable whatsmeow.DownloadableMessage, file whatsmeow.File) error {
+	ctx := context.Background()
+	if MediaDownloadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, MediaDownloadTimeout)
+		defer cancel()
+	}
+
+	err := waClient.DownloadToFile(ctx, downloadable, file)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s: %v", ErrMediaDownloadTimeout, MediaDownloadTimeout, err)
+	}
+	return err
+}
+
+// mediaTooLarge reports whether size exceeds MaxMediaSize
+func mediaTooLarge(size int64) bool {
+	return MaxMediaSize > 0 && size > MaxMediaSize
+}
+
+// sendOversizedMediaPlaceholder posts a text note instead of media over MaxMediaSize
+func (s *Service) sendOversizedMediaPlaceholder(client *Client, conversationID int, msgType, sourceID, mediaType, fileName, caption string, size int64) error {
+	log.Warn().
+		Str("media_type", mediaType).
+		Int64("size_bytes", size).
+		Int64("max_bytes", MaxMediaSize).
+		Str("filename", fileName).
+		Msg("⚠ Media exceeds Chatwoot size limit, sending placeholder")
+
+	content := fmt.Sprintf("[%s %s not forwarded: %.1f MB exceeds the %.1f MB limit]",
+		mediaType, fileName, float64(size)/(1024*1024), float64(MaxMediaSize)/(1024*1024))
+	if caption != "" {
+		content = caption + "\n\n" + content
+	}
+
+	if _, err := client.CreateMessage(conversationID, msgType, content, false, sourceID); err != nil {
+		return fmt.Errorf("failed to send media placeholder to chatwoot: %w", err)
+	}
+	return nil
+}
+
 // formatToE164 formats a phone number to E.164 format
 // Handles WhatsApp Multi-Device JIDs like: 5511999999999:84@s.whatsapp.net
+// Other numbers go through NormalizePhone first, keeping their digits when it
+// cannot read them.
 func formatToE164(phone string) string {
 	// CRITICAL: First remove @ and everything after (domain)
 	if idx := strings.Index(phone, "@"); idx != -1 {
 		phone = phone[:idx]
+	} else if normalized, ok := NormalizePhone(phone); ok {
+		return normalized
 	}
 
 	// CRITICAL: Then remove : and everything after (Device ID in Multi-Device)
@@ -0,0 +1,1423 @@
+package chatwoot
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+	_ "modernc.org/sqlite"
+)
+
+// fakeDownloader writes size synthetic bytes instead of fetching from WhatsApp
+type fakeDownloader struct {
+	size   int
+	called bool
+}
+
+func (f *fakeDownloader) DownloadToFile(ctx context.Context, msg whatsmeow.DownloadableMessage, file whatsmeow.File) error {
+	f.called = true
+	_, err := file.Write([]byte(strings.Repeat("x", f.size)))
+	return err
+}
+
+// recordedRequest captures what the fake Chatwoot server received
+type recordedRequest struct {
+	contentType string
+	content     string
+	attachment  int
+	fileName    string
+	fileType    string
+}
+
+func newFakeChatwoot(t *testing.T) (*Client, *[]recordedRequest) {
+	t.Helper()
+	var requests []recordedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := recordedRequest{contentType: r.Header.Get("Content-Type")}
+		if strings.HasPrefix(rec.contentType, "multipart/form-data") {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Errorf("Failed to parse multipart form: %v", err)
+			}
+			rec.content = r.FormValue("content")
+			if file, header, err := r.FormFile("attachments[]"); err == nil {
+				data, _ := io.ReadAll(file)
+				rec.attachment = len(data)
+				rec.fileName = header.Filename
+				rec.fileType = header.Header.Get("Content-Type")
+				file.Close()
+			}
+		} else {
+			var msg MessageRequest
+			json.NewDecoder(r.Body).Decode(&msg)
+			rec.content = msg.Content
+		}
+		requests = append(requests, rec)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 1}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewClient(&Config{URL: server.URL, AccountID: "1", Token: "token"})
+	return client, &requests
+}
+
+func videoEvent(fileLength *uint64) *events.Message {
+	return &events.Message{
+		Info: types.MessageInfo{ID: "VIDEO1"},
+		Message: &waE2E.Message{VideoMessage: &waE2E.VideoMessage{
+			Mimetype:   proto.String("video/mp4"),
+			Caption:    proto.String("holiday"),
+			FileLength: fileLength,
+		}},
+	}
+}
+
+func TestSendMediaMessageOversizedDownload(t *testing.T) {
+	previous := MaxMediaSize
+	MaxMediaSize = 1024
+	t.Cleanup(func() { MaxMediaSize = previous })
+
+	client, requests := newFakeChatwoot(t)
+	downloader := &fakeDownloader{size: 4096}
+	s := &Service{}
+
+	// No declared length, so the size is only known after downloading
+	err := s.sendMediaMessage(client, downloader, videoEvent(nil), 10, "incoming", "WAID:VIDEO1", "video/mp4", "holiday", "video")
+	if err != nil {
+		t.Fatalf("sendMediaMessage failed: %v", err)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("Expected 1 request to Chatwoot, got %d", len(*requests))
+	}
+	req := (*requests)[0]
+	if strings.HasPrefix(req.contentType, "multipart/form-data") {
+		t.Errorf("Expected placeholder text message, got multipart upload")
+	}
+	if !strings.Contains(req.content, "not forwarded") || !strings.Contains(req.content, "holiday") {
+		t.Errorf("Unexpected placeholder content: %q", req.content)
+	}
+}
+
+func TestSendMediaMessagePreservesFileNameAndMimeType(t *testing.T) {
+	tests := []struct {
+		name      string
+		mediaType string
+		message   *waE2E.Message
+		mimeType  string
+		fileName  string
+		fileType  string
+	}{
+		{"png image", "image", &waE2E.Message{ImageMessage: &waE2E.ImageMessage{Mimetype: proto.String("image/png")}}, "image/png", "MEDIA1.png", "image/png"},
+		{"webp image", "image", &waE2E.Message{ImageMessage: &waE2E.ImageMessage{Mimetype: proto.String("image/webp")}}, "image/webp", "MEDIA1.webp", "image/webp"},
+		{"image without mimetype", "image", &waE2E.Message{ImageMessage: &waE2E.ImageMessage{}}, "", "MEDIA1.jpg", "image/jpeg"},
+		{"quicktime video", "video", &waE2E.Message{VideoMessage: &waE2E.VideoMessage{Mimetype: proto.String("video/quicktime")}}, "video/quicktime", "MEDIA1.mov", "video/quicktime"},
+		{"voice note", "audio", &waE2E.Message{AudioMessage: &waE2E.AudioMessage{Mimetype: proto.String("audio/ogg; codecs=opus")}}, "audio/ogg; codecs=opus", "MEDIA1.ogg", "audio/ogg; codecs=opus"},
+		{"mp4 audio", "audio", &waE2E.Message{AudioMessage: &waE2E.AudioMessage{Mimetype: proto.String("audio/mp4")}}, "audio/mp4", "MEDIA1.m4a", "audio/mp4"},
+		{"named document", "document", &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
+			FileName: proto.String("Q3 report.xlsx"),
+			Mimetype: proto.String("application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"),
+		}}, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "Q3 report.xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{"document named by title", "document", &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
+			Title:    proto.String("contract"),
+			Mimetype: proto.String("application/pdf"),
+		}}, "application/pdf", "contract.pdf", "application/pdf"},
+		{"document with a path", "document", &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
+			FileName: proto.String("..\\..\\notes.txt"),
+			Mimetype: proto.String("text/plain"),
+		}}, "text/plain", "notes.txt", "text/plain"},
+		{"unnamed document", "document", &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{Mimetype: proto.String("application/zip")}}, "application/zip", "MEDIA1.zip", "application/zip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, requests := newFakeChatwoot(t)
+			s := &Service{}
+			evt := &events.Message{Info: types.MessageInfo{ID: "MEDIA1"}, Message: tt.message}
+
+			err := s.sendMediaMessage(client, &fakeDownloader{size: 16}, evt, 10, "incoming", "WAID:MEDIA1", tt.mimeType, "", tt.mediaType)
+			if err != nil {
+				t.Fatalf("sendMediaMessage failed: %v", err)
+			}
+			if len(*requests) != 1 {
+				t.Fatalf("Expected 1 request to Chatwoot, got %d", len(*requests))
+			}
+			req := (*requests)[0]
+			if req.fileName != tt.fileName {
+				t.Errorf("Expected filename %q, got %q", tt.fileName, req.fileName)
+			}
+			if req.fileType != tt.fileType {
+				t.Errorf("Expected mimetype %q, got %q", tt.fileType, req.fileType)
+			}
+		})
+	}
+}
+
+func TestSendMediaMessageDeclaredOversizedSkipsDownload(t *testing.T) {
+	previous := MaxMediaSize
+	MaxMediaSize = 1024
+	t.Cleanup(func() { MaxMediaSize = previous })
+
+	client, requests := newFakeChatwoot(t)
+	downloader := &fakeDownloader{size: 4096}
+	s := &Service{}
+
+	err := s.sendMediaMessage(client, downloader, videoEvent(proto.Uint64(4096)), 10, "incoming", "WAID:VIDEO1", "video/mp4", "holiday", "video")
+	if err != nil {
+		t.Fatalf("sendMediaMessage failed: %v", err)
+	}
+	if downloader.called {
+		t.Errorf("Expected download to be skipped for declared oversized media")
+	}
+	if len(*requests) != 1 || !strings.Contains((*requests)[0].content, "not forwarded") {
+		t.Errorf("Expected a single placeholder message, got %+v", *requests)
+	}
+}
+
+// blockingDownloader stalls until the download context is done
+type blockingDownloader struct{}
+
+func (blockingDownloader) DownloadToFile(ctx context.Context, msg whatsmeow.DownloadableMessage, file whatsmeow.File) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSendMediaMessageDownloadTimeout(t *testing.T) {
+	previous := MediaDownloadTimeout
+	MediaDownloadTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { MediaDownloadTimeout = previous })
+
+	err := downloadMedia(blockingDownloader{}, videoEvent(nil).Message.GetVideoMessage(), nil)
+	if !errors.Is(err, ErrMediaDownloadTimeout) {
+		t.Fatalf("Expected ErrMediaDownloadTimeout, got %v", err)
+	}
+
+	client, requests := newFakeChatwoot(t)
+	s := &Service{}
+
+	start := time.Now()
+	err = s.sendMediaMessage(client, blockingDownloader{}, videoEvent(nil), 10, "incoming", "WAID:VIDEO1", "video/mp4", "holiday", "video")
+	if err != nil {
+		t.Fatalf("sendMediaMessage failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected the download deadline to fire, took %s", elapsed)
+	}
+	if len(*requests) != 1 || !strings.Contains((*requests)[0].content, "download timed out") {
+		t.Errorf("Expected a single timeout placeholder, got %+v", *requests)
+	}
+}
+
+func TestSendMediaMessageWithinLimit(t *testing.T) {
+	previous := MaxMediaSize
+	MaxMediaSize = 1024
+	t.Cleanup(func() { MaxMediaSize = previous })
+
+	client, requests := newFakeChatwoot(t)
+	downloader := &fakeDownloader{size: 512}
+	s := &Service{}
+
+	err := s.sendMediaMessage(client, downloader, videoEvent(proto.Uint64(512)), 10, "incoming", "WAID:VIDEO1", "video/mp4", "holiday", "video")
+	if err != nil {
+		t.Fatalf("sendMediaMessage failed: %v", err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("Expected 1 request to Chatwoot, got %d", len(*requests))
+	}
+	if (*requests)[0].attachment != 512 {
+		t.Errorf("Expected 512 byte attachment, got %d", (*requests)[0].attachment)
+	}
+}
+
+func newDedupeTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+	db, err := sqlx.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	// A single connection keeps the in-memory database shared
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`CREATE TABLE chatwoot_dedupe (
+		user_id TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		expires_at INTEGER NOT NULL,
+		PRIMARY KEY (user_id, message_id)
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create dedupe table: %v", err)
+	}
+	return db
+}
+
+// flakyMediaChatwoot fails the first failures media uploads. When storeFailed
+// is set those uploads are stored before failing, as when Chatwoot times out
+// after saving the message.
+type flakyMediaChatwoot struct {
+	mu          sync.Mutex
+	failures    int
+	storeFailed bool
+	uploads     int
+	sourceIDs   []string
+	attachments []int
+	stored      []MessageResponse
+}
+
+func (f *flakyMediaChatwoot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(map[string]interface{}{"payload": f.stored})
+		return
+	}
+
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.uploads++
+	f.sourceIDs = append(f.sourceIDs, r.FormValue("source_id"))
+	if file, _, err := r.FormFile("attachments[]"); err == nil {
+		data, _ := io.ReadAll(file)
+		f.attachments = append(f.attachments, len(data))
+		file.Close()
+	}
+
+	failing := f.uploads <= f.failures
+	if !failing || f.storeFailed {
+		f.stored = append(f.stored, MessageResponse{ID: len(f.stored) + 1, SourceID: r.FormValue("source_id")})
+	}
+	if failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"message": "try again"}`))
+		return
+	}
+	json.NewEncoder(w).Encode(f.stored[len(f.stored)-1])
+}
+
+func TestSendMediaMessageRetriesFlakyUpload(t *testing.T) {
+	previous := MediaUploadBackoff
+	MediaUploadBackoff = time.Millisecond
+	t.Cleanup(func() { MediaUploadBackoff = previous })
+
+	tests := []struct {
+		name        string
+		failures    int
+		storeFailed bool
+		uploads     int
+		err         bool
+	}{
+		{"recovers after failures", 2, false, 3, false},
+		{"gives up after all attempts", 3, false, 3, true},
+		{"does not resend a stored upload", 1, true, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flaky := &flakyMediaChatwoot{failures: tt.failures, storeFailed: tt.storeFailed}
+			server := httptest.NewServer(flaky)
+			defer server.Close()
+			client := NewClient(&Config{URL: server.URL, AccountID: "1", Token: "token"})
+
+			file := strings.NewReader(strings.Repeat("x", 2048))
+			id, err := client.SendMediaMessage(10, "incoming", file, "photo.jpg", "image/jpeg", "", "WAID:MEDIA1")
+			if tt.err != (err != nil) {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if flaky.uploads != tt.uploads {
+				t.Errorf("Expected %d uploads, got %d", tt.uploads, flaky.uploads)
+			}
+			for i, size := range flaky.attachments {
+				if size != 2048 {
+					t.Errorf("Upload %d sent %d bytes, expected the whole file", i+1, size)
+				}
+				if flaky.sourceIDs[i] != "WAID:MEDIA1" {
+					t.Errorf("Upload %d sent source_id %q", i+1, flaky.sourceIDs[i])
+				}
+			}
+			if tt.err {
+				return
+			}
+			if len(flaky.stored) != 1 {
+				t.Fatalf("Expected 1 stored message, got %d", len(flaky.stored))
+			}
+			if id != flaky.stored[0].ID {
+				t.Errorf("Expected message id %d, got %d", flaky.stored[0].ID, id)
+			}
+		})
+	}
+}
+
+func TestSendMediaMessageDoesNotRetryUnseekable(t *testing.T) {
+	flaky := &flakyMediaChatwoot{failures: 1}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+	client := NewClient(&Config{URL: server.URL, AccountID: "1", Token: "token"})
+
+	file := io.MultiReader(strings.NewReader("data"))
+	if _, err := client.SendMediaMessage(10, "incoming", file, "photo.jpg", "image/jpeg", "", "WAID:MEDIA1"); err == nil {
+		t.Fatal("Expected the failed upload to be returned")
+	}
+	if flaky.uploads != 1 {
+		t.Errorf("Expected a single upload, got %d", flaky.uploads)
+	}
+}
+
+func TestEventMessageForwardedAsText(t *testing.T) {
+	previous := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = previous })
+
+	evt := &events.Message{
+		Info: types.MessageInfo{ID: "EVENT1", MessageSource: types.MessageSource{Chat: types.NewJID("5491155553934", types.DefaultUserServer)}},
+		Message: &waE2E.Message{EventMessage: &waE2E.EventMessage{
+			Name:        proto.String("Planning"),
+			Description: proto.String("Quarterly planning"),
+			StartTime:   proto.Int64(1735732800),
+			EndTime:     proto.Int64(1735736400),
+			Location:    &waE2E.LocationMessage{Name: proto.String("Office"), Address: proto.String("Av. Corrientes 1234")},
+			JoinLink:    proto.String("https://call.whatsapp.com/video/abc"),
+		}},
+	}
+
+	s := &Service{}
+	if s.shouldSkipMessage(evt) {
+		t.Fatal("Expected event messages not to be skipped")
+	}
+
+	client, requests := newFakeChatwoot(t)
+	if err := s.sendMessageToChatwoot(client, nil, evt, 10, "incoming"); err != nil {
+		t.Fatalf("sendMessageToChatwoot failed: %v", err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("Expected 1 request to Chatwoot, got %d", len(*requests))
+	}
+	expected := "📅 Planning\n" +
+		"When: Wed, 01 Jan 2025 12:00 UTC - Wed, 01 Jan 2025 13:00 UTC\n" +
+		"Where: Office Av. Corrientes 1234\n" +
+		"Call: https://call.whatsapp.com/video/abc\n" +
+		"\n" +
+		"Quarterly planning"
+	if got := (*requests)[0].content; got != expected {
+		t.Errorf("Unexpected Chatwoot content:\n%s", got)
+	}
+}
+
+func TestPersistedDedupeSurvivesRestart(t *testing.T) {
+	db := newDedupeTestDB(t)
+
+	first := &Service{db: db}
+	if first.isPersistedDuplicate("user1", "MSG1") {
+		t.Fatalf("Expected first delivery not to be a duplicate")
+	}
+
+	// A fresh service has an empty in-memory cache, like after a restart
+	restarted := &Service{db: db}
+	if !restarted.isPersistedDuplicate("user1", "MSG1") {
+		t.Errorf("Expected redelivered message to be skipped after restart")
+	}
+	if restarted.isPersistedDuplicate("user2", "MSG1") {
+		t.Errorf("Expected same message id for another user not to be a duplicate")
+	}
+}
+
+func TestPersistedDedupeIsAtomic(t *testing.T) {
+	db := newDedupeTestDB(t)
+	s := &Service{db: db}
+
+	// Of concurrent deliveries of the same message only one gets through
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	forwarded := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !s.isPersistedDuplicate("user1", "MSG1") {
+				mu.Lock()
+				forwarded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if forwarded != 1 {
+		t.Errorf("Expected exactly one delivery forwarded, got %d", forwarded)
+	}
+
+	// An expired row does not block the message and is renewed
+	if _, err := db.Exec(`INSERT INTO chatwoot_dedupe (user_id, message_id, expires_at) VALUES (?, ?, ?)`, "user1", "OLD", time.Now().Add(-time.Hour).Unix()); err != nil {
+		t.Fatalf("Failed to insert expired row: %v", err)
+	}
+	if s.isPersistedDuplicate("user1", "OLD") {
+		t.Errorf("Expected an expired row not to be a duplicate")
+	}
+	if !s.isPersistedDuplicate("user1", "OLD") {
+		t.Errorf("Expected the renewed row to be a duplicate")
+	}
+}
+
+func TestPlaceholderReleasedOnDecrypt(t *testing.T) {
+	s := &Service{db: newDedupeTestDB(t)}
+	evt := &events.Message{
+		Info:    types.MessageInfo{ID: "MSG1"},
+		Message: &waE2E.Message{Conversation: proto.String("hello")},
+	}
+
+	// Without Chatwoot config nothing is forwarded, the dedupe cache still
+	// records what was seen
+	s.HandlePlaceholderMessage("user1", evt, nil)
+	if _, found := s.dedupeCache.Load(placeholderKey("MSG1")); !found {
+		t.Fatal("Expected the placeholder to be recorded")
+	}
+	if _, found := s.dedupeCache.Load("MSG1"); found {
+		t.Fatal("Expected the placeholder not to record the message id")
+	}
+
+	s.HandleIncomingMessage("user1", evt, nil)
+	if _, found := s.dedupeCache.Load(placeholderKey("MSG1")); found {
+		t.Error("Expected the placeholder to be released once the message arrived")
+	}
+	if _, found := s.dedupeCache.Load("MSG1"); !found {
+		t.Error("Expected the message id to be recorded")
+	}
+}
+
+func TestCleanupExpiredDedupe(t *testing.T) {
+	db := newDedupeTestDB(t)
+	s := &Service{db: db}
+
+	if _, err := db.Exec(`INSERT INTO chatwoot_dedupe (user_id, message_id, expires_at) VALUES (?, ?, ?)`, "user1", "OLD", time.Now().Add(-time.Hour).Unix()); err != nil {
+		t.Fatalf("Failed to insert expired row: %v", err)
+	}
+	s.isPersistedDuplicate("user1", "NEW")
+
+	removed, err := s.CleanupExpiredDedupe()
+	if err != nil {
+		t.Fatalf("CleanupExpiredDedupe failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 expired row removed, got %d", removed)
+	}
+	if !s.isPersistedDuplicate("user1", "NEW") {
+		t.Errorf("Expected unexpired row to be kept")
+	}
+}
+
+func TestPurgeDedupeCacheHonoursRetention(t *testing.T) {
+	s := &Service{}
+	now := time.Now()
+	s.dedupeCache.Store("OLD", now.Add(-6*time.Minute))
+	s.dedupeCache.Store("RECENT", now.Add(-time.Minute))
+
+	s.purgeDedupeCache(now, 5*time.Minute)
+
+	if _, ok := s.dedupeCache.Load("OLD"); ok {
+		t.Errorf("Expected entry older than the retention to be purged")
+	}
+	if _, ok := s.dedupeCache.Load("RECENT"); !ok {
+		t.Errorf("Expected entry within the retention to be kept")
+	}
+}
+
+func TestCleanupDedupeCacheUsesConfiguredInterval(t *testing.T) {
+	oldTTL, oldInterval := DedupeCacheTTL, DedupeCleanupInterval
+	DedupeCacheTTL, DedupeCleanupInterval = 20*time.Millisecond, 10*time.Millisecond
+	defer func() { DedupeCacheTTL, DedupeCleanupInterval = oldTTL, oldInterval }()
+
+	db := newDedupeTestDB(t)
+	s := NewService(db)
+	defer s.Close()
+	s.dedupeCache.Store("MSG", time.Now())
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := s.dedupeCache.Load("MSG"); !ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected entry to be purged after the configured retention")
+}
+
+func TestServiceCloseStopsCleanupGoroutine(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 20; i++ {
+		s := NewService(nil)
+		s.Close()
+		// A second Close must not block or panic
+		s.Close()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected no leaked goroutines, had %d before and %d after", before, after)
+	}
+}
+
+// newAssignmentChatwoot fakes the conversation and assignment endpoints and
+// records every assignment payload it receives
+func newAssignmentChatwoot(t *testing.T, assignStatus int) (*Client, *[]AssignmentRequest) {
+	t.Helper()
+	var assignments []AssignmentRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/accounts/1/conversations":
+			w.Write([]byte(`{"id": 42}`))
+		case "/api/v1/accounts/1/conversations/42/assignments":
+			var req AssignmentRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			assignments = append(assignments, req)
+			w.WriteHeader(assignStatus)
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewClient(&Config{URL: server.URL, AccountID: "1", Token: "token"})
+	return client, &assignments
+}
+
+func newConversationTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+	db, err := sqlx.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`CREATE TABLE chatwoot_conversations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		chat_jid TEXT NOT NULL,
+		chatwoot_conversation_id INTEGER NOT NULL,
+		chatwoot_contact_id INTEGER NOT NULL,
+		chatwoot_inbox_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(user_id, chat_jid)
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create conversations table: %v", err)
+	}
+	return db
+}
+
+func TestEnsureConversationAssignsDefaults(t *testing.T) {
+	client, assignments := newAssignmentChatwoot(t, http.StatusOK)
+	s := &Service{db: newConversationTestDB(t)}
+	config := &Config{
+		InboxID:           sql.NullInt64{Int64: 7, Valid: true},
+		DefaultAssigneeID: sql.NullInt64{Int64: 3, Valid: true},
+		DefaultTeamID:     sql.NullInt64{Int64: 5, Valid: true},
+	}
+
+	convID, err := s.ensureConversation("user1", client, config, 10, "5511999999999@s.whatsapp.net")
+	if err != nil {
+		t.Fatalf("ensureConversation failed: %v", err)
+	}
+	if convID != 42 {
+		t.Errorf("Expected conversation 42, got %d", convID)
+	}
+
+	expected := []AssignmentRequest{{TeamID: 5}, {AssigneeID: 3}}
+	if len(*assignments) != len(expected) {
+		t.Fatalf("Expected assignments %+v, got %+v", expected, *assignments)
+	}
+	for i := range expected {
+		if (*assignments)[i] != expected[i] {
+			t.Errorf("Expected assignment %+v at %d, got %+v", expected[i], i, (*assignments)[i])
+		}
+	}
+
+	// A cached conversation is not reassigned
+	if _, err := s.ensureConversation("user1", client, config, 10, "5511999999999@s.whatsapp.net"); err != nil {
+		t.Fatalf("ensureConversation failed: %v", err)
+	}
+	if len(*assignments) != len(expected) {
+		t.Errorf("Expected no assignment for existing conversation, got %+v", *assignments)
+	}
+}
+
+func TestEnsureConversationKeepsConversationWhenAssignmentFails(t *testing.T) {
+	client, assignments := newAssignmentChatwoot(t, http.StatusUnprocessableEntity)
+	db := newConversationTestDB(t)
+	s := &Service{db: db}
+	config := &Config{
+		InboxID:           sql.NullInt64{Int64: 7, Valid: true},
+		DefaultAssigneeID: sql.NullInt64{Int64: 3, Valid: true},
+	}
+
+	convID, err := s.ensureConversation("user1", client, config, 10, "5511999999999@s.whatsapp.net")
+	if err != nil {
+		t.Fatalf("Expected assignment failure to be ignored, got %v", err)
+	}
+	if convID != 42 || len(*assignments) != 1 {
+		t.Errorf("Expected conversation 42 with one assignment attempt, got %d and %+v", convID, *assignments)
+	}
+
+	var stored int64
+	if err := db.Get(&stored, `SELECT chatwoot_conversation_id FROM chatwoot_conversations WHERE user_id = ?`, "user1"); err != nil || stored != 42 {
+		t.Errorf("Expected conversation 42 to be persisted, got %d (%v)", stored, err)
+	}
+}
+
+func TestEnsureConversationWithoutDefaultsSkipsAssignment(t *testing.T) {
+	client, assignments := newAssignmentChatwoot(t, http.StatusOK)
+	s := &Service{db: newConversationTestDB(t)}
+	config := &Config{InboxID: sql.NullInt64{Int64: 7, Valid: true}}
+
+	if _, err := s.ensureConversation("user1", client, config, 10, "5511999999999@s.whatsapp.net"); err != nil {
+		t.Fatalf("ensureConversation failed: %v", err)
+	}
+	if len(*assignments) != 0 {
+		t.Errorf("Expected no assignment without defaults, got %+v", *assignments)
+	}
+}
+
+func TestEnsureConversationConcurrentFirstMessages(t *testing.T) {
+	var mu sync.Mutex
+	creates := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ConversationRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		creates[req.SourceID]++
+		id := len(creates)*100 + creates[req.SourceID]
+		mu.Unlock()
+		// Keep the creation in flight while the other messages arrive
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id": %d}`, id)
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewClient(&Config{URL: server.URL, AccountID: "1", Token: "token"})
+	s := &Service{db: newConversationTestDB(t)}
+	config := &Config{InboxID: sql.NullInt64{Int64: 7, Valid: true}}
+	chats := []string{"5511999999999@s.whatsapp.net", "5511888888888@s.whatsapp.net"}
+
+	const perChat = 10
+	ids := make([][]int, len(chats))
+	var wg sync.WaitGroup
+	for c, chatJID := range chats {
+		ids[c] = make([]int, perChat)
+		for i := 0; i < perChat; i++ {
+			wg.Add(1)
+			go func(c, i int, chatJID string) {
+				defer wg.Done()
+				convID, err := s.ensureConversation("user1", client, config, 10, chatJID)
+				if err != nil {
+					t.Errorf("ensureConversation failed: %v", err)
+				}
+				ids[c][i] = convID
+			}(c, i, chatJID)
+		}
+	}
+	wg.Wait()
+
+	for c, chatJID := range chats {
+		if n := creates["wa:"+chatJID]; n != 1 {
+			t.Errorf("Expected a single conversation created for %s, got %d", chatJID, n)
+		}
+		for i := range ids[c] {
+			if ids[c][i] != ids[c][0] {
+				t.Errorf("Expected every message of %s in conversation %d, got %v", chatJID, ids[c][0], ids[c])
+				break
+			}
+		}
+	}
+	if ids[0][0] == ids[1][0] {
+		t.Errorf("Expected distinct conversations per chat, got %d for both", ids[0][0])
+	}
+}
+
+func TestPurgeCacheRemovesOnlyOldMappings(t *testing.T) {
+	db := newConversationTestDB(t)
+	if _, err := db.Exec(`CREATE TABLE chatwoot_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		chatwoot_message_id INTEGER NOT NULL,
+		chatwoot_conversation_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(user_id, message_id)
+	)`); err != nil {
+		t.Fatalf("Failed to create messages table: %v", err)
+	}
+	s := &Service{db: db}
+
+	old := time.Now().UTC().AddDate(0, 0, -60).Format("2006-01-02 15:04:05")
+	for _, q := range []string{
+		`INSERT INTO chatwoot_conversations (user_id, chat_jid, chatwoot_conversation_id, chatwoot_contact_id, chatwoot_inbox_id, created_at, updated_at) VALUES ('user1', 'old@s.whatsapp.net', 1, 10, 7, '` + old + `', '` + old + `')`,
+		`INSERT INTO chatwoot_conversations (user_id, chat_jid, chatwoot_conversation_id, chatwoot_contact_id, chatwoot_inbox_id, created_at, updated_at) VALUES ('user2', 'old@s.whatsapp.net', 2, 10, 7, '` + old + `', '` + old + `')`,
+		`INSERT INTO chatwoot_conversations (user_id, chat_jid, chatwoot_conversation_id, chatwoot_contact_id, chatwoot_inbox_id) VALUES ('user1', 'new@s.whatsapp.net', 3, 10, 7)`,
+		`INSERT INTO chatwoot_messages (user_id, message_id, chatwoot_message_id, chatwoot_conversation_id, created_at) VALUES ('user1', 'OLDMSG', 100, 1, '` + old + `')`,
+		`INSERT INTO chatwoot_messages (user_id, message_id, chatwoot_message_id, chatwoot_conversation_id) VALUES ('user1', 'NEWMSG', 101, 3)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("Failed to insert row: %v", err)
+		}
+	}
+	s.conversationCache.Store("user1:old@s.whatsapp.net", 1)
+	s.conversationCache.Store("user1:new@s.whatsapp.net", 3)
+
+	cutoff := time.Now().AddDate(0, 0, -30)
+	count := func(table string) int {
+		var n int
+		if err := db.Get(&n, `SELECT COUNT(*) FROM `+table); err != nil {
+			t.Fatalf("Failed to count %s: %v", table, err)
+		}
+		return n
+	}
+
+	// A dry run only counts
+	result, err := s.PurgeCache(cutoff, "", true)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if *result != (CachePurgeResult{Conversations: 2, Messages: 1, DryRun: true}) {
+		t.Errorf("Unexpected dry run result %+v", *result)
+	}
+	if count("chatwoot_conversations") != 3 || count("chatwoot_messages") != 2 {
+		t.Fatal("Expected a dry run to keep every row")
+	}
+
+	// Purging one user leaves the others untouched
+	result, err = s.PurgeCache(cutoff, "user1", false)
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if *result != (CachePurgeResult{Conversations: 1, Messages: 1}) {
+		t.Errorf("Unexpected purge result %+v", *result)
+	}
+
+	var remaining []string
+	if err := db.Select(&remaining, `SELECT user_id || ':' || chat_jid FROM chatwoot_conversations ORDER BY user_id, chat_jid`); err != nil {
+		t.Fatalf("Failed to list conversations: %v", err)
+	}
+	if strings.Join(remaining, ",") != "user1:new@s.whatsapp.net,user2:old@s.whatsapp.net" {
+		t.Errorf("Expected only the old user1 conversation purged, got %v", remaining)
+	}
+	var messageID string
+	if err := db.Get(&messageID, `SELECT message_id FROM chatwoot_messages`); err != nil || messageID != "NEWMSG" {
+		t.Errorf("Expected only NEWMSG to remain, got %q (%v)", messageID, err)
+	}
+
+	if _, ok := s.conversationCache.Load("user1:old@s.whatsapp.net"); ok {
+		t.Error("Expected the purged conversation to leave the memory cache")
+	}
+	if _, ok := s.conversationCache.Load("user1:new@s.whatsapp.net"); !ok {
+		t.Error("Expected the recent conversation to stay in the memory cache")
+	}
+}
+
+func TestSyncContactsSummary(t *testing.T) {
+	previousConcurrency, previousInterval := ContactSyncConcurrency, ContactSyncInterval
+	ContactSyncConcurrency, ContactSyncInterval = 2, time.Millisecond
+	t.Cleanup(func() { ContactSyncConcurrency, ContactSyncInterval = previousConcurrency, previousInterval })
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(5 * time.Millisecond)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Query().Get("q"), "5511111111111"):
+			w.Write([]byte(`{"payload": [{"id": 1}]}`))
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"payload": []}`))
+		case r.Method == http.MethodPost:
+			var req CreateContactPayloadRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.PhoneNumber == "+5533333333333" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				w.Write([]byte(`{"message": "Phone number is invalid"}`))
+				return
+			}
+			w.Write([]byte(`{"payload": {"contact": {"id": 2}}}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	config := &Config{URL: server.URL, AccountID: "1", Token: "token", InboxID: sql.NullInt64{Int64: 7, Valid: true}}
+	contacts := []SyncContact{
+		{JID: "5511111111111@s.whatsapp.net", Phone: "+5511111111111", Name: "Found"},
+		{JID: "5522222222222@s.whatsapp.net", Phone: "+5522222222222", Name: "New"},
+		{JID: "5533333333333@s.whatsapp.net", Phone: "+5533333333333", Name: "Broken"},
+		{JID: "5544444444444@s.whatsapp.net", Phone: "+5544444444444", Name: "Another"},
+	}
+
+	s := &Service{}
+	summary := s.syncContacts(context.Background(), NewClient(config), config, nil, contacts)
+
+	if summary.Total != 4 || summary.Found != 1 || summary.Created != 2 || summary.Failed != 1 || summary.Skipped != 0 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	if len(summary.Failures) != 1 || summary.Failures[0].JID != "5533333333333@s.whatsapp.net" {
+		t.Errorf("Expected failure for the broken contact, got %+v", summary.Failures)
+	}
+	if maxInFlight > 2 {
+		t.Errorf("Expected at most 2 concurrent Chatwoot requests, got %d", maxInFlight)
+	}
+}
+
+func TestSyncContactsStopsOnCancel(t *testing.T) {
+	s := &Service{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	config := &Config{URL: "http://127.0.0.1:0", AccountID: "1", InboxID: sql.NullInt64{Int64: 7, Valid: true}}
+	summary := s.syncContacts(ctx, NewClient(config), config, nil, []SyncContact{{JID: "a", Phone: "+1", Name: "a"}, {JID: "b", Phone: "+2", Name: "b"}})
+	if summary.Skipped != 2 {
+		t.Errorf("Expected every contact to be skipped after cancel, got %+v", summary)
+	}
+}
+
+func TestImportContacts(t *testing.T) {
+	previousConcurrency, previousInterval := ContactSyncConcurrency, ContactSyncInterval
+	ContactSyncConcurrency, ContactSyncInterval = 2, 0
+	t.Cleanup(func() { ContactSyncConcurrency, ContactSyncInterval = previousConcurrency, previousInterval })
+
+	var mu sync.Mutex
+	var updated UpdateContactRequest
+	var created CreateContactPayloadRequest
+	creates := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Query().Get("q"), "5511111111111"):
+			w.Write([]byte(`{"payload": [{"id": 1}]}`))
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Query().Get("q"), "5544444444444"):
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"payload": []}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/api/v1/accounts/1/contacts/1":
+			json.NewDecoder(r.Body).Decode(&updated)
+			w.Write([]byte(`{"payload": {"id": 1}}`))
+		case r.Method == http.MethodPost:
+			creates++
+			json.NewDecoder(r.Body).Decode(&created)
+			w.Write([]byte(`{"payload": {"contact": {"id": 2}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewClient(&Config{URL: server.URL, AccountID: "1", Token: "token"})
+	contacts := []ImportContact{
+		{Name: "Found", Phone: "+55 11 11111-1111", CustomAttributes: map[string]interface{}{"plan": "gold"}},
+		{Name: "New", Phone: "5522222222222", CustomAttributes: map[string]interface{}{"plan": "free"}},
+		{Name: "Broken", Phone: "not a phone"},
+		{Name: "Unknown", Phone: "5544444444444"},
+	}
+
+	s := &Service{}
+	summary := s.importContacts(context.Background(), client, 7, contacts)
+
+	if summary.Total != 4 || summary.Created != 1 || summary.Updated != 1 || summary.Failed != 2 || summary.Skipped != 0 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	expected := []ImportResult{
+		{Phone: "+55 11 11111-1111", ContactID: 1, Status: ImportUpdated},
+		{Phone: "5522222222222", ContactID: 2, Status: ImportCreated},
+	}
+	for i, want := range expected {
+		if summary.Results[i] != want {
+			t.Errorf("Expected result %d to be %+v, got %+v", i, want, summary.Results[i])
+		}
+	}
+	if summary.Results[2].Status != ImportFailed || summary.Results[2].Error == "" {
+		t.Errorf("Expected the invalid phone to fail with an error, got %+v", summary.Results[2])
+	}
+	// A failed search is not taken for a missing contact
+	if summary.Results[3].Status != ImportFailed || summary.Results[3].Error == "" || creates != 1 {
+		t.Errorf("Expected the failed search to fail without creating a contact, got %+v and %d creates", summary.Results[3], creates)
+	}
+
+	if updated.Name != "Found" || updated.CustomAttributes["plan"] != "gold" {
+		t.Errorf("Expected the existing contact updated with its attributes, got %+v", updated)
+	}
+	if created.PhoneNumber != "+5522222222222" || created.Identifier != "5522222222222@s.whatsapp.net" ||
+		created.InboxID != 7 || created.CustomAttributes["plan"] != "free" {
+		t.Errorf("Expected the new contact created with its attributes, got %+v", created)
+	}
+}
+
+func TestEnsureContactSendsAttributes(t *testing.T) {
+	var created []CreateContactPayloadRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"payload": []}`))
+			return
+		}
+		var req CreateContactPayloadRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		created = append(created, req)
+		w.Write([]byte(`{"payload": {"contact": {"id": 3}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	config := &Config{URL: server.URL, AccountID: "1", Token: "token", InboxID: sql.NullInt64{Int64: 7, Valid: true}}
+	client := NewClient(config)
+	s := &Service{}
+
+	if _, _, err := s.ensureContact(client, config, nil, "+5511999999999", "Maria", "5511999999999:12@s.whatsapp.net"); err != nil {
+		t.Fatalf("Failed to ensure contact: %v", err)
+	}
+	if _, _, err := s.ensureContact(client, config, nil, "+123456789012345", "123456789012345", "123456789012345@lid"); err != nil {
+		t.Fatalf("Failed to ensure LID contact: %v", err)
+	}
+	if len(created) != 2 {
+		t.Fatalf("Expected 2 contacts created, got %d", len(created))
+	}
+
+	attributes := created[0].CustomAttributes
+	if attributes["whatsapp_jid"] != "5511999999999@s.whatsapp.net" || attributes["whatsapp_push_name"] != "Maria" {
+		t.Errorf("Expected the JID without device and the push name, got %v", attributes)
+	}
+	attributes = created[1].CustomAttributes
+	if attributes["whatsapp_lid"] != "123456789012345@lid" || attributes["whatsapp_jid"] != nil || attributes["whatsapp_push_name"] != nil {
+		t.Errorf("Expected only the LID when the name is the phone fallback, got %v", attributes)
+	}
+}
+
+func TestEnsureContactUpdatesName(t *testing.T) {
+	var renames []UpdateContactRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`{"payload": [{"id": 5, "name": "Old Name", "custom_attributes": {"whatsapp_jid": "5511999999999@s.whatsapp.net", "whatsapp_push_name": "Old Name", "plan": "gold"}}]}`))
+		case http.MethodPut:
+			if r.URL.Path != "/api/v1/accounts/1/contacts/5" {
+				t.Errorf("Unexpected update path %s", r.URL.Path)
+			}
+			var req UpdateContactRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			renames = append(renames, req)
+			w.Write([]byte(`{"payload": {"id": 5}}`))
+		default:
+			t.Errorf("Unexpected %s request", r.Method)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	config := &Config{URL: server.URL, AccountID: "1", Token: "token", InboxID: sql.NullInt64{Int64: 7, Valid: true}}
+	client := NewClient(config)
+	s := &Service{}
+	ensure := func(name string) {
+		t.Helper()
+		contactID, created, err := s.ensureContact(client, config, nil, "+5511999999999", name, "5511999999999@s.whatsapp.net")
+		if err != nil || contactID != 5 || created {
+			t.Fatalf("Expected the existing contact, got %d, %v, %v", contactID, created, err)
+		}
+	}
+
+	// Names edited by agents are kept unless update_contact_names is set
+	ensure("New Name")
+	if len(renames) != 0 {
+		t.Fatalf("Expected no update without update_contact_names, got %+v", renames)
+	}
+
+	config.UpdateContactNames = true
+	ensure("Old Name")
+	ensure("5511999999999")
+	if len(renames) != 0 {
+		t.Fatalf("Expected no update for the same name or the phone fallback, got %+v", renames)
+	}
+
+	ensure("New Name")
+	if len(renames) != 1 || renames[0].Name != "New Name" {
+		t.Fatalf("Expected the contact renamed to New Name, got %+v", renames)
+	}
+	// The push name attribute follows the name, keeping attributes set by agents
+	attributes := renames[0].CustomAttributes
+	if attributes["whatsapp_push_name"] != "New Name" || attributes["whatsapp_jid"] != "5511999999999@s.whatsapp.net" || attributes["plan"] != "gold" {
+		t.Errorf("Expected the push name attribute updated, got %v", attributes)
+	}
+}
+
+func TestEnsureContactUpdatesAttributes(t *testing.T) {
+	var updates []UpdateContactRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`{"payload": [{"id": 5, "name": "Maria", "custom_attributes": {"plan": "gold"}}]}`))
+		case http.MethodPut:
+			var req UpdateContactRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			updates = append(updates, req)
+			w.Write([]byte(`{"payload": {"id": 5}}`))
+		default:
+			t.Errorf("Unexpected %s request", r.Method)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	config := &Config{URL: server.URL, AccountID: "1", Token: "token", InboxID: sql.NullInt64{Int64: 7, Valid: true}}
+	client := NewClient(config)
+	s := &Service{}
+
+	// Contacts created before the attributes existed, or by agents, only get
+	// them with update_contact_names
+	if _, _, err := s.ensureContact(client, config, nil, "+5511999999999", "Maria", "5511999999999:12@s.whatsapp.net"); err != nil {
+		t.Fatalf("Failed to ensure contact: %v", err)
+	}
+	if len(updates) != 0 {
+		t.Fatalf("Expected no update without update_contact_names, got %+v", updates)
+	}
+
+	config.UpdateContactNames = true
+	if _, _, err := s.ensureContact(client, config, nil, "+5511999999999", "Maria", "5511999999999:12@s.whatsapp.net"); err != nil {
+		t.Fatalf("Failed to ensure contact: %v", err)
+	}
+	if len(updates) != 1 || updates[0].Name != "" {
+		t.Fatalf("Expected one attribute update without a rename, got %+v", updates)
+	}
+	attributes := updates[0].CustomAttributes
+	if attributes["whatsapp_jid"] != "5511999999999@s.whatsapp.net" || attributes["whatsapp_push_name"] != "Maria" || attributes["plan"] != "gold" {
+		t.Errorf("Expected the WhatsApp attributes added to the agent's, got %v", attributes)
+	}
+}
+
+// fakeAvatars serves profile pictures by JID user, counting the lookups
+type fakeAvatars struct {
+	pictures map[string]*types.ProfilePictureInfo
+	errs     map[string]error
+	lookups  int
+}
+
+func (f *fakeAvatars) GetProfilePictureInfo(ctx context.Context, jid types.JID, params *whatsmeow.GetProfilePictureParams) (*types.ProfilePictureInfo, error) {
+	f.lookups++
+	if err := f.errs[jid.User]; err != nil {
+		return nil, err
+	}
+	pic := f.pictures[jid.User]
+	if pic == nil {
+		return nil, whatsmeow.ErrProfilePictureNotSet
+	}
+	if params.ExistingID == pic.ID {
+		return nil, nil
+	}
+	return pic, nil
+}
+
+func TestEnsureContactAvatar(t *testing.T) {
+	var created []CreateContactPayloadRequest
+	var updated []UpdateContactRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Query().Get("q"), "5511111111111"):
+			w.Write([]byte(`{"payload": [{"id": 1, "name": "Known"}]}`))
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"payload": []}`))
+		case r.Method == http.MethodPut:
+			var req UpdateContactRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			updated = append(updated, req)
+			w.Write([]byte(`{"payload": {"id": 1}}`))
+		case r.Method == http.MethodPost:
+			var req CreateContactPayloadRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			created = append(created, req)
+			w.Write([]byte(`{"payload": {"contact": {"id": 2}}}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	avatars := &fakeAvatars{
+		pictures: map[string]*types.ProfilePictureInfo{
+			"5511111111111": {ID: "100", URL: "https://pps.whatsapp.net/known.jpg"},
+			"5522222222222": {ID: "200", URL: "https://pps.whatsapp.net/new.jpg"},
+		},
+		errs: map[string]error{"5533333333333": whatsmeow.ErrProfilePictureUnauthorized},
+	}
+	config := &Config{UserID: "user1", URL: server.URL, AccountID: "1", Token: "token", InboxID: sql.NullInt64{Int64: 7, Valid: true}}
+	client := NewClient(config)
+	s := &Service{}
+	ensure := func(phone string) {
+		t.Helper()
+		if _, _, err := s.ensureContact(client, config, avatars, "+"+phone, "Name", phone+"@s.whatsapp.net"); err != nil {
+			t.Fatalf("Failed to ensure contact %s: %v", phone, err)
+		}
+	}
+
+	ensure("5522222222222")
+	ensure("5533333333333")
+	if len(created) != 2 || created[0].AvatarURL != "https://pps.whatsapp.net/new.jpg" {
+		t.Fatalf("Expected the new contact created with its avatar, got %+v", created)
+	}
+	if created[1].AvatarURL != "" {
+		t.Errorf("Expected no avatar for a hidden profile picture, got %q", created[1].AvatarURL)
+	}
+
+	// A known contact without avatar gets it, then keeps it until the refresh
+	ensure("5511111111111")
+	ensure("5511111111111")
+	if len(updated) != 1 || updated[0].AvatarURL != "https://pps.whatsapp.net/known.jpg" || updated[0].Name != "" {
+		t.Fatalf("Expected one avatar update for the known contact, got %+v", updated)
+	}
+
+	previousRefresh := AvatarRefreshInterval
+	AvatarRefreshInterval = 0
+	t.Cleanup(func() { AvatarRefreshInterval = previousRefresh })
+
+	lookups := avatars.lookups
+	ensure("5511111111111")
+	if avatars.lookups != lookups+1 || len(updated) != 1 {
+		t.Errorf("Expected an unchanged avatar to be checked but not updated, got %d lookups and %+v", avatars.lookups-lookups, updated)
+	}
+	avatars.pictures["5511111111111"] = &types.ProfilePictureInfo{ID: "101", URL: "https://pps.whatsapp.net/changed.jpg"}
+	ensure("5511111111111")
+	if len(updated) != 2 || updated[1].AvatarURL != "https://pps.whatsapp.net/changed.jpg" {
+		t.Errorf("Expected the changed avatar copied to Chatwoot, got %+v", updated)
+	}
+}
+
+func TestInitializeInboxReusesExisting(t *testing.T) {
+	var posts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			posts++
+			w.Write([]byte(`{"id": 99}`))
+			return
+		}
+		w.Write([]byte(`{"payload": [{"id": 3, "name": "Other"}, {"id": 8, "name": "Wuzapi"}]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	s := &Service{}
+	inboxID, err := s.InitializeInbox(&Config{URL: server.URL, AccountID: "1", Token: "token", NameInbox: "Wuzapi"}, "https://wuzapi/chatwoot/webhook/x")
+	if err != nil {
+		t.Fatalf("Failed to initialize inbox: %v", err)
+	}
+	if inboxID != 8 {
+		t.Errorf("Expected the existing inbox 8 to be reused, got %d", inboxID)
+	}
+	if posts != 0 {
+		t.Errorf("Expected neither inbox nor bot contact to be created, got %d POST requests", posts)
+	}
+}
+
+func TestInitializeInboxRetriesTransientFailures(t *testing.T) {
+	previousAttempts, previousBackoff := InboxCreateAttempts, InboxCreateBackoff
+	InboxCreateAttempts, InboxCreateBackoff = 3, 0
+	t.Cleanup(func() { InboxCreateAttempts, InboxCreateBackoff = previousAttempts, previousBackoff })
+
+	// The first creation fails after Chatwoot stored the inbox, the listing
+	// before the retry finds it
+	var mu sync.Mutex
+	var inboxPosts, contactPosts, lists int
+	created := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet:
+			lists++
+			if lists == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"message": "maintenance"}`))
+				return
+			}
+			if created {
+				w.Write([]byte(`{"payload": [{"id": 12, "name": "Wuzapi"}]}`))
+				return
+			}
+			w.Write([]byte(`{"payload": []}`))
+		case strings.HasSuffix(r.URL.Path, "/inboxes"):
+			inboxPosts++
+			created = true
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`{"message": "bad gateway"}`))
+		default:
+			contactPosts++
+			w.Write([]byte(`{"payload": {"contact": {"id": 1}}}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	s := &Service{}
+	config := &Config{URL: server.URL, AccountID: "1", Token: "token", NameInbox: "Wuzapi"}
+	inboxID, err := s.InitializeInbox(config, "https://wuzapi/chatwoot/webhook/x")
+	if err != nil {
+		t.Fatalf("Expected the transient failures to be retried, got %v", err)
+	}
+	if inboxID != 12 || inboxPosts != 1 || lists != 3 {
+		t.Errorf("Expected inbox 12 found after one creation and 3 listings, got inbox %d, %d creations, %d listings", inboxID, inboxPosts, lists)
+	}
+	if contactPosts != 0 {
+		t.Errorf("Expected no second bot contact for the inbox found, got %d", contactPosts)
+	}
+
+	// Attempts are bounded
+	var attempts int
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"payload": []}`))
+			return
+		}
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"message": "maintenance"}`))
+	}))
+	t.Cleanup(down.Close)
+
+	InboxCreateAttempts = 2
+	config.URL = down.URL
+	if _, err := s.InitializeInbox(config, "https://wuzapi/chatwoot/webhook/x"); err == nil {
+		t.Fatal("Expected the inbox creation to fail after the last attempt")
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 creation attempts, got %d", attempts)
+	}
+}
+
+func TestInitializeInboxFieldErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"message": "Validation failed", "errors": [{"field": "name", "message": "has already been taken"}, {"field": "webhook_url", "message": "is invalid"}]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	s := &Service{}
+	_, err := s.InitializeInbox(&Config{URL: server.URL, AccountID: "1", Token: "token", NameInbox: "Wuzapi"}, "not-a-url")
+	if err == nil {
+		t.Fatalf("Expected inbox creation to fail")
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected an APIError in the chain, got %T: %v", err, err)
+	}
+	if apiErr.StatusCode != http.StatusUnprocessableEntity || apiErr.Message != "Validation failed" {
+		t.Errorf("Unexpected APIError %+v", apiErr)
+	}
+	expected := []FieldError{{Field: "name", Message: "has already been taken"}, {Field: "webhook_url", Message: "is invalid"}}
+	if len(apiErr.Errors) != len(expected) || apiErr.Errors[0] != expected[0] || apiErr.Errors[1] != expected[1] {
+		t.Errorf("Expected field errors %+v, got %+v", expected, apiErr.Errors)
+	}
+	if !strings.Contains(err.Error(), "name: has already been taken") {
+		t.Errorf("Expected field errors in message, got %q", err.Error())
+	}
+}
+
+func TestFindConversationDoesNotCreate(t *testing.T) {
+	db := newConversationTestDB(t)
+	s := &Service{db: db}
+
+	if _, err := db.Exec(`INSERT INTO chatwoot_conversations (user_id, chat_jid, chatwoot_conversation_id, chatwoot_contact_id, chatwoot_inbox_id) VALUES ('user1', '120363313346913103@g.us', 42, 10, 7)`); err != nil {
+		t.Fatalf("Failed to insert conversation: %v", err)
+	}
+
+	convID, found, err := s.findConversation("user1", "120363313346913103@g.us")
+	if err != nil || !found || convID != 42 {
+		t.Errorf("Expected conversation 42, got %d, %v, %v", convID, found, err)
+	}
+
+	_, found, err = s.findConversation("user1", "5511999999999@s.whatsapp.net")
+	if err != nil || found {
+		t.Errorf("Expected no conversation for unknown chat, got %v, %v", found, err)
+	}
+
+	var count int
+	if err := db.Get(&count, `SELECT COUNT(*) FROM chatwoot_conversations`); err != nil || count != 1 {
+		t.Errorf("Expected lookups not to create conversations, got %d rows (%v)", count, err)
+	}
+}
+
+func TestFormatToE164Regions(t *testing.T) {
+	t.Cleanup(func() { DefaultPhoneRegion = "" })
+
+	tests := []struct {
+		region string
+		phone  string
+		want   string
+	}{
+		// JIDs always carry the country code
+		{"US", "5511999999999:84@s.whatsapp.net", "+5511999999999"},
+		{"BR", "(11) 99999-9999", "+5511999999999"},
+		{"BR", "011 99999-9999", "+5511999999999"},
+		{"US", "(202) 555-0123", "+12025550123"},
+		{"US", "1 202 555 0123", "+12025550123"},
+		{"GB", "020 7946 0958", "+442079460958"},
+		{"GB", "07911 123456", "+447911123456"},
+		{"DE", "030 123456", "+4930123456"},
+		{"IN", "098765 43210", "+919876543210"},
+		{"AR", "011 15-2345-6789", "+5491123456789"},
+		{"MX", "55 1234 5678", "+525512345678"},
+		// International numbers ignore the region
+		{"BR", "+44 20 7946 0958", "+442079460958"},
+		{"GB", "00 1 202 555 0123", "+12025550123"},
+		// Without a region, or when parsing fails, the digits are kept
+		{"", "(11) 99999-9999", "+11999999999"},
+		{"US", "12345", "+12345"},
+		{"", "+55 11 99999-9999", "+5511999999999"},
+	}
+	for _, tt := range tests {
+		if err := SetDefaultPhoneRegion(tt.region); err != nil {
+			t.Fatalf("SetDefaultPhoneRegion(%q): %v", tt.region, err)
+		}
+		if got := formatToE164(tt.phone); got != tt.want {
+			t.Errorf("formatToE164(%q) in %q = %q, want %q", tt.phone, tt.region, got, tt.want)
+		}
+	}
+
+	if err := SetDefaultPhoneRegion("XX"); err == nil {
+		t.Error("expected an unknown region to be rejected")
+	}
+	if err := SetDefaultPhoneRegion("br"); err != nil || DefaultPhoneRegion != "BR" {
+		t.Errorf("expected the region to be upper cased, got %q (%v)", DefaultPhoneRegion, err)
+	}
+}
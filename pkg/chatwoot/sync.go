@@ -0,0 +1,152 @@
+package chatwoot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+)
+
+// Defaults for contact resync and import. Contacts are started at a fixed
+// pace of one per ContactSyncInterval, whatever the Chatwoot calls each one
+// makes, so concurrency only hides request latency.
+var (
+	ContactSyncConcurrency = 4
+	ContactSyncInterval    = 100 * time.Millisecond
+)
+
+// SyncContact is a WhatsApp contact to upsert into Chatwoot
+type SyncContact struct {
+	JID   string
+	Phone string
+	Name  string
+}
+
+// SyncFailure describes a contact that could not be synced
+type SyncFailure struct {
+	JID   string `json:"jid"`
+	Error string `json:"error"`
+}
+
+// SyncSummary reports the outcome of a contact resync
+type SyncSummary struct {
+	Total    int           `json:"total"`
+	Created  int           `json:"created"`
+	Found    int           `json:"found"`
+	Failed   int           `json:"failed"`
+	Skipped  int           `json:"skipped,omitempty"`
+	Failures []SyncFailure `json:"failures,omitempty"`
+}
+
+// SyncContacts upserts contacts into Chatwoot for userID using ensureContact,
+// with at most ContactSyncConcurrency requests in flight and one contact
+// started per ContactSyncInterval. It stops early when ctx is cancelled.
+// Avatars are read from waClient when it is not nil.
+func (s *Service) SyncContacts(ctx context.Context, userID string, contacts []SyncContact, waClient *whatsmeow.Client) (*SyncSummary, error) {
+	config, err := s.getConfig(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chatwoot config: %w", err)
+	}
+	if !config.Enabled {
+		return nil, fmt.Errorf("chatwoot is disabled for this user")
+	}
+	if !config.InboxID.Valid || config.InboxID.Int64 == 0 {
+		return nil, fmt.Errorf("inbox_id not configured")
+	}
+
+	var avatars avatarFetcher
+	if waClient != nil {
+		avatars = waClient
+	}
+	return s.syncContacts(ctx, NewClient(config), config, avatars, contacts), nil
+}
+
+func (s *Service) syncContacts(ctx context.Context, client *Client, config *Config, avatars avatarFetcher, contacts []SyncContact) *SyncSummary {
+	summary := &SyncSummary{Total: len(contacts)}
+	var mu sync.Mutex
+
+	forEachLimited(ctx, len(contacts), func(i int) {
+		contact := contacts[i]
+		_, created, err := s.ensureContact(client, config, avatars, contact.Phone, contact.Name, contact.JID)
+
+		mu.Lock()
+		switch {
+		case err != nil:
+			summary.Failed++
+			summary.Failures = append(summary.Failures, SyncFailure{JID: contact.JID, Error: err.Error()})
+		case created:
+			summary.Created++
+		default:
+			summary.Found++
+		}
+		mu.Unlock()
+
+		if err != nil {
+			log.Warn().Err(err).Str("jid", contact.JID).Msg("Failed to sync contact to Chatwoot")
+		}
+	})
+
+	// Contacts never started because ctx was cancelled
+	summary.Skipped = summary.Total - summary.Created - summary.Found - summary.Failed
+
+	log.Info().
+		Int("total", summary.Total).
+		Int("created", summary.Created).
+		Int("found", summary.Found).
+		Int("failed", summary.Failed).
+		Int("skipped", summary.Skipped).
+		Msg("Chatwoot contact sync finished")
+
+	return summary
+}
+
+// forEachLimited calls do for each index below count, with at most
+// ContactSyncConcurrency calls in flight and one started per
+// ContactSyncInterval. Indexes not started when ctx is cancelled are skipped.
+func forEachLimited(ctx context.Context, count int, do func(i int)) {
+	workers := ContactSyncConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var limiter <-chan time.Time
+	if ContactSyncInterval > 0 {
+		ticker := time.NewTicker(ContactSyncInterval)
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				do(i)
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < count; i++ {
+		if limiter != nil {
+			select {
+			case <-ctx.Done():
+				break feed
+			case <-limiter:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
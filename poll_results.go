@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.mau.fi/whatsmeow/util/gcmutil"
+	"go.mau.fi/whatsmeow/util/hkdfutil"
+	"google.golang.org/protobuf/proto"
+)
+
+// pollRetention is how long a poll is remembered after its last activity.
+// Votes for polls older than that can no longer be decrypted or tallied.
+const pollRetention = 7 * 24 * time.Hour
+
+var errPollNotTracked = errors.New("poll not tracked")
+
+// trackedPoll keeps what is needed to decrypt and tally the votes of a poll:
+// the message secret of the poll message and its option names. Selectable is
+// how many options a voter may pick, 0 when there is no limit.
+type trackedPoll struct {
+	sync.Mutex
+	ID         string
+	Chat       types.JID
+	Sender     types.JID
+	Question   string
+	Options    []string
+	Secret     []byte
+	Selectable int
+	hashes     map[[sha256.Size]byte]string
+	votes      map[string][]string
+}
+
+// pollOptionTally is the number of votes for one option and who cast them
+type pollOptionTally struct {
+	Name   string   `json:"name"`
+	Votes  int      `json:"votes"`
+	Voters []string `json:"voters"`
+}
+
+// pollResults is the payload of the PollResults event, sent after every vote
+type pollResults struct {
+	PollID          string            `json:"pollId"`
+	Chat            string            `json:"chat"`
+	Question        string            `json:"question"`
+	SelectableCount int               `json:"selectableCount"`
+	Options         []pollOptionTally `json:"options"`
+	TotalVoters     int               `json:"totalVoters"`
+	Voter           string            `json:"voter"`
+	Selected        []string          `json:"selected"`
+}
+
+// pollVoteDecrypter decrypts a vote using keys held elsewhere, such as the
+// whatsmeow store
+type pollVoteDecrypter func(evt *events.Message) (*waE2E.PollVoteMessage, error)
+
+// pollTracker remembers the polls of every session so their votes can be
+// aggregated. Polls are only held in memory and forgotten on restart.
+type pollTracker struct {
+	polls *cache.Cache
+}
+
+func newPollTracker() *pollTracker {
+	return &pollTracker{polls: cache.New(pollRetention, time.Hour)}
+}
+
+var polls = newPollTracker()
+
+func pollKey(userID, pollID string) string {
+	return userID + "|" + pollID
+}
+
+// pollCreation returns the poll carried by msg, whatever version was used
+func pollCreation(msg *waE2E.Message) *waE2E.PollCreationMessage {
+	for _, poll := range []*waE2E.PollCreationMessage{
+		msg.GetPollCreationMessage(),
+		msg.GetPollCreationMessageV2(),
+		msg.GetPollCreationMessageV3(),
+		msg.GetPollCreationMessageV5(),
+	} {
+		if poll != nil {
+			return poll
+		}
+	}
+	return nil
+}
+
+// track remembers the poll in msg, sent by sender to chat. It reports false
+// when msg is not a poll or lacks the secret needed to decrypt its votes.
+func (t *pollTracker) track(userID, pollID string, chat, sender types.JID, msg *waE2E.Message) bool {
+	creation := pollCreation(msg)
+	secret := msg.GetMessageContextInfo().GetMessageSecret()
+	if creation == nil || len(secret) == 0 {
+		return false
+	}
+
+	poll := &trackedPoll{
+		ID:         pollID,
+		Chat:       chat,
+		Sender:     sender,
+		Question:   creation.GetName(),
+		Secret:     secret,
+		Selectable: int(creation.GetSelectableOptionsCount()),
+		hashes:     make(map[[sha256.Size]byte]string),
+		votes:      make(map[string][]string),
+	}
+	for _, option := range creation.GetOptions() {
+		name := option.GetOptionName()
+		poll.Options = append(poll.Options, name)
+		poll.hashes[sha256.Sum256([]byte(name))] = name
+	}
+
+	t.polls.Set(pollKey(userID, pollID), poll, cache.DefaultExpiration)
+	return true
+}
+
+// vote decrypts the poll update in evt, records it as the voter's current
+// choice and returns the updated tally. fallback, when set, is tried if the
+// tracked secret does not decrypt the vote.
+func (t *pollTracker) vote(userID string, evt *events.Message, fallback pollVoteDecrypter) (*pollResults, error) {
+	update := evt.Message.GetPollUpdateMessage()
+	if update == nil {
+		return nil, errors.New("not a poll update")
+	}
+
+	key := pollKey(userID, update.GetPollCreationMessageKey().GetID())
+	cached, found := t.polls.Get(key)
+	if !found {
+		return nil, errPollNotTracked
+	}
+	poll := cached.(*trackedPoll)
+
+	vote, err := decryptPollVote(poll, evt)
+	if err != nil && fallback != nil {
+		vote, err = fallback(evt)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	poll.Lock()
+	defer poll.Unlock()
+
+	selected := make([]string, 0, len(vote.GetSelectedOptions()))
+	for _, hash := range vote.GetSelectedOptions() {
+		var sum [sha256.Size]byte
+		copy(sum[:], hash)
+		if name, ok := poll.hashes[sum]; ok {
+			selected = append(selected, name)
+		}
+	}
+
+	// A new vote replaces the previous one, an empty vote withdraws it
+	voter := evt.Info.Sender.ToNonAD().String()
+	if len(selected) == 0 {
+		delete(poll.votes, voter)
+	} else {
+		poll.votes[voter] = selected
+	}
+	t.polls.Set(key, poll, cache.DefaultExpiration)
+
+	results := poll.tally()
+	results.Voter = voter
+	results.Selected = selected
+	return results, nil
+}
+
+// tally counts the current votes of every option. The caller holds the lock.
+func (p *trackedPoll) tally() *pollResults {
+	results := &pollResults{
+		PollID:          p.ID,
+		Chat:            p.Chat.String(),
+		Question:        p.Question,
+		SelectableCount: p.Selectable,
+		TotalVoters:     len(p.votes),
+	}
+	for _, name := range p.Options {
+		option := pollOptionTally{Name: name, Voters: []string{}}
+		for voter, choices := range p.votes {
+			for _, choice := range choices {
+				if choice == name {
+					option.Voters = append(option.Voters, voter)
+					break
+				}
+			}
+		}
+		sort.Strings(option.Voters)
+		option.Votes = len(option.Voters)
+		results.Options = append(results.Options, option)
+	}
+	return results
+}
+
+// decryptPollVote decrypts a vote with the secret of the tracked poll, the
+// way WhatsApp derives the key for poll vote modifications
+func decryptPollVote(poll *trackedPoll, evt *events.Message) (*waE2E.PollVoteMessage, error) {
+	encrypted := evt.Message.GetPollUpdateMessage().GetVote()
+	key, additionalData := pollVoteKey(poll, evt.Info.Sender)
+	plaintext, err := gcmutil.Decrypt(key, encrypted.GetEncIV(), encrypted.GetEncPayload(), additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt poll vote: %w", err)
+	}
+
+	var vote waE2E.PollVoteMessage
+	if err := proto.Unmarshal(plaintext, &vote); err != nil {
+		return nil, fmt.Errorf("failed to decode poll vote: %w", err)
+	}
+	return &vote, nil
+}
+
+// pollVoteKey derives the encryption key and additional data of a vote cast
+// by voter on poll
+func pollVoteKey(poll *trackedPoll, voter types.JID) ([]byte, []byte) {
+	voterStr := voter.ToNonAD().String()
+	useCase := poll.ID + poll.Sender.ToNonAD().String() + voterStr + "Poll Vote"
+	key := hkdfutil.SHA256(poll.Secret, nil, []byte(useCase), 32)
+	return key, fmt.Appendf(nil, "%s\x00%s", poll.ID, voterStr)
+}
+
+// summary renders the results as text for Chatwoot notes
+func (r *pollResults) summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Poll results: %s\n", r.Question)
+	for _, option := range r.Options {
+		fmt.Fprintf(&b, "- %s: %d\n", option.Name, option.Votes)
+	}
+	fmt.Fprintf(&b, "Total voters: %d", r.TotalVoters)
+	return b.String()
+}
+
+// handlePollMessage tracks polls and turns votes into PollResults events,
+// delivered to the webhooks and as a private note to Chatwoot
+func (mycli *MyClient) handlePollMessage(evt *events.Message) {
+	if polls.track(mycli.userID, evt.Info.ID, evt.Info.Chat, evt.Info.Sender, evt.Message) {
+		return
+	}
+	if evt.Message.GetPollUpdateMessage() == nil {
+		return
+	}
+
+	results, err := polls.vote(mycli.userID, evt, func(evt *events.Message) (*waE2E.PollVoteMessage, error) {
+		return mycli.WAClient.DecryptPollVote(context.Background(), evt)
+	})
+	if err != nil {
+		log.Debug().Err(err).Str("id", evt.Info.ID).Msg("Could not aggregate poll vote")
+		return
+	}
+
+	postmap := map[string]interface{}{
+		"type":  "PollResults",
+		"event": results,
+	}
+	sendEventWithWebHook(mycli, postmap, "")
+
+	go func() {
+		if err := chatwootService(mycli.db).PostPrivateNote(mycli.userID, results.Chat, results.summary()); err != nil {
+			log.Debug().Err(err).Str("poll", results.PollID).Msg("Failed to post poll results to Chatwoot")
+		}
+	}()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// maxPresenceSubscriptions caps the number of contacts subscribed to in one call
+const maxPresenceSubscriptions = 256
+
+// presenceSubscriber is the part of the whatsmeow client used to subscribe to
+// presence updates
+type presenceSubscriber interface {
+	SubscribePresence(ctx context.Context, jid types.JID) error
+}
+
+// presenceSubscription is the outcome of subscribing to one contact
+type presenceSubscription struct {
+	Input      string `json:"input"`
+	Jid        string `json:"jid,omitempty"`
+	Subscribed bool   `json:"subscribed"`
+	Error      string `json:"error,omitempty"`
+}
+
+// presenceSharing reports what WhatsApp shares with the account given its own
+// privacy settings. Hiding your last seen hides everyone's from you, and when
+// online follows last seen the online status is hidden as well.
+func presenceSharing(settings types.PrivacySettings) (online bool, lastSeen bool) {
+	lastSeen = settings.LastSeen != types.PrivacySettingNone
+	online = lastSeen || settings.Online != types.PrivacySettingMatchLastSeen
+	return online, lastSeen
+}
+
+// subscribePresences subscribes to the presence of each user in inputs.
+// Groups and other non-user JIDs are refused, and inputs naming the same user
+// are subscribed once, keeping the order of their first appearance.
+func subscribePresences(ctx context.Context, subscriber presenceSubscriber, inputs []string) []presenceSubscription {
+	subscriptions := make([]presenceSubscription, 0, len(inputs))
+	seen := make(map[string]bool)
+
+	for _, input := range inputs {
+		input = strings.TrimSpace(input)
+		if input == "" {
+			continue
+		}
+		jid, ok := parseJID(input)
+		if !ok || jid.User == "" || (jid.Server != types.DefaultUserServer && jid.Server != types.HiddenUserServer) {
+			if !seen[input] {
+				seen[input] = true
+				subscriptions = append(subscriptions, presenceSubscription{Input: input, Error: "invalid jid format"})
+			}
+			continue
+		}
+		jid = jid.ToNonAD()
+		key := jid.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		subscription := presenceSubscription{Input: input, Jid: key}
+		if err := subscriber.SubscribePresence(ctx, jid); err != nil {
+			log.Warn().Err(err).Str("jid", key).Msg("Failed to subscribe to presence")
+			subscription.Error = err.Error()
+		} else {
+			subscription.Subscribed = true
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// privacySettingValues are the values WhatsApp accepts for each privacy setting
+var privacySettingValues = map[types.PrivacySettingType][]types.PrivacySetting{
+	types.PrivacySettingTypeGroupAdd:     {types.PrivacySettingAll, types.PrivacySettingContacts, types.PrivacySettingContactBlacklist, types.PrivacySettingNone},
+	types.PrivacySettingTypeLastSeen:     {types.PrivacySettingAll, types.PrivacySettingContacts, types.PrivacySettingContactBlacklist, types.PrivacySettingNone},
+	types.PrivacySettingTypeStatus:       {types.PrivacySettingAll, types.PrivacySettingContacts, types.PrivacySettingContactBlacklist, types.PrivacySettingNone},
+	types.PrivacySettingTypeProfile:      {types.PrivacySettingAll, types.PrivacySettingContacts, types.PrivacySettingContactBlacklist, types.PrivacySettingNone},
+	types.PrivacySettingTypeReadReceipts: {types.PrivacySettingAll, types.PrivacySettingNone},
+	types.PrivacySettingTypeOnline:       {types.PrivacySettingAll, types.PrivacySettingMatchLastSeen},
+	types.PrivacySettingTypeCallAdd:      {types.PrivacySettingAll, types.PrivacySettingKnown},
+}
+
+// parsePrivacySetting checks that value is allowed for setting, both matched
+// case-insensitively
+func parsePrivacySetting(setting, value string) (types.PrivacySettingType, types.PrivacySetting, error) {
+	name := types.PrivacySettingType(strings.ToLower(strings.TrimSpace(setting)))
+	allowed, found := privacySettingValues[name]
+	if !found {
+		return "", "", fmt.Errorf("unknown privacy setting %q, must be one of groupadd, last, status, profile, readreceipts, online or calladd", setting)
+	}
+	wanted := types.PrivacySetting(strings.ToLower(strings.TrimSpace(value)))
+	names := make([]string, 0, len(allowed))
+	for _, candidate := range allowed {
+		if wanted == candidate {
+			return name, wanted, nil
+		}
+		names = append(names, string(candidate))
+	}
+	return "", "", fmt.Errorf("invalid value %q for privacy setting %s, must be one of %s", value, name, strings.Join(names, ", "))
+}
+
+// Gets the privacy settings of the account
+func (s *server) GetPrivacySettings() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+		settings, err := client.TryFetchPrivacySettings(ctx, r.URL.Query().Get("refresh") == "true")
+		if err != nil {
+			log.Error().Err(err).Str("userID", txtid).Msg("Failed to get privacy settings")
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("failed to get privacy settings: %v", err))
+			return
+		}
+
+		responseJson, err := json.Marshal(settings)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Changes one privacy setting of the account
+func (s *server) SetPrivacySetting() http.HandlerFunc {
+
+	type privacyStruct struct {
+		Setting string
+		Value   string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		var t privacyStruct
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+		name, value, err := parsePrivacySetting(t.Setting, t.Value)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+		settings, err := client.SetPrivacySetting(ctx, name, value)
+		if err != nil {
+			log.Error().Err(err).Str("userID", txtid).Str("setting", string(name)).Msg("Failed to set privacy setting")
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("failed to set privacy setting: %v", err))
+			return
+		}
+		log.Info().Str("userID", txtid).Str("setting", string(name)).Str("value", string(value)).Msg("Privacy setting changed")
+
+		responseJson, err := json.Marshal(settings)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,8 +22,248 @@ var (
 	rabbitEnabled bool
 	rabbitOnce    sync.Once
 	rabbitQueue   string
+
+	// rabbitMaxBody is the largest message body published, in bytes. Zero
+	// disables the check.
+	rabbitMaxBody int64 = 16 * 1024 * 1024
+
+	// rabbitQueueOptions are applied to every queue declared before publishing
+	rabbitQueueOptions = defaultRabbitQueueConfig
 )
 
+// rabbitQueueConfig holds the type and flags queues are declared with
+type rabbitQueueConfig struct {
+	Type       string
+	Durable    bool
+	AutoDelete bool
+	Exclusive  bool
+}
+
+var defaultRabbitQueueConfig = rabbitQueueConfig{Type: "classic", Durable: true}
+
+// queueDeclarer is the part of an amqp091 channel used to declare queues
+type queueDeclarer interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp091.Table) (amqp091.Queue, error)
+}
+
+// loadRabbitQueueConfig reads the queue options from RABBITMQ_QUEUE_TYPE,
+// RABBITMQ_QUEUE_DURABLE, RABBITMQ_QUEUE_AUTO_DELETE and
+// RABBITMQ_QUEUE_EXCLUSIVE, keeping the defaults for unset variables
+func loadRabbitQueueConfig(getenv func(string) string) (rabbitQueueConfig, error) {
+	config := defaultRabbitQueueConfig
+	if v := getenv("RABBITMQ_QUEUE_TYPE"); v != "" {
+		config.Type = strings.ToLower(strings.TrimSpace(v))
+	}
+	flags := []struct {
+		env   string
+		value *bool
+	}{
+		{"RABBITMQ_QUEUE_DURABLE", &config.Durable},
+		{"RABBITMQ_QUEUE_AUTO_DELETE", &config.AutoDelete},
+		{"RABBITMQ_QUEUE_EXCLUSIVE", &config.Exclusive},
+	}
+	for _, flag := range flags {
+		v := getenv(flag.env)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid %s %q, use true or false", flag.env, v)
+		}
+		*flag.value = b
+	}
+	return config, config.validate()
+}
+
+// validate rejects combinations RabbitMQ refuses to declare
+func (c rabbitQueueConfig) validate() error {
+	switch c.Type {
+	case "classic":
+		return nil
+	case "quorum":
+		if c.Exclusive {
+			return errors.New("quorum queues cannot be exclusive")
+		}
+		if c.AutoDelete {
+			return errors.New("quorum queues cannot be auto-delete")
+		}
+		if !c.Durable {
+			return errors.New("quorum queues must be durable")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid queue type %q, use classic or quorum", c.Type)
+}
+
+// arguments returns the declare arguments for the queue type. Classic
+// queues are declared without arguments, as before the type was configurable.
+func (c rabbitQueueConfig) arguments() amqp091.Table {
+	if c.Type == "quorum" {
+		return amqp091.Table{"x-queue-type": "quorum"}
+	}
+	return nil
+}
+
+// declareRabbitQueue declares name with config (idempotent)
+func declareRabbitQueue(ch queueDeclarer, name string, config rabbitQueueConfig) error {
+	_, err := ch.QueueDeclare(
+		name,
+		config.Durable,
+		config.AutoDelete,
+		config.Exclusive,
+		false, // no-wait
+		config.arguments(),
+	)
+	return err
+}
+
+// rabbitPinger is the part of an amqp091 channel used to check publishing
+type rabbitPinger interface {
+	queueDeclarer
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) error
+	Get(queue string, autoAck bool) (amqp091.Delivery, bool, error)
+	QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error)
+}
+
+// rabbitChannelOpener opens the channel a ping runs on and returns it with
+// the function closing it
+type rabbitChannelOpener func() (rabbitPinger, func(), error)
+
+// openRabbitPingChannel returns an opener of short-lived channels on conn, so
+// a ping never shares the channel events are published on, nil when conn is
+// not connected
+func openRabbitPingChannel(conn *amqp091.Connection) rabbitChannelOpener {
+	if conn == nil || conn.IsClosed() {
+		return nil
+	}
+	return func() (rabbitPinger, func(), error) {
+		ch, err := conn.Channel()
+		if err != nil {
+			return nil, nil, err
+		}
+		return ch, func() {
+			if err := ch.Close(); err != nil {
+				log.Warn().Err(err).Msg("Could not close RabbitMQ test channel")
+			}
+		}, nil
+	}
+}
+
+// rabbitPingTimeout is how long a ping waits for its message to come back
+var rabbitPingTimeout = 3 * time.Second
+
+// rabbitStatus reports the RabbitMQ configuration and, when asked, whether a
+// ping message could be published and read back
+type rabbitStatus struct {
+	Status        string `json:"status"`
+	Enabled       bool   `json:"rabbitEnabled"`
+	URLConfigured bool   `json:"url_configured"`
+	URL           string `json:"url,omitempty"`
+	Queue         string `json:"queue"`
+	QueueType     string `json:"queue_type"`
+	Ping          string `json:"ping,omitempty"`
+	PingLatencyMs int64  `json:"ping_latency_ms,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// redactRabbitURL strips the credentials from an AMQP URL
+func redactRabbitURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "(invalid url)"
+	}
+	u.User = nil
+	return u.String()
+}
+
+// checkRabbit reports the RabbitMQ status, connected when open is set. With
+// ping a message is published to a temporary queue on a channel from open and
+// read back, confirming publishing works end to end; the queue and the
+// channel are closed afterwards.
+func checkRabbit(rabbitURL string, enabled bool, open rabbitChannelOpener, ping bool) rabbitStatus {
+	status := rabbitStatus{
+		Enabled:       enabled,
+		URLConfigured: rabbitURL != "",
+		Queue:         rabbitQueue,
+		QueueType:     rabbitQueueOptions.Type,
+	}
+	if rabbitURL == "" {
+		status.Status = "disabled"
+		return status
+	}
+	status.URL = redactRabbitURL(rabbitURL)
+	if !enabled || open == nil {
+		status.Status = "disconnected"
+		return status
+	}
+	status.Status = "connected"
+	if !ping {
+		return status
+	}
+
+	start := time.Now()
+	ch, closeChannel, err := open()
+	if err != nil {
+		status.Status = "ping_failed"
+		status.Ping = "failed"
+		status.Error = fmt.Sprintf("could not open test channel: %v", err)
+		return status
+	}
+	defer closeChannel()
+	if err := pingRabbit(ch); err != nil {
+		status.Status = "ping_failed"
+		status.Ping = "failed"
+		status.Error = err.Error()
+		return status
+	}
+	status.Ping = "ok"
+	status.PingLatencyMs = time.Since(start).Milliseconds()
+	return status
+}
+
+// pingRabbit publishes a message to a server-named, exclusive queue and waits
+// for it to be delivered back
+func pingRabbit(ch rabbitPinger) error {
+	queue, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return fmt.Errorf("could not declare test queue: %w", err)
+	}
+	defer func() {
+		if _, err := ch.QueueDelete(queue.Name, false, false, false); err != nil {
+			log.Warn().Err(err).Str("queue", queue.Name).Msg("Could not delete RabbitMQ test queue")
+		}
+	}()
+
+	body := []byte(fmt.Sprintf(`{"type":"Ping","timestamp":%d}`, time.Now().UnixNano()))
+	err = ch.Publish("", queue.Name, false, false, amqp091.Publishing{ContentType: "application/json", Body: body})
+	if err != nil {
+		return fmt.Errorf("could not publish test message: %w", err)
+	}
+
+	deadline := time.Now().Add(rabbitPingTimeout)
+	for {
+		msg, ok, err := ch.Get(queue.Name, true)
+		if err != nil {
+			return fmt.Errorf("could not read test message: %w", err)
+		}
+		if ok {
+			if !bytes.Equal(msg.Body, body) {
+				return errors.New("test message came back altered")
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("test message was not delivered in time")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// rabbitOmittedFields are the large payload fields dropped from events over
+// rabbitMaxBody. Media stays reachable through the s3 field when S3 is on.
+var rabbitOmittedFields = []string{"base64", "raw"}
+
 const (
 	maxRetries    = 10
 	retryInterval = 3 * time.Second
@@ -32,12 +278,24 @@ func InitRabbitMQ() {
 		rabbitQueue = "whatsapp_events" // default queue
 	}
 
+	if v := os.Getenv("RABBITMQ_MAX_BODY_MB"); v != "" {
+		if size, err := strconv.Atoi(v); err == nil {
+			rabbitMaxBody = int64(size) * 1024 * 1024
+		}
+	}
+
 	if rabbitURL == "" {
 		rabbitEnabled = false
 		log.Info().Msg("RABBITMQ_URL is not set. RabbitMQ publishing disabled.")
 		return
 	}
 
+	queueOptions, err := loadRabbitQueueConfig(os.Getenv)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid RabbitMQ queue configuration")
+	}
+	rabbitQueueOptions = queueOptions
+
 	// Attempt to connect with retry
 	for attempt := 1; attempt <= maxRetries; attempt++ {
 		log.Info().
@@ -101,6 +359,7 @@ func InitRabbitMQ() {
 
 		log.Info().
 			Str("queue", rabbitQueue).
+			Str("queue_type", rabbitQueueOptions.Type).
 			Int("attempt", attempt).
 			Msg("RabbitMQ connection established successfully")
 
@@ -175,15 +434,13 @@ func PublishToRabbit(data []byte, queueOverride ...string) error {
 	if len(queueOverride) > 0 && queueOverride[0] != "" {
 		queueName = queueOverride[0]
 	}
+	if rabbitBodyTooLarge(data) {
+		err := fmt.Errorf("message body of %d bytes exceeds the %d bytes limit", len(data), rabbitMaxBody)
+		log.Error().Err(err).Str("queue", queueName).Msg("Not publishing oversized message to RabbitMQ")
+		return err
+	}
 	// Declare queue (idempotent)
-	_, err := rabbitChannel.QueueDeclare(
-		queueName,
-		true,  // durable
-		false, // auto-delete
-		false, // exclusive
-		false, // no-wait
-		nil,   // arguments
-	)
+	err := declareRabbitQueue(rabbitChannel, queueName, rabbitQueueOptions)
 	if err != nil {
 		log.Error().Err(err).Str("queue", queueName).Msg("Could not declare RabbitMQ queue")
 		return err
@@ -252,21 +509,63 @@ func sendToGlobalRabbit(jsonData []byte, token string, userID string, queueName
 	originalData["instanceName"] = instance_name
 
 	// Marshal back to JSON
-	enhancedJSON, err := json.Marshal(originalData)
+	enhancedJSON, err := json.Marshal(payloadNaming.apply(originalData))
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to marshal enhanced data for RabbitMQ")
 		return
 	}
 
+	if rabbitBodyTooLarge(enhancedJSON) {
+		var fits bool
+		enhancedJSON, fits = shrinkRabbitPayload(originalData)
+		if !fits {
+			reason := fmt.Sprintf("event of %d bytes exceeds the RabbitMQ limit of %d bytes even without media", len(enhancedJSON), rabbitMaxBody)
+			log.Error().Str("userID", userID).Msg(reason)
+			PublishDataErrorToQueue(WebhookErrorPayload{
+				Payload:      map[string]interface{}{"type": originalData["type"], "userID": userID, "instanceName": instance_name},
+				UserID:       userID,
+				AttemptTime:  time.Now(),
+				ErrorMessage: reason,
+			})
+			return
+		}
+		log.Warn().Str("userID", userID).Int("size", len(enhancedJSON)).Msg("Event exceeded the RabbitMQ limit, published without media")
+	}
+
 	err = PublishToRabbit(enhancedJSON, queueName...)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to publish to RabbitMQ")
 	}
 }
 
+// rabbitBodyTooLarge reports whether data exceeds rabbitMaxBody
+func rabbitBodyTooLarge(data []byte) bool {
+	return rabbitMaxBody > 0 && int64(len(data)) > rabbitMaxBody
+}
+
+// shrinkRabbitPayload drops the media fields from an event, flagging it with
+// mediaOmitted, and reports whether the result fits in rabbitMaxBody
+func shrinkRabbitPayload(data map[string]interface{}) ([]byte, bool) {
+	shrunk := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		shrunk[k] = v
+	}
+	for _, field := range rabbitOmittedFields {
+		delete(shrunk, field)
+	}
+	shrunk["mediaOmitted"] = true
+
+	body, err := json.Marshal(payloadNaming.apply(shrunk))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal shrunk data for RabbitMQ")
+		return nil, false
+	}
+	return body, !rabbitBodyTooLarge(body)
+}
+
 func PublishFileErrorToQueue(payload WebhookFileErrorPayload) {
 
-	queueName := *webhookErrorQueueName
+	queueName := webhookErrorQueue()
 
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -283,7 +582,7 @@ func PublishFileErrorToQueue(payload WebhookFileErrorPayload) {
 }
 
 func PublishDataErrorToQueue(payload WebhookErrorPayload) {
-	queueName := *webhookErrorQueueName
+	queueName := webhookErrorQueue()
 	body, err := json.Marshal(payload)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to marshal data error payload for RabbitMQ")
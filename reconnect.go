@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// reconnectPolicy controls how a session reconnects after the connection to
+// WhatsApp drops. Delays double on every attempt, starting at BaseDelay and
+// capped at MaxDelay. MaxAttempts 0 retries until the session reconnects.
+type reconnectPolicy struct {
+	Enabled     bool
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var defaultReconnectPolicy = reconnectPolicy{
+	Enabled:     true,
+	MaxAttempts: 0,
+	BaseDelay:   2 * time.Second,
+	MaxDelay:    5 * time.Minute,
+}
+
+// reconnecting holds the user ids with a reconnect loop in progress
+var reconnecting sync.Map
+
+// backoff returns the delay before the given attempt (starting at 1), or
+// false when the policy does not allow it
+func (p reconnectPolicy) backoff(attempt int) (time.Duration, bool) {
+	if !p.Enabled || attempt < 1 || (p.MaxAttempts > 0 && attempt > p.MaxAttempts) {
+		return 0, false
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay, true
+}
+
+// reconnectReason reports whether evt is a connection loss the session
+// should recover from. Logouts, bans and replaced streams are final and are
+// never retried.
+func reconnectReason(evt interface{}) (string, bool) {
+	switch evt := evt.(type) {
+	case *events.Disconnected:
+		return "disconnected", true
+	case *events.KeepAliveTimeout:
+		// Same threshold whatsmeow uses before forcing a reconnect
+		if time.Since(evt.LastSuccess) > whatsmeow.KeepAliveMaxFailTime {
+			return "keepalive_timeout", true
+		}
+	}
+	return "", false
+}
+
+// shouldReconnect decides whether evt starts a reconnect loop under policy
+func shouldReconnect(evt interface{}, policy reconnectPolicy) (string, bool) {
+	if !policy.Enabled {
+		return "", false
+	}
+	return reconnectReason(evt)
+}
+
+// getReconnectPolicy loads the user's reconnect policy, falling back to the
+// defaults when it cannot be read
+func (s *server) getReconnectPolicy(userID string) reconnectPolicy {
+	var row struct {
+		Enabled     bool `db:"reconnect_enabled"`
+		MaxAttempts int  `db:"reconnect_max_attempts"`
+		BaseDelay   int  `db:"reconnect_base_delay"`
+		MaxDelay    int  `db:"reconnect_max_delay"`
+	}
+	err := s.db.Get(&row, `SELECT COALESCE(reconnect_enabled, TRUE) AS reconnect_enabled,
+		COALESCE(reconnect_max_attempts, 0) AS reconnect_max_attempts,
+		COALESCE(reconnect_base_delay, 2) AS reconnect_base_delay,
+		COALESCE(reconnect_max_delay, 300) AS reconnect_max_delay
+		FROM users WHERE id = $1`, userID)
+	if err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Could not load reconnect policy, using defaults")
+		return defaultReconnectPolicy
+	}
+
+	return reconnectPolicy{
+		Enabled:     row.Enabled,
+		MaxAttempts: row.MaxAttempts,
+		BaseDelay:   time.Duration(row.BaseDelay) * time.Second,
+		MaxDelay:    time.Duration(row.MaxDelay) * time.Second,
+	}
+}
+
+// handleReconnect starts a reconnect loop when evt is a connection loss and
+// the user's policy allows it. Only one loop runs per user at a time.
+func (mycli *MyClient) handleReconnect(evt interface{}) {
+	policy := mycli.s.getReconnectPolicy(mycli.userID)
+	reason, ok := shouldReconnect(evt, policy)
+	if !ok {
+		return
+	}
+
+	if _, running := reconnecting.LoadOrStore(mycli.userID, true); running {
+		log.Debug().Str("userID", mycli.userID).Str("reason", reason).Msg("Reconnect already in progress")
+		return
+	}
+	defer reconnecting.Delete(mycli.userID)
+
+	if reason == "keepalive_timeout" {
+		mycli.WAClient.Disconnect()
+	}
+	mycli.reconnect(reason, policy)
+}
+
+// reconnectAfterConnectFailure hands a session whose first connection failed
+// over to the user's reconnect policy, as no Disconnected event follows to
+// start it. It reports whether the session ended up connected.
+func (mycli *MyClient) reconnectAfterConnectFailure(err error) bool {
+	policy := mycli.s.getReconnectPolicy(mycli.userID)
+	if !policy.Enabled {
+		return false
+	}
+
+	if _, running := reconnecting.LoadOrStore(mycli.userID, true); running {
+		return false
+	}
+	defer reconnecting.Delete(mycli.userID)
+
+	log.Warn().Err(err).Str("userID", mycli.userID).Msg("Could not connect to WhatsApp, retrying under the reconnect policy")
+	mycli.reconnect("connect_failed", policy)
+	return mycli.WAClient.IsConnected()
+}
+
+func (mycli *MyClient) reconnect(reason string, policy reconnectPolicy) {
+	for attempt := 1; ; attempt++ {
+		delay, ok := policy.backoff(attempt)
+		if !ok {
+			log.Error().Str("userID", mycli.userID).Int("attempts", attempt-1).Msg("Giving up reconnecting to WhatsApp")
+			postmap := map[string]interface{}{
+				"type":     "ReconnectFailed",
+				"reason":   reason,
+				"attempts": attempt - 1,
+			}
+			sendEventWithWebHook(mycli, postmap, "")
+			return
+		}
+
+		log.Warn().Str("userID", mycli.userID).Int("attempt", attempt).Dur("wait_time", delay).Msg("Reconnecting to WhatsApp")
+		postmap := map[string]interface{}{
+			"type":    "Reconnecting",
+			"reason":  reason,
+			"attempt": attempt,
+			"delay":   delay.Seconds(),
+		}
+		sendEventWithWebHook(mycli, postmap, "")
+		time.Sleep(delay)
+
+		// Stop when the session was killed or replaced while waiting
+		if clientManager.GetWhatsmeowClient(mycli.userID) != mycli.WAClient {
+			return
+		}
+		if mycli.WAClient.IsConnected() {
+			return
+		}
+
+		err := mycli.WAClient.Connect()
+		if err == nil || errors.Is(err, whatsmeow.ErrAlreadyConnected) {
+			log.Info().Str("userID", mycli.userID).Int("attempt", attempt).Msg("Reconnected to WhatsApp")
+			return
+		}
+		log.Warn().Err(err).Str("userID", mycli.userID).Int("attempt", attempt).Msg("Failed to reconnect to WhatsApp")
+	}
+}
@@ -57,6 +57,14 @@ func (s *server) routes() {
 	adminRoutes.Handle("/users/{id}", s.EditUser()).Methods("PUT")
 	adminRoutes.Handle("/users/{id}", s.DeleteUser()).Methods("DELETE")
 	adminRoutes.Handle("/users/{id}/full", s.DeleteUserComplete()).Methods("DELETE")
+	adminRoutes.Handle("/users/{id}/diagnostics", s.UserDiagnostics()).Methods("GET")
+	adminRoutes.Handle("/users/{id}/config", s.UserEffectiveConfig()).Methods("GET")
+	adminRoutes.Handle("/sessions", s.ListSessions()).Methods("GET")
+	adminRoutes.Handle("/config/reload", s.ReloadConfig()).Methods("POST")
+	adminRoutes.Handle("/chatwoot/cache/purge", s.PurgeChatwootCache()).Methods("POST")
+	adminRoutes.Handle("/metrics", s.GetMetrics()).Methods("GET")
+	adminRoutes.Handle("/rabbitmq/test", s.TestRabbitMQ()).Methods("POST")
+	adminRoutes.Handle("/webhooks/replay", s.ReplayWebhookErrors()).Methods("POST")
 
 	c := alice.New()
 	c = c.Append(s.authalice)
@@ -78,10 +86,14 @@ func (s *server) routes() {
 	c = c.Append(hlog.RefererHandler("referer"))
 	c = c.Append(hlog.RequestIDHandler("req_id", "Request-Id"))
 
+	// Message sends also go through the global send rate limit
+	send := c.Append(s.sendRateLimit)
+
 	s.router.Handle("/session/connect", c.Then(s.Connect())).Methods("POST")
 	s.router.Handle("/session/disconnect", c.Then(s.Disconnect())).Methods("POST")
 	s.router.Handle("/session/logout", c.Then(s.Logout())).Methods("POST")
 	s.router.Handle("/session/status", c.Then(s.GetStatus())).Methods("GET")
+	s.router.Handle("/session/profile", c.Then(s.GetSessionProfile())).Methods("GET")
 	s.router.Handle("/session/qr", c.Then(s.GetQR())).Methods("GET")
 	s.router.Handle("/session/pairphone", c.Then(s.PairPhone())).Methods("POST")
 	s.router.Handle("/session/history", c.Then(s.RequestHistorySync())).Methods("GET")
@@ -90,6 +102,13 @@ func (s *server) routes() {
 	s.router.Handle("/webhook", c.Then(s.GetWebhook())).Methods("GET")
 	s.router.Handle("/webhook", c.Then(s.DeleteWebhook())).Methods("DELETE")
 	s.router.Handle("/webhook", c.Then(s.UpdateWebhook())).Methods("PUT")
+	s.router.Handle("/webhook/history", c.Then(s.GetWebhookHistory())).Methods("GET")
+	s.router.Handle("/webhook/template", c.Then(s.SetWebhookTemplate())).Methods("POST")
+	s.router.Handle("/webhook/template", c.Then(s.GetWebhookTemplate())).Methods("GET")
+	s.router.Handle("/webhook/template", c.Then(s.DeleteWebhookTemplate())).Methods("DELETE")
+	s.router.Handle("/webhook/cert", c.Then(s.SetWebhookClientCert())).Methods("POST")
+	s.router.Handle("/webhook/cert", c.Then(s.GetWebhookClientCert())).Methods("GET")
+	s.router.Handle("/webhook/cert", c.Then(s.DeleteWebhookClientCert())).Methods("DELETE")
 
 	s.router.Handle("/session/proxy", c.Then(s.SetProxy())).Methods("POST")
 	s.router.Handle("/session/history", c.Then(s.SetHistory())).Methods("POST")
@@ -103,38 +122,66 @@ func (s *server) routes() {
 	s.router.Handle("/session/hmac/config", c.Then(s.GetHmacConfig())).Methods("GET")
 	s.router.Handle("/session/hmac/config", c.Then(s.DeleteHmacConfig())).Methods("DELETE")
 
-	s.router.Handle("/chat/send/text", c.Then(s.SendMessage())).Methods("POST")
+	s.router.Handle("/session/reconnect/config", c.Then(s.ConfigureReconnect())).Methods("POST")
+	s.router.Handle("/session/reconnect/config", c.Then(s.GetReconnectConfig())).Methods("GET")
+	s.router.Handle("/session/autoread", c.Then(s.SetAutoRead())).Methods("POST")
+	s.router.Handle("/session/autoread", c.Then(s.GetAutoRead())).Methods("GET")
+	s.router.Handle("/session/privacy", c.Then(s.GetPrivacySettings())).Methods("GET")
+	s.router.Handle("/session/privacy", c.Then(s.SetPrivacySetting())).Methods("POST")
+
+	s.router.Handle("/chat/send/text", send.Then(s.SendMessage())).Methods("POST")
 	s.router.Handle("/chat/delete", c.Then(s.DeleteMessage())).Methods("POST")
-	s.router.Handle("/chat/send/image", c.Then(s.SendImage())).Methods("POST")
-	s.router.Handle("/chat/send/audio", c.Then(s.SendAudio())).Methods("POST")
-	s.router.Handle("/chat/send/document", c.Then(s.SendDocument())).Methods("POST")
-	//	s.router.Handle("/chat/send/template", c.Then(s.SendTemplate())).Methods("POST")
-	s.router.Handle("/chat/send/video", c.Then(s.SendVideo())).Methods("POST")
-	s.router.Handle("/chat/send/sticker", c.Then(s.SendSticker())).Methods("POST")
-	s.router.Handle("/chat/send/location", c.Then(s.SendLocation())).Methods("POST")
-	s.router.Handle("/chat/send/contact", c.Then(s.SendContact())).Methods("POST")
-	s.router.Handle("/chat/react", c.Then(s.React())).Methods("POST")
-	s.router.Handle("/chat/send/buttons", c.Then(s.SendButtons())).Methods("POST")
-	s.router.Handle("/chat/send/list", c.Then(s.SendList())).Methods("POST")
-	s.router.Handle("/chat/send/poll", c.Then(s.SendPoll())).Methods("POST")
-	s.router.Handle("/chat/send/edit", c.Then(s.SendEditMessage())).Methods("POST")
+	s.router.Handle("/chat/send/image", send.Then(s.SendImage())).Methods("POST")
+	s.router.Handle("/chat/send/audio", send.Then(s.SendAudio())).Methods("POST")
+	s.router.Handle("/chat/send/document", send.Then(s.SendDocument())).Methods("POST")
+	//	s.router.Handle("/chat/send/template", send.Then(s.SendTemplate())).Methods("POST")
+	s.router.Handle("/chat/send/video", send.Then(s.SendVideo())).Methods("POST")
+	s.router.Handle("/chat/send/sticker", send.Then(s.SendSticker())).Methods("POST")
+	s.router.Handle("/chat/send/location", send.Then(s.SendLocation())).Methods("POST")
+	s.router.Handle("/chat/send/location/live/update", send.Then(s.UpdateLiveLocation())).Methods("POST")
+	s.router.Handle("/chat/send/location/live/stop", send.Then(s.StopLiveLocation())).Methods("POST")
+	s.router.Handle("/chat/send/contact", send.Then(s.SendContact())).Methods("POST")
+	s.router.Handle("/chat/react", send.Then(s.React())).Methods("POST")
+	s.router.Handle("/chat/send/buttons", send.Then(s.SendButtons())).Methods("POST")
+	s.router.Handle("/chat/send/list", send.Then(s.SendList())).Methods("POST")
+	s.router.Handle("/chat/send/poll", send.Then(s.SendPoll())).Methods("POST")
+	s.router.Handle("/chat/send/edit", send.Then(s.SendEditMessage())).Methods("POST")
+	s.router.Handle("/chat/forward", send.Then(s.ForwardMessage())).Methods("POST")
 	s.router.Handle("/chat/history", c.Then(s.GetHistory())).Methods("GET")
+	s.router.Handle("/chat/status", c.Then(s.GetMessageStatus())).Methods("GET")
+	s.router.Handle("/chat/list", c.Then(s.ListChats())).Methods("GET")
+	s.router.Handle("/chat/meta", c.Then(s.SetChatMeta())).Methods("POST")
+	s.router.Handle("/chat/meta", c.Then(s.GetChatMeta())).Methods("GET")
+	s.router.Handle("/chat/history/export", c.Then(s.ExportHistory())).Methods("GET")
 	s.router.Handle("/chat/request-unavailable-message", c.Then(s.RequestUnavailableMessage())).Methods("POST")
 	s.router.Handle("/chat/archive", c.Then(s.ArchiveChat())).Methods("POST")
+	s.router.Handle("/chat/mute", c.Then(s.MuteChat(true))).Methods("POST")
+	s.router.Handle("/chat/unmute", c.Then(s.MuteChat(false))).Methods("POST")
+	s.router.Handle("/chat/pin", c.Then(s.PinChat(true))).Methods("POST")
+	s.router.Handle("/chat/unpin", c.Then(s.PinChat(false))).Methods("POST")
 
 	s.router.Handle("/status/set/text", c.Then(s.SetStatusMessage())).Methods("POST")
+	s.router.Handle("/status/set/image", c.Then(s.SetStatusImage())).Methods("POST")
+	s.router.Handle("/status/set/video", c.Then(s.SetStatusVideo())).Methods("POST")
 
 	s.router.Handle("/call/reject", c.Then(s.RejectCall())).Methods("POST")
 
 	s.router.Handle("/user/presence", c.Then(s.SendPresence())).Methods("POST")
+	s.router.Handle("/user/presence/subscribe", c.Then(s.SubscribePresence())).Methods("POST")
 	s.router.Handle("/user/info", c.Then(s.GetUser())).Methods("POST")
 	s.router.Handle("/user/check", c.Then(s.CheckUser())).Methods("POST")
+	s.router.Handle("/user/resolve", c.Then(s.ResolveUser())).Methods("POST")
 	s.router.Handle("/user/avatar", c.Then(s.GetAvatar())).Methods("POST")
+	s.router.Handle("/user/business", c.Then(s.GetBusinessProfile())).Methods("GET")
+	s.router.Handle("/user/business", c.Then(s.SetBusinessProfile())).Methods("POST")
 	s.router.Handle("/user/contacts", c.Then(s.GetContacts())).Methods("GET")
 	s.router.Handle("/user/lid/{jid}", c.Then(s.GetUserLID())).Methods("GET")
+	s.router.Handle("/user/lid/batch", c.Then(s.GetUserLIDs())).Methods("POST")
 
 	s.router.Handle("/chat/presence", c.Then(s.ChatPresence())).Methods("POST")
 	s.router.Handle("/chat/markread", c.Then(s.MarkRead())).Methods("POST")
+	s.router.Handle("/chat/markread/batch", c.Then(s.MarkReadBatch())).Methods("POST")
+	s.router.Handle("/chat/markunread", c.Then(s.MarkChatUnread())).Methods("POST")
 	s.router.Handle("/chat/downloadimage", c.Then(s.DownloadImage())).Methods("POST")
 	s.router.Handle("/chat/downloadvideo", c.Then(s.DownloadVideo())).Methods("POST")
 	s.router.Handle("/chat/downloadaudio", c.Then(s.DownloadAudio())).Methods("POST")
@@ -144,7 +191,9 @@ func (s *server) routes() {
 	s.router.Handle("/group/create", c.Then(s.CreateGroup())).Methods("POST")
 	s.router.Handle("/group/list", c.Then(s.ListGroups())).Methods("GET")
 	s.router.Handle("/group/info", c.Then(s.GetGroupInfo())).Methods("GET")
+	s.router.Handle("/group/participants", c.Then(s.GetGroupParticipants())).Methods("GET")
 	s.router.Handle("/group/invitelink", c.Then(s.GetGroupInviteLink())).Methods("GET")
+	s.router.Handle("/group/invitelink/revoke", c.Then(s.RevokeGroupInviteLink())).Methods("POST")
 	s.router.Handle("/group/photo", c.Then(s.SetGroupPhoto())).Methods("POST")
 	s.router.Handle("/group/photo/remove", c.Then(s.RemoveGroupPhoto())).Methods("POST")
 	s.router.Handle("/group/leave", c.Then(s.GroupLeave())).Methods("POST")
@@ -152,6 +201,7 @@ func (s *server) routes() {
 	s.router.Handle("/group/topic", c.Then(s.SetGroupTopic())).Methods("POST")
 	s.router.Handle("/group/announce", c.Then(s.SetGroupAnnounce())).Methods("POST")
 	s.router.Handle("/group/locked", c.Then(s.SetGroupLocked())).Methods("POST")
+	s.router.Handle("/group/settings", c.Then(s.SetGroupSettings())).Methods("POST")
 	s.router.Handle("/group/ephemeral", c.Then(s.SetDisappearingTimer())).Methods("POST")
 	s.router.Handle("/group/join", c.Then(s.GroupJoin())).Methods("POST")
 	s.router.Handle("/group/inviteinfo", c.Then(s.GetGroupInviteInfo())).Methods("POST")
@@ -162,6 +212,8 @@ func (s *server) routes() {
 	s.router.Handle("/chatwoot/config", c.Then(s.GetChatwootConfig())).Methods("GET")
 	s.router.Handle("/chatwoot/config", c.Then(s.SetChatwootConfig())).Methods("POST")
 	s.router.Handle("/chatwoot/config", c.Then(s.DeleteChatwootConfig())).Methods("DELETE")
+	s.router.Handle("/chatwoot/contacts/sync", c.Then(s.SyncChatwootContacts())).Methods("POST")
+	s.router.Handle("/chatwoot/contacts/import", c.Then(s.ImportChatwootContacts())).Methods("POST")
 
 	s.router.Handle("/newsletter/list", c.Then(s.ListNewsletter())).Methods("GET")
 
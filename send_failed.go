@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// errMediaRejected wraps a media upload failure, after which the message is
+// never sent
+var errMediaRejected = errors.New("media upload rejected")
+
+// sendFailureReason classifies why a message could not be sent
+func sendFailureReason(err error) string {
+	var disconnected *whatsmeow.DisconnectedError
+	switch {
+	case errors.Is(err, errMediaRejected):
+		return "media_rejected"
+	case errors.Is(err, whatsmeow.ErrServerReturnedError):
+		return "server_rejected"
+	case errors.Is(err, whatsmeow.ErrMessageTimedOut):
+		return "timeout"
+	case errors.Is(err, whatsmeow.ErrNotConnected), errors.Is(err, whatsmeow.ErrNotLoggedIn), errors.As(err, &disconnected):
+		return "not_connected"
+	case errors.Is(err, whatsmeow.ErrNoSession), errors.Is(err, whatsmeow.ErrUnknownServer),
+		errors.Is(err, whatsmeow.ErrRecipientADJID), errors.Is(err, whatsmeow.ErrBroadcastListUnsupported):
+		return "invalid_recipient"
+	}
+	return "send_error"
+}
+
+// sendFailedEvent builds the MessageSendFailed event for a message that
+// could not be sent
+func sendFailedEvent(chat types.JID, messageID, messageType string, err error) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "MessageSendFailed",
+		"event": map[string]interface{}{
+			"id":          messageID,
+			"chat":        chat.String(),
+			"messageType": messageType,
+			"reason":      sendFailureReason(err),
+			"error":       err.Error(),
+			"timestamp":   time.Now().Unix(),
+		},
+	}
+}
+
+// notifySendFailed emits MessageSendFailed once a send failed for good, so
+// callers that do not wait for the API response still learn about it
+func (s *server) notifySendFailed(userID string, chat types.JID, messageID, messageType string, err error) {
+	mycli := clientManager.GetMyClient(userID)
+	if mycli == nil {
+		return
+	}
+	log.Warn().Err(err).Str("userID", userID).Str("id", messageID).Str("chat", chat.String()).Msg("Message send failed")
+	sendEventWithWebHook(mycli, sendFailedEvent(chat, messageID, messageType, err), "")
+}
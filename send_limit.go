@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sendLimiter caps the messages sent by all sessions together. It is set
+// from -sendrate and -sendburst at startup, nil when there is no limit.
+var sendLimiter *tokenBucket
+
+// tokenBucket lets through rate events per second on average, with bursts
+// of up to burst events after a quiet period
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// newSendRateLimiter validates a global send limit. A rate of 0 disables
+// it, a burst of 0 allows as many messages at once as the rate.
+func newSendRateLimiter(rate, burst int) (*tokenBucket, error) {
+	if rate < 0 {
+		return nil, fmt.Errorf("invalid send rate %d", rate)
+	}
+	if burst < 0 {
+		return nil, fmt.Errorf("invalid send burst %d", burst)
+	}
+	if rate == 0 {
+		return nil, nil
+	}
+	if burst == 0 {
+		burst = rate
+	}
+	return newTokenBucket(float64(rate), burst, time.Now), nil
+}
+
+// newTokenBucket returns a full bucket reading the time from now
+func newTokenBucket(rate float64, burst int, now func() time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now(), now: now}
+}
+
+// take spends a token if one is left. Otherwise it returns how long until
+// the next one is available.
+func (b *tokenBucket) take() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// refund gives back a token spent on an event that did not happen
+func (b *tokenBucket) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// sendStatusRecorder remembers the status a send handler responded with
+type sendStatusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *sendStatusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sendStatusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// sendRateLimit refuses messages with 429 once the global send limit is
+// reached, telling clients when to retry with Retry-After. The token is
+// given back when the handler fails, as no message went out.
+func (s *server) sendRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sendLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := sendLimiter.take(); !ok {
+			txtid := r.Context().Value("userinfo").(Values).Get("Id")
+			log.Warn().Str("userID", txtid).Str("path", r.URL.Path).Dur("retry_after", wait).Msg("Refusing message, global send rate limit reached")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			s.Respond(w, r, http.StatusTooManyRequests, errors.New("global send rate limit reached, retry later"))
+			return
+		}
+
+		rec := &sendStatusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status >= 300 {
+			sendLimiter.refund()
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// sessionProfileTTL is how long the account's own profile is served from
+// the cache. Changes made through wuzapi or announced by WhatsApp drop it
+// earlier.
+const sessionProfileTTL = 10 * time.Minute
+
+// sessionProfileCache holds the profile of each user's account, by user id
+var sessionProfileCache = cache.New(sessionProfileTTL, 20*time.Minute)
+
+// sessionProfile is the profile of the logged in account
+type sessionProfile struct {
+	JID          string
+	PushName     string
+	BusinessName string                 `json:",omitempty"`
+	About        string                 `json:",omitempty"`
+	Avatar       map[string]interface{} `json:",omitempty"`
+	FetchedAt    int64
+}
+
+// forgetSessionProfileOf drops the cached profile of the user whose account
+// is jid, after WhatsApp announced a change to it
+func (mycli *MyClient) forgetSessionProfileOf(jid types.JID) {
+	if own := mycli.WAClient.Store.ID; own != nil && own.User == jid.User {
+		sessionProfileCache.Delete(mycli.userID)
+	}
+}
+
+// fetchSessionProfile reads the account's own profile from WhatsApp. The
+// about text and the avatar are left out when they cannot be read, rather
+// than failing the whole profile.
+func (s *server) fetchSessionProfile(ctx context.Context, txtid string, client *whatsmeow.Client) (*sessionProfile, error) {
+	if client.Store.ID == nil {
+		return nil, errors.New("session not logged in")
+	}
+	jid := client.Store.ID.ToNonAD()
+	profile := &sessionProfile{
+		JID:          jid.String(),
+		PushName:     client.Store.PushName,
+		BusinessName: client.Store.BusinessName,
+		FetchedAt:    time.Now().Unix(),
+	}
+
+	if info, err := client.GetUserInfo(ctx, []types.JID{jid}); err != nil {
+		log.Warn().Err(err).Str("userID", txtid).Msg("Failed to get own about text")
+	} else {
+		profile.About = info[jid].Status
+	}
+
+	pic, err := client.GetProfilePictureInfo(ctx, jid, &whatsmeow.GetProfilePictureParams{})
+	switch {
+	case errors.Is(err, whatsmeow.ErrProfilePictureNotSet) || (err == nil && pic == nil):
+	case err != nil:
+		log.Warn().Err(err).Str("userID", txtid).Msg("Failed to get own avatar")
+	default:
+		avatar, err := s.deliverAvatar(ctx, txtid, jid, pic)
+		if err != nil {
+			log.Warn().Err(err).Str("userID", txtid).Msg("Failed to download own avatar")
+			avatar = map[string]interface{}{"ID": pic.ID, "Type": pic.Type}
+		}
+		avatar["URL"] = pic.URL
+		profile.Avatar = avatar
+	}
+	return profile, nil
+}
+
+// Gets the profile of the logged in account: push name, about text and avatar
+func (s *server) GetSessionProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client, ok := s.readyClient(w, r, txtid)
+		if !ok {
+			return
+		}
+
+		var profile *sessionProfile
+		if cached, found := sessionProfileCache.Get(txtid); found && r.URL.Query().Get("refresh") != "true" {
+			profile = cached.(*sessionProfile)
+		} else {
+			ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+			defer cancel()
+			var err error
+			profile, err = s.fetchSessionProfile(ctx, txtid, client)
+			if err != nil {
+				s.Respond(w, r, http.StatusConflict, err)
+				return
+			}
+			sessionProfileCache.Set(txtid, profile, cache.DefaultExpiration)
+		}
+
+		responseJson, err := json.Marshal(profile)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
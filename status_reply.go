@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// messageContextInfo returns the context info of the message types that can
+// quote another message
+func messageContextInfo(msg *waE2E.Message) *waE2E.ContextInfo {
+	switch {
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetContextInfo()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage().GetContextInfo()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage().GetContextInfo()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetContextInfo()
+	}
+	return nil
+}
+
+// messageText returns the text or caption of a message
+func messageText(msg *waE2E.Message) string {
+	switch {
+	case msg.GetConversation() != "":
+		return msg.GetConversation()
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetText()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetCaption()
+	}
+	return ""
+}
+
+// statusReplyEvent builds the StatusReply event of a message replying to one
+// of our status updates. It returns nil for other messages.
+func statusReplyEvent(evt *events.Message) map[string]interface{} {
+	if evt.Info.IsFromMe {
+		return nil
+	}
+	contextInfo := messageContextInfo(evt.Message)
+	if contextInfo.GetRemoteJID() != types.StatusBroadcastJID.String() || contextInfo.GetStanzaID() == "" {
+		return nil
+	}
+	return map[string]interface{}{
+		"type": "StatusReply",
+		"event": map[string]interface{}{
+			"id":         evt.Info.ID,
+			"chat":       evt.Info.Chat.String(),
+			"sender":     evt.Info.Sender.String(),
+			"pushName":   evt.Info.PushName,
+			"statusId":   contextInfo.GetStanzaID(),
+			"statusText": messageText(contextInfo.GetQuotedMessage()),
+			"text":       messageText(evt.Message),
+			"timestamp":  evt.Info.Timestamp.Unix(),
+		},
+	}
+}
+
+// handleStatusReply turns replies to our status updates into StatusReply
+// events, delivered to the webhooks and as a private note to Chatwoot
+func (mycli *MyClient) handleStatusReply(evt *events.Message) {
+	postmap := statusReplyEvent(evt)
+	if postmap == nil {
+		return
+	}
+	sendEventWithWebHook(mycli, postmap, "")
+
+	event := postmap["event"].(map[string]interface{})
+	note := fmt.Sprintf("Reply to your status %s", event["statusId"])
+	if text := event["statusText"].(string); text != "" {
+		note = fmt.Sprintf("Reply to your status: %s", text)
+	}
+	go func() {
+		if err := chatwootService(mycli.db).PostPrivateNote(mycli.userID, evt.Info.Chat.String(), note); err != nil {
+			log.Debug().Err(err).Str("id", evt.Info.ID).Msg("Failed to post status reply note to Chatwoot")
+		}
+	}()
+}
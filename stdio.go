@@ -10,6 +10,8 @@
 //   - Routes requests to existing HTTP handlers via httptest
 //   - Writes JSON-RPC 2.0 responses to stdout
 //   - Supports both notification and request/response patterns
+//   - Accepts batches, answered with one array holding the response of
+//     each request, so failed elements do not fail the others
 //
 // JSON-RPC methods map directly to HTTP endpoints (e.g., "user.login"
 // maps to POST /user/login). See JSON-RPC-API.md for available methods.
@@ -24,7 +26,11 @@ import (
 	"fmt"
 	"io"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
 
 	"github.com/rs/zerolog/log"
 )
@@ -112,24 +118,34 @@ type stdioServer struct {
 	server *server
 	stdin  io.Reader
 	stdout io.Writer
+
+	// writeMu serializes responses and notifications written to stdout
+	writeMu sync.Mutex
+
+	// batch collects the responses of the batch being handled, written
+	// together once every element is done. Nil outside of batches.
+	batch *[]json.RawMessage
+
+	// subscriptions holds the event types pushed as notifications.
+	// A nil map means all events are forwarded (the default).
+	subMu         sync.RWMutex
+	subscriptions map[string]bool
 }
 
 // NewStdioServer creates a new stdio server instance
 func NewStdioServer(s *server) *stdioServer {
-	return &stdioServer{
-		server: s,
-		stdin:  os.Stdin,
-		stdout: os.Stdout,
-	}
+	return newStdioServerWithIO(s, os.Stdin, os.Stdout)
 }
 
 // newStdioServerWithIO creates a stdio server with custom IO streams (for testing)
 func newStdioServerWithIO(s *server, stdin io.Reader, stdout io.Writer) *stdioServer {
-	return &stdioServer{
+	ss := &stdioServer{
 		server: s,
 		stdin:  stdin,
 		stdout: stdout,
 	}
+	s.stdio = ss
+	return ss
 }
 
 func (ss *stdioServer) Start() error {
@@ -146,6 +162,10 @@ func (ss *stdioServer) Start() error {
 		if len(line) == 0 {
 			continue // Skip empty lines
 		}
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] == '[' {
+			ss.handleBatch(trimmed)
+			continue
+		}
 		ss.handleRequest(line)
 	}
 
@@ -178,9 +198,52 @@ func (ss *stdioServer) handleRequest(requestBytes []byte) {
 		Str("id", req.ID.String()).
 		Str("method", req.Method).
 		Msg("Processing stdio request")
+
+	// A panicking handler fails its request instead of the whole stdio loop
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().
+				Interface("panic_info", r).
+				Str("id", req.ID.String()).
+				Str("method", req.Method).
+				Bytes("stack", debug.Stack()).
+				Msg("Panic recovered while handling stdio request")
+			ss.sendError(req.ID, -32603, "internal error")
+		}
+	}()
 	ss.routeRequest(&req)
 }
 
+// handleBatch handles a JSON-RPC batch. Elements are handled in order as
+// separate requests, and their responses, successful or not, are written as
+// one array with the id of each element.
+func (ss *stdioServer) handleBatch(requestBytes []byte) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(requestBytes, &elements); err != nil {
+		ss.sendError(ID{}, 400, fmt.Sprintf("invalid JSON request: %v", err))
+		return
+	}
+	if len(elements) == 0 {
+		ss.sendError(ID{}, 400, "empty batch")
+		return
+	}
+
+	responses := make([]json.RawMessage, 0, len(elements))
+	ss.batch = &responses
+	for _, element := range elements {
+		ss.handleRequest(element)
+	}
+	ss.batch = nil
+
+	batchBytes, err := json.Marshal(responses)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal batch response")
+		return
+	}
+	ss.writeLine(batchBytes)
+	log.Debug().Int("requests", len(elements)).Int("responses", len(responses)).Msg("Sent stdio batch response")
+}
+
 // routeRequest dispatches the request to the appropriate HTTP handler
 // getUserIdParam extracts and validates userId from request params
 func (ss *stdioServer) getUserIdParam(req *jsonRpcRequest) (string, bool) {
@@ -192,7 +255,113 @@ func (ss *stdioServer) getUserIdParam(req *jsonRpcRequest) (string, bool) {
 	return userId, true
 }
 
+// JSON-RPC 2.0 error code for invalid method parameters
+const rpcInvalidParams = -32602
+
+// requiredParams declares the params each method needs before it is
+// dispatched. Names match the HTTP payload fields and are matched
+// case-insensitively, like encoding/json does when decoding the body.
+var requiredParams = map[string][]string{
+	"session.pairphone":                {"Phone"},
+	"session.privacy.set":              {"Setting", "Value"},
+	"chat.send.text":                   {"Phone", "Body"},
+	"chat.send.image":                  {"Phone", "Image"},
+	"chat.send.video":                  {"Phone", "Video"},
+	"chat.send.document":               {"Phone", "Document", "FileName"},
+	"chat.send.audio":                  {"Phone", "Audio"},
+	"chat.send.sticker":                {"Phone"},
+	"chat.send.location":               {"Phone", "Latitude", "Longitude"},
+	"chat.send.location.live.update":   {"Id", "Latitude", "Longitude"},
+	"chat.send.location.live.stop":     {"Id"},
+	"chat.send.contact":                {"Phone", "Name", "Vcard"},
+	"chat.send.poll":                   {"Group", "Header", "Options"},
+	"chat.send.buttons":                {"Phone", "Title", "Buttons"},
+	"chat.send.edit":                   {"Phone", "Body", "Id"},
+	"chat.forward":                     {"Phone", "Id"},
+	"chat.delete":                      {"Phone", "Id"},
+	"chat.react":                       {"Phone", "Id"},
+	"chat.archive":                     {"jid"},
+	"chat.mute":                        {"jid", "duration"},
+	"chat.unmute":                      {"jid"},
+	"chat.pin":                         {"jid"},
+	"chat.unpin":                       {"jid"},
+	"chat.presence":                    {"Phone", "State"},
+	"chat.markread":                    {"Id"},
+	"chat.markunread":                  {"jid"},
+	"chat.meta.set":                    {"jid"},
+	"chat.request-unavailable-message": {"Chat", "Sender", "ID"},
+	"user.info":                        {"Phone"},
+	"user.check":                       {"Phone"},
+	"user.resolve":                     {"Phone"},
+	"user.lid.batch":                   {"jids"},
+	"user.presence.subscribe":          {"jids"},
+	"user.avatar":                      {"Phone"},
+	"status.set.text":                  {"Body"},
+	"status.set.image":                 {"Image"},
+	"status.set.video":                 {"Video"},
+	"call.reject":                      {"call_from", "call_id"},
+	"group.create":                     {"Name", "Participants"},
+	"group.name":                       {"GroupJID", "Name"},
+	"group.topic":                      {"GroupJID", "Topic"},
+	"group.settings":                   {"GroupJID"},
+	"group.join":                       {"Code"},
+	"group.inviteinfo":                 {"Code"},
+	"group.invitelink.revoke":          {"GroupJID"},
+	"group.updateparticipants":         {"GroupJID", "Phone", "Action"},
+	"group.participants":               {"groupJID"},
+	"webhook.cert.set":                 {"cert", "key"},
+}
+
+// missingRequiredParam returns the first required param absent from params
+func missingRequiredParam(method string, params map[string]interface{}) (string, bool) {
+	for _, name := range requiredParams[method] {
+		found := false
+		for key, value := range params {
+			if !strings.EqualFold(key, name) {
+				continue
+			}
+			switch v := value.(type) {
+			case nil:
+			case string:
+				found = v != ""
+			case []interface{}:
+				found = len(v) > 0
+			default:
+				found = true
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// paginationQuery turns the optional limit and offset params of list methods
+// into a query string
+func paginationQuery(params map[string]interface{}) string {
+	var query []string
+	if limit, ok := params["limit"].(float64); ok {
+		query = append(query, fmt.Sprintf("limit=%d", int(limit)))
+	}
+	if offset, ok := params["offset"].(float64); ok {
+		query = append(query, fmt.Sprintf("offset=%d", int(offset)))
+	}
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + strings.Join(query, "&")
+}
+
 func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
+	if name, missing := missingRequiredParam(req.Method, req.Params); missing {
+		ss.sendError(req.ID, rpcInvalidParams, fmt.Sprintf("invalid params: missing required param %s", name))
+		return
+	}
+
 	// Map stdio method to HTTP route and method
 	var httpMethod, httpPath string
 
@@ -201,6 +370,11 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 		httpMethod = "GET"
 		httpPath = "/health"
 
+	// Stdio control methods (handled locally, not routed to HTTP)
+	case "stdio.subscribe":
+		ss.handleSubscribe(req)
+		return
+
 	// Admin user management
 	case "admin.users.add":
 		httpMethod = "POST"
@@ -240,6 +414,40 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 			return
 		}
 		httpPath = "/admin/users/" + userId + "/full"
+	case "admin.users.diagnostics":
+		httpMethod = "GET"
+		userId, ok := ss.getUserIdParam(req)
+		if !ok {
+			// Error sent by getUserIdParam.
+			return
+		}
+		httpPath = "/admin/users/" + userId + "/diagnostics"
+	case "admin.users.config":
+		httpMethod = "GET"
+		userId, ok := ss.getUserIdParam(req)
+		if !ok {
+			// Error sent by getUserIdParam.
+			return
+		}
+		httpPath = "/admin/users/" + userId + "/config"
+	case "admin.sessions.list":
+		httpMethod = "GET"
+		httpPath = "/admin/sessions"
+	case "admin.config.reload":
+		httpMethod = "POST"
+		httpPath = "/admin/config/reload"
+	case "admin.chatwoot.cache.purge":
+		httpMethod = "POST"
+		httpPath = "/admin/chatwoot/cache/purge"
+	case "admin.metrics":
+		httpMethod = "GET"
+		httpPath = "/admin/metrics"
+	case "admin.rabbitmq.test":
+		httpMethod = "POST"
+		httpPath = "/admin/rabbitmq/test"
+	case "admin.webhooks.replay":
+		httpMethod = "POST"
+		httpPath = "/admin/webhooks/replay"
 
 	// Session management
 	case "session.connect":
@@ -251,6 +459,9 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 	case "session.status":
 		httpMethod = "GET"
 		httpPath = "/session/status"
+	case "session.profile":
+		httpMethod = "GET"
+		httpPath = "/session/profile"
 	case "session.disconnect":
 		httpMethod = "POST"
 		httpPath = "/session/disconnect"
@@ -278,6 +489,24 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 	case "session.hmac.config.delete":
 		httpMethod = "DELETE"
 		httpPath = "/session/hmac/config"
+	case "session.reconnect.config":
+		httpMethod = "POST"
+		httpPath = "/session/reconnect/config"
+	case "session.reconnect.config.get":
+		httpMethod = "GET"
+		httpPath = "/session/reconnect/config"
+	case "session.autoread":
+		httpMethod = "POST"
+		httpPath = "/session/autoread"
+	case "session.autoread.get":
+		httpMethod = "GET"
+		httpPath = "/session/autoread"
+	case "session.privacy.get":
+		httpMethod = "GET"
+		httpPath = "/session/privacy"
+	case "session.privacy.set":
+		httpMethod = "POST"
+		httpPath = "/session/privacy"
 
 	// Messaging
 	case "chat.send.text":
@@ -301,6 +530,12 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 	case "chat.send.location":
 		httpMethod = "POST"
 		httpPath = "/chat/send/location"
+	case "chat.send.location.live.update":
+		httpMethod = "POST"
+		httpPath = "/chat/send/location/live/update"
+	case "chat.send.location.live.stop":
+		httpMethod = "POST"
+		httpPath = "/chat/send/location/live/stop"
 	case "chat.send.contact":
 		httpMethod = "POST"
 		httpPath = "/chat/send/contact"
@@ -316,6 +551,9 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 	case "chat.send.edit":
 		httpMethod = "POST"
 		httpPath = "/chat/send/edit"
+	case "chat.forward":
+		httpMethod = "POST"
+		httpPath = "/chat/forward"
 	case "chat.delete":
 		httpMethod = "POST"
 		httpPath = "/chat/delete"
@@ -325,12 +563,30 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 	case "chat.archive":
 		httpMethod = "POST"
 		httpPath = "/chat/archive"
+	case "chat.mute":
+		httpMethod = "POST"
+		httpPath = "/chat/mute"
+	case "chat.unmute":
+		httpMethod = "POST"
+		httpPath = "/chat/unmute"
+	case "chat.pin":
+		httpMethod = "POST"
+		httpPath = "/chat/pin"
+	case "chat.unpin":
+		httpMethod = "POST"
+		httpPath = "/chat/unpin"
 	case "chat.presence":
 		httpMethod = "POST"
 		httpPath = "/chat/presence"
 	case "chat.markread":
 		httpMethod = "POST"
 		httpPath = "/chat/markread"
+	case "chat.markread.batch":
+		httpMethod = "POST"
+		httpPath = "/chat/markread/batch"
+	case "chat.markunread":
+		httpMethod = "POST"
+		httpPath = "/chat/markunread"
 	case "chat.request-unavailable-message":
 		httpMethod = "POST"
 		httpPath = "/chat/request-unavailable-message"
@@ -358,6 +614,35 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 		if limit, ok := req.Params["limit"].(float64); ok {
 			httpPath += fmt.Sprintf("&limit=%d", int(limit))
 		}
+	case "chat.status":
+		httpMethod = "GET"
+		messageID, ok := req.Params["id"].(string)
+		if !ok || messageID == "" {
+			ss.sendError(req.ID, 400, "missing or invalid id parameter")
+			return
+		}
+		httpPath = "/chat/status?id=" + url.QueryEscape(messageID)
+	case "chat.list":
+		httpMethod = "GET"
+		httpPath = "/chat/list" + paginationQuery(req.Params)
+		if since, ok := req.Params["since"].(float64); ok {
+			if strings.Contains(httpPath, "?") {
+				httpPath += fmt.Sprintf("&since=%d", int64(since))
+			} else {
+				httpPath += fmt.Sprintf("?since=%d", int64(since))
+			}
+		}
+	case "chat.meta.set":
+		httpMethod = "POST"
+		httpPath = "/chat/meta"
+	case "chat.meta.get":
+		httpMethod = "GET"
+		chatJID, ok := req.Params["jid"].(string)
+		if !ok || chatJID == "" {
+			ss.sendError(req.ID, 400, "missing or invalid jid parameter")
+			return
+		}
+		httpPath = "/chat/meta?jid=" + url.QueryEscape(chatJID)
 
 	// User info
 	case "user.contacts":
@@ -366,15 +651,30 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 	case "user.presence":
 		httpMethod = "POST"
 		httpPath = "/user/presence"
+	case "user.presence.subscribe":
+		httpMethod = "POST"
+		httpPath = "/user/presence/subscribe"
 	case "user.info":
 		httpMethod = "POST"
 		httpPath = "/user/info"
 	case "user.check":
 		httpMethod = "POST"
 		httpPath = "/user/check"
+	case "user.resolve":
+		httpMethod = "POST"
+		httpPath = "/user/resolve"
 	case "user.avatar":
 		httpMethod = "POST"
 		httpPath = "/user/avatar"
+	case "user.business.get":
+		httpMethod = "GET"
+		httpPath = "/user/business"
+		if phone, ok := req.Params["phone"].(string); ok && phone != "" {
+			httpPath += "?phone=" + url.QueryEscape(phone)
+		}
+	case "user.business.set":
+		httpMethod = "POST"
+		httpPath = "/user/business"
 	case "user.lid":
 		httpMethod = "GET"
 		jid, ok := req.Params["jid"].(string)
@@ -383,11 +683,20 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 			return
 		}
 		httpPath = "/user/lid/" + jid
+	case "user.lid.batch":
+		httpMethod = "POST"
+		httpPath = "/user/lid/batch"
 
 	// Status
 	case "status.set.text":
 		httpMethod = "POST"
 		httpPath = "/status/set/text"
+	case "status.set.image":
+		httpMethod = "POST"
+		httpPath = "/status/set/image"
+	case "status.set.video":
+		httpMethod = "POST"
+		httpPath = "/status/set/video"
 
 	// Calls
 	case "call.reject":
@@ -397,16 +706,37 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 	// Group management
 	case "group.list":
 		httpMethod = "GET"
-		httpPath = "/group/list"
+		httpPath = "/group/list" + paginationQuery(req.Params)
 	case "group.create":
 		httpMethod = "POST"
 		httpPath = "/group/create"
 	case "group.info":
 		httpMethod = "GET"
 		httpPath = "/group/info"
+	case "group.participants":
+		httpMethod = "GET"
+		groupJID, _ := req.Params["groupJID"].(string)
+		httpPath = "/group/participants?groupJID=" + url.QueryEscape(groupJID)
+		if refresh, ok := req.Params["refresh"].(bool); ok && refresh {
+			httpPath += "&refresh=true"
+		}
 	case "group.invitelink":
 		httpMethod = "GET"
 		httpPath = "/group/invitelink"
+	case "group.invitelink.revoke":
+		httpMethod = "POST"
+		httpPath = "/group/invitelink/revoke"
+	case "group.invitelink.qr":
+		httpMethod = "GET"
+		groupJID, ok := req.Params["groupJID"].(string)
+		if !ok || groupJID == "" {
+			ss.sendError(req.ID, 400, "missing or invalid groupJID parameter")
+			return
+		}
+		httpPath = "/group/invitelink?qr=true&groupJID=" + url.QueryEscape(groupJID)
+		if reset, ok := req.Params["reset"].(bool); ok && reset {
+			httpPath += "&reset=true"
+		}
 	case "group.photo":
 		httpMethod = "POST"
 		httpPath = "/group/photo"
@@ -428,6 +758,9 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 	case "group.locked":
 		httpMethod = "POST"
 		httpPath = "/group/locked"
+	case "group.settings":
+		httpMethod = "POST"
+		httpPath = "/group/settings"
 	case "group.ephemeral":
 		httpMethod = "POST"
 		httpPath = "/group/ephemeral"
@@ -444,7 +777,7 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 	// Newsletter
 	case "newsletter.list":
 		httpMethod = "GET"
-		httpPath = "/newsletter/list"
+		httpPath = "/newsletter/list" + paginationQuery(req.Params)
 
 	// Webhook management
 	case "webhook.get":
@@ -459,6 +792,27 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 	case "webhook.delete":
 		httpMethod = "DELETE"
 		httpPath = "/webhook"
+	case "webhook.history":
+		httpMethod = "GET"
+		httpPath = "/webhook/history" + paginationQuery(req.Params)
+	case "webhook.template.set":
+		httpMethod = "POST"
+		httpPath = "/webhook/template"
+	case "webhook.template.get":
+		httpMethod = "GET"
+		httpPath = "/webhook/template"
+	case "webhook.template.delete":
+		httpMethod = "DELETE"
+		httpPath = "/webhook/template"
+	case "webhook.cert.set":
+		httpMethod = "POST"
+		httpPath = "/webhook/cert"
+	case "webhook.cert.get":
+		httpMethod = "GET"
+		httpPath = "/webhook/cert"
+	case "webhook.cert.delete":
+		httpMethod = "DELETE"
+		httpPath = "/webhook/cert"
 
 	default:
 		ss.sendError(req.ID, 404, fmt.Sprintf("unknown method: %s", req.Method))
@@ -467,6 +821,54 @@ func (ss *stdioServer) routeRequest(req *jsonRpcRequest) {
 	ss.executeHTTPHandler(req, httpMethod, httpPath)
 }
 
+// handleSubscribe sets the event types pushed as notifications on this stream.
+// Passing "All" restores the default of forwarding every event.
+func (ss *stdioServer) handleSubscribe(req *jsonRpcRequest) {
+	rawEvents, ok := req.Params["events"].([]interface{})
+	if !ok || len(rawEvents) == 0 {
+		ss.sendError(req.ID, 400, "missing or invalid events parameter")
+		return
+	}
+
+	events := make(map[string]bool)
+	for _, raw := range rawEvents {
+		eventType, ok := raw.(string)
+		if !ok || !isValidEventType(eventType) {
+			ss.sendError(req.ID, 400, fmt.Sprintf("invalid event type: %v", raw))
+			return
+		}
+		events[eventType] = true
+	}
+
+	subscribed := make([]string, 0, len(events))
+	ss.subMu.Lock()
+	if events["All"] {
+		ss.subscriptions = nil
+		subscribed = append(subscribed, "All")
+	} else {
+		ss.subscriptions = events
+		for _, eventType := range supportedEventTypes {
+			if events[eventType] {
+				subscribed = append(subscribed, eventType)
+			}
+		}
+	}
+	ss.subMu.Unlock()
+
+	log.Info().Strs("events", subscribed).Msg("Updated stdio notification subscriptions")
+	ss.sendSuccess(req.ID, 200, map[string]interface{}{"events": subscribed})
+}
+
+// isSubscribed reports whether notifications for eventType should be pushed
+func (ss *stdioServer) isSubscribed(eventType string) bool {
+	ss.subMu.RLock()
+	defer ss.subMu.RUnlock()
+	if ss.subscriptions == nil {
+		return true
+	}
+	return ss.subscriptions[eventType]
+}
+
 // executeHTTPHandler wraps the existing HTTP handler and adapts it for stdio
 func (ss *stdioServer) executeHTTPHandler(req *jsonRpcRequest, httpMethod, httpPath string) {
 	// Create a mock HTTP request
@@ -581,8 +983,12 @@ func (ss *stdioServer) writeResponse(response jsonRpcResponse) {
 		}
 	}
 
-	// Write to stdout with newline
-	fmt.Fprintf(ss.stdout, "%s\n", string(responseBytes))
+	// Write to stdout with newline, or keep it for the batch response
+	if ss.batch != nil {
+		*ss.batch = append(*ss.batch, responseBytes)
+	} else {
+		ss.writeLine(responseBytes)
+	}
 
 	// Log with appropriate fields based on response type
 	logEvent := log.Debug().Str("id", response.ID.String())
@@ -594,6 +1000,13 @@ func (ss *stdioServer) writeResponse(response jsonRpcResponse) {
 	logEvent.Msg("Sent stdio response")
 }
 
+// writeLine writes a single newline-terminated message to stdout
+func (ss *stdioServer) writeLine(line []byte) {
+	ss.writeMu.Lock()
+	defer ss.writeMu.Unlock()
+	fmt.Fprintf(ss.stdout, "%s\n", string(line))
+}
+
 // jsonRpcNotification represents a one-way notification (no id, no response expected)
 // Follows JSON-RPC 2.0 specification
 type jsonRpcNotification struct {
@@ -603,11 +1016,15 @@ type jsonRpcNotification struct {
 }
 
 // SendNotification sends a JSON-RPC notification to stdout (webhooks in stdio mode)
-// This is thread-safe - os.Stdout writes are atomic at the OS level
+// Events not matching the stdio.subscribe filter are dropped
 func (s *server) SendNotification(method string, params map[string]interface{}) {
 	if s.mode != Stdio {
 		return
 	}
+	if s.stdio != nil && !s.stdio.isSubscribed(method) {
+		log.Debug().Str("method", method).Msg("Skipping stdio notification, event not subscribed")
+		return
+	}
 
 	notification := jsonRpcNotification{
 		JSONRPC: "2.0",
@@ -621,7 +1038,11 @@ func (s *server) SendNotification(method string, params map[string]interface{})
 		return
 	}
 
-	fmt.Fprintf(os.Stdout, "%s\n", string(notificationBytes))
+	if s.stdio != nil {
+		s.stdio.writeLine(notificationBytes)
+	} else {
+		fmt.Fprintf(os.Stdout, "%s\n", string(notificationBytes))
+	}
 
 	log.Debug().
 		Str("method", method).
@@ -4,11 +4,17 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/go-resty/resty/v2"
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
+	"go.mau.fi/whatsmeow"
 	_ "modernc.org/sqlite"
 )
 
@@ -279,8 +285,8 @@ func TestChatSendText(t *testing.T) {
 	}
 
 	errorObj := sendResponse["error"].(map[string]interface{})
-	if errorObj["code"].(float64) != 500 {
-		t.Errorf("Expected error code 500 (no session), got %v", errorObj["code"])
+	if errorObj["code"].(float64) != 503 {
+		t.Errorf("Expected error code 503 (no session), got %v", errorObj["code"])
 	}
 }
 
@@ -601,6 +607,89 @@ func TestWebhookDelete(t *testing.T) {
 	}
 }
 
+func TestWebhookHistory(t *testing.T) {
+	s := makeTestServer(t)
+	previous := *webhookHistory
+	*webhookHistory = true
+	t.Cleanup(func() { *webhookHistory = previous })
+
+	addResponse := executeRequest(t, s, newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "HistoryUser",
+		"token":      "webhook-history-token",
+	}).toJSON(t))
+	userId := addResponse["result"].(map[string]interface{})["id"].(string)
+
+	changes := []struct {
+		method string
+		params map[string]interface{}
+	}{
+		{"webhook.set", map[string]interface{}{"webhookurl": "http://example.com/first?secret=abc", "events": []string{"Message"}}},
+		{"webhook.update", map[string]interface{}{"webhook": "http://example.com/second", "events": []string{"Message", "Receipt"}, "active": true}},
+		{"webhook.delete", map[string]interface{}{}},
+	}
+	for i, change := range changes {
+		id := fmt.Sprintf("%d", i+2)
+		change.params["token"] = "webhook-history-token"
+		assertJSONRPC20Success(t, executeRequest(t, s, newRequest(id, change.method, change.params).toJSON(t)), id)
+	}
+
+	response := executeRequest(t, s, newRequest("5", "webhook.history", map[string]interface{}{"token": "webhook-history-token"}).toJSON(t))
+	result := assertJSONRPC20Success(t, response, "5").(map[string]interface{})
+	if result["total"] != float64(3) || result["enabled"] != true {
+		t.Fatalf("Expected 3 recorded changes, got %+v", result)
+	}
+
+	// The most recent change comes first, with URLs unmasked
+	history := result["history"].([]interface{})
+	want := []struct{ action, webhook, events string }{
+		{"delete", "", "[]"},
+		{"update", "http://example.com/second", "[Message Receipt]"},
+		{"set", "http://example.com/first?secret=abc", "[Message]"},
+	}
+	for i, w := range want {
+		entry := history[i].(map[string]interface{})
+		if entry["action"] != w.action || entry["webhook"] != w.webhook || fmt.Sprint(entry["events"]) != w.events || entry["changed_at"] == "" {
+			t.Errorf("history %d: expected %+v, got %+v", i, w, entry)
+		}
+	}
+
+	// Pages are taken from the most recent change
+	response = executeRequest(t, s, newRequest("6", "webhook.history", map[string]interface{}{"token": "webhook-history-token", "limit": 1, "offset": 1}).toJSON(t))
+	result = assertJSONRPC20Success(t, response, "6").(map[string]interface{})
+	if page := result["history"].([]interface{}); len(page) != 1 || page[0].(map[string]interface{})["action"] != "update" {
+		t.Errorf("Expected the second most recent change alone, got %+v", result["history"])
+	}
+
+	// Nothing more is recorded once the history is turned off
+	*webhookHistory = false
+	assertJSONRPC20Success(t, executeRequest(t, s, newRequest("7", "webhook.set", map[string]interface{}{
+		"token":      "webhook-history-token",
+		"webhookurl": "http://example.com/third",
+	}).toJSON(t)), "7")
+	response = executeRequest(t, s, newRequest("8", "webhook.history", map[string]interface{}{"token": "webhook-history-token"}).toJSON(t))
+	if result = assertJSONRPC20Success(t, response, "8").(map[string]interface{}); result["total"] != float64(3) {
+		t.Errorf("Expected no change recorded with the history off, got %+v", result["total"])
+	}
+
+	// The audit trail outlives the user, with foreign keys enforced as in
+	// production
+	if _, err := s.db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		t.Fatalf("Failed to enable foreign keys: %v", err)
+	}
+	assertJSONRPC20Success(t, executeRequest(t, s, newRequest("9", "admin.users.delete", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"userId":     userId,
+	}).toJSON(t)), "9")
+	var kept int
+	if err := s.db.Get(&kept, "SELECT COUNT(*) FROM webhook_history WHERE user_id = $1", userId); err != nil {
+		t.Fatalf("Failed to count webhook history: %v", err)
+	}
+	if kept != 3 {
+		t.Errorf("Expected the 3 changes to be kept after deleting the user, got %d", kept)
+	}
+}
+
 func TestNumericRequestID(t *testing.T) {
 	s := makeTestServer(t)
 
@@ -825,3 +914,1389 @@ func TestGroupList(t *testing.T) {
 		t.Errorf("Expected either result or error field")
 	}
 }
+
+func TestGroupInviteLinkRevoke(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "RevokeUser",
+		"token":      "revoke-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	// No WhatsApp session, but the method must route to the revoke endpoint
+	revokeRequest := newRequest("2", "group.invitelink.revoke", map[string]interface{}{
+		"token":    "revoke-token",
+		"GroupJID": "120362023605733675@g.us",
+	}).toJSON(t)
+	revokeResponse := executeRequest(t, s, revokeRequest)
+
+	expected := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "2",
+	}
+	if diff := compareJSON(expected, revokeResponse); diff != "" {
+		t.Errorf("Response mismatch:\n%s", diff)
+	}
+	if revokeResponse["result"] == nil && revokeResponse["error"] == nil {
+		t.Errorf("Expected either result or error field")
+	}
+
+	// GroupJID is required
+	missingRequest := newRequest("3", "group.invitelink.revoke", map[string]interface{}{
+		"token": "revoke-token",
+	}).toJSON(t)
+	assertJSONRPC20Error(t, executeRequest(t, s, missingRequest), "3", -32602)
+}
+
+func TestUserAvatarResolutionRouting(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "AvatarUser",
+		"token":      "avatar-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	for i, resolution := range []string{"preview", "full"} {
+		id := fmt.Sprintf("%d", i+2)
+		avatarRequest := newRequest(id, "user.avatar", map[string]interface{}{
+			"token":      "avatar-token",
+			"Phone":      "5491155554445",
+			"Resolution": resolution,
+			"Deliver":    "base64",
+		}).toJSON(t)
+		avatarResponse := executeRequest(t, s, avatarRequest)
+
+		// No WhatsApp session, but the request must reach the avatar endpoint
+		expected := map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      id,
+		}
+		if diff := compareJSON(expected, avatarResponse); diff != "" {
+			t.Errorf("%s: response mismatch:\n%s", resolution, diff)
+		}
+		if avatarResponse["result"] == nil && avatarResponse["error"] == nil {
+			t.Errorf("%s: expected either result or error field", resolution)
+		}
+	}
+}
+
+func TestUserBusinessRouting(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "BusinessUser",
+		"token":      "business-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	tests := []struct {
+		method string
+		params map[string]interface{}
+	}{
+		{"user.business.get", map[string]interface{}{"token": "business-token"}},
+		{"user.business.get", map[string]interface{}{"token": "business-token", "phone": "5491155554445"}},
+		{"user.business.set", map[string]interface{}{"token": "business-token", "Description": "Open every day"}},
+	}
+
+	for i, tt := range tests {
+		id := fmt.Sprintf("%d", i+2)
+		response := executeRequest(t, s, newRequest(id, tt.method, tt.params).toJSON(t))
+
+		// No WhatsApp session, but the method must route to the business endpoint
+		expected := map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      id,
+		}
+		if diff := compareJSON(expected, response); diff != "" {
+			t.Errorf("%s: response mismatch:\n%s", tt.method, diff)
+		}
+		if response["result"] == nil && response["error"] == nil {
+			t.Errorf("%s: expected either result or error field", tt.method)
+		}
+	}
+}
+
+func TestWebhookSetRejectsDisallowedHost(t *testing.T) {
+	s := makeTestServer(t)
+
+	oldPolicy := webhookPolicy
+	t.Cleanup(func() { webhookPolicy = oldPolicy })
+	webhookPolicy, _ = newWebhookHostPolicy("hooks.example.com", "", false)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "PolicyUser",
+		"token":      "policy-token",
+	}).toJSON(t)
+	userId := executeRequest(t, s, addRequest)["result"].(map[string]interface{})["id"].(string)
+
+	setRequest := newRequest("2", "webhook.set", map[string]interface{}{
+		"token":      "policy-token",
+		"webhookurl": "https://hooks.example.com/wuzapi",
+	}).toJSON(t)
+	assertJSONRPC20Success(t, executeRequest(t, s, setRequest), "2")
+
+	blockedRequest := newRequest("3", "webhook.set", map[string]interface{}{
+		"token":      "policy-token",
+		"webhookurl": "https://evil.com/hook",
+	}).toJSON(t)
+	assertJSONRPC20Error(t, executeRequest(t, s, blockedRequest), "3", 400)
+
+	updateRequest := newRequest("4", "webhook.update", map[string]interface{}{
+		"token":   "policy-token",
+		"webhook": "https://evil.com/hook",
+		"active":  true,
+	}).toJSON(t)
+	assertJSONRPC20Error(t, executeRequest(t, s, updateRequest), "4", 400)
+
+	adminAddRequest := newRequest("5", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "PolicyUser2",
+		"token":      "policy-token-2",
+		"webhook":    "https://evil.com/hook",
+	}).toJSON(t)
+	assertJSONRPC20Error(t, executeRequest(t, s, adminAddRequest), "5", 400)
+
+	adminEditRequest := newRequest("6", "admin.users.edit", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"userId":     userId,
+		"webhook":    "https://evil.com/hook",
+	}).toJSON(t)
+	assertJSONRPC20Error(t, executeRequest(t, s, adminEditRequest), "6", 400)
+}
+
+func TestChatStatusFromReceipts(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "StatusUser",
+		"token":      "status-token",
+	}).toJSON(t)
+	addResponse := executeRequest(t, s, addRequest)
+	userId := addResponse["result"].(map[string]interface{})["id"].(string)
+
+	chat := "5491155553934@s.whatsapp.net"
+	if err := s.saveMessageToHistory(userId, chat, "me", "MSG1", "text", "hello", "", "", "", true); err != nil {
+		t.Fatalf("Failed to save message: %v", err)
+	}
+
+	status := func(id string) string {
+		response := executeRequest(t, s, newRequest(id, "chat.status", map[string]interface{}{
+			"token": "status-token",
+			"id":    "MSG1",
+		}).toJSON(t))
+		result := assertJSONRPC20Success(t, response, id).(map[string]interface{})
+		return result["status"].(string)
+	}
+
+	if got := status("2"); got != "sent" {
+		t.Errorf("Expected sent before any receipt, got %q", got)
+	}
+
+	now := time.Now()
+	steps := []struct {
+		status string
+		want   string
+	}{
+		{"delivered", "delivered"},
+		{"read", "read"},
+		{"delivered", "read"}, // late delivery receipt must not downgrade
+		{"played", "played"},
+	}
+	for i, step := range steps {
+		if err := s.saveMessageReceipt(userId, chat, "MSG1", step.status, now.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("saveMessageReceipt(%s) failed: %v", step.status, err)
+		}
+		if got := status(fmt.Sprintf("%d", i+3)); got != step.want {
+			t.Errorf("After %s receipt expected %q, got %q", step.status, step.want, got)
+		}
+	}
+
+	missing := executeRequest(t, s, newRequest("10", "chat.status", map[string]interface{}{
+		"token": "status-token",
+		"id":    "UNKNOWN",
+	}).toJSON(t))
+	assertJSONRPC20Error(t, missing, "10", 404)
+
+	// Ids are escaped in the query, so "&" and "+" reach the handler as is
+	if err := s.saveMessageToHistory(userId, chat, "me", "MSG+2&x=1", "text", "hi", "", "", "", true); err != nil {
+		t.Fatalf("Failed to save message: %v", err)
+	}
+	escaped := executeRequest(t, s, newRequest("12", "chat.status", map[string]interface{}{
+		"token": "status-token",
+		"id":    "MSG+2&x=1",
+	}).toJSON(t))
+	if got := assertJSONRPC20Success(t, escaped, "12").(map[string]interface{}); got["status"] != "sent" {
+		t.Errorf("Expected the stored message with special characters, got %v", got)
+	}
+
+	noID := executeRequest(t, s, newRequest("11", "chat.status", map[string]interface{}{
+		"token": "status-token",
+	}).toJSON(t))
+	assertJSONRPC20Error(t, noID, "11", 400)
+}
+
+func TestChatMetadata(t *testing.T) {
+	s := makeTestServer(t)
+
+	for i, token := range []string{"meta-token", "other-meta-token"} {
+		executeRequest(t, s, newRequest(fmt.Sprintf("add%d", i), "admin.users.add", map[string]interface{}{
+			"adminToken": "test-admin-token",
+			"name":       fmt.Sprintf("MetaUser%d", i),
+			"token":      token,
+		}).toJSON(t))
+	}
+
+	chat := "5491155553934@s.whatsapp.net"
+	set := func(id string, params map[string]interface{}) map[string]interface{} {
+		params["token"] = "meta-token"
+		params["jid"] = chat
+		response := executeRequest(t, s, newRequest(id, "chat.meta.set", params).toJSON(t))
+		return assertJSONRPC20Success(t, response, id).(map[string]interface{})
+	}
+	get := func(id, token string) map[string]interface{} {
+		return executeRequest(t, s, newRequest(id, "chat.meta.get", map[string]interface{}{
+			"token": token,
+			"jid":   chat,
+		}).toJSON(t))
+	}
+
+	// Nothing is stored until the metadata is set
+	assertJSONRPC20Error(t, get("1", "meta-token"), "1", 404)
+
+	stored := set("2", map[string]interface{}{
+		"tags":  []string{"vip", " lead ", "vip", ""},
+		"notes": "Prefers calls in the morning",
+		"owner": "alice",
+	})
+	if stored["jid"] != chat || stored["notes"] != "Prefers calls in the morning" || stored["owner"] != "alice" {
+		t.Errorf("Unexpected stored metadata: %+v", stored)
+	}
+	if tags := fmt.Sprint(stored["tags"]); tags != "[vip lead]" {
+		t.Errorf("Expected tags trimmed and deduplicated, got %s", tags)
+	}
+
+	got := assertJSONRPC20Success(t, get("3", "meta-token"), "3").(map[string]interface{})
+	if got["owner"] != "alice" || fmt.Sprint(got["tags"]) != "[vip lead]" {
+		t.Errorf("Expected stored metadata from get, got %+v", got)
+	}
+
+	// Setting again replaces the whole record
+	set("4", map[string]interface{}{"owner": "bob"})
+	got = assertJSONRPC20Success(t, get("5", "meta-token"), "5").(map[string]interface{})
+	if got["owner"] != "bob" || got["notes"] != "" || fmt.Sprint(got["tags"]) != "[]" {
+		t.Errorf("Expected metadata to be overwritten, got %+v", got)
+	}
+
+	// Metadata belongs to the user who stored it
+	assertJSONRPC20Error(t, get("6", "other-meta-token"), "6", 404)
+
+	// The jid is passed through whole, not parsed as part of the query
+	response := executeRequest(t, s, newRequest("6b", "chat.meta.get", map[string]interface{}{
+		"token": "meta-token",
+		"jid":   chat + "&jid=5491155553999@s.whatsapp.net",
+	}).toJSON(t))
+	if response["error"] == nil {
+		t.Errorf("Expected a jid carrying a query string not to match %s, got %v", chat, response["result"])
+	}
+
+	tests := []struct {
+		method string
+		params map[string]interface{}
+		code   float64
+	}{
+		{"chat.meta.set", map[string]interface{}{"token": "meta-token", "owner": "alice"}, -32602},
+		{"chat.meta.set", map[string]interface{}{"token": "meta-token", "jid": "5491155553934"}, 400},
+		{"chat.meta.get", map[string]interface{}{"token": "meta-token"}, 400},
+		{"chat.meta.get", map[string]interface{}{"token": "meta-token", "jid": "@s.whatsapp.net"}, 400},
+	}
+	for i, tt := range tests {
+		id := fmt.Sprintf("%d", i+7)
+		response := executeRequest(t, s, newRequest(id, tt.method, tt.params).toJSON(t))
+		assertJSONRPC20Error(t, response, id, tt.code)
+	}
+}
+
+func TestListPaginationRouting(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "PagingUser",
+		"token":      "paging-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	tests := []struct {
+		method string
+		params map[string]interface{}
+		code   float64
+	}{
+		// Valid pages reach the handler, which then needs a WhatsApp session
+		{"group.list", map[string]interface{}{"token": "paging-token", "limit": 50, "offset": 50}, 503},
+		{"newsletter.list", map[string]interface{}{"token": "paging-token", "limit": 50, "offset": 50}, 503},
+		{"group.list", map[string]interface{}{"token": "paging-token"}, 503},
+		{"group.list", map[string]interface{}{"token": "paging-token", "limit": 0}, 400},
+		{"newsletter.list", map[string]interface{}{"token": "paging-token", "offset": -1}, 400},
+	}
+
+	for i, tt := range tests {
+		id := fmt.Sprintf("%d", i+2)
+		response := executeRequest(t, s, newRequest(id, tt.method, tt.params).toJSON(t))
+		assertJSONRPC20Error(t, response, id, tt.code)
+	}
+}
+
+func TestChatMuteRouting(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "MuteUser",
+		"token":      "mute-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	chat := "5491155553934@s.whatsapp.net"
+	tests := []struct {
+		method string
+		params map[string]interface{}
+		code   float64
+	}{
+		// Valid requests reach the handler, which then needs a WhatsApp session
+		{"chat.mute", map[string]interface{}{"token": "mute-token", "jid": chat, "duration": "8h"}, 503},
+		{"chat.mute", map[string]interface{}{"token": "mute-token", "jid": chat, "duration": "1w"}, 503},
+		{"chat.mute", map[string]interface{}{"token": "mute-token", "jid": chat, "duration": "always"}, 503},
+		{"chat.unmute", map[string]interface{}{"token": "mute-token", "jid": chat}, 503},
+		{"chat.mute", map[string]interface{}{"token": "mute-token", "jid": chat, "duration": "2d"}, 400},
+		// Missing required params are rejected before routing
+		{"chat.mute", map[string]interface{}{"token": "mute-token", "jid": chat}, -32602},
+		{"chat.unmute", map[string]interface{}{"token": "mute-token"}, -32602},
+	}
+
+	for i, tt := range tests {
+		id := fmt.Sprintf("%d", i+2)
+		response := executeRequest(t, s, newRequest(id, tt.method, tt.params).toJSON(t))
+		assertJSONRPC20Error(t, response, id, tt.code)
+	}
+}
+
+func TestChatMarkUnreadRouting(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "UnreadUser",
+		"token":      "markunread-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	tests := []struct {
+		method string
+		params map[string]interface{}
+		code   float64
+	}{
+		// A valid request reaches the handler, which then needs a WhatsApp session
+		{"chat.markunread", map[string]interface{}{"token": "markunread-token", "jid": "5491155553934@s.whatsapp.net"}, 503},
+		// Missing required params are rejected before routing
+		{"chat.markunread", map[string]interface{}{"token": "markunread-token"}, -32602},
+		{"chat.markunread", map[string]interface{}{"token": "wrong-token", "jid": "5491155553934@s.whatsapp.net"}, 401},
+	}
+
+	for i, tt := range tests {
+		id := fmt.Sprintf("%d", i+2)
+		response := executeRequest(t, s, newRequest(id, tt.method, tt.params).toJSON(t))
+		assertJSONRPC20Error(t, response, id, tt.code)
+	}
+}
+
+func TestChatPinRouting(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "PinUser",
+		"token":      "pin-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	chat := "120363313346913103@g.us"
+	tests := []struct {
+		method string
+		params map[string]interface{}
+		code   float64
+	}{
+		// Valid requests reach the handler, which then needs a WhatsApp session
+		{"chat.pin", map[string]interface{}{"token": "pin-token", "jid": chat}, 503},
+		{"chat.unpin", map[string]interface{}{"token": "pin-token", "jid": chat}, 503},
+		{"chat.pin", map[string]interface{}{"token": "pin-token", "jid": chat, "message_id": "MSG1", "duration": "24h"}, 503},
+		{"chat.unpin", map[string]interface{}{"token": "pin-token", "jid": chat, "message_id": "MSG1"}, 503},
+		{"chat.pin", map[string]interface{}{"token": "pin-token", "jid": chat, "message_id": "MSG1", "duration": "1h"}, 400},
+		{"chat.pin", map[string]interface{}{"token": "pin-token", "jid": chat, "duration": "24h"}, 400},
+		{"chat.pin", map[string]interface{}{"token": "pin-token"}, -32602},
+	}
+
+	for i, tt := range tests {
+		id := fmt.Sprintf("%d", i+2)
+		response := executeRequest(t, s, newRequest(id, tt.method, tt.params).toJSON(t))
+		assertJSONRPC20Error(t, response, id, tt.code)
+	}
+}
+
+func TestNoSessionIsConsistentAcrossHandlers(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "NoSessionUser",
+		"token":      "nosession-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	tests := []struct {
+		method string
+		params map[string]interface{}
+	}{
+		{"chat.send.text", map[string]interface{}{"Phone": "5491155553934", "Body": "hi"}},
+		{"group.list", map[string]interface{}{}},
+		{"group.info", map[string]interface{}{"GroupJID": "120363313346913103@g.us"}},
+		{"user.info", map[string]interface{}{"Phone": []string{"5491155553934"}}},
+		{"user.contacts", map[string]interface{}{}},
+	}
+
+	for i, tt := range tests {
+		id := fmt.Sprintf("%d", i+2)
+		tt.params["token"] = "nosession-token"
+		response := executeRequest(t, s, newRequest(id, tt.method, tt.params).toJSON(t))
+		errorObj := assertJSONRPC20Error(t, response, id, 503)
+		if errorObj["message"] != "no session" {
+			t.Errorf("%s: expected no session error, got %v", tt.method, errorObj["message"])
+		}
+	}
+}
+
+func TestChatMarkReadBatchRouting(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "MarkReadBatchUser",
+		"token":      "markreadbatch-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	tests := []struct {
+		params map[string]interface{}
+		code   int
+	}{
+		{map[string]interface{}{}, 400},
+		{map[string]interface{}{"Messages": make([]map[string]string, maxMarkReadBatch+1)}, 400},
+		{map[string]interface{}{"Messages": []map[string]string{{"Id": "A1", "ChatPhone": "5491155553934"}, {"Id": ""}}}, 503},
+		{map[string]interface{}{"Chats": []map[string]interface{}{{"ChatPhone": "5491155553934", "Id": []string{"A1", "A2"}}}}, 503},
+	}
+
+	for i, tt := range tests {
+		id := fmt.Sprintf("%d", i+2)
+		tt.params["token"] = "markreadbatch-token"
+		response := executeRequest(t, s, newRequest(id, "chat.markread.batch", tt.params).toJSON(t))
+		assertJSONRPC20Error(t, response, id, float64(tt.code))
+	}
+}
+
+func TestUserLIDBatchRouting(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "LIDBatchUser",
+		"token":      "lidbatch-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	tests := []struct {
+		params map[string]interface{}
+		code   float64
+	}{
+		{map[string]interface{}{}, -32602},
+		{map[string]interface{}{"jids": make([]string, maxLIDBatch+1)}, 400},
+		{map[string]interface{}{"jids": []string{"5491155553934", "123456789012345@lid"}}, 503},
+	}
+
+	for i, tt := range tests {
+		id := fmt.Sprintf("%d", i+2)
+		tt.params["token"] = "lidbatch-token"
+		response := executeRequest(t, s, newRequest(id, "user.lid.batch", tt.params).toJSON(t))
+		assertJSONRPC20Error(t, response, id, tt.code)
+	}
+}
+
+func TestUserPresenceSubscribeRouting(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "PresenceSubscribeUser",
+		"token":      "presencesubscribe-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	tests := []struct {
+		params map[string]interface{}
+		code   float64
+	}{
+		{map[string]interface{}{}, -32602},
+		{map[string]interface{}{"jids": make([]string, maxPresenceSubscriptions+1)}, 400},
+		{map[string]interface{}{"jids": []string{"5491155553934", "123456789012345@lid"}}, 503},
+	}
+
+	for i, tt := range tests {
+		id := fmt.Sprintf("%d", i+2)
+		tt.params["token"] = "presencesubscribe-token"
+		response := executeRequest(t, s, newRequest(id, "user.presence.subscribe", tt.params).toJSON(t))
+		assertJSONRPC20Error(t, response, id, tt.code)
+	}
+}
+
+func TestGroupSettingsRouting(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "GroupSettingsUser",
+		"token":      "groupsettings-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	tests := []struct {
+		params map[string]interface{}
+		code   float64
+	}{
+		{map[string]interface{}{"Name": "Team"}, -32602},
+		{map[string]interface{}{"GroupJID": "120363313346913103@g.us"}, 400},
+		{map[string]interface{}{"GroupJID": "120363313346913103@g.us", "Name": ""}, 400},
+		{map[string]interface{}{"GroupJID": "120363313346913103@g.us", "Name": "Team", "Announce": true, "Locked": true}, 503},
+	}
+
+	for i, tt := range tests {
+		id := fmt.Sprintf("%d", i+2)
+		tt.params["token"] = "groupsettings-token"
+		response := executeRequest(t, s, newRequest(id, "group.settings", tt.params).toJSON(t))
+		assertJSONRPC20Error(t, response, id, tt.code)
+	}
+}
+
+func TestGroupParticipantsRouting(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "GroupParticipantsUser",
+		"token":      "groupparticipants-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	tests := []struct {
+		params map[string]interface{}
+		code   float64
+	}{
+		{map[string]interface{}{}, -32602},
+		{map[string]interface{}{"groupJID": "5491155553934@s.whatsapp.net"}, 400},
+		// A group reaches the handler, which then needs a WhatsApp session
+		{map[string]interface{}{"groupJID": "120363313346913103@g.us"}, 503},
+		{map[string]interface{}{"groupJID": "120363313346913103@g.us", "refresh": true}, 503},
+		// The groupJID is passed through whole, not parsed as part of the query
+		{map[string]interface{}{"groupJID": "120363313346913103@g.us&refresh=true"}, 400},
+	}
+
+	for i, tt := range tests {
+		id := fmt.Sprintf("%d", i+2)
+		tt.params["token"] = "groupparticipants-token"
+		response := executeRequest(t, s, newRequest(id, "group.participants", tt.params).toJSON(t))
+		assertJSONRPC20Error(t, response, id, tt.code)
+	}
+}
+
+func TestAdminConfigReload(t *testing.T) {
+	s := makeTestServer(t)
+
+	oldRetry, oldCount, oldDelay := *webhookRetryEnabled, *webhookRetryCount, *webhookRetryDelaySeconds
+	*webhookRetryEnabled, *webhookRetryCount, *webhookRetryDelaySeconds = true, 1, 0
+	t.Cleanup(func() {
+		*webhookRetryEnabled, *webhookRetryCount, *webhookRetryDelaySeconds = oldRetry, oldCount, oldDelay
+	})
+
+	configured := map[string]string{
+		"WEBHOOK_RETRY_COUNT":          "3",
+		"WEBHOOK_RETRY_ENABLED":        "1",
+		"WUZAPI_GLOBAL_ENCRYPTION_KEY": "a-new-key-that-needs-a-restart!!",
+	}
+	oldSource := configReloadSource
+	configReloadSource = func() (func(string) string, error) {
+		return func(key string) string { return configured[key] }, nil
+	}
+	t.Cleanup(func() { configReloadSource = oldSource })
+	t.Cleanup(func() { reloadedSettings = map[string]bool{} })
+
+	reload := func(id string, params map[string]interface{}) map[string]interface{} {
+		params["adminToken"] = "test-admin-token"
+		return executeRequest(t, s, newRequest(id, "admin.config.reload", params).toJSON(t))
+	}
+
+	result := assertJSONRPC20Success(t, reload("1", map[string]interface{}{}), "1").(map[string]interface{})
+	changed := result["changed"].([]interface{})
+	if len(changed) != 1 {
+		t.Fatalf("Expected only the retry count to change, got %v", changed)
+	}
+	change := changed[0].(map[string]interface{})
+	if change["key"] != "WEBHOOK_RETRY_COUNT" || change["previous"] != "1" || change["value"] != "3" {
+		t.Errorf("Unexpected change %v", change)
+	}
+	if restart := fmt.Sprint(result["restart_required"]); restart != "[WUZAPI_GLOBAL_ENCRYPTION_KEY]" {
+		t.Errorf("Expected the encryption key to need a restart, got %s", restart)
+	}
+
+	// Settings read from the .env file are reported as set by the environment,
+	// changed or not, though they never reach the process environment
+	addResponse := executeRequest(t, s, newRequest("10", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "ReloadConfigUser",
+		"token":      "reload-config-token",
+	}).toJSON(t))
+	userId := addResponse["result"].(map[string]interface{})["id"].(string)
+	configResult := assertJSONRPC20Success(t, executeRequest(t, s, newRequest("11", "admin.users.config", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"userId":     userId,
+	}).toJSON(t)), "11").(map[string]interface{})
+	retry := configResult["config"].(map[string]interface{})["webhook_retry"].(map[string]interface{})
+	for name, source := range map[string]string{"count": "env", "enabled": "env", "delay_seconds": "default"} {
+		if got := retry[name].(map[string]interface{})["source"]; got != source {
+			t.Errorf("Expected webhook_retry.%s from %s after reload, got %v", name, source, got)
+		}
+	}
+
+	// The new retry count applies to the next webhook delivery
+	var attempts int32
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer hook.Close()
+	clientManager.SetHTTPClient("reloaduser", resty.New())
+	t.Cleanup(func() { clientManager.DeleteHTTPClient("reloaduser") })
+	callHookWithTemplate(hook.URL, map[string]string{"jsonData": `{"type":"Message"}`}, "reloaduser", nil, nil)
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("Expected 3 delivery attempts after reload, got %d", got)
+	}
+
+	// Restart-only, unknown and invalid settings are refused without applying anything
+	configured["WEBHOOK_RETRY_COUNT"] = "5"
+	configured["WEBHOOK_RETRY_DELAY_SECONDS"] = "soon"
+	tests := []struct {
+		keys []string
+		code float64
+	}{
+		{[]string{"WUZAPI_GLOBAL_ENCRYPTION_KEY"}, 400},
+		{[]string{"NOT_A_SETTING"}, 400},
+		{[]string{"WEBHOOK_RETRY_COUNT", "WEBHOOK_RETRY_DELAY_SECONDS"}, 400},
+	}
+	for i, tt := range tests {
+		id := fmt.Sprintf("%d", i+2)
+		assertJSONRPC20Error(t, reload(id, map[string]interface{}{"keys": tt.keys}), id, tt.code)
+	}
+	if _, count, _ := webhookRetrySettings(); count != 3 {
+		t.Errorf("Expected a refused reload to keep the retry count at 3, got %d", count)
+	}
+
+	unauthorized := executeRequest(t, s, newRequest("9", "admin.config.reload", map[string]interface{}{
+		"adminToken": "wrong-token",
+	}).toJSON(t))
+	assertJSONRPC20Error(t, unauthorized, "9", 401)
+}
+
+func TestChatList(t *testing.T) {
+	s := makeTestServer(t)
+
+	addResponse := executeRequest(t, s, newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "ListUser",
+		"token":      "list-token",
+	}).toJSON(t))
+	userId := addResponse["result"].(map[string]interface{})["id"].(string)
+
+	alice := "5491155550001@s.whatsapp.net"
+	bob := "5491155550002@s.whatsapp.net"
+	carol := "5491155550003@s.whatsapp.net"
+	// The reply to bob was typed on the phone, so it is stored with the
+	// account's own device JID instead of "me"
+	messages := []struct {
+		chat, sender, id, text string
+		fromMe                 bool
+	}{
+		{alice, alice, "A1", "hi", false},
+		{bob, bob, "B1", "question?", false},
+		{bob, "5491155550000:12@s.whatsapp.net", "B2", "answer", true},
+		{carol, carol, "C1", "ping", false},
+		{alice, alice, "A2", "are you there? " + strings.Repeat("x", 200), false},
+	}
+	for _, m := range messages {
+		if err := s.saveMessageToHistory(userId, m.chat, m.sender, m.id, "text", m.text, "", "", "", m.fromMe); err != nil {
+			t.Fatalf("Failed to save message %s: %v", m.id, err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := s.saveMessageReceipt(userId, carol, "C1", "read", time.Now()); err != nil {
+		t.Fatalf("saveMessageReceipt failed: %v", err)
+	}
+
+	list := func(id string, params map[string]interface{}) map[string]interface{} {
+		params["token"] = "list-token"
+		response := executeRequest(t, s, newRequest(id, "chat.list", params).toJSON(t))
+		return assertJSONRPC20Success(t, response, id).(map[string]interface{})
+	}
+
+	result := list("2", map[string]interface{}{})
+	chats := result["Chats"].([]interface{})
+	if len(chats) != 3 || result["Total"].(float64) != 3 {
+		t.Fatalf("Expected 3 chats, got %v", result)
+	}
+	want := []struct {
+		chat   string
+		last   string
+		fromMe bool
+		unread float64
+	}{
+		{alice, "A2", false, 2},
+		{carol, "C1", false, 0},
+		{bob, "B2", true, 0},
+	}
+	for i, w := range want {
+		chat := chats[i].(map[string]interface{})
+		last := chat["last_message"].(map[string]interface{})
+		if chat["chat_jid"] != w.chat || last["id"] != w.last || last["from_me"] != w.fromMe || chat["unread_count"] != w.unread {
+			t.Errorf("Chat %d: expected %s with last message %s (from me %v, %v unread), got %v", i, w.chat, w.last, w.fromMe, w.unread, chat)
+		}
+	}
+	preview := chats[0].(map[string]interface{})["last_message"].(map[string]interface{})["preview"].(string)
+	if !strings.HasPrefix(preview, "are you there?") || len([]rune(preview)) != 101 {
+		t.Errorf("Expected a truncated preview, got %q", preview)
+	}
+	if bobPreview := chats[2].(map[string]interface{})["last_message"].(map[string]interface{})["preview"]; bobPreview != "answer" {
+		t.Errorf("Expected the reply as preview, got %v", bobPreview)
+	}
+
+	page := list("3", map[string]interface{}{"limit": 1, "offset": 1})["Chats"].([]interface{})
+	if len(page) != 1 || page[0].(map[string]interface{})["chat_jid"] != carol {
+		t.Errorf("Expected the second chat on page 2, got %v", page)
+	}
+
+	future := list("4", map[string]interface{}{"since": time.Now().Add(time.Hour).Unix()})
+	if len(future["Chats"].([]interface{})) != 0 || future["Total"].(float64) != 0 {
+		t.Errorf("Expected no chats active in the future, got %v", future)
+	}
+	past := list("5", map[string]interface{}{"since": time.Now().Add(-time.Hour).Unix()})
+	if past["Total"].(float64) != 3 {
+		t.Errorf("Expected all chats since an hour ago, got %v", past)
+	}
+
+	// Pruning the receipts must not turn carol's chat unread again
+	if _, err := s.cleanupMessageReceipts(0); err != nil {
+		t.Fatalf("cleanupMessageReceipts failed: %v", err)
+	}
+	pruned := list("6", map[string]interface{}{})["Chats"].([]interface{})
+	if unread := pruned[1].(map[string]interface{})["unread_count"]; unread != float64(0) {
+		t.Errorf("Expected carol's chat to stay read after the receipt cleanup, got %v unread", unread)
+	}
+}
+
+func TestStdioSubscribeFiltersNotifications(t *testing.T) {
+	s := makeTestServer(t)
+	s.mode = Stdio
+
+	subscribeRequest := newRequest("1", "stdio.subscribe", map[string]interface{}{
+		"events": []string{"Message"},
+	}).toJSON(t)
+
+	stdin := bytes.NewBufferString(subscribeRequest + "\n")
+	stdout := &bytes.Buffer{}
+	stdioServer := newStdioServerWithIO(s, stdin, stdout)
+	if err := stdioServer.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response:\n%s\nError: %v", stdout.String(), err)
+	}
+	result := assertJSONRPC20Success(t, response, "1").(map[string]interface{})
+	events := result["events"].([]interface{})
+	if len(events) != 1 || events[0] != "Message" {
+		t.Errorf("Expected events [Message], got %v", events)
+	}
+
+	stdout.Reset()
+	s.SendNotification("ReadReceipt", map[string]interface{}{"type": "ReadReceipt"})
+	s.SendNotification("Message", map[string]interface{}{"type": "Message"})
+	s.SendNotification("Presence", map[string]interface{}{"type": "Presence"})
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 notification, got %d:\n%s", len(lines), stdout.String())
+	}
+
+	var notification map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &notification); err != nil {
+		t.Fatalf("Failed to parse notification: %v", err)
+	}
+	if notification["method"] != "Message" {
+		t.Errorf("Expected Message notification, got %v", notification["method"])
+	}
+}
+
+func TestStdioSubscribeInvalidEvent(t *testing.T) {
+	s := makeTestServer(t)
+
+	request := newRequest("1", "stdio.subscribe", map[string]interface{}{
+		"events": []string{"NotAnEvent"},
+	}).toJSON(t)
+	response := executeRequest(t, s, request)
+
+	assertJSONRPC20Error(t, response, "1", 400)
+}
+
+func TestStdioBatchPartialSuccess(t *testing.T) {
+	s := makeTestServer(t)
+
+	elements := []string{
+		newRequest("add", "admin.users.add", map[string]interface{}{
+			"adminToken": "test-admin-token",
+			"name":       "BatchUser",
+			"token":      "stdio-batch-token",
+		}).toJSON(t),
+		newRequest(7, "chat.send.text", map[string]interface{}{
+			"token": "stdio-batch-token",
+			"Phone": "5491155553934",
+		}).toJSON(t),
+		newRequest("send", "chat.send.text", map[string]interface{}{
+			"token": "stdio-batch-token",
+			"Phone": "5491155553934",
+			"Body":  "hello",
+		}).toJSON(t),
+		`{"jsonrpc":"2.0","method":"admin.users.list"}`,
+		newRequest("list", "admin.users.list", map[string]interface{}{
+			"adminToken": "test-admin-token",
+		}).toJSON(t),
+	}
+	stdin := bytes.NewBufferString("[" + strings.Join(elements, ",") + "]\n")
+	stdout := &bytes.Buffer{}
+	stdioServer := newStdioServerWithIO(s, stdin, stdout)
+	if err := stdioServer.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected one batch response line, got %d:\n%s", len(lines), stdout.String())
+	}
+	var responses []map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &responses); err != nil {
+		t.Fatalf("Failed to parse batch response:\n%s\nError: %v", lines[0], err)
+	}
+	if len(responses) != len(elements) {
+		t.Fatalf("Expected %d responses, got %d: %v", len(elements), len(responses), responses)
+	}
+
+	// Each element keeps its own id and outcome, in request order
+	assertJSONRPC20Success(t, responses[0], "add")
+	assertJSONRPC20Error(t, responses[1], float64(7), -32602)
+	assertJSONRPC20Error(t, responses[2], "send", 503)
+	assertJSONRPC20Error(t, responses[3], nil, 400)
+	assertJSONRPC20Success(t, responses[4], "list")
+
+	// An empty batch is a single error
+	stdout.Reset()
+	stdioServer = newStdioServerWithIO(s, bytes.NewBufferString("[]\n"), stdout)
+	if err := stdioServer.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response:\n%s\nError: %v", stdout.String(), err)
+	}
+	assertJSONRPC20Error(t, response, nil, 400)
+}
+
+func TestStdioRecoversHandlerPanic(t *testing.T) {
+	s := makeTestServer(t)
+
+	// Stand-in handlers: session.status panics, session.qr answers
+	s.router = mux.NewRouter()
+	s.router.HandleFunc("/session/status", func(w http.ResponseWriter, r *http.Request) {
+		panic("handler blew up")
+	})
+	s.router.HandleFunc("/session/qr", func(w http.ResponseWriter, r *http.Request) {
+		s.Respond(w, r, http.StatusOK, `{"QRCode":""}`)
+	})
+
+	requests := newRequest("panic-1", "session.status", nil).toJSON(t) + "\n" +
+		newRequest("after-2", "session.qr", nil).toJSON(t) + "\n"
+	stdout := &bytes.Buffer{}
+	stdioServer := newStdioServerWithIO(s, bytes.NewBufferString(requests), stdout)
+	if err := stdioServer.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 responses, got %d:\n%s", len(lines), stdout.String())
+	}
+
+	var panicked, after map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &panicked); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	errorObj := assertJSONRPC20Error(t, panicked, "panic-1", -32603)
+	if errorObj["message"] != "internal error" {
+		t.Errorf("Expected internal error, got %v", errorObj["message"])
+	}
+
+	// The loop keeps serving requests after the panic
+	if err := json.Unmarshal([]byte(lines[1]), &after); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	assertJSONRPC20Success(t, after, "after-2")
+}
+
+func TestMissingRequiredParams(t *testing.T) {
+	s := makeTestServer(t)
+
+	tests := []struct {
+		method  string
+		params  map[string]interface{}
+		missing string
+	}{
+		{"chat.send.text", map[string]interface{}{"token": "any", "Phone": "5511999999999"}, "Body"},
+		{"chat.send.text", map[string]interface{}{"token": "any", "body": "hello"}, "Phone"},
+		{"chat.send.document", map[string]interface{}{"token": "any", "phone": "5511999999999", "document": "data:..."}, "FileName"},
+		{"group.create", map[string]interface{}{"token": "any", "name": "Team", "participants": []string{}}, "Participants"},
+	}
+
+	for _, tt := range tests {
+		request := newRequest("1", tt.method, tt.params).toJSON(t)
+		response := executeRequest(t, s, request)
+
+		errorObj := assertJSONRPC20Error(t, response, "1", -32602)
+		if msg := errorObj["message"].(string); !strings.Contains(msg, tt.missing) {
+			t.Errorf("%s: expected error naming %q, got %q", tt.method, tt.missing, msg)
+		}
+	}
+}
+
+func TestRequiredParamsPresentDispatches(t *testing.T) {
+	s := makeTestServer(t)
+
+	// Params are satisfied, so the request reaches the HTTP handler and fails auth
+	request := newRequest("1", "chat.send.text", map[string]interface{}{
+		"token": "unknown-token",
+		"Phone": "5511999999999",
+		"Body":  "hello",
+	}).toJSON(t)
+	response := executeRequest(t, s, request)
+
+	assertJSONRPC20Error(t, response, "1", 401)
+}
+
+func TestAdminSessionsList(t *testing.T) {
+	s := makeTestServer(t)
+
+	for _, name := range []string{"Alice", "Bob"} {
+		addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+			"adminToken": "test-admin-token",
+			"name":       name,
+			"token":      strings.ToLower(name) + "-session-token",
+		}).toJSON(t)
+		addResponse := executeRequest(t, s, addRequest)
+		if addResponse["error"] != nil {
+			t.Fatalf("Failed to add user %s: %v", name, addResponse["error"])
+		}
+	}
+
+	listRequest := newRequest("2", "admin.sessions.list", map[string]interface{}{
+		"adminToken": "test-admin-token",
+	}).toJSON(t)
+	listResponse := executeRequest(t, s, listRequest)
+
+	result := assertJSONRPC20Success(t, listResponse, "2").(map[string]interface{})
+	sessions := result["sessions"].([]interface{})
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+	}
+
+	for i, name := range []string{"Alice", "Bob"} {
+		session := sessions[i].(map[string]interface{})
+		expected := map[string]interface{}{
+			"name":          name,
+			"connected":     false,
+			"loggedIn":      false,
+			"device_count":  float64(0),
+			"last_activity": "",
+		}
+		if diff := compareJSON(expected, session); diff != "" {
+			t.Errorf("Session mismatch:\n%s", diff)
+		}
+	}
+
+	// Deleting a user forgets its activity
+	bobId := sessions[1].(map[string]interface{})["id"].(string)
+	clientManager.TouchActivity(bobId)
+	deleteRequest := newRequest("3", "admin.users.delete", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"userId":     bobId,
+	}).toJSON(t)
+	if deleteResponse := executeRequest(t, s, deleteRequest); deleteResponse["error"] != nil {
+		t.Fatalf("Failed to delete user: %v", deleteResponse["error"])
+	}
+	if _, ok := clientManager.GetLastActivity(bobId); ok {
+		t.Error("Expected the activity of a deleted user to be cleared")
+	}
+}
+
+func TestAdminUserDiagnostics(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "DiagnosticsUser",
+		"token":      "diagnostics-token",
+		"webhook":    "https://example.com/hook",
+		"events":     "Message",
+	}).toJSON(t)
+	addResponse := executeRequest(t, s, addRequest)
+	userId := addResponse["result"].(map[string]interface{})["id"].(string)
+
+	// Only the admin token is used, the user's token is never sent
+	diagnosticsRequest := newRequest("2", "admin.users.diagnostics", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"userId":     userId,
+	}).toJSON(t)
+	result := assertJSONRPC20Success(t, executeRequest(t, s, diagnosticsRequest), "2").(map[string]interface{})
+	if result["id"] != userId {
+		t.Errorf("Expected diagnostics of %s, got %v", userId, result["id"])
+	}
+	diagnostics := result["diagnostics"].(map[string]interface{})
+
+	session := diagnostics["session"].(map[string]interface{})
+	if session["code"] != float64(200) {
+		t.Fatalf("Expected session status to succeed, got %v", session)
+	}
+	expected := map[string]interface{}{
+		"id":        userId,
+		"name":      "DiagnosticsUser",
+		"connected": false,
+		"loggedIn":  false,
+		"token":     "***",
+	}
+	if diff := compareJSON(expected, session["data"].(map[string]interface{})); diff != "" {
+		t.Errorf("Session status mismatch:\n%s", diff)
+	}
+
+	webhook := diagnostics["webhook"].(map[string]interface{})
+	if data, _ := webhook["data"].(map[string]interface{}); data == nil || data["webhook"] != "https://example.com/hook" {
+		t.Errorf("Unexpected webhook diagnostic: %v", webhook)
+	}
+	if chatwoot := diagnostics["chatwoot"].(map[string]interface{}); chatwoot["code"] != float64(404) {
+		t.Errorf("Expected Chatwoot not to be configured, got %v", chatwoot)
+	}
+
+	unknownRequest := newRequest("3", "admin.users.diagnostics", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"userId":     "nosuchuser",
+	}).toJSON(t)
+	assertJSONRPC20Error(t, executeRequest(t, s, unknownRequest), "3", 404)
+
+	userRequest := newRequest("4", "admin.users.diagnostics", map[string]interface{}{
+		"adminToken": "diagnostics-token",
+		"userId":     userId,
+	}).toJSON(t)
+	assertJSONRPC20Error(t, executeRequest(t, s, userRequest), "4", 401)
+}
+
+func TestSessionConnectRejectedAtCapacity(t *testing.T) {
+	s := makeTestServer(t)
+
+	previous := *maxSessions
+	*maxSessions = 1
+	defer func() { *maxSessions = previous }()
+
+	// Another user already holds the only slot
+	clientManager.SetWhatsmeowClient("capacity-other", &whatsmeow.Client{})
+	defer clientManager.DeleteWhatsmeowClient("capacity-other")
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "CapacityUser",
+		"token":      "capacity-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	connectRequest := newRequest("2", "session.connect", map[string]interface{}{
+		"token":     "capacity-token",
+		"Immediate": true,
+	}).toJSON(t)
+	assertJSONRPC20Error(t, executeRequest(t, s, connectRequest), "2", 503)
+
+	listRequest := newRequest("3", "admin.sessions.list", map[string]interface{}{
+		"adminToken": "test-admin-token",
+	}).toJSON(t)
+	result := assertJSONRPC20Success(t, executeRequest(t, s, listRequest), "3").(map[string]interface{})
+	expected := map[string]interface{}{
+		"max_sessions":    float64(1),
+		"active_sessions": float64(1),
+		"available":       float64(0),
+	}
+	if diff := compareJSON(expected, result["capacity"].(map[string]interface{})); diff != "" {
+		t.Errorf("Capacity mismatch:\n%s", diff)
+	}
+}
+
+func TestStatusSetMediaRouting(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "StatusUser",
+		"token":      "status-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	tests := []struct {
+		method string
+		field  string
+	}{
+		{"status.set.image", "Image"},
+		{"status.set.video", "Video"},
+	}
+
+	for _, tt := range tests {
+		// Missing media is rejected before dispatch
+		missingRequest := newRequest("2", tt.method, map[string]interface{}{
+			"token": "status-token",
+		}).toJSON(t)
+		missingResponse := executeRequest(t, s, missingRequest)
+		errorObj := assertJSONRPC20Error(t, missingResponse, "2", -32602)
+		if msg := errorObj["message"].(string); !strings.Contains(msg, tt.field) {
+			t.Errorf("%s: expected error naming %s, got %q", tt.method, tt.field, msg)
+		}
+
+		// With media present the request reaches the handler, which has no WhatsApp session
+		request := newRequest("3", tt.method, map[string]interface{}{
+			"token":    "status-token",
+			tt.field:   "data:image/png;base64,iVBORw0KGgo=",
+			"Audience": map[string]interface{}{"Type": "only", "List": []string{"5511999999999"}},
+		}).toJSON(t)
+		response := executeRequest(t, s, request)
+		errorObj = assertJSONRPC20Error(t, response, "3", 503)
+		if errorObj["message"] != "no session" {
+			t.Errorf("%s: expected no session error, got %v", tt.method, errorObj["message"])
+		}
+	}
+}
+
+func TestAdminUserEffectiveConfig(t *testing.T) {
+	s := makeTestServer(t)
+
+	oldFlagWasSet := flagWasSet
+	flagWasSet = func(name string) bool { return name == "retrycount" || name == "retrydelay" }
+	t.Cleanup(func() { flagWasSet = oldFlagWasSet })
+	oldKey := *globalEncryptionKey
+	*globalEncryptionKey = "0123456789abcdef0123456789abcdef"
+	t.Cleanup(func() { *globalEncryptionKey = oldKey })
+	// The environment wins over a flag given on the command line
+	t.Setenv("WEBHOOK_RETRY_DELAY_SECONDS", "10")
+	t.Setenv("WEBHOOK_FORMAT", "json")
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "ConfigUser",
+		"token":      "config-token",
+		"webhook":    "https://example.com/hook",
+		"events":     "Message",
+		"hmacKey":    "0123456789abcdef0123456789abcdef",
+	}).toJSON(t)
+	addResponse := executeRequest(t, s, addRequest)
+	userId := addResponse["result"].(map[string]interface{})["id"].(string)
+
+	configRequest := newRequest("2", "admin.users.config", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"userId":     userId,
+	}).toJSON(t)
+	result := assertJSONRPC20Success(t, executeRequest(t, s, configRequest), "2").(map[string]interface{})
+	config := result["config"].(map[string]interface{})
+
+	setting := func(group, name string) map[string]interface{} {
+		t.Helper()
+		values, _ := config[group].(map[string]interface{})
+		value, _ := values[name].(map[string]interface{})
+		if value == nil {
+			t.Fatalf("missing setting %s.%s in %v", group, name, config)
+		}
+		return value
+	}
+	for _, tc := range []struct {
+		group, name string
+		expected    map[string]interface{}
+	}{
+		{"webhook", "url", map[string]interface{}{"value": "https://example.com/hook", "source": "user"}},
+		{"webhook", "format", map[string]interface{}{"value": "json", "source": "env"}},
+		{"webhook", "hmac_key", map[string]interface{}{"value": "***", "source": "user"}},
+		{"webhook", "template", map[string]interface{}{"value": "", "source": "default"}},
+		{"webhook_retry", "count", map[string]interface{}{"source": "flag"}},
+		{"webhook_retry", "delay_seconds", map[string]interface{}{"source": "env"}},
+		{"webhook_retry", "enabled", map[string]interface{}{"source": "default"}},
+		{"media", "delivery", map[string]interface{}{"value": "base64", "source": "default"}},
+		{"chatwoot", "enabled", map[string]interface{}{"value": false, "source": "default"}},
+	} {
+		if diff := compareJSON(tc.expected, setting(tc.group, tc.name)); diff != "" {
+			t.Errorf("%s.%s mismatch:\n%s", tc.group, tc.name, diff)
+		}
+	}
+
+	unknownRequest := newRequest("3", "admin.users.config", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"userId":     "nosuchuser",
+	}).toJSON(t)
+	assertJSONRPC20Error(t, executeRequest(t, s, unknownRequest), "3", 404)
+}
+
+func TestSessionProfileRouting(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "ProfileUser",
+		"token":      "profile-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	// The request reaches the handler, which has no WhatsApp session
+	request := newRequest("2", "session.profile", map[string]interface{}{
+		"token": "profile-token",
+	}).toJSON(t)
+	errorObj := assertJSONRPC20Error(t, executeRequest(t, s, request), "2", 503)
+	if errorObj["message"] != "no session" {
+		t.Errorf("Expected no session error, got %v", errorObj["message"])
+	}
+
+	unauthorized := newRequest("3", "session.profile", map[string]interface{}{
+		"token": "wrong-token",
+	}).toJSON(t)
+	assertJSONRPC20Error(t, executeRequest(t, s, unauthorized), "3", 401)
+}
+
+func TestSessionPrivacyRouting(t *testing.T) {
+	s := makeTestServer(t)
+
+	addRequest := newRequest("1", "admin.users.add", map[string]interface{}{
+		"adminToken": "test-admin-token",
+		"name":       "PrivacyUser",
+		"token":      "privacy-settings-token",
+	}).toJSON(t)
+	executeRequest(t, s, addRequest)
+
+	// Both methods reach their handler, which has no WhatsApp session
+	getRequest := newRequest("2", "session.privacy.get", map[string]interface{}{
+		"token": "privacy-settings-token",
+	}).toJSON(t)
+	errorObj := assertJSONRPC20Error(t, executeRequest(t, s, getRequest), "2", 503)
+	if errorObj["message"] != "no session" {
+		t.Errorf("Expected no session error, got %v", errorObj["message"])
+	}
+
+	setRequest := newRequest("3", "session.privacy.set", map[string]interface{}{
+		"token":   "privacy-settings-token",
+		"Setting": "last",
+		"Value":   "contacts",
+	}).toJSON(t)
+	errorObj = assertJSONRPC20Error(t, executeRequest(t, s, setRequest), "3", 503)
+	if errorObj["message"] != "no session" {
+		t.Errorf("Expected no session error, got %v", errorObj["message"])
+	}
+
+	missingValue := newRequest("4", "session.privacy.set", map[string]interface{}{
+		"token":   "privacy-settings-token",
+		"Setting": "last",
+	}).toJSON(t)
+	assertJSONRPC20Error(t, executeRequest(t, s, missingValue), "4", -32602)
+
+	unauthorized := newRequest("5", "session.privacy.get", map[string]interface{}{
+		"token": "wrong-token",
+	}).toJSON(t)
+	assertJSONRPC20Error(t, executeRequest(t, s, unauthorized), "5", 401)
+}
+
+func TestGlobalSendRateLimit(t *testing.T) {
+	s := makeTestServer(t)
+
+	for i, name := range []string{"first", "second"} {
+		executeRequest(t, s, newRequest(fmt.Sprintf("add%d", i), "admin.users.add", map[string]interface{}{
+			"adminToken": "test-admin-token",
+			"name":       "SendLimit " + name,
+			"token":      "sendlimit-" + name + "-token",
+		}).toJSON(t))
+	}
+
+	now := time.Unix(1700000000, 0)
+	sendLimiter = newTokenBucket(1, 2, func() time.Time { return now })
+	t.Cleanup(func() { sendLimiter = nil })
+
+	send := func(id, user string) map[string]interface{} {
+		return executeRequest(t, s, newRequest(id, "chat.send.text", map[string]interface{}{
+			"token": "sendlimit-" + user + "-token",
+			"Phone": "5491155553934",
+			"Body":  "hello",
+		}).toJSON(t))
+	}
+
+	// Sends failing in the handler, here for lack of a WhatsApp session,
+	// give their token back and never exhaust the limit
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("fail%d", i)
+		assertJSONRPC20Error(t, send(id, "first"), id, 503)
+	}
+
+	// The first user spends the burst
+	sendLimiter.take()
+	sendLimiter.take()
+
+	// The limit is shared, so the second user is throttled too
+	assertJSONRPC20Error(t, send("3", "second"), "3", 429)
+
+	// Forwards and reactions are messages too
+	for _, method := range []string{"chat.forward", "chat.react"} {
+		response := executeRequest(t, s, newRequest(method, method, map[string]interface{}{
+			"token": "sendlimit-second-token",
+			"Phone": "5491155553934",
+			"Id":    "3EB06F9067F80BAB89FF",
+		}).toJSON(t))
+		assertJSONRPC20Error(t, response, method, 429)
+	}
+
+	// Other endpoints are not limited
+	response := executeRequest(t, s, newRequest("4", "chat.markunread", map[string]interface{}{
+		"token": "sendlimit-second-token",
+		"jid":   "5491155553934@s.whatsapp.net",
+	}).toJSON(t))
+	assertJSONRPC20Error(t, response, "4", 503)
+
+	// A token is back after a second at one message per second
+	now = now.Add(time.Second)
+	if ok, _ := sendLimiter.take(); !ok {
+		t.Fatal("expected a token after a second")
+	}
+	assertJSONRPC20Error(t, send("6", "first"), "6", 429)
+}
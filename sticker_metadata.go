@@ -0,0 +1,19 @@
+package main
+
+import "fmt"
+
+// maxStickerEmojis is the number of emojis WhatsApp associates with a sticker
+const maxStickerEmojis = 3
+
+// validateStickerEmojis checks the emojis given for a sticker's metadata
+func validateStickerEmojis(emojis []string) error {
+	if len(emojis) > maxStickerEmojis {
+		return fmt.Errorf("too many Emojis, at most %d are allowed", maxStickerEmojis)
+	}
+	for _, emoji := range emojis {
+		if !isEmoji(emoji) {
+			return fmt.Errorf("invalid emoji %q in Emojis", emoji)
+		}
+	}
+	return nil
+}
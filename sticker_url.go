@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+	"github.com/vincent-petithory/dataurl"
+)
+
+// stickerURLMaxBytes caps the size of a sticker downloaded from a URL.
+// WhatsApp refuses static stickers over 500KB and animated ones over 1MB, so
+// larger files would only fail after being processed.
+const stickerURLMaxBytes = 2 << 20
+
+// stickerURLCache keeps downloaded stickers by URL, as the same sticker tends
+// to be sent over and over
+var stickerURLCache = cache.New(time.Hour, 2*time.Hour)
+
+// stickerDownload is a sticker downloaded from a URL
+type stickerDownload struct {
+	data     []byte
+	mimeType string
+}
+
+// fetchStickerURL downloads the sticker at stickerURL, or takes it from the
+// cache, and returns it as a data URL for processStickerData
+func fetchStickerURL(ctx context.Context, stickerURL string) (string, error) {
+	if !isHTTPURL(stickerURL) {
+		return "", errors.New("url must be an http or https URL")
+	}
+
+	if cached, found := stickerURLCache.Get(stickerURL); found {
+		if sticker, ok := cached.(stickerDownload); ok {
+			log.Debug().Str("url", stickerURL).Msg("Sticker fetched from cache")
+			return dataurl.New(sticker.data, sticker.mimeType).String(), nil
+		}
+	}
+
+	data, contentType, err := fetchURLBytes(ctx, stickerURL, stickerURLMaxBytes)
+	if err != nil {
+		return "", err
+	}
+
+	// Servers often label files as application/octet-stream, so fall back to
+	// sniffing before refusing what is not an image or a video
+	mimeType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	if !strings.HasPrefix(mimeType, "image/") && !strings.HasPrefix(mimeType, "video/") {
+		mimeType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(mimeType, "image/") && !strings.HasPrefix(mimeType, "video/") {
+		return "", errors.New("url does not point to an image or video")
+	}
+
+	stickerURLCache.Set(stickerURL, stickerDownload{data: data, mimeType: mimeType}, cache.DefaultExpiration)
+	return dataurl.New(data, mimeType).String(), nil
+}
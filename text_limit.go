@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// textLimit caps the length of text message bodies. It is set from
+// -maxtextlength and -textlengthpolicy at startup.
+var textLimit textLengthLimit
+
+// textLengthLimit is a maximum body length, in characters, and what to do
+// with longer bodies: reject them or truncate them to the maximum
+type textLengthLimit struct {
+	Max    int
+	Policy string
+}
+
+// newTextLengthLimit validates a limit. A maxLength of 0 disables it.
+func newTextLengthLimit(maxLength int, policy string) (textLengthLimit, error) {
+	policy = strings.ToLower(strings.TrimSpace(policy))
+	if maxLength < 0 {
+		return textLengthLimit{}, fmt.Errorf("invalid maximum text length %d", maxLength)
+	}
+	if policy != "reject" && policy != "truncate" {
+		return textLengthLimit{}, fmt.Errorf("invalid text length policy %q, use reject or truncate", policy)
+	}
+	return textLengthLimit{Max: maxLength, Policy: policy}, nil
+}
+
+// apply returns body within the limit and whether it was truncated, or an
+// error when the body is too long and the policy rejects it
+func (l textLengthLimit) apply(body string) (string, bool, error) {
+	if l.Max == 0 || utf8.RuneCountInString(body) <= l.Max {
+		return body, false, nil
+	}
+	if l.Policy != "truncate" {
+		return "", false, fmt.Errorf("Body exceeds the maximum length of %d characters", l.Max)
+	}
+	return string([]rune(body)[:l.Max]), true, nil
+}
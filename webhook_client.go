@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// newWebhookHTTPClient returns the HTTP client webhooks of a user are
+// delivered with. Each request, redirects and retries aside, gives up after
+// timeout so slow endpoints go to retry instead of holding the connection.
+// Connections are dialed within dialTimeout and probed every keepAlive while
+// idle, zero disabling the probes. Every connection goes through the webhook
+// host policy.
+func newWebhookHTTPClient(timeout, dialTimeout, keepAlive time.Duration) *resty.Client {
+	if keepAlive == 0 {
+		keepAlive = -1
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: keepAlive}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialWebhook(dialer)
+	transport.TLSHandshakeTimeout = dialTimeout
+
+	httpClient := resty.NewWithClient(&http.Client{Transport: transport})
+	httpClient.SetTimeout(timeout)
+	return httpClient
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// idempotencyKeyHeader carries the delivery key on every webhook request
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// webhookDeliveryTTL is how long acknowledged deliveries are remembered
+	webhookDeliveryTTL = 72 * time.Hour
+)
+
+// webhookDeliveries remembers acknowledged deliveries across restarts. It is
+// nil unless webhook dedupe is enabled.
+var webhookDeliveries *webhookDeliveryStore
+
+type webhookDeliveryStore struct {
+	db *sqlx.DB
+}
+
+func newWebhookDeliveryStore(db *sqlx.DB) *webhookDeliveryStore {
+	return &webhookDeliveryStore{db: db}
+}
+
+func (s *webhookDeliveryStore) query(q string) string {
+	if s.db.DriverName() == "sqlite" {
+		q = strings.NewReplacer("$1", "?", "$2", "?", "$3", "?").Replace(q)
+	}
+	return q
+}
+
+// acked reports whether the delivery with key was already acknowledged by
+// the receiver. Lookup errors fail open so events are never dropped.
+func (s *webhookDeliveryStore) acked(key string) bool {
+	var deliveredAt int64
+	err := s.db.Get(&deliveredAt, s.query(`SELECT delivered_at FROM webhook_deliveries WHERE idempotency_key = $1`), key)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Warn().Err(err).Str("key", key).Msg("Could not check webhook delivery state")
+		}
+		return false
+	}
+	return true
+}
+
+// markAcked records that the receiver acknowledged the delivery with key
+func (s *webhookDeliveryStore) markAcked(key, userID string) {
+	_, err := s.db.Exec(s.query(`INSERT INTO webhook_deliveries (idempotency_key, user_id, delivered_at) VALUES ($1, $2, $3)
+		ON CONFLICT (idempotency_key) DO UPDATE SET delivered_at = excluded.delivered_at`), key, userID, time.Now().Unix())
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("Could not record webhook delivery")
+	}
+}
+
+// cleanup removes deliveries acknowledged more than webhookDeliveryTTL ago
+func (s *webhookDeliveryStore) cleanup() (int64, error) {
+	res, err := s.db.Exec(s.query(`DELETE FROM webhook_deliveries WHERE delivered_at < $1`), time.Now().Add(-webhookDeliveryTTL).Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// runCleanup prunes expired deliveries every hour
+func (s *webhookDeliveryStore) runCleanup() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if n, err := s.cleanup(); err != nil {
+			log.Warn().Err(err).Msg("Failed to clean up webhook deliveries")
+		} else if n > 0 {
+			log.Debug().Int64("removed", n).Msg("Cleaned up webhook deliveries")
+		}
+		<-ticker.C
+	}
+}
+
+// webhookIdempotencyKey derives a stable key for delivering an event to a
+// URL. Events with a WhatsApp id (messages) are keyed by it, so a message
+// redelivered by WhatsApp after a restart gets the same key; other events are
+// keyed by their payload.
+func webhookIdempotencyKey(myurl, jsonData string) string {
+	if jsonData == "" {
+		return ""
+	}
+
+	var envelope struct {
+		Type  string `json:"type"`
+		Event struct {
+			Info struct {
+				ID string `json:"ID"`
+			} `json:"Info"`
+		} `json:"event"`
+	}
+	eventID := ""
+	if err := json.Unmarshal([]byte(jsonData), &envelope); err == nil && envelope.Event.Info.ID != "" {
+		eventID = envelope.Type + ":" + envelope.Event.Info.ID
+	} else {
+		sum := sha256.Sum256([]byte(jsonData))
+		eventID = hex.EncodeToString(sum[:])
+	}
+
+	sum := sha256.Sum256([]byte(eventID + "\x00" + myurl))
+	return hex.EncodeToString(sum[:16])
+}
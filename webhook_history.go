@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Changes recorded in the webhook history
+const (
+	webhookActionSet    = "set"
+	webhookActionUpdate = "update"
+	webhookActionDelete = "delete"
+)
+
+// WebhookHistoryEntry is the webhook URL and events of a user after a change.
+// URLs are stored in full, query string included, for auditing.
+type WebhookHistoryEntry struct {
+	Action    string    `json:"action"`
+	Webhook   string    `json:"webhook"`
+	Events    []string  `json:"events"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// recordWebhookChange appends the webhook URL and events now stored for a
+// user to their history, when -webhookhistory is set. A failure is logged and
+// does not undo the change.
+func (s *server) recordWebhookChange(userID, action string) {
+	if !*webhookHistory {
+		return
+	}
+
+	var current struct {
+		Webhook string `db:"webhook"`
+		Events  string `db:"events"`
+	}
+	err := s.db.Get(&current, "SELECT COALESCE(webhook, '') AS webhook, COALESCE(events, '') AS events FROM users WHERE id = $1", userID)
+	if err == nil {
+		_, err = s.db.Exec("INSERT INTO webhook_history (user_id, action, webhook, events, changed_at) VALUES ($1, $2, $3, $4, $5)",
+			userID, action, current.Webhook, current.Events, time.Now().UTC())
+	}
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Str("action", action).Msg("Failed to record webhook change")
+	}
+}
+
+// getWebhookHistory returns a page of the webhook history of a user, the most
+// recent change first, and the number of changes recorded
+func (s *server) getWebhookHistory(userID string, limit, offset int) ([]WebhookHistoryEntry, int, error) {
+	var total int
+	if err := s.db.Get(&total, "SELECT COUNT(*) FROM webhook_history WHERE user_id = $1", userID); err != nil {
+		return nil, 0, fmt.Errorf("failed to count webhook history: %w", err)
+	}
+
+	var rows []struct {
+		Action    string    `db:"action"`
+		Webhook   string    `db:"webhook"`
+		Events    string    `db:"events"`
+		ChangedAt time.Time `db:"changed_at"`
+	}
+	err := s.db.Select(&rows, "SELECT action, webhook, events, changed_at FROM webhook_history WHERE user_id = $1 ORDER BY id DESC LIMIT $2 OFFSET $3", userID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read webhook history: %w", err)
+	}
+
+	history := make([]WebhookHistoryEntry, 0, len(rows))
+	for _, row := range rows {
+		events := []string{}
+		if row.Events != "" {
+			events = strings.Split(row.Events, ",")
+		}
+		history = append(history, WebhookHistoryEntry{Action: row.Action, Webhook: row.Webhook, Events: events, ChangedAt: row.ChangedAt})
+	}
+	return history, total, nil
+}
+
+// Gets the history of the webhook URL and events changes of the user
+func (s *server) GetWebhookHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		limit, offset, err := parsePagination(r)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		history, total, err := s.getWebhookHistory(txtid, limit, offset)
+		if err != nil {
+			log.Error().Err(err).Str("userID", txtid).Msg("Failed to get webhook history")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("could not get webhook history"))
+			return
+		}
+
+		response := map[string]interface{}{
+			"enabled": *webhookHistory,
+			"history": history,
+			"total":   total,
+			"limit":   limit,
+			"offset":  offset,
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+// webhookClientCerts holds the client certificate each user presents to
+// webhook endpoints that require mutual TLS
+var webhookClientCerts sync.Map
+
+// parseWebhookClientCert checks that certPEM and keyPEM are a matching pair
+// and that the certificate is valid right now
+func parseWebhookClientCert(certPEM, keyPEM string) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	if now.Before(cert.Leaf.NotBefore) {
+		return nil, fmt.Errorf("certificate is not valid before %s", cert.Leaf.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if now.After(cert.Leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate expired on %s", cert.Leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return &cert, nil
+}
+
+// setWebhookClientCert replaces the cached client certificate of a user, nil
+// removing it. Idle connections are closed so the next delivery handshakes
+// with the new certificate.
+func setWebhookClientCert(userID string, cert *tls.Certificate) {
+	if cert == nil {
+		webhookClientCerts.Delete(userID)
+	} else {
+		webhookClientCerts.Store(userID, cert)
+	}
+	if httpClient := clientManager.GetHTTPClient(userID); httpClient != nil {
+		httpClient.GetClient().CloseIdleConnections()
+	}
+}
+
+// webhookClientCertificate is the GetClientCertificate hook of the webhook
+// client of a user. Without a configured certificate the handshake goes on
+// without one, and endpoints requiring it reject the connection.
+func webhookClientCertificate(userID string) func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		if v, ok := webhookClientCerts.Load(userID); ok {
+			return v.(*tls.Certificate), nil
+		}
+		return &tls.Certificate{}, nil
+	}
+}
+
+// loadWebhookClientCert decrypts and caches the client certificate stored
+// for a user
+func loadWebhookClientCert(db *sqlx.DB, userID string) {
+	var certData, keyData []byte
+	if err := db.QueryRow("SELECT webhook_client_cert, webhook_client_key FROM users WHERE id = $1", userID).Scan(&certData, &keyData); err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Could not load webhook client certificate")
+		return
+	}
+	if len(certData) == 0 || len(keyData) == 0 {
+		webhookClientCerts.Delete(userID)
+		return
+	}
+
+	cert, err := decryptWebhookClientCert(certData, keyData)
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Msg("Stored webhook client certificate is unusable, delivering without it")
+		webhookClientCerts.Delete(userID)
+		return
+	}
+	webhookClientCerts.Store(userID, cert)
+}
+
+// decryptWebhookClientCert decrypts a stored certificate and key pair
+func decryptWebhookClientCert(certData, keyData []byte) (*tls.Certificate, error) {
+	certPEM, err := decryptHMACKey(certData)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := decryptHMACKey(keyData)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// webhookClientCertInfo describes a client certificate without its key
+func webhookClientCertInfo(leaf *x509.Certificate) map[string]interface{} {
+	return map[string]interface{}{
+		"configured": true,
+		"subject":    leaf.Subject.String(),
+		"issuer":     leaf.Issuer.String(),
+		"not_before": leaf.NotBefore.UTC().Format(time.RFC3339),
+		"not_after":  leaf.NotAfter.UTC().Format(time.RFC3339),
+	}
+}
+
+// SetWebhookClientCert stores the client certificate and key presented to
+// webhook endpoints that require mutual TLS
+func (s *server) SetWebhookClientCert() http.HandlerFunc {
+	type clientCertStruct struct {
+		Cert string `json:"cert"`
+		Key  string `json:"key"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		var t clientCertStruct
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+		if t.Cert == "" || t.Key == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing cert or key in payload"))
+			return
+		}
+
+		cert, err := parseWebhookClientCert(t.Cert, t.Key)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, fmt.Errorf("invalid client certificate: %v", err))
+			return
+		}
+
+		encryptedCert, err := encryptHMACKey(t.Cert)
+		var encryptedKey []byte
+		if err == nil {
+			encryptedKey, err = encryptHMACKey(t.Key)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to encrypt webhook client certificate")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to encrypt client certificate"))
+			return
+		}
+
+		_, err = s.db.Exec("UPDATE users SET webhook_client_cert = $1, webhook_client_key = $2 WHERE id = $3", encryptedCert, encryptedKey, txtid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not save client certificate: %v", err))
+			return
+		}
+		setWebhookClientCert(txtid, cert)
+		log.Info().Str("userID", txtid).Str("subject", cert.Leaf.Subject.String()).Msg("Webhook client certificate configured")
+
+		response := webhookClientCertInfo(cert.Leaf)
+		response["Details"] = "Webhook client certificate set successfully"
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// GetWebhookClientCert reports the client certificate presented to webhook
+// endpoints. The key is never returned.
+func (s *server) GetWebhookClientCert() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		var certData []byte
+		if err := s.db.QueryRow("SELECT webhook_client_cert FROM users WHERE id = $1", txtid).Scan(&certData); err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not get client certificate: %v", err))
+			return
+		}
+
+		response := map[string]interface{}{"configured": false}
+		if len(certData) > 0 {
+			certPEM, err := decryptHMACKey(certData)
+			if err != nil {
+				log.Error().Err(err).Str("userID", txtid).Msg("Failed to decrypt webhook client certificate")
+				s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to decrypt client certificate"))
+				return
+			}
+			block, _ := pem.Decode([]byte(certPEM))
+			if block == nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New("stored client certificate is not PEM"))
+				return
+			}
+			leaf, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not parse client certificate: %v", err))
+				return
+			}
+			response = webhookClientCertInfo(leaf)
+		}
+
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// DeleteWebhookClientCert removes the client certificate, webhooks are then
+// delivered without one
+func (s *server) DeleteWebhookClientCert() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		_, err := s.db.Exec("UPDATE users SET webhook_client_cert = NULL, webhook_client_key = NULL WHERE id = $1", txtid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("could not delete client certificate: %v", err))
+			return
+		}
+		setWebhookClientCert(txtid, nil)
+		log.Info().Str("userID", txtid).Msg("Webhook client certificate removed")
+
+		responseJson, err := json.Marshal(map[string]interface{}{"Details": "Webhook client certificate deleted successfully"})
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// webhookOrderMaxPending caps the deliveries waiting behind a slow or failing
+// one of the same chat
+const webhookOrderMaxPending = 1000
+
+// webhookOrder serializes user webhook deliveries per chat when ordered
+// delivery is enabled
+var webhookOrder = newOrderedQueue(webhookOrderMaxPending)
+
+// orderedQueue runs jobs sharing a key one after the other, in the order they
+// were enqueued. Jobs with different keys run concurrently.
+type orderedQueue struct {
+	mu      sync.Mutex
+	pending map[string][]func()
+	limit   int
+}
+
+// newOrderedQueue returns a queue holding up to limit pending jobs per key
+func newOrderedQueue(limit int) *orderedQueue {
+	return &orderedQueue{pending: make(map[string][]func()), limit: limit}
+}
+
+// enqueue schedules job after the jobs already queued for key. A worker runs
+// while a key has pending jobs and exits once its queue is drained. It
+// returns false, dropping job, when the queue of key is full.
+func (q *orderedQueue) enqueue(key string, job func()) bool {
+	q.mu.Lock()
+	jobs, running := q.pending[key]
+	if len(jobs) >= q.limit {
+		q.mu.Unlock()
+		return false
+	}
+	q.pending[key] = append(jobs, job)
+	q.mu.Unlock()
+
+	if !running {
+		go q.run(key)
+	}
+	return true
+}
+
+func (q *orderedQueue) run(key string) {
+	for {
+		q.mu.Lock()
+		jobs := q.pending[key]
+		if len(jobs) == 0 {
+			delete(q.pending, key)
+			q.mu.Unlock()
+			return
+		}
+		job := jobs[0]
+		q.pending[key] = jobs[1:]
+		q.mu.Unlock()
+
+		job()
+	}
+}
+
+// webhookOrderKey returns the queue key of an event delivered to webhookurl,
+// or "" when deliveries are unordered or the event does not belong to a chat
+func webhookOrderKey(webhookurl, userID string, jsonData []byte) string {
+	if !*webhookOrdered {
+		return ""
+	}
+
+	var envelope struct {
+		Event struct {
+			Chat string `json:"Chat"`
+			Info struct {
+				Chat string `json:"Chat"`
+			} `json:"Info"`
+		} `json:"event"`
+	}
+	if err := json.Unmarshal(jsonData, &envelope); err != nil {
+		return ""
+	}
+
+	chat := envelope.Event.Info.Chat
+	if chat == "" {
+		chat = envelope.Event.Chat
+	}
+	if chat == "" {
+		return ""
+	}
+	return userID + "|" + webhookurl + "|" + chat
+}
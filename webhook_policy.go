@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// lookupWebhookIP resolves webhook hosts, replaced in tests
+var lookupWebhookIP = net.LookupIP
+
+// webhookPolicy restricts the webhook URLs users can set. It is nil when no
+// restriction is configured.
+var webhookPolicy *webhookHostPolicy
+
+// webhookHostPolicy holds the operator's webhook destination rules. Rules are
+// hostnames, optionally with a leading "*." or "." to match subdomains, or
+// IPs and CIDRs matched against the addresses the host resolves to.
+type webhookHostPolicy struct {
+	allowHosts   []string
+	allowNets    []*net.IPNet
+	denyHosts    []string
+	denyNets     []*net.IPNet
+	blockPrivate bool
+}
+
+// newWebhookHostPolicy parses comma separated allow and deny rules. It
+// returns nil when nothing is restricted.
+func newWebhookHostPolicy(allow, deny string, blockPrivate bool) (*webhookHostPolicy, error) {
+	p := &webhookHostPolicy{blockPrivate: blockPrivate}
+
+	var err error
+	if p.allowHosts, p.allowNets, err = parseWebhookHostRules(allow); err != nil {
+		return nil, err
+	}
+	if p.denyHosts, p.denyNets, err = parseWebhookHostRules(deny); err != nil {
+		return nil, err
+	}
+
+	if !p.hasAllowRules() && len(p.denyHosts) == 0 && len(p.denyNets) == 0 && !blockPrivate {
+		return nil, nil
+	}
+	return p, nil
+}
+
+func parseWebhookHostRules(rules string) ([]string, []*net.IPNet, error) {
+	var hosts []string
+	var nets []*net.IPNet
+	for _, rule := range strings.Split(rules, ",") {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		if rule == "" {
+			continue
+		}
+		if strings.Contains(rule, "/") {
+			_, block, err := net.ParseCIDR(rule)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid webhook host rule %q: %w", rule, err)
+			}
+			nets = append(nets, block)
+			continue
+		}
+		if ip := net.ParseIP(rule); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		hosts = append(hosts, rule)
+	}
+	return hosts, nets, nil
+}
+
+func (p *webhookHostPolicy) hasAllowRules() bool {
+	return len(p.allowHosts) > 0 || len(p.allowNets) > 0
+}
+
+// matchHost reports whether host matches one of the hostname rules
+func matchHost(host string, rules []string) bool {
+	for _, rule := range rules {
+		switch {
+		case strings.HasPrefix(rule, "*."):
+			if strings.HasSuffix(host, rule[1:]) {
+				return true
+			}
+		case strings.HasPrefix(rule, "."):
+			if host == rule[1:] || strings.HasSuffix(host, rule) {
+				return true
+			}
+		case host == rule:
+			return true
+		}
+	}
+	return false
+}
+
+// matchNets reports whether any of ips is in one of nets
+func matchNets(ips []net.IP, nets []*net.IPNet) bool {
+	for _, ip := range ips {
+		for _, block := range nets {
+			if block.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allNetsMatch reports whether every one of ips is in one of nets
+func allNetsMatch(ips []net.IP, nets []*net.IPNet) bool {
+	for _, ip := range ips {
+		if !matchNets([]net.IP{ip}, nets) {
+			return false
+		}
+	}
+	return len(ips) > 0
+}
+
+// check validates a webhook URL against the policy
+func (p *webhookHostPolicy) check(rawURL string) error {
+	if rawURL == "" {
+		return nil
+	}
+	if !isHTTPURL(rawURL) {
+		return errors.New("webhook must be an http or https URL")
+	}
+	parsed, _ := url.Parse(rawURL)
+	_, err := p.checkHost(strings.ToLower(parsed.Hostname()))
+	return err
+}
+
+// checkHost validates a webhook host against the policy and returns the
+// addresses it was checked with, nil when no rule needed them. Deny rules
+// always win. A destination explicitly allowed may be private, otherwise
+// private and loopback addresses are refused when blockPrivate is set.
+func (p *webhookHostPolicy) checkHost(host string) ([]net.IP, error) {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else if len(p.allowNets) > 0 || len(p.denyNets) > 0 || p.blockPrivate {
+		resolved, err := lookupWebhookIP(host)
+		if err != nil || len(resolved) == 0 {
+			return nil, fmt.Errorf("could not resolve webhook host %s", host)
+		}
+		ips = resolved
+	}
+
+	if matchHost(host, p.denyHosts) || matchNets(ips, p.denyNets) {
+		return nil, fmt.Errorf("webhook host %s is not allowed", host)
+	}
+
+	allowed := matchHost(host, p.allowHosts) || allNetsMatch(ips, p.allowNets)
+	if p.hasAllowRules() && !allowed {
+		return nil, fmt.Errorf("webhook host %s is not in the allowed list", host)
+	}
+
+	if p.blockPrivate && !allowed {
+		for _, ip := range ips {
+			if isPrivateOrLoopback(ip) {
+				return nil, fmt.Errorf("webhook host %s resolves to a private address", host)
+			}
+		}
+	}
+	return ips, nil
+}
+
+// operatorWebhookKey marks the context of requests to the webhooks set by
+// the operator, such as the global webhook, which the policy does not cover
+type operatorWebhookKey struct{}
+
+// webhookRequestContext returns the context a webhook to myurl is sent with
+func webhookRequestContext(myurl string) context.Context {
+	ctx := context.Background()
+	if *globalWebhook != "" && myurl == *globalWebhook {
+		ctx = context.WithValue(ctx, operatorWebhookKey{}, true)
+	}
+	return ctx
+}
+
+// dialWebhook connects to a webhook endpoint, checking the host against the
+// policy again at dial time. Hosts are dialed on the addresses they were
+// checked with, so a DNS answer changing after the URL was set, or between
+// check and dial, cannot point the request somewhere else. Redirect targets
+// go through the same check when dialed.
+func dialWebhook(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		policy := webhookPolicy
+		if policy == nil || ctx.Value(operatorWebhookKey{}) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected address format from http transport: %q: %w", addr, err)
+		}
+		ips, err := policy.checkHost(strings.ToLower(host))
+		if err != nil {
+			log.Warn().Err(err).Str("host", host).Msg("Refused to connect to webhook host")
+			return nil, err
+		}
+		if ips == nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		var lastErr error
+		for _, ip := range ips {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+// validateWebhookURL checks a user webhook URL against the configured policy
+func validateWebhookURL(rawURL string) error {
+	if webhookPolicy == nil {
+		return nil
+	}
+	return webhookPolicy.check(rawURL)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+)
+
+// Bounds of the number of failed webhooks replayed in one call
+const (
+	defaultReplayLimit = 100
+	maxReplayLimit     = 1000
+)
+
+// errorQueueGetter is the part of the RabbitMQ channel used to take failed
+// webhooks off the error queue
+type errorQueueGetter interface {
+	Get(queue string, autoAck bool) (amqp091.Delivery, bool, error)
+}
+
+// webhookErrorSource returns the channel failed webhooks are read from, nil
+// when RabbitMQ is not connected
+var webhookErrorSource = func() errorQueueGetter {
+	if !rabbitEnabled || rabbitChannel == nil {
+		return nil
+	}
+	return rabbitChannel
+}
+
+// webhookReplayResult reports what a replay did. Failed webhooks are back on
+// the error queue with their new error, and invalid messages, including the
+// webhooks that could not be sent at all, are left there untouched.
+type webhookReplayResult struct {
+	Replayed  int `json:"replayed"`
+	Delivered int `json:"delivered"`
+	Failed    int `json:"failed"`
+	Invalid   int `json:"invalid"`
+}
+
+// replayPayload turns the body stored with a failed webhook back into the
+// payload it was built from. Form and file webhooks keep their fields, while
+// JSON webhooks stored the event itself.
+func replayPayload(stored map[string]interface{}) map[string]string {
+	if _, ok := stored["jsonData"].(string); !ok {
+		data, err := json.Marshal(stored)
+		if err != nil {
+			return nil
+		}
+		return map[string]string{"jsonData": string(data)}
+	}
+
+	payload := make(map[string]string, len(stored))
+	for k, v := range stored {
+		if str, ok := v.(string); ok {
+			payload[k] = str
+		} else if data, err := json.Marshal(v); err == nil {
+			payload[k] = string(data)
+		}
+	}
+	return payload
+}
+
+// errWebhookNotReplayed is wrapped by the errors of failed webhooks that
+// could not be sent at all, and so were not published again
+var errWebhookNotReplayed = errors.New("webhook not replayed")
+
+// replayWebhook delivers a failed webhook again, once, signing it with the
+// HMAC key it was first sent with
+func replayWebhook(failed WebhookFileErrorPayload) error {
+	if failed.URL == "" || failed.Payload == nil {
+		return fmt.Errorf("%w: missing url or payload", errWebhookNotReplayed)
+	}
+	var encryptedHmacKey []byte
+	if failed.EncryptedHmacKey != "" {
+		key, err := hex.DecodeString(failed.EncryptedHmacKey)
+		if err != nil {
+			return fmt.Errorf("%w: invalid encryptedHmacKey: %v", errWebhookNotReplayed, err)
+		}
+		encryptedHmacKey = key
+	}
+
+	payload := replayPayload(failed.Payload)
+	if failed.FilePath != "" {
+		if _, err := os.Stat(failed.FilePath); err != nil {
+			return fmt.Errorf("%w: file of webhook no longer available: %v", errWebhookNotReplayed, err)
+		}
+		delete(payload, "file")
+		return sendFileHook(failed.URL, payload, failed.UserID, failed.FilePath, encryptedHmacKey, nil, false)
+	}
+	return sendHook(failed.URL, payload, failed.UserID, encryptedHmacKey, nil, false)
+}
+
+// replayWebhookErrors takes up to limit failed webhooks off queue and
+// delivers them again. Messages are all taken before any is delivered, so
+// those failing again and published back to the queue are not replayed twice.
+func replayWebhookErrors(source errorQueueGetter, queue string, limit int) (*webhookReplayResult, error) {
+	var deliveries []amqp091.Delivery
+	for len(deliveries) < limit {
+		delivery, ok, err := source.Get(queue, false)
+		if err != nil {
+			for _, d := range deliveries {
+				d.Nack(false, true)
+			}
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	result := &webhookReplayResult{}
+	for _, delivery := range deliveries {
+		var failed WebhookFileErrorPayload
+		if err := json.Unmarshal(delivery.Body, &failed); err != nil || failed.URL == "" {
+			log.Warn().Err(err).Str("queue", queue).Msg("Skipping invalid message in webhook error queue")
+			result.Invalid++
+			delivery.Nack(false, true)
+			continue
+		}
+
+		err := replayWebhook(failed)
+		if errors.Is(err, errWebhookNotReplayed) {
+			// Nothing was sent or published again, the message stays queued
+			log.Warn().Err(err).Str("url", failed.URL).Str("userID", failed.UserID).Msg("Skipping webhook that cannot be replayed")
+			result.Invalid++
+			delivery.Nack(false, true)
+			continue
+		}
+
+		result.Replayed++
+		if err != nil {
+			log.Warn().Err(err).Str("url", failed.URL).Str("userID", failed.UserID).Msg("Replayed webhook failed")
+			result.Failed++
+		} else {
+			result.Delivered++
+		}
+		// Failed deliveries were published again with their new error
+		delivery.Ack(false)
+	}
+	return result, nil
+}
+
+// Replays the failed webhooks waiting in the RabbitMQ error queue
+func (s *server) ReplayWebhookErrors() http.HandlerFunc {
+
+	type replayStruct struct {
+		Limit int `json:"limit"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var t replayStruct
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil && !errors.Is(err, io.EOF) {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			return
+		}
+		if t.Limit == 0 {
+			t.Limit = defaultReplayLimit
+		}
+		if t.Limit < 0 || t.Limit > maxReplayLimit {
+			s.Respond(w, r, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxReplayLimit))
+			return
+		}
+
+		source := webhookErrorSource()
+		if source == nil {
+			s.Respond(w, r, http.StatusServiceUnavailable, errors.New("rabbitmq is not connected"))
+			return
+		}
+
+		queue := webhookErrorQueue()
+		result, err := replayWebhookErrors(source, queue, t.Limit)
+		if err != nil {
+			log.Error().Err(err).Str("queue", queue).Msg("Failed to read webhook error queue")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to read webhook error queue"))
+			return
+		}
+		log.Info().Str("queue", queue).Int("replayed", result.Replayed).Int("delivered", result.Delivered).Int("failed", result.Failed).Msg("Replayed failed webhooks")
+
+		responseJson, err := json.Marshal(result)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	// webhookTimestampHeader carries the Unix time the delivery attempt was signed
+	webhookTimestampHeader = "x-webhook-timestamp"
+
+	// webhookSignedHeadersHeader lists the headers covered by x-hmac-signature
+	webhookSignedHeadersHeader = "x-hmac-signed-headers"
+)
+
+// signableWebhookHeaders are the headers that can be included in the webhook
+// signature. Idempotency-Key identifies the event being delivered.
+var signableWebhookHeaders = map[string]bool{
+	webhookTimestampHeader:                true,
+	strings.ToLower(idempotencyKeyHeader): true,
+}
+
+// webhookSignedHeaders are the headers signed together with the body, in
+// canonical order. Empty keeps body-only signatures.
+var webhookSignedHeaders []string
+
+// parseWebhookSignedHeaders parses a comma separated header list, keeping
+// the given order as the canonical one
+func parseWebhookSignedHeaders(list string) ([]string, error) {
+	var headers []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !signableWebhookHeaders[name] {
+			return nil, fmt.Errorf("header %q cannot be signed, supported: %s, %s", name, webhookTimestampHeader, strings.ToLower(idempotencyKeyHeader))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("header %q listed twice", name)
+		}
+		seen[name] = true
+		headers = append(headers, name)
+	}
+	return headers, nil
+}
+
+// webhookSignatureHeaders returns the values of the signed headers for a
+// delivery attempt made at now
+func webhookSignatureHeaders(signed []string, idempotencyKey string, now time.Time) http.Header {
+	headers := http.Header{}
+	for _, name := range signed {
+		switch name {
+		case webhookTimestampHeader:
+			headers.Set(name, strconv.FormatInt(now.Unix(), 10))
+		case strings.ToLower(idempotencyKeyHeader):
+			headers.Set(name, idempotencyKey)
+		}
+	}
+	return headers
+}
+
+// canonicalWebhookContent is what gets signed: one "name:value" line per
+// signed header, in order, followed by the body. Without signed headers it is
+// the body alone.
+func canonicalWebhookContent(signed []string, headers http.Header, body []byte) []byte {
+	var b bytes.Buffer
+	for _, name := range signed {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.TrimSpace(headers.Get(name)))
+		b.WriteByte('\n')
+	}
+	b.Write(body)
+	return b.Bytes()
+}
+
+// signWebhookRequest signs body plus the configured headers and sets the
+// signature, the signed headers and their list on req
+func signWebhookRequest(req *resty.Request, body []byte, idempotencyKey string, encryptedHmacKey []byte) error {
+	headers := webhookSignatureHeaders(webhookSignedHeaders, idempotencyKey, time.Now())
+	signature, err := generateHmacSignature(canonicalWebhookContent(webhookSignedHeaders, headers, body), encryptedHmacKey)
+	if err != nil || signature == "" {
+		return err
+	}
+
+	req.SetHeader("x-hmac-signature", signature)
+	if len(webhookSignedHeaders) > 0 {
+		for _, name := range webhookSignedHeaders {
+			req.SetHeader(name, headers.Get(name))
+		}
+		req.SetHeader(webhookSignedHeadersHeader, strings.Join(webhookSignedHeaders, ";"))
+	}
+	return nil
+}
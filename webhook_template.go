@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"text/template"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+// maxWebhookTemplateOutput caps the size of a rendered payload so a runaway
+// template cannot build huge bodies
+const maxWebhookTemplateOutput = 1 << 20
+
+// webhookTemplates holds the parsed payload template of each user
+var webhookTemplates sync.Map
+
+// webhookTemplateFuncs is the whole function set available to templates.
+// Templates only see the event data, there is no access to the environment
+// or the filesystem.
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// parseWebhookTemplate parses a user payload template. The rendered output
+// must be a JSON document.
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(webhookTemplateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// setWebhookTemplate replaces the cached template of a user. An empty text
+// removes it.
+func setWebhookTemplate(userID, text string) error {
+	if text == "" {
+		webhookTemplates.Delete(userID)
+		return nil
+	}
+	tmpl, err := parseWebhookTemplate(text)
+	if err != nil {
+		return err
+	}
+	webhookTemplates.Store(userID, tmpl)
+	return nil
+}
+
+// loadWebhookTemplate caches the template stored for a user
+func loadWebhookTemplate(db *sqlx.DB, userID string) {
+	var text string
+	if err := db.Get(&text, "SELECT COALESCE(webhook_template, '') FROM users WHERE id = $1", userID); err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Could not load webhook template")
+		return
+	}
+	if err := setWebhookTemplate(userID, text); err != nil {
+		log.Error().Err(err).Str("userID", userID).Msg("Stored webhook template is invalid, sending raw payloads")
+	}
+}
+
+// renderWebhookTemplate executes tmpl against event and checks the result is
+// valid JSON
+func renderWebhookTemplate(tmpl *template.Template, event map[string]interface{}) ([]byte, error) {
+	out := &limitedBuffer{limit: maxWebhookTemplateOutput}
+	if err := tmpl.Execute(out, event); err != nil {
+		return nil, err
+	}
+	if !json.Valid(out.buf.Bytes()) {
+		return nil, errors.New("rendered payload is not valid JSON")
+	}
+	return out.buf.Bytes(), nil
+}
+
+// errWebhookTemplateOutput stops a template whose output grows past
+// maxWebhookTemplateOutput
+var errWebhookTemplateOutput = fmt.Errorf("rendered payload exceeds %d bytes", maxWebhookTemplateOutput)
+
+// limitedBuffer is a buffer refusing writes past limit bytes, so a runaway
+// template is stopped before the whole body is built
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) > b.limit {
+		return 0, errWebhookTemplateOutput
+	}
+	return b.buf.Write(p)
+}
+
+// userWebhookTemplate returns the payload template of a user, or nil
+func userWebhookTemplate(userID string) *template.Template {
+	if v, ok := webhookTemplates.Load(userID); ok {
+		return v.(*template.Template)
+	}
+	return nil
+}
+
+// applyWebhookTemplate reshapes event with tmpl. It returns false when tmpl
+// is nil or rendering fails, in which case the raw event must be sent.
+func applyWebhookTemplate(tmpl *template.Template, userID string, event map[string]interface{}) ([]byte, bool) {
+	if tmpl == nil {
+		return nil, false
+	}
+
+	rendered, err := renderWebhookTemplate(tmpl, event)
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Msg("Webhook template failed, sending raw payload")
+		return nil, false
+	}
+	return rendered, true
+}
+
+// templateWebhookPayload applies tmpl to a form payload, replacing jsonData
+// with the rendered document. The template sees the same event as JSON
+// webhooks, including instanceName and userID.
+func templateWebhookPayload(tmpl *template.Template, userID string, payload map[string]string) map[string]string {
+	if tmpl == nil {
+		return payload
+	}
+
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(payload["jsonData"]), &event); err != nil {
+		return payload
+	}
+	if instanceName, ok := payload["instanceName"]; ok {
+		event["instanceName"] = instanceName
+	}
+	event["userID"] = userID
+
+	rendered, ok := applyWebhookTemplate(tmpl, userID, event)
+	if !ok {
+		return payload
+	}
+
+	templated := make(map[string]string, len(payload))
+	for k, v := range payload {
+		templated[k] = v
+	}
+	templated["jsonData"] = string(rendered)
+	return templated
+}
@@ -16,14 +16,12 @@ import (
 	"strings"
 	"sync"
 	"time"
-	"wuzapi/pkg/chatwoot"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/jmoiron/sqlx"
 	"github.com/mdp/qrterminal/v3"
 	"github.com/patrickmn/go-cache"
 	"github.com/rs/zerolog/log"
-	"github.com/skip2/go-qrcode"
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/appstate"
 	"go.mau.fi/whatsmeow/proto/waCompanionReg"
@@ -93,16 +91,35 @@ func sendToUserWebHookWithHmac(webhookurl string, path string, jsonData []byte,
 
 	if webhookurl != "" {
 		log.Info().Str("url", webhookurl).Msg("Calling user webhook")
+		tmpl := userWebhookTemplate(userID)
+
+		// Deliveries for the same chat wait for the previous one, retries included
+		orderKey := webhookOrderKey(webhookurl, userID, jsonData)
 
 		if path == "" {
-			go callHookWithHmac(webhookurl, data, userID, encryptedHmacKey)
+			if orderKey != "" {
+				if !webhookOrder.enqueue(orderKey, func() {
+					callHookWithTemplate(webhookurl, data, userID, encryptedHmacKey, tmpl)
+				}) {
+					log.Error().Str("userID", userID).Str("url", webhookurl).Msg("Dropping webhook, too many deliveries pending for the chat")
+				}
+			} else {
+				go callHookWithTemplate(webhookurl, data, userID, encryptedHmacKey, tmpl)
+			}
 		} else {
 			// Create a channel to capture the error from the goroutine
 			errChan := make(chan error, 1)
-			go func() {
-				err := callHookFileWithHmac(webhookurl, data, userID, path, encryptedHmacKey)
+			job := func() {
+				err := callHookFileWithTemplate(webhookurl, data, userID, path, encryptedHmacKey, tmpl)
 				errChan <- err
-			}()
+			}
+			if orderKey != "" {
+				if !webhookOrder.enqueue(orderKey, job) {
+					errChan <- errors.New("too many deliveries pending for the chat")
+				}
+			} else {
+				go job()
+			}
 
 			// Optionally handle the error from the channel (if needed)
 			if err := <-errChan; err != nil {
@@ -114,6 +131,27 @@ func sendToUserWebHookWithHmac(webhookurl string, path string, jsonData []byte,
 	}
 }
 
+// forwardUndecryptablePlaceholder forwards a placeholder to Chatwoot for a
+// message that failed to decrypt, so new contacts get a conversation right
+// away. The message itself is forwarded when it decrypts later.
+func (mycli *MyClient) forwardUndecryptablePlaceholder(evt *events.UndecryptableMessage) {
+	cwService := chatwootService(mycli.db)
+
+	// Create placeholder Message event to trigger conversation creation
+	placeholderEvt := &events.Message{
+		Info: evt.Info,
+		Message: &waE2E.Message{
+			Conversation: proto.String("[Aguardando descriptografia...]"),
+		},
+	}
+
+	if err := cwService.HandlePlaceholderMessage(mycli.userID, placeholderEvt, mycli.WAClient); err != nil {
+		log.Debug().Err(err).Msg("Failed to create Chatwoot conversation for undecryptable")
+	} else {
+		log.Info().Str("chat", evt.Info.Chat.String()).Msg("✓ Conversation created for undecryptable message")
+	}
+}
+
 func updateAndGetUserSubscriptions(mycli *MyClient) ([]string, error) {
 	// Get updated events from cache/database
 	currentEvents := ""
@@ -159,66 +197,10 @@ func getUserWebhookUrl(token string) string {
 	return webhookurl
 }
 
+// sendEventWithWebHook routes a webhook-style event through the sinks
+// enabled for the user (stdio, webhooks and queues)
 func sendEventWithWebHook(mycli *MyClient, postmap map[string]interface{}, path string) {
-	webhookurl := getUserWebhookUrl(mycli.token)
-
-	// Get updated events from cache/database
-	subscribedEvents, err := updateAndGetUserSubscriptions(mycli)
-	if err != nil {
-		return
-	}
-
-	eventType, ok := postmap["type"].(string)
-	if !ok {
-		log.Error().Msg("Event type is not a string in postmap")
-		return
-	}
-
-	// Log subscription details for debugging
-	log.Debug().
-		Str("userID", mycli.userID).
-		Str("eventType", eventType).
-		Strs("subscribedEvents", subscribedEvents).
-		Msg("Checking event subscription")
-
-	// Check if the current event is in the subscriptions
-	checkIfSubscribedInEvent := checkIfSubscribedToEvent(subscribedEvents, postmap["type"].(string), mycli.userID)
-	if !checkIfSubscribedInEvent {
-		return
-	}
-
-	// In stdio mode, send as JSON-RPC notification instead of HTTP webhook
-	if mycli.s != nil && mycli.s.mode == Stdio {
-		mycli.s.SendNotification(eventType, postmap)
-		return
-	}
-
-	// Prepare webhook data
-	jsonData, err := json.Marshal(postmap)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to marshal postmap to JSON")
-		return
-	}
-
-	// Get HMAC key for this user
-	var encryptedHmacKey []byte
-	if userinfo, found := userinfocache.Get(mycli.token); found {
-		encryptedB64 := userinfo.(Values).Get("HmacKeyEncrypted")
-		if encryptedB64 != "" {
-			var err error
-			encryptedHmacKey, err = base64.StdEncoding.DecodeString(encryptedB64)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to decode HMAC key from cache")
-			}
-		}
-	}
-
-	sendToUserWebHookWithHmac(webhookurl, path, jsonData, mycli.userID, mycli.token, encryptedHmacKey)
-
-	// Get global webhook if configured
-	go sendToGlobalWebHook(jsonData, mycli.token, mycli.userID)
-
-	go sendToGlobalRabbit(jsonData, mycli.token, mycli.userID)
+	dispatcher.dispatchPostmap(mycli, postmap, path)
 }
 
 func checkIfSubscribedToEvent(subscribedEvents []string, eventType string, userId string) bool {
@@ -233,6 +215,24 @@ func checkIfSubscribedToEvent(subscribedEvents []string, eventType string, userI
 	return true
 }
 
+// qrExpiry holds, per user, when the QR code currently stored expires and
+// whatsmeow rotates it
+var qrExpiry sync.Map
+
+// qrExpiresIn returns the whole seconds the user's current QR code remains
+// valid for
+func qrExpiresIn(userID string) (int, bool) {
+	v, ok := qrExpiry.Load(userID)
+	if !ok {
+		return 0, false
+	}
+	remaining := time.Until(v.(time.Time))
+	if remaining < 0 {
+		remaining = 0
+	}
+	return int(remaining / time.Second), true
+}
+
 // Connects to Whatsapp Websocket on server startup if last state was connected
 func (s *server) connectOnStartup() {
 	rows, err := s.db.Queryx("SELECT id,name,token,jid,webhook,events,proxy_url,CASE WHEN s3_enabled THEN 'true' ELSE 'false' END AS s3_enabled,media_delivery,COALESCE(history, 0) as history,hmac_key FROM users WHERE connected=1")
@@ -263,6 +263,11 @@ func (s *server) connectOnStartup() {
 				hmacKeyEncrypted = base64.StdEncoding.EncodeToString(hmac_key)
 			}
 
+			if !clientManager.ReserveSession(txtid, *maxSessions) {
+				log.Warn().Str("userID", txtid).Int("max_sessions", *maxSessions).Msg("Not connecting on startup, session limit reached")
+				continue
+			}
+
 			log.Info().Str("token", token).Msg("Connect to Whatsapp on startup")
 			v := Values{map[string]string{
 				"Id":               txtid,
@@ -412,6 +417,13 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 		client = whatsmeow.NewClient(deviceStore, nil)
 	}
 
+	// Reconnects follow the user's reconnect policy instead of whatsmeow's defaults
+	client.EnableAutoReconnect = false
+
+	// Messages that fail to decrypt are asked from the phone when the sender
+	// does not resend them
+	client.AutomaticMessageRerequestFromPhone = *requestUnavailable
+
 	// Now we can use the client with the manager
 	clientManager.SetWhatsmeowClient(userID, client)
 
@@ -424,13 +436,24 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 	// Store the MyClient in clientManager
 	clientManager.SetMyClient(userID, &mycli)
 
-	httpClient := resty.New()
+	// Cache the webhook payload template used by callHookWithTemplate
+	loadWebhookTemplate(s.db, userID)
+
+	// Cache the auto-read setting checked for every incoming message, dropping
+	// the batcher bound to the client of a previous connection
+	stopAutoReader(userID)
+	loadAutoRead(s.db, userID)
+
+	// Cache the client certificate presented to mutual TLS webhook endpoints
+	loadWebhookClientCert(s.db, userID)
+
+	httpClient := newWebhookHTTPClient(time.Duration(*webhookTimeout)*time.Second,
+		time.Duration(*webhookDialTimeout)*time.Second, time.Duration(*webhookKeepAlive)*time.Second)
 	httpClient.SetRedirectPolicy(resty.FlexibleRedirectPolicy(15))
 	if *waDebug == "DEBUG" {
 		httpClient.SetDebug(true)
 	}
-	httpClient.SetTimeout(30 * time.Second)
-	httpClient.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
+	httpClient.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true, GetClientCertificate: webhookClientCertificate(userID)})
 	httpClient.OnError(func(req *resty.Request, err error) {
 		if v, ok := err.(*resty.ResponseError); ok {
 			// v.Response contains the last response from the server
@@ -496,8 +519,8 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 						fmt.Println("QR code:\n", evt.Code)
 					}
 					// Store encoded/embeded base64 QR on database for retrieval with the /qr endpoint
-					image, _ := qrcode.Encode(evt.Code, qrcode.Medium, 256)
-					base64qrcode := "data:image/png;base64," + base64.StdEncoding.EncodeToString(image)
+					base64qrcode, _ := qrCodeDataURI(evt.Code)
+					qrExpiry.Store(userID, time.Now().Add(evt.Timeout))
 					sqlStmt := `UPDATE users SET qrcode=$1 WHERE id=$2`
 					_, err := s.db.Exec(sqlStmt, base64qrcode, userID)
 					if err != nil {
@@ -526,6 +549,7 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 					postmap["type"] = "QRTimeout"
 					sendEventWithWebHook(&mycli, postmap, "")
 
+					qrExpiry.Delete(userID)
 					sqlStmt := `UPDATE users SET qrcode='' WHERE id=$1`
 					_, err := s.db.Exec(sqlStmt, userID)
 					if err != nil {
@@ -543,6 +567,7 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 					killchannel[userID] <- true
 				} else if evt.Event == "success" {
 					log.Info().Msg("QR pairing ok!")
+					qrExpiry.Delete(userID)
 					// Clear QR code after pairing
 					sqlStmt := `UPDATE users SET qrcode='', connected=1 WHERE id=$1`
 					_, err := s.db.Exec(sqlStmt, userID)
@@ -583,6 +608,7 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 				log.Info().
 					Int("attempt", attempt+1).
 					Msg("Successfully connected to WhatsApp")
+				lastErr = nil
 				break
 			}
 
@@ -594,6 +620,11 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 				Msg("Failed to connect to WhatsApp")
 		}
 
+		// Keep trying under the user's reconnect policy before giving up
+		if lastErr != nil && mycli.reconnectAfterConnectFailure(lastErr) {
+			lastErr = nil
+		}
+
 		if lastErr != nil {
 			log.Error().
 				Err(lastErr).
@@ -604,6 +635,7 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 			clientManager.DeleteWhatsmeowClient(userID)
 			clientManager.DeleteMyClient(userID)
 			clientManager.DeleteHTTPClient(userID)
+			qrExpiry.Delete(userID)
 
 			sqlStmt := `UPDATE users SET qrcode='', connected=0 WHERE id=$1`
 			_, dbErr := s.db.Exec(sqlStmt, userID)
@@ -633,6 +665,7 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 			clientManager.DeleteWhatsmeowClient(userID)
 			clientManager.DeleteMyClient(userID)
 			clientManager.DeleteHTTPClient(userID)
+			qrExpiry.Delete(userID)
 			sqlStmt := `UPDATE users SET qrcode='', connected=0 WHERE id=$1`
 			_, err := s.db.Exec(sqlStmt, userID)
 			if err != nil {
@@ -657,6 +690,7 @@ func fileToBase64(filepath string) (string, string, error) {
 
 func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 	txtid := mycli.userID
+	clientManager.TouchActivity(txtid)
 	postmap := make(map[string]interface{})
 	postmap["event"] = rawEvt
 	dowebhook := 0
@@ -675,6 +709,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 	case *events.Connected, *events.PushNameSetting:
 		postmap["type"] = "Connected"
 		dowebhook = 1
+		sessionProfileCache.Delete(mycli.userID)
 		if len(mycli.WAClient.Store.PushName) == 0 {
 			break
 		}
@@ -839,29 +874,16 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 
 		log.Info().Str("id", evt.Info.ID).Str("source", evt.Info.SourceString()).Str("parts", strings.Join(metaParts, ", ")).Msg("Message Received")
 
-		// Chatwoot Enterprise Integration
-		go func() {
-			// Check dedupe cache first - skip if this message was sent via Chatwoot API
-			if _, exists := messageDedupeCache.Load(evt.Info.ID); exists {
-				log.Debug().
-					Str("message_id", evt.Info.ID).
-					Msg("Message ID found in dedupe cache, skipping Chatwoot forward (loop prevention)")
-
-				// Schedule cleanup after 5 minutes
-				go func(id string) {
-					time.Sleep(5 * time.Minute)
-					messageDedupeCache.Delete(id)
-					log.Debug().Str("message_id", id).Msg("Removed message from dedupe cache")
-				}(evt.Info.ID)
+		// Integrations such as Chatwoot receive the raw message
+		go dispatcher.deliver(&dispatchEvent{Client: mycli, Type: "Message", Message: evt})
 
-				return
-			}
+		if *pollResultsEnabled {
+			mycli.handlePollMessage(evt)
+		}
+		mycli.handleKeepInChatMessage(evt)
+		mycli.handleStatusReply(evt)
 
-			cwService := chatwoot.NewService(mycli.db)
-			if err := cwService.HandleIncomingMessage(mycli.userID, evt, mycli.WAClient); err != nil {
-				log.Debug().Err(err).Str("message_id", evt.Info.ID).Msg("Chatwoot forwarding error")
-			}
-		}()
+		mycli.autoRead(evt)
 
 		if !*skipMedia {
 			// try to get Image if any
@@ -1412,6 +1434,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 					mediaLink,
 					replyToMessageID,
 					string(evtJSON),
+					evt.Info.IsFromMe,
 				)
 				if err != nil {
 					log.Error().Err(err).Msg("Failed to save message to history")
@@ -1429,6 +1452,13 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 	case *events.Receipt:
 		postmap["type"] = "ReadReceipt"
 		dowebhook = 1
+		if status, ok := receiptStatus(evt.Type); ok {
+			for _, id := range evt.MessageIDs {
+				if err := mycli.s.saveMessageReceipt(mycli.userID, evt.Chat.String(), id, status, evt.Timestamp); err != nil {
+					log.Warn().Err(err).Str("id", id).Msg("Could not store message receipt")
+				}
+			}
+		}
 		//if evt.Type == events.ReceiptTypeRead || evt.Type == events.ReceiptTypeReadSelf {
 		if evt.Type == types.ReceiptTypeRead || evt.Type == types.ReceiptTypeReadSelf {
 			log.Info().Strs("id", evt.MessageIDs).Str("source", evt.SourceString()).Str("timestamp", fmt.Sprintf("%v", evt.Timestamp)).Msg("Message was read")
@@ -1706,6 +1736,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 								mediaLink,
 								quotedMessageID,
 								string(evtJSON),
+								isFromMe,
 							)
 							if err != nil {
 								log.Error().Err(err).
@@ -1742,6 +1773,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 			default:
 			}
 		}()
+		clientManager.ClearActivity(mycli.userID)
 		sqlStmt := `UPDATE users SET connected=0 WHERE id=$1`
 		_, err := mycli.db.Exec(sqlStmt, mycli.userID)
 		if err != nil {
@@ -1776,6 +1808,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 		postmap["type"] = "Disconnected"
 		dowebhook = 1
 		log.Info().Str("reason", fmt.Sprintf("%+v", evt)).Msg("Disconnected from Whatsapp")
+		go mycli.handleReconnect(evt)
 	case *events.ConnectFailure:
 		postmap["type"] = "ConnectFailure"
 		dowebhook = 1
@@ -1786,23 +1819,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 		log.Warn().Str("info", evt.Info.SourceString()).Msg("Undecryptable message received")
 
 		// CRITICAL: Create Chatwoot conversation for undecryptable messages (new contacts)
-		go func() {
-			cwService := chatwoot.NewService(mycli.db)
-
-			// Create placeholder Message event to trigger conversation creation
-			placeholderEvt := &events.Message{
-				Info: evt.Info,
-				Message: &waE2E.Message{
-					Conversation: proto.String("[Aguardando descriptografia...]"),
-				},
-			}
-
-			if err := cwService.HandleIncomingMessage(mycli.userID, placeholderEvt, mycli.WAClient); err != nil {
-				log.Debug().Err(err).Msg("Failed to create Chatwoot conversation for undecryptable")
-			} else {
-				log.Info().Str("chat", evt.Info.Chat.String()).Msg("✓ Conversation created for undecryptable message")
-			}
-		}()
+		go mycli.forwardUndecryptablePlaceholder(evt)
 	case *events.MediaRetry:
 		postmap["type"] = "MediaRetry"
 		dowebhook = 1
@@ -1818,6 +1835,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 	case *events.Picture:
 		postmap["type"] = "Picture"
 		dowebhook = 1
+		mycli.forgetSessionProfileOf(evt.JID)
 		log.Info().Str("jid", evt.JID.String()).Msg("Picture updated")
 	case *events.BlocklistChange:
 		postmap["type"] = "BlocklistChange"
@@ -1835,6 +1853,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 		postmap["type"] = "KeepAliveTimeout"
 		dowebhook = 1
 		log.Warn().Msg("Keep alive timeout")
+		go mycli.handleReconnect(evt)
 	case *events.ClientOutdated:
 		postmap["type"] = "ClientOutdated"
 		dowebhook = 1